@@ -15,9 +15,11 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/ghodss/yaml"
 	helpers "github.com/mudler/luet/cmd/helpers"
@@ -25,9 +27,11 @@ import (
 	"github.com/mudler/luet/pkg/api/core/types"
 	"github.com/mudler/luet/pkg/api/core/types/artifact"
 	"github.com/mudler/luet/pkg/compiler"
+	"github.com/mudler/luet/pkg/compiler/backend"
 	"github.com/mudler/luet/pkg/installer"
 
 	pkg "github.com/mudler/luet/pkg/database"
+	pkgHelpers "github.com/mudler/luet/pkg/helpers"
 	fileHelpers "github.com/mudler/luet/pkg/helpers/file"
 	tree "github.com/mudler/luet/pkg/tree"
 
@@ -65,6 +69,22 @@ Build package without dependencies (needs the images already in the host, or eit
 Build packages specifying multiple definition trees:
 
 	$ luet build --tree overlay/path --tree overlay/path2 utils/yq ...
+
+Build all packages, skipping only the ones depending on failed builds, and write reports for CI:
+
+	$ luet build --all --keep-going --report-junit report.xml --report-json report.json
+
+Build all packages for several platforms, one artifact for each:
+
+	$ luet build --all --platform linux/amd64,linux/arm64
+
+Build only the packages changed since a git revision, and the ones depending on them (add --pretend to just list them):
+
+	$ luet build --changed-since origin/master
+
+Build reproducible artifacts (SOURCE_DATE_EPOCH is honoured as well), and check that a second build from scratch gives the same ones:
+
+	$ SOURCE_DATE_EPOCH=$(git log -1 --format=%ct) luet build --all --verify-reproducible
 `, PreRun: func(cmd *cobra.Command, args []string) {
 		viper.BindPFlag("tree", cmd.Flags().Lookup("tree"))
 		viper.BindPFlag("destination", cmd.Flags().Lookup("destination"))
@@ -118,6 +138,63 @@ Build packages specifying multiple definition trees:
 		pretend, _ := cmd.Flags().GetBool("pretend")
 		fromRepo, _ := cmd.Flags().GetBool("from-repositories")
 		fromDockerfiles, _ := cmd.Flags().GetBool("dockerfiles")
+		keepGoing, _ := cmd.Flags().GetBool("keep-going")
+		reportJUnit, _ := cmd.Flags().GetString("report-junit")
+		reportJSON, _ := cmd.Flags().GetString("report-json")
+		platformsFlag, _ := cmd.Flags().GetString("platform")
+		reproducible, _ := cmd.Flags().GetBool("reproducible")
+		verifyReproducible, _ := cmd.Flags().GetBool("verify-reproducible")
+		sourcesCache, _ := cmd.Flags().GetString("sources-cache")
+		changedSince, _ := cmd.Flags().GetString("changed-since")
+		limits := types.BuildLimits{}
+		limits.Timeout, _ = cmd.Flags().GetString("build-timeout")
+		limits.Memory, _ = cmd.Flags().GetString("build-memory")
+		limits.CPUs, _ = cmd.Flags().GetString("build-cpus")
+		if noNetwork, _ := cmd.Flags().GetBool("build-no-network"); noNetwork {
+			network := false
+			limits.Network = &network
+		}
+		if _, err := limits.GetTimeout(); err != nil {
+			util.DefaultContext.Fatal(err.Error())
+		}
+		if _, err := limits.GetCPUs(); err != nil {
+			util.DefaultContext.Fatal(err.Error())
+		}
+
+		runtimeDepsFlag, _ := cmd.Flags().GetString("runtime-deps")
+		var runtimeDeps types.RuntimeDepsMode
+		switch runtimeDepsFlag {
+		case "none":
+			runtimeDeps = types.RuntimeDepsNone
+		case string(types.RuntimeDepsWarn), string(types.RuntimeDepsAdd):
+			runtimeDeps = types.RuntimeDepsMode(runtimeDepsFlag)
+		default:
+			util.DefaultContext.Fatal("Invalid --runtime-deps '" + runtimeDepsFlag + "', available: none,warn,add")
+		}
+
+		compressionLevel, _ := cmd.Flags().GetInt("compression-level")
+		zstdLong, _ := cmd.Flags().GetBool("zstd-long")
+		if err := artifact.ValidateCompression(types.CompressionImplementation(compressionType), compressionLevel); err != nil {
+			util.DefaultContext.Fatal(err.Error())
+		}
+
+		sourceDateEpoch, epochSet, err := pkgHelpers.SourceDateEpoch()
+		if err != nil {
+			util.DefaultContext.Fatal(err.Error())
+		}
+		if !epochSet && (reproducible || verifyReproducible) {
+			sourceDateEpoch = time.Unix(0, 0).UTC()
+		}
+
+		// The zero platform builds for the host, without recording it in the artifacts
+		platforms := []types.Platform{{}}
+		if platformsFlag != "" {
+			var err error
+			platforms, err = types.ParsePlatforms(platformsFlag)
+			if err != nil {
+				util.DefaultContext.Fatal("Invalid platform: ", err.Error())
+			}
+		}
 
 		compilerSpecs := types.NewLuetCompilationspecs()
 
@@ -196,6 +273,12 @@ Build packages specifying multiple definition trees:
 			compiler.WithContext(util.DefaultContext),
 			compiler.BackendArgs(backendArgs),
 			compiler.Concurrency(concurrency),
+			compiler.SourceDateEpoch(sourceDateEpoch),
+			compiler.WithSourcesCachePath(sourcesCache),
+			compiler.WithBuildLimits(limits),
+			compiler.WithRuntimeDeps(runtimeDeps),
+			compiler.CompressionLevel(compressionLevel),
+			compiler.ZstdLongWindow(zstdLong),
 			compiler.WithCompressionType(types.CompressionImplementation(compressionType))}
 
 		if pushFinalImages {
@@ -216,7 +299,40 @@ Build packages specifying multiple definition trees:
 
 		luetCompiler := compiler.NewLuetCompiler(compilerBackend, generalRecipe.GetDatabase(), compileropts...)
 
-		if full {
+		if changedSince != "" {
+			files, err := tree.ChangedFiles(changedSince, treePaths...)
+			if err != nil {
+				util.DefaultContext.Fatal(err.Error())
+			}
+			for _, p := range tree.PackagesOfFiles(generalRecipe.GetDatabase(), files) {
+				spec, err := luetCompiler.FromPackage(p)
+				if err != nil {
+					util.DefaultContext.Fatal("Error: " + err.Error())
+				}
+				util.DefaultContext.Info(":package: Selecting changed", p.HumanReadableString())
+				spec.SetOutputPath(dst)
+				compilerSpecs.Add(spec)
+			}
+			if compilerSpecs.Len() == 0 {
+				util.DefaultContext.Info("No package changed since", changedSince)
+				return
+			}
+
+			if pretend || keepGoing {
+				// Show also the reverse dependencies which would be built, or
+				// build them along with the changed packages, skipping only
+				// the ones depending on failed builds
+				revdepSpecs, err := luetCompiler.ComputeReverseDeps(compilerSpecs)
+				if err != nil {
+					util.DefaultContext.Fatal("Error: " + err.Error())
+				}
+				for _, spec := range revdepSpecs.All() {
+					compilerSpecs.Add(spec)
+				}
+			} else {
+				revdeps = true
+			}
+		} else if full {
 			specs, err := luetCompiler.FromDatabase(generalRecipe.GetDatabase(), true, dst)
 			if err != nil {
 				util.DefaultContext.Fatal(err.Error())
@@ -255,12 +371,42 @@ Build packages specifying multiple definition trees:
 			}
 		}
 
+		compile := func() ([]*artifact.PackageArtifact, []error) {
+			switch {
+			case revdeps:
+				return luetCompiler.CompileWithReverseDeps(privileged, compilerSpecs)
+			case keepGoing:
+				return luetCompiler.CompileKeepGoing(privileged, compilerSpecs)
+			default:
+				return luetCompiler.CompileParallel(privileged, compilerSpecs)
+			}
+		}
+
+		buildAll := func(dst string) (artifacts []*artifact.PackageArtifact, errs []error) {
+			for _, platform := range platforms {
+				luetCompiler.Options.Platform = platform
+				// Artifacts of each platform go in their own folder
+				platformDst := dst
+				if !platform.IsZero() {
+					platformDst = filepath.Join(dst, platform.DirName())
+					if err := os.MkdirAll(platformDst, os.ModePerm); err != nil {
+						util.DefaultContext.Fatal("Failed creating the destination of platform", platform.String(), ":", err.Error())
+					}
+					util.DefaultContext.Info(":gear: Building for platform", platform.String())
+				}
+				for _, spec := range compilerSpecs.All() {
+					spec.SetOutputPath(platformDst)
+				}
+				a, e := compile()
+				artifacts = append(artifacts, a...)
+				errs = append(errs, e...)
+			}
+			return
+		}
+
 		var artifact []*artifact.PackageArtifact
 		var errs []error
-		if revdeps {
-			artifact, errs = luetCompiler.CompileWithReverseDeps(privileged, compilerSpecs)
-
-		} else if pretend {
+		if pretend && !revdeps {
 			var toCalculate []*types.LuetCompilationSpec
 			if full {
 				var err error
@@ -312,17 +458,77 @@ Build packages specifying multiple definition trees:
 				}
 			}
 		} else {
+			artifact, errs = buildAll(dst)
+		}
 
-			artifact, errs = luetCompiler.CompileParallel(privileged, compilerSpecs)
+		report := luetCompiler.Report()
+		if reportJSON != "" {
+			if err := report.WriteJSON(reportJSON); err != nil {
+				util.DefaultContext.Error("Failed writing JSON report: " + err.Error())
+			}
+		}
+		if reportJUnit != "" {
+			if err := report.WriteJUnit(reportJUnit); err != nil {
+				util.DefaultContext.Error("Failed writing JUnit report: " + err.Error())
+			}
+		}
+		for _, a := range artifact {
+			util.DefaultContext.Info("Artifact generated:", a.Path)
 		}
 		if len(errs) != 0 {
+			util.DefaultContext.Error(fmt.Sprintf("%d package(s) failed to build:", len(errs)))
+			if timedOut := report.TimedOut(); timedOut != 0 {
+				util.DefaultContext.Error(fmt.Sprintf("%d package(s) timed out", timedOut))
+			}
+			if skipped := report.Skipped(); skipped != 0 {
+				util.DefaultContext.Error(fmt.Sprintf("%d package(s) skipped as depending on failed ones", skipped))
+			}
 			for _, e := range errs {
+				var buildErr *compiler.BuildError
+				if errors.As(e, &buildErr) && buildErr.Package != nil {
+					msg := ":x: " + buildErr.Package.HumanReadableString()
+					if backend.IsTimeout(e) {
+						msg = ":hourglass: " + buildErr.Package.HumanReadableString() + " timed out"
+					}
+					if buildErr.LogFile != "" {
+						msg += " (log: " + buildErr.LogFile + ")"
+					}
+					util.DefaultContext.Error(msg)
+				}
 				util.DefaultContext.Error("Error: " + e.Error())
 			}
 			util.DefaultContext.Fatal("Bailing out")
 		}
-		for _, a := range artifact {
-			util.DefaultContext.Info("Artifact generated:", a.Path)
+
+		if verifyReproducible && !pretend {
+			verifyDst, err := util.DefaultContext.TempDir("reproducible")
+			helpers.CheckErr(err)
+			defer os.RemoveAll(verifyDst)
+
+			util.DefaultContext.Info(":repeat: Building again from scratch to verify reproducibility")
+			// Neither cached nor pulled images must be reused
+			luetCompiler.Options.PullFirst = false
+			luetCompiler.Options.Rebuild = true
+			luetCompiler.Options.BackendArgs = append(luetCompiler.Options.BackendArgs, "--no-cache")
+
+			second, errs := buildAll(verifyDst)
+			for _, e := range errs {
+				util.DefaultContext.Error("Error: " + e.Error())
+			}
+			if len(errs) != 0 {
+				util.DefaultContext.Fatal("Second build failed, cannot verify reproducibility")
+			}
+
+			mismatches, err := compiler.CompareArtifacts(artifact, second)
+			helpers.CheckErr(err)
+			if len(mismatches) != 0 {
+				util.DefaultContext.Error(fmt.Sprintf("%d artifact(s) are not reproducible:", len(mismatches)))
+				for _, m := range mismatches {
+					util.DefaultContext.Error(":x: " + m.String())
+				}
+				util.DefaultContext.Fatal("Bailing out")
+			}
+			util.DefaultContext.Success(fmt.Sprintf(":white_check_mark: %d artifact(s) are reproducible", len(artifact)))
 		}
 	},
 }
@@ -349,7 +555,9 @@ func init() {
 	buildCmd.Flags().StringSliceP("backend-args", "a", []string{}, "Backend args")
 
 	buildCmd.Flags().String("destination", filepath.Join(path, "build"), "Destination folder")
-	buildCmd.Flags().String("compression", "none", "Compression alg: none, gzip, zstd")
+	buildCmd.Flags().String("compression", "none", "Compression alg: none, gzip, zstd, xz, lz4")
+	buildCmd.Flags().Int("compression-level", 0, "Compression level: 1-9 (1-22 for zstd), 0 for the default one")
+	buildCmd.Flags().Bool("zstd-long", false, "Enable zstd long distance matching, for better ratios on large packages")
 	buildCmd.Flags().String("image-repository", "luet/cache", "Default base image string for generated image")
 	buildCmd.Flags().Bool("push", false, "Push images to a hub")
 	buildCmd.Flags().Bool("pull", false, "Pull images from a hub")
@@ -366,6 +574,19 @@ func init() {
 	buildCmd.Flags().Bool("from-repositories", false, "Consume the user-defined repositories to pull specfiles from")
 	buildCmd.Flags().Bool("rebuild", false, "To combine with --pull. Allows to rebuild the target package even if an image is available, against a local values file")
 	buildCmd.Flags().Bool("pretend", false, "Just print what packages will be compiled")
+	buildCmd.Flags().Bool("keep-going", false, "Keep building the packages which don't depend on failed ones")
+	buildCmd.Flags().String("report-junit", "", "Write a JUnit XML build report to the given file")
+	buildCmd.Flags().String("report-json", "", "Write a JSON build report to the given file")
+	buildCmd.Flags().String("platform", "", "Comma separated list of platforms to build for (e.g. linux/amd64,linux/arm64). Artifacts of each platform are written in a sub-folder of the destination")
+	buildCmd.Flags().Bool("reproducible", false, "Generate reproducible artifacts, using SOURCE_DATE_EPOCH (or the Unix epoch, if unset) as build time")
+	buildCmd.Flags().Bool("verify-reproducible", false, "Build a second time from scratch and fail if the artifacts differ. Implies --reproducible")
+	buildCmd.Flags().String("build-timeout", "", "Default maximum time of each image build of a package (e.g. 1h), packages can override it with 'timeout'")
+	buildCmd.Flags().String("build-memory", "", "Default memory limit of the build containers (e.g. 4g), packages can override it with 'memory'")
+	buildCmd.Flags().String("build-cpus", "", "Default number of CPUs the build containers can use (e.g. 2), packages can override it with 'cpus'")
+	buildCmd.Flags().Bool("build-no-network", false, "Run the build containers without network by default, packages can override it with 'network'")
+	buildCmd.Flags().String("changed-since", "", "Build only the packages changed since the given git revision, along with their reverse dependencies")
+	buildCmd.Flags().String("runtime-deps", "warn", "What to do with the runtime dependencies detected from the shared libraries linked by the packages, not in their requires ( available: none,warn,add )")
+	buildCmd.Flags().String("sources-cache", "", "Folder where package sources are downloaded to (defaults to the user cache folder)")
 	buildCmd.Flags().StringArrayP("pull-repository", "p", []string{}, "A list of repositories to pull the cache from")
 
 	buildCmd.Flags().StringP("output", "o", "terminal", "Output format ( Defaults: terminal, available: json,yaml )")
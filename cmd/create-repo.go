@@ -15,6 +15,7 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 
@@ -54,6 +55,10 @@ Change compression method:
 Create a repository from the metadata description defined in the luet.yaml config file:
 
 	$ luet create-repo --repo repository1
+
+Keep only the 3 newest versions of each package, removing the older artifacts not referenced by snapshots:
+
+	$ luet create-repo --keep 3
 `,
 	PreRun: func(cmd *cobra.Command, args []string) {
 		viper.BindPFlag("packages", cmd.Flags().Lookup("packages"))
@@ -153,6 +158,19 @@ Create a repository from the metadata description defined in the luet.yaml confi
 			)
 		}
 
+		if keep, _ := cmd.Flags().GetInt("keep"); keep > 0 {
+			keepSnapshots, _ := cmd.Flags().GetStringSlice("keep-snapshot")
+			pruneSnapshots, _ := cmd.Flags().GetBool("prune-snapshots")
+			output := installer.NewSystemRepository(types.LuetRepository{Name: name, Type: t, Urls: []string{dst}})
+			res, err := installer.PrunePackages(util.DefaultContext, viper.GetString("packages"), output, installer.PruneOptions{
+				Keep:           keep,
+				Snapshots:      keepSnapshots,
+				PruneSnapshots: pruneSnapshots,
+			})
+			helpers.CheckErr(err)
+			util.DefaultContext.Info(fmt.Sprintf("Pruned %d artifacts and %d snapshots", len(res.Artifacts), len(res.Snapshots)))
+		}
+
 		repo, err = installer.GenerateRepository(opts...)
 		helpers.CheckErr(err)
 
@@ -172,6 +190,8 @@ Create a repository from the metadata description defined in the luet.yaml confi
 			metaFile.SetFileName(metaName)
 		}
 		repo.SetSnapshotID(snapshotID)
+		deltas, _ := cmd.Flags().GetInt("deltas")
+		repo.SetDeltas(deltas)
 		repo.SetRepositoryFile(installer.REPOFILE_TREE_KEY, treeFile)
 		repo.SetRepositoryFile(installer.REPOFILE_META_KEY, metaFile)
 
@@ -201,12 +221,16 @@ func init() {
 	createrepoCmd.Flags().Bool("push-images", false, "Enable/Disable docker image push for docker repositories")
 	createrepoCmd.Flags().Bool("from-metadata", false, "Consider metadata files from the packages folder while indexing the new tree")
 
-	createrepoCmd.Flags().String("tree-compression", "gzip", "Compression alg: none, gzip, zstd")
+	createrepoCmd.Flags().String("tree-compression", "gzip", "Compression alg: none, gzip, zstd, xz, lz4")
 	createrepoCmd.Flags().String("tree-filename", installer.TREE_TARBALL, "Repository tree filename")
-	createrepoCmd.Flags().String("meta-compression", "none", "Compression alg: none, gzip, zstd")
+	createrepoCmd.Flags().String("meta-compression", "none", "Compression alg: none, gzip, zstd, xz, lz4")
 	createrepoCmd.Flags().String("meta-filename", installer.REPOSITORY_METAFILE+".tar", "Repository metadata filename")
 	createrepoCmd.Flags().Bool("from-repositories", false, "Consume the user-defined repositories to pull specfiles from")
 	createrepoCmd.Flags().String("snapshot-id", "", "Unique ID to use when creating repository snapshots")
+	createrepoCmd.Flags().Int("deltas", 10, "Number of revision deltas to publish, to let clients a few revisions behind sync only the changes (0 disables them)")
+	createrepoCmd.Flags().Int("keep", 0, "Remove all but the given number of versions of each package from the packages folder and the repository (0 keeps all of them)")
+	createrepoCmd.Flags().Bool("prune-snapshots", false, "Remove the snapshots not given with --keep-snapshot when pruning with --keep")
+	createrepoCmd.Flags().StringSlice("keep-snapshot", []string{}, "Snapshot to keep when pruning snapshots, along with the artifacts it refers to")
 
 	RootCmd.AddCommand(createrepoCmd)
 }
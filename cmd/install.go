@@ -43,6 +43,10 @@ To not install deps of a package:
 To force install a package:
 	
 	$ luet install --force utils/busybox ...
+
+To install exactly the packages of a lock file written by "luet lock":
+
+	$ luet install --locked luet.lock
 `,
 	Aliases: []string{"i"},
 	PreRun: func(cmd *cobra.Command, args []string) {
@@ -54,6 +58,11 @@ To force install a package:
 	Run: func(cmd *cobra.Command, args []string) {
 		var toInstall types.Packages
 
+		locked, _ := cmd.Flags().GetString("locked")
+		if locked != "" && len(args) > 0 {
+			util.DefaultContext.Fatal("Packages can't be specified along with --locked")
+		}
+
 		for _, a := range args {
 			pack, err := helpers.ParsePackageStr(a)
 			if err != nil {
@@ -89,7 +98,17 @@ To force install a package:
 			Database: util.SystemDB(util.DefaultContext.Config),
 			Target:   util.DefaultContext.Config.System.Rootfs,
 		}
-		err := inst.Install(toInstall, system)
+
+		var err error
+		if locked != "" {
+			var lock *installer.LockFile
+			lock, err = installer.ReadLockFile(locked)
+			if err == nil {
+				err = inst.InstallLocked(lock, system)
+			}
+		} else {
+			err = inst.Install(toInstall, system)
+		}
 		if err != nil {
 			util.DefaultContext.Fatal("Error: " + err.Error())
 		}
@@ -106,6 +125,7 @@ func init() {
 	installCmd.Flags().Bool("solver-concurrent", false, "Use concurrent solver (experimental)")
 	installCmd.Flags().BoolP("yes", "y", false, "Don't ask questions")
 	installCmd.Flags().Bool("download-only", false, "Download only")
+	installCmd.Flags().String("locked", "", "Install exactly the packages of the given lock file, without solving")
 	installCmd.Flags().StringArray("finalizer-env", []string{},
 		"Set finalizer environment in the format key=value.")
 
@@ -0,0 +1,81 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+package cmd
+
+import (
+	helpers "github.com/mudler/luet/cmd/helpers"
+	"github.com/mudler/luet/cmd/util"
+	"github.com/mudler/luet/pkg/api/core/types"
+	installer "github.com/mudler/luet/pkg/installer"
+
+	"github.com/spf13/cobra"
+)
+
+var lockCmd = &cobra.Command{
+	Use:   "lock <pkg1> <pkg2> ...",
+	Short: "Write a lock file for a set of packages",
+	Long: `Solves the given packages against the system repositories and writes a lock file
+with the exact artifacts of the solution:
+
+	$ luet lock -o luet.lock utils/busybox utils/yq ...
+
+The lock file can then be installed without solving, getting the same package set every time:
+
+	$ luet install --locked luet.lock
+
+The solution is computed as for an empty system. Installing from the lock file fails if
+any repository revision or artifact checksum changed since it was written.
+`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var toLock types.Packages
+
+		for _, a := range args {
+			pack, err := helpers.ParsePackageStr(a)
+			if err != nil {
+				util.DefaultContext.Fatal("Invalid package string ", a, ": ", err.Error())
+			}
+			toLock = append(toLock, pack)
+		}
+
+		output, _ := cmd.Flags().GetString("output")
+		relax, _ := cmd.Flags().GetBool("relax")
+
+		inst := installer.NewLuetInstaller(installer.LuetInstallerOptions{
+			Concurrency:         util.DefaultContext.Config.General.Concurrency,
+			SolverOptions:       util.DefaultContext.Config.Solver,
+			Relaxed:             relax,
+			PackageRepositories: util.DefaultContext.Config.SystemRepositories,
+			Context:             util.DefaultContext,
+		})
+
+		lock, err := inst.Lock(toLock)
+		if err != nil {
+			util.DefaultContext.Fatal("Error: " + err.Error())
+		}
+
+		if err := lock.WriteFile(output); err != nil {
+			util.DefaultContext.Fatal("Error: " + err.Error())
+		}
+		util.DefaultContext.Info("Locked", len(lock.Packages), "packages in", output)
+	},
+}
+
+func init() {
+	lockCmd.Flags().StringP("output", "o", "luet.lock", "Path of the lock file to write")
+	lockCmd.Flags().Bool("relax", false, "Relax installation constraints")
+
+	RootCmd.AddCommand(lockCmd)
+}
@@ -52,6 +52,11 @@ Afterwards, you can use the content generated and associate it with a tree and a
 		dst := viper.GetString("destination")
 		compressionType := viper.GetString("compression")
 		concurrency := util.DefaultContext.Config.General.Concurrency
+		compressionLevel, _ := cmd.Flags().GetInt("compression-level")
+		zstdLong, _ := cmd.Flags().GetBool("zstd-long")
+		if err := artifact.ValidateCompression(types.CompressionImplementation(compressionType), compressionLevel); err != nil {
+			util.DefaultContext.Fatal(err.Error())
+		}
 
 		if len(args) != 1 {
 			util.DefaultContext.Fatal("You must specify a package name")
@@ -67,7 +72,10 @@ Afterwards, you can use the content generated and associate it with a tree and a
 		spec := &types.LuetCompilationSpec{Package: p}
 		a := artifact.NewPackageArtifact(filepath.Join(dst, p.GetFingerPrint()+".package.tar"))
 		a.CompressionType = types.CompressionImplementation(compressionType)
-		err = a.Compress(sourcePath, concurrency)
+		err = a.Compress(sourcePath, concurrency,
+			artifact.WithCompressionLevel(compressionLevel),
+			artifact.WithZstdLongWindow(zstdLong),
+		)
 		if err != nil {
 			util.DefaultContext.Fatal("failed compressing ", packageName, ": ", err.Error())
 		}
@@ -92,7 +100,9 @@ func init() {
 	}
 	packCmd.Flags().String("source", path, "Source folder")
 	packCmd.Flags().String("destination", path, "Destination folder")
-	packCmd.Flags().String("compression", "gzip", "Compression alg: none, gzip")
+	packCmd.Flags().String("compression", "gzip", "Compression alg: none, gzip, zstd, xz, lz4")
+	packCmd.Flags().Int("compression-level", 0, "Compression level: 1-9 (1-22 for zstd), 0 for the default one")
+	packCmd.Flags().Bool("zstd-long", false, "Enable zstd long distance matching, for better ratios on large packages")
 
 	RootCmd.AddCommand(packCmd)
 }
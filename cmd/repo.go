@@ -35,5 +35,10 @@ func init() {
 		NewRepoGetCommand(),
 		NewRepoListCommand(),
 		NewRepoUpdateCommand(),
+		NewRepoMirrorCommand(),
+		NewRepoPruneCommand(),
+		NewRepoListSnapshotsCommand(),
+		NewRepoDiffCommand(),
+		NewRepoCheckCommand(),
 	)
 }
@@ -0,0 +1,134 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@luet.io>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmd_repo
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ghodss/yaml"
+	"github.com/mudler/luet/cmd/util"
+	"github.com/mudler/luet/pkg/api/core/types"
+	installer "github.com/mudler/luet/pkg/installer"
+	"github.com/pterm/pterm"
+
+	"github.com/spf13/cobra"
+)
+
+func NewRepoCheckCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "check [OPTIONS] <repository>",
+		Short: "Check the consistency of a repository",
+		Long: `Checks that a repository can be installed from: the checksums of its metadata files and of the
+artifacts in its index, that every package of its tree has an artifact, and that the runtime requirements
+of its packages are available in it or in the repositories given with --dependency.
+
+Artifacts of docker repositories are only checked to be available in the registry.
+The command exits with a non-zero status if any problem is found.`,
+		Example: `
+# Check a configured repository:
+$> luet repo check luet
+
+# Check a repository built locally, which depends on the packages of another repository:
+$> luet repo check --source-type disk build/ --dependency luet
+
+# Get a report for CI:
+$> luet repo check --source-type http https://example.com/repo -o json
+`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			sourceType, _ := cmd.Flags().GetString("source-type")
+			dependencies, _ := cmd.Flags().GetStringSlice("dependency")
+			out, _ := cmd.Flags().GetString("output")
+
+			var repo *installer.LuetSystemRepository
+			if sourceType != "" {
+				repo = installer.NewSystemRepository(types.LuetRepository{Name: args[0], Type: sourceType, Urls: []string{args[0]}})
+			} else {
+				r, err := util.DefaultContext.Config.GetSystemRepository(args[0])
+				if err != nil {
+					util.DefaultContext.Fatal(err.Error())
+				}
+				repo = installer.NewSystemRepository(*r)
+			}
+
+			opts := installer.CheckOptions{}
+			for _, name := range dependencies {
+				r, err := util.DefaultContext.Config.GetSystemRepository(name)
+				if err != nil {
+					util.DefaultContext.Fatal(err.Error())
+				}
+				synced, err := installer.NewSystemRepository(*r).Sync(util.DefaultContext, false)
+				if err != nil {
+					util.DefaultContext.Fatal("Error on sync repository " + name + ": " + err.Error())
+				}
+				opts.Dependencies = append(opts.Dependencies, synced)
+			}
+
+			report, err := installer.CheckRepository(util.DefaultContext, repo, opts)
+			if err != nil {
+				util.DefaultContext.Fatal(err.Error())
+			}
+
+			switch out {
+			case "yaml", "json":
+				y, err := yaml.Marshal(report)
+				if err != nil {
+					util.DefaultContext.Fatal(err.Error())
+				}
+				if out == "json" {
+					if y, err = yaml.YAMLToJSON(y); err != nil {
+						util.DefaultContext.Fatal(err.Error())
+					}
+				}
+				fmt.Println(string(y))
+			default:
+				printCheckReport(report)
+			}
+
+			if !report.OK() {
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().String("source-type", "", "Type of the repository when given as an URL or a path (disk, http, docker)")
+	cmd.Flags().StringSlice("dependency", []string{}, "Name of a configured repository providing requirements of the packages")
+	cmd.Flags().StringP("output", "o", "terminal", "Output format ( Defaults: terminal, available: json,yaml )")
+
+	return cmd
+}
+
+func printCheckReport(r *installer.CheckReport) {
+	fmt.Printf("Repository %s: revision %d, %d packages, %d artifacts\n", pterm.LightBlue(r.Repository),
+		r.Revision, r.Packages, r.Artifacts)
+	if r.OK() {
+		fmt.Println(pterm.LightGreen("No problems found"))
+		return
+	}
+
+	fmt.Printf("\n%s (%d):\n", pterm.LightRed("Problems"), len(r.Problems))
+	for _, p := range r.Problems {
+		subject := p.Package
+		if p.File != "" {
+			if subject != "" {
+				subject += " "
+			}
+			subject += "(" + p.File + ")"
+		}
+		fmt.Printf("  [%s] %s: %s\n", p.Kind, subject, p.Message)
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@luet.io>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmd_repo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/mudler/luet/cmd/util"
+	"github.com/mudler/luet/pkg/api/core/types"
+	installer "github.com/mudler/luet/pkg/installer"
+	"github.com/pterm/pterm"
+
+	"github.com/spf13/cobra"
+)
+
+func NewRepoDiffCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "diff [OPTIONS] <repository>",
+		Short: "Show the package changes between two states of a repository",
+		Long: `Compares two states of a repository, and shows the packages added, removed, upgraded and rebuilt
+with the same version but different artifacts.
+
+A state is either "latest", the ID of a snapshot or a revision of the repository. By default the latest
+revision is compared with the previous one. Revisions are resolved by listing the snapshots of the repository,
+so they can be compared only if the repository is generated with --snapshot-id, and only snapshot IDs can be
+given for http repositories.`,
+		Example: `
+# Show what changed with the last revision:
+$> luet repo diff luet
+
+# Write the changelog between two snapshots for the release notes:
+$> luet repo diff luet --from release-1 --to release-2 -o markdown
+
+# Compare a repository built locally with one of its revisions:
+$> luet repo diff --source-type disk build/ --from 12
+`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			sourceType, _ := cmd.Flags().GetString("source-type")
+			from, _ := cmd.Flags().GetString("from")
+			to, _ := cmd.Flags().GetString("to")
+			out, _ := cmd.Flags().GetString("output")
+
+			var repo *installer.LuetSystemRepository
+			if sourceType != "" {
+				repo = installer.NewSystemRepository(types.LuetRepository{Name: args[0], Type: sourceType, Urls: []string{args[0]}})
+			} else {
+				r, err := util.DefaultContext.Config.GetSystemRepository(args[0])
+				if err != nil {
+					util.DefaultContext.Fatal(err.Error())
+				}
+				repo = installer.NewSystemRepository(*r)
+			}
+
+			diff, err := repo.Diff(util.DefaultContext, from, to)
+			if err != nil {
+				util.DefaultContext.Fatal(err.Error())
+			}
+
+			switch out {
+			case "yaml", "json":
+				y, err := yaml.Marshal(diff)
+				if err != nil {
+					util.DefaultContext.Fatal(err.Error())
+				}
+				if out == "json" {
+					if y, err = yaml.YAMLToJSON(y); err != nil {
+						util.DefaultContext.Fatal(err.Error())
+					}
+				}
+				fmt.Println(string(y))
+			case "markdown":
+				fmt.Print(diffMarkdown(repo.GetName(), diff))
+			default:
+				printDiff(repo.GetName(), diff)
+			}
+		},
+	}
+
+	cmd.Flags().String("source-type", "", "Type of the repository when given as an URL or a path (disk, http, docker)")
+	cmd.Flags().String("from", "", "State to compare from: latest, a snapshot ID or a revision (defaults to the revision before --to)")
+	cmd.Flags().String("to", "latest", "State to compare to: latest, a snapshot ID or a revision")
+	cmd.Flags().StringP("output", "o", "terminal", "Output format ( Defaults: terminal, available: json,yaml,markdown )")
+
+	return cmd
+}
+
+func stateDate(s installer.RepositoryState) string {
+	tsec, _ := strconv.ParseInt(s.LastUpdate, 10, 64)
+	return time.Unix(tsec, 0).Format("2006-01-02")
+}
+
+func changeName(c installer.PackageChange) string {
+	if c.Platform != "" {
+		return fmt.Sprintf("%s [%s]", c.Package, c.Platform)
+	}
+	return c.Package
+}
+
+func changeVersions(c installer.PackageChange) string {
+	switch {
+	case c.From == "":
+		return c.To
+	case c.To == "" || c.From == c.To:
+		return c.From
+	}
+	return c.From + " → " + c.To
+}
+
+type diffSection struct {
+	title, symbol string
+	color         func(a ...interface{}) string
+	changes       []installer.PackageChange
+}
+
+func diffSections(d *installer.RepositoryDiff) []diffSection {
+	return []diffSection{
+		{"Added", "+", pterm.LightGreen, d.Added},
+		{"Upgraded", "^", pterm.LightBlue, d.Upgraded},
+		{"Rebuilt", "~", pterm.LightYellow, d.Rebuilt},
+		{"Removed", "-", pterm.LightRed, d.Removed},
+	}
+}
+
+func printDiff(name string, d *installer.RepositoryDiff) {
+	fmt.Printf("Repository %s: revision %s (%s) -> revision %s (%s)\n", pterm.LightBlue(name),
+		pterm.LightRed(d.From.Revision), stateDate(d.From),
+		pterm.LightGreen(d.To.Revision), stateDate(d.To))
+	if d.Empty() {
+		fmt.Println("No package changes")
+		return
+	}
+
+	for _, s := range diffSections(d) {
+		if len(s.changes) == 0 {
+			continue
+		}
+		fmt.Printf("\n%s (%d):\n", s.title, len(s.changes))
+		for _, c := range s.changes {
+			fmt.Printf("  %s %s %s\n", s.color(s.symbol), changeName(c), changeVersions(c))
+		}
+	}
+}
+
+func diffMarkdown(name string, d *installer.RepositoryDiff) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## %s revision %d (%s)\n\n", name, d.To.Revision, stateDate(d.To))
+	fmt.Fprintf(&b, "Changes since revision %d (%s).\n", d.From.Revision, stateDate(d.From))
+	if d.Empty() {
+		b.WriteString("\nNo package changes.\n")
+		return b.String()
+	}
+
+	for _, s := range diffSections(d) {
+		if len(s.changes) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n### %s\n\n", s.title)
+		for _, c := range s.changes {
+			pkg := "`" + c.Package + "`"
+			if c.Platform != "" {
+				pkg += " (" + c.Platform + ")"
+			}
+			fmt.Fprintf(&b, "- %s %s\n", pkg, changeVersions(c))
+		}
+	}
+	return b.String()
+}
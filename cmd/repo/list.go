@@ -73,7 +73,7 @@ func NewRepoListCommand() *cobra.Command {
 
 						r := installer.NewSystemRepository(repo)
 						localRepo, _ := r.ReadSpecFile(filepath.Join(repobasedir,
-							installer.REPOSITORY_SPECFILE))
+							r.ReferenceFile()))
 						if localRepo != nil {
 							tsec, _ := strconv.ParseInt(localRepo.GetLastUpdate(), 10, 64)
 							repoRevision = pterm.LightRed(localRepo.GetRevision()) +
@@ -81,6 +81,10 @@ func NewRepoListCommand() *cobra.Command {
 						}
 					}
 
+					if repo.Snapshot != "" {
+						repoText += "\n  Snapshot " + pterm.LightBlue(repo.Snapshot)
+					}
+
 					if repoRevision != "" {
 						fmt.Println(
 							fmt.Sprintf("%s\n  %s\n  Revision %s", repoColor, repoText, repoRevision))
@@ -0,0 +1,101 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@luet.io>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmd_repo
+
+import (
+	"fmt"
+
+	helpers "github.com/mudler/luet/cmd/helpers"
+	"github.com/mudler/luet/cmd/util"
+	"github.com/mudler/luet/pkg/api/core/types"
+	"github.com/mudler/luet/pkg/compiler"
+	installer "github.com/mudler/luet/pkg/installer"
+
+	"github.com/spf13/cobra"
+)
+
+func NewRepoMirrorCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "mirror [OPTIONS] <repository> <destination>",
+		Short: "Mirror a repository to a folder or a container registry",
+		Long: `Copies a repository, with the artifacts of all its packages, to a local folder or to a container registry.
+Artifacts which are already in the destination are skipped, so it can be run again to keep the mirror in sync.
+
+The repository is one of the system ones, or an URL when --source-type is given.`,
+		Example: `
+# Mirror a system repository in a folder, which can be served over http:
+$> luet repo mirror luet /srv/mirrors/luet --url https://mirror.example.com/luet
+
+# Mirror only some packages, along with their runtime dependencies:
+$> luet repo mirror luet /srv/mirrors/luet --package utils/yq --package net/curl
+
+# Mirror an http repository to a registry:
+$> luet repo mirror --source-type http https://example.com/repo quay.io/example/repo --type docker
+`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			sourceType, _ := cmd.Flags().GetString("source-type")
+			t, _ := cmd.Flags().GetString("type")
+			urls, _ := cmd.Flags().GetStringSlice("url")
+			packages, _ := cmd.Flags().GetStringSlice("package")
+			force, _ := cmd.Flags().GetBool("force")
+			backendType, _ := cmd.Flags().GetString("backend")
+
+			var src *installer.LuetSystemRepository
+			if sourceType != "" {
+				src = installer.NewSystemRepository(types.LuetRepository{Name: "mirror", Type: sourceType, Urls: []string{args[0]}})
+			} else {
+				repo, err := util.DefaultContext.Config.GetSystemRepository(args[0])
+				if err != nil {
+					util.DefaultContext.Fatal(err.Error())
+				}
+				src = installer.NewSystemRepository(*repo)
+			}
+
+			opts := installer.MirrorOptions{Type: t, Urls: urls, Force: force}
+			for _, p := range packages {
+				pack, err := helpers.ParsePackageStr(p)
+				if err != nil {
+					util.DefaultContext.Fatal("Invalid package string ", p, ": ", err.Error())
+				}
+				opts.Packages = append(opts.Packages, pack)
+			}
+			if t == installer.DockerRepositoryType {
+				b, err := compiler.NewBackend(util.DefaultContext, backendType)
+				if err != nil {
+					util.DefaultContext.Fatal(err.Error())
+				}
+				opts.Backend = b
+			}
+
+			res, err := installer.MirrorRepository(util.DefaultContext, src, args[1], opts)
+			if err != nil {
+				util.DefaultContext.Fatal(err.Error())
+			}
+			util.DefaultContext.Success(fmt.Sprintf("Repository %s mirrored to %s: %d artifacts copied, %d already up to date",
+				src.GetName(), args[1], res.Copied, res.Skipped))
+		},
+	}
+
+	cmd.Flags().String("source-type", "", "Type of the repository when given as an URL (disk, http, docker)")
+	cmd.Flags().String("type", installer.DiskRepositoryType, "Type of the destination (disk, docker)")
+	cmd.Flags().StringSlice("url", []string{}, "URLs advertised by the mirrored repository (defaults to the source ones)")
+	cmd.Flags().StringSlice("package", []string{}, "Mirror only the given packages, along with their runtime dependencies")
+	cmd.Flags().Bool("force", false, "Copy again the artifacts already in the destination")
+	cmd.Flags().String("backend", "docker", "Backend used to push images to docker destinations (docker, buildah)")
+
+	return cmd
+}
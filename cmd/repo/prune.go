@@ -0,0 +1,83 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@luet.io>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmd_repo
+
+import (
+	"fmt"
+
+	"github.com/mudler/luet/cmd/util"
+	installer "github.com/mudler/luet/pkg/installer"
+
+	"github.com/spf13/cobra"
+)
+
+func NewRepoPruneCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "prune [OPTIONS] <path>",
+		Short: "Remove old package versions from a repository",
+		Long: `Removes from the repository generated in a folder the artifacts of all but the newest versions of each package.
+The artifacts referenced by the snapshots are kept. With --prune-snapshots, the snapshots which aren't explicitly
+retained with --keep-snapshot are removed too.
+
+The tree and the index of the repository are regenerated, bumping its revision.`,
+		Example: `
+# Keep the 3 newest versions of each package:
+$> luet repo prune build/
+
+# Keep only the newest version, removing all the snapshots but release-1 and keeping what it refers to:
+$> luet repo prune build/ --keep 1 --prune-snapshots --keep-snapshot release-1
+
+# Show what would be removed:
+$> luet repo prune build/ --dry-run
+`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			keep, _ := cmd.Flags().GetInt("keep")
+			snapshots, _ := cmd.Flags().GetStringSlice("keep-snapshot")
+			pruneSnapshots, _ := cmd.Flags().GetBool("prune-snapshots")
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+			res, err := installer.PruneRepository(util.DefaultContext, args[0], installer.PruneOptions{
+				Keep:           keep,
+				Snapshots:      snapshots,
+				PruneSnapshots: pruneSnapshots,
+				DryRun:         dryRun,
+			})
+			if err != nil {
+				util.DefaultContext.Fatal(err.Error())
+			}
+
+			if dryRun {
+				for _, id := range res.Snapshots {
+					util.DefaultContext.Info("Snapshot", id, "would be removed")
+				}
+				for _, a := range res.Artifacts {
+					util.DefaultContext.Info(a.CompileSpec.GetPackage().HumanReadableString(), a.RepositoryPath(), "would be removed")
+				}
+				return
+			}
+			util.DefaultContext.Success(fmt.Sprintf("Repository %s pruned: %d artifacts and %d snapshots removed",
+				args[0], len(res.Artifacts), len(res.Snapshots)))
+		},
+	}
+
+	cmd.Flags().Int("keep", 3, "Number of versions to keep for each package")
+	cmd.Flags().Bool("prune-snapshots", false, "Remove the snapshots not given with --keep-snapshot")
+	cmd.Flags().StringSlice("keep-snapshot", []string{}, "Snapshot to keep when pruning snapshots, along with the artifacts it refers to")
+	cmd.Flags().Bool("dry-run", false, "Only show what would be removed")
+
+	return cmd
+}
@@ -0,0 +1,98 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@luet.io>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmd_repo
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/mudler/luet/cmd/util"
+	installer "github.com/mudler/luet/pkg/installer"
+
+	"github.com/spf13/cobra"
+)
+
+func NewRepoListSnapshotsCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "list-snapshots [OPTIONS] <repository>",
+		Short: "List the snapshots of a repository",
+		Long: `Lists the snapshots of one of the system repositories, which it can be pinned to by setting
+the snapshot field of its configuration. Snapshots of http repositories can't be listed.`,
+		Example: `
+$> luet repo list-snapshots luet
+
+# Pin the repository to a snapshot, in /etc/luet/repos.conf.d/luet.yml:
+name: "luet"
+type: "docker"
+snapshot: "20220204175357"
+urls:
+  - "quay.io/luet/base"
+`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			out, _ := cmd.Flags().GetString("output")
+			quiet, _ := cmd.Flags().GetBool("quiet")
+
+			repo, err := util.DefaultContext.Config.GetSystemRepository(args[0])
+			if err != nil {
+				util.DefaultContext.Fatal(err.Error())
+			}
+			snapshots, err := installer.NewSystemRepository(*repo).Snapshots(util.DefaultContext)
+			if err != nil {
+				util.DefaultContext.Fatal(err.Error())
+			}
+
+			switch out {
+			case "yaml", "json":
+				y, err := yaml.Marshal(snapshots)
+				if err != nil {
+					util.DefaultContext.Fatal(err.Error())
+				}
+				if out == "json" {
+					if y, err = yaml.YAMLToJSON(y); err != nil {
+						util.DefaultContext.Fatal(err.Error())
+					}
+				}
+				fmt.Println(string(y))
+			default:
+				if quiet {
+					for _, s := range snapshots {
+						fmt.Println(s.ID)
+					}
+					return
+				}
+				t := &util.TableWriter{}
+				t.AppendRow([]string{"Snapshot", "Revision", "Date", ""})
+				for _, s := range snapshots {
+					tsec, _ := strconv.ParseInt(s.LastUpdate, 10, 64)
+					pinned := ""
+					if s.ID == repo.Snapshot {
+						pinned = "pinned"
+					}
+					t.AppendRow([]string{s.ID, strconv.Itoa(s.Revision), time.Unix(tsec, 0).String(), pinned})
+				}
+				t.Render()
+			}
+		},
+	}
+
+	cmd.Flags().StringP("output", "o", "terminal", "Output format ( Defaults: terminal, available: json,yaml )")
+	cmd.Flags().BoolP("quiet", "q", false, "Show only the snapshot IDs")
+
+	return cmd
+}
@@ -70,6 +70,12 @@ $> luet repo update repo1 repo2
 					}
 				}
 			}
+
+			// Cached solutions are keyed on repository revisions and would
+			// not be hit anymore after an update.
+			if err := installer.CleanSolverCache(util.DefaultContext); err != nil {
+				util.DefaultContext.Warning("Failed cleaning solver cache: " + err.Error())
+			}
 		},
 	}
 
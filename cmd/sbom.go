@@ -0,0 +1,85 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+
+	helpers "github.com/mudler/luet/cmd/helpers"
+	"github.com/mudler/luet/cmd/util"
+	"github.com/mudler/luet/pkg/api/core/types"
+	installer "github.com/mudler/luet/pkg/installer"
+
+	"github.com/spf13/cobra"
+)
+
+var sbomCmd = &cobra.Command{
+	Use:   "sbom [<pkg> <pkg2> ...]",
+	Short: "Generate the SBOM of the installed packages",
+	Long: `Generates an SPDX bill of materials of the packages installed in the system, in the SPDX JSON form.
+
+	$ luet sbom
+
+To describe only some of the packages:
+
+	$ luet sbom system/busybox utils/yq
+
+To write it to a file:
+
+	$ luet sbom --file system.spdx.json
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		file, _ := cmd.Flags().GetString("file")
+
+		system := &installer.System{
+			Database: util.SystemDB(util.DefaultContext.Config),
+			Target:   util.DefaultContext.Config.System.Rootfs,
+		}
+
+		packs := types.Packages{}
+		for _, a := range args {
+			pack, err := helpers.ParsePackageStr(a)
+			if err != nil {
+				util.DefaultContext.Fatal("Invalid package string ", a, ": ", err.Error())
+			}
+			installed, err := system.Database.FindPackages(pack)
+			if err != nil || len(installed) == 0 {
+				util.DefaultContext.Fatal("Package ", a, " is not installed")
+			}
+			packs = append(packs, installed...)
+		}
+
+		doc := system.SBOM(util.DefaultContext, packs)
+		if file != "" {
+			if err := doc.Write(file); err != nil {
+				util.DefaultContext.Fatal("Error: " + err.Error())
+			}
+			return
+		}
+
+		dat, err := doc.JSON()
+		if err != nil {
+			util.DefaultContext.Fatal("Error: " + err.Error())
+		}
+		fmt.Println(string(dat))
+	},
+}
+
+func init() {
+	sbomCmd.Flags().StringP("file", "f", "", "Write the SBOM to the given file instead of the standard output")
+
+	RootCmd.AddCommand(sbomCmd)
+}
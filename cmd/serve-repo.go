@@ -15,10 +15,19 @@
 package cmd
 
 import (
+	"context"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
+	"github.com/docker/go-units"
 	"github.com/mudler/luet/cmd/util"
+	"github.com/mudler/luet/pkg/api/core/types"
+	"github.com/mudler/luet/pkg/api/server"
+	installer "github.com/mudler/luet/pkg/installer"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -27,7 +36,35 @@ import (
 var serverepoCmd = &cobra.Command{
 	Use:   "serve-repo",
 	Short: "Embedded micro-http server",
-	Long:  `Embedded mini http server for serving local repositories`,
+	Long: `Embedded mini http server for serving local repositories.
+
+Along with the repository files, a JSON API is served to query the packages of the repository,
+which is reloaded whenever its repository.yaml changes:
+
+	GET /api/v1/packages                    List the packages
+	GET /api/v1/search?q=<regex>            Search packages by name
+	GET /api/v1/packages/<category>/<name>  Versions, dependencies, reverse dependencies and files of a package
+	GET /api/v1/files?path=<path>           Packages owning a file
+
+With --proxy, the files of an upstream repository are served through a local cache instead. The
+upstream repository is either the name of a configured repository, or the path of a repository config
+file. Its specs are fetched again after --ttl, while its artifacts are fetched on the first request,
+verified against the checksums of its index, and served from the cache thereafter. The least recently
+used files are evicted when the cache exceeds --cache-size.
+
+The server can serve over TLS with --tls-cert and --tls-key, and require the credentials of the users of
+an htpasswd file (--htpasswd) or one of the bearer tokens in a file (--tokens). Requests are logged, and
+the requests in flight are waited for when the server is stopped.`,
+	Example: `
+# Serve a repository built locally:
+$> luet serve-repo --dir build/
+
+# Cache the packages of a configured repository for the build agents:
+$> luet serve-repo --proxy luet --cache-size 20GB --ttl 5m
+
+# Serve over TLS to authenticated users only:
+$> luet serve-repo --dir build/ --tls-cert cert.pem --tls-key key.pem --htpasswd /etc/luet/htpasswd
+`,
 	PreRun: func(cmd *cobra.Command, args []string) {
 		viper.BindPFlag("dir", cmd.Flags().Lookup("dir"))
 		viper.BindPFlag("address", cmd.Flags().Lookup("address"))
@@ -39,10 +76,52 @@ var serverepoCmd = &cobra.Command{
 		port := viper.GetString("port")
 		address := viper.GetString("address")
 
-		http.Handle("/", http.FileServer(http.Dir(dir)))
+		upstream, _ := cmd.Flags().GetString("proxy")
+		certFile, _ := cmd.Flags().GetString("tls-cert")
+		keyFile, _ := cmd.Flags().GetString("tls-key")
+		htpasswd, _ := cmd.Flags().GetString("htpasswd")
+		tokens, _ := cmd.Flags().GetString("tokens")
+		shutdownTimeout, _ := cmd.Flags().GetDuration("shutdown-timeout")
+
+		if (certFile == "") != (keyFile == "") {
+			util.DefaultContext.Fatal("Both --tls-cert and --tls-key are required to serve over TLS")
+		}
+
+		var handler http.Handler
+		if upstream != "" {
+			handler = newProxy(cmd, upstream)
+			util.DefaultContext.Info("Proxying ", upstream, " on port: ", port)
+		} else {
+			handler = server.New(util.DefaultContext, dir)
+			util.DefaultContext.Info("Serving ", dir, " on port: ", port)
+		}
 
-		util.DefaultContext.Info("Serving ", dir, " on HTTP port: ", port)
-		util.DefaultContext.Fatal(http.ListenAndServe(address+":"+port, nil))
+		if htpasswd != "" || tokens != "" {
+			auth := server.NewAuth()
+			if htpasswd != "" {
+				if err := auth.LoadHtpasswd(htpasswd); err != nil {
+					util.DefaultContext.Fatal(err.Error())
+				}
+			}
+			if tokens != "" {
+				if err := auth.LoadTokens(tokens); err != nil {
+					util.DefaultContext.Fatal(err.Error())
+				}
+			}
+			handler = auth.Handler(handler)
+		}
+		handler = server.AccessLog(util.DefaultContext, handler)
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := server.ListenAndServe(ctx, address+":"+port, handler, server.ServeOptions{
+			CertFile:        certFile,
+			KeyFile:         keyFile,
+			ShutdownTimeout: shutdownTimeout,
+		}); err != nil {
+			util.DefaultContext.Fatal(err.Error())
+		}
+		util.DefaultContext.Info("Server stopped")
 	},
 }
 
@@ -54,6 +133,58 @@ func init() {
 	serverepoCmd.Flags().String("dir", path, "Packages folder (output from build)")
 	serverepoCmd.Flags().String("port", "9090", "Listening port")
 	serverepoCmd.Flags().String("address", "0.0.0.0", "Listening address")
+	serverepoCmd.Flags().String("proxy", "", "Name or config file of an upstream repository to serve through a cache")
+	serverepoCmd.Flags().String("cache-dir", "", "Cache of the upstream repository (defaults to the proxy folder in the packages cache)")
+	serverepoCmd.Flags().Duration("ttl", time.Minute, "Time after which the upstream repository specs are fetched again")
+	serverepoCmd.Flags().String("cache-size", "10GB", "Maximum size of the cache of the upstream repository (0 for no limit)")
+	serverepoCmd.Flags().String("tls-cert", "", "Certificate file to serve over TLS")
+	serverepoCmd.Flags().String("tls-key", "", "Key file of the TLS certificate")
+	serverepoCmd.Flags().String("htpasswd", "", "htpasswd file of the users allowed, with bcrypt or SHA1 passwords")
+	serverepoCmd.Flags().String("tokens", "", "File with the bearer tokens allowed, one per line")
+	serverepoCmd.Flags().Duration("shutdown-timeout", 30*time.Second, "Time to wait for the requests in flight when shutting down")
 
 	RootCmd.AddCommand(serverepoCmd)
 }
+
+// newProxy returns a caching proxy for the upstream repository, given as a
+// configured repository name or as the path of a repository config file
+func newProxy(cmd *cobra.Command, upstream string) *server.Proxy {
+	cacheDir, _ := cmd.Flags().GetString("cache-dir")
+	ttl, _ := cmd.Flags().GetDuration("ttl")
+	cacheSize, _ := cmd.Flags().GetString("cache-size")
+
+	var repo *types.LuetRepository
+	if info, err := os.Stat(upstream); err == nil && !info.IsDir() {
+		dat, err := os.ReadFile(upstream)
+		if err != nil {
+			util.DefaultContext.Fatal(err.Error())
+		}
+		if repo, err = types.LoadRepository(dat); err != nil {
+			util.DefaultContext.Fatal("Invalid repository config " + upstream + ": " + err.Error())
+		}
+	} else {
+		r, err := util.DefaultContext.Config.GetSystemRepository(upstream)
+		if err != nil {
+			util.DefaultContext.Fatal(err.Error())
+		}
+		repo = r
+	}
+
+	size, err := units.RAMInBytes(cacheSize)
+	if err != nil {
+		util.DefaultContext.Fatal("Invalid cache size " + cacheSize + ": " + err.Error())
+	}
+	if cacheDir == "" {
+		cacheDir = filepath.Join(util.DefaultContext.Config.System.PkgsCachePath, "proxy", repo.Name)
+	}
+
+	p, err := server.NewProxy(util.DefaultContext, installer.NewSystemRepository(*repo), server.ProxyOptions{
+		CacheDir: cacheDir,
+		TTL:      ttl,
+		MaxSize:  size,
+	})
+	if err != nil {
+		util.DefaultContext.Fatal(err.Error())
+	}
+	return p
+}
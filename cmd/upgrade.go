@@ -44,8 +44,10 @@ var upgradeCmd = &cobra.Command{
 
 		yes := viper.GetBool("yes")
 		downloadOnly, _ := cmd.Flags().GetBool("download-only")
+		minimizeChanges, _ := cmd.Flags().GetBool("minimize-changes")
 
 		util.DefaultContext.Config.Solver.Implementation = types.SolverSingleCoreSimple
+		util.DefaultContext.Config.Solver.MinimizeChanges = minimizeChanges
 
 		util.DefaultContext.Debug("Solver", util.DefaultContext.GetConfig().Solver)
 
@@ -108,6 +110,7 @@ func init() {
 	upgradeCmd.Flags().Bool("solver-concurrent", false, "Use concurrent solver (experimental)")
 	upgradeCmd.Flags().BoolP("yes", "y", false, "Don't ask questions")
 	upgradeCmd.Flags().Bool("download-only", false, "Download only")
+	upgradeCmd.Flags().Bool("minimize-changes", false, "Avoid removing or downgrading installed packages where possible, and report the changes that could not be avoided (experimental)")
 	upgradeCmd.Flags().Bool("oscheck", false, "Perform automatically oschecks after upgrades")
 
 	RootCmd.AddCommand(upgradeCmd)
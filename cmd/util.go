@@ -26,7 +26,9 @@ import (
 	"github.com/docker/go-units"
 	"github.com/mudler/luet/pkg/api/core/image"
 	"github.com/mudler/luet/pkg/api/core/types"
+	"github.com/mudler/luet/pkg/api/core/types/artifact"
 	fileHelper "github.com/mudler/luet/pkg/helpers/file"
+	"github.com/mudler/luet/pkg/installer"
 	"github.com/pkg/errors"
 
 	"github.com/mudler/luet/cmd/util"
@@ -204,6 +206,42 @@ func NewExistCommand() *cobra.Command {
 	return c
 }
 
+func NewRecompressCommand() *cobra.Command {
+
+	c := &cobra.Command{
+		Use:   "recompress path",
+		Short: "Convert the artifacts of a local repository to another compression",
+		Long: `recompress converts the package archives of a repository generated with create-repo to the given compression type.
+Their checksums and the repository index are updated, and a new revision of the repository is created:
+
+	luet util recompress --compression zstd --compression-level 19 --zstd-long /path/to/repo
+`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			compression, _ := cmd.Flags().GetString("compression")
+			level, _ := cmd.Flags().GetInt("compression-level")
+			zstdLong, _ := cmd.Flags().GetBool("zstd-long")
+
+			t := types.CompressionImplementation(compression)
+			if err := artifact.ValidateCompression(t, level); err != nil {
+				util.DefaultContext.Fatal(err.Error())
+			}
+
+			err := installer.RecompressRepository(util.DefaultContext, args[0], t,
+				util.DefaultContext.Config.General.Concurrency, level, zstdLong)
+			if err != nil {
+				util.DefaultContext.Fatal(err.Error())
+			}
+			util.DefaultContext.Success("Repository recompressed with", compression)
+		},
+	}
+
+	c.Flags().String("compression", "zstd", "Compression alg: none, gzip, zstd, xz, lz4")
+	c.Flags().Int("compression-level", 0, "Compression level: 1-9 (1-22 for zstd), 0 for the default one")
+	c.Flags().Bool("zstd-long", false, "Enable zstd long distance matching, for better ratios on large packages")
+	return c
+}
+
 var utilGroup = &cobra.Command{
 	Use:   "util [command] [OPTIONS]",
 	Short: "General luet internal utilities exposed",
@@ -216,5 +254,6 @@ func init() {
 		NewUnpackCommand(),
 		NewPackCommand(),
 		NewExistCommand(),
+		NewRecompressCommand(),
 	)
 }
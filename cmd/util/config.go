@@ -251,6 +251,7 @@ func setDefaults(viper *viper.Viper) {
 	viper.SetDefault("solver.rate", 0.7)
 	viper.SetDefault("solver.discount", 1.0)
 	viper.SetDefault("solver.max_attempts", 9000)
+	viper.SetDefault("solver.cache", false)
 }
 
 // InitViper inits a new viper
@@ -273,11 +274,13 @@ func InitViper(RootCmd *cobra.Command) {
 	pflags.String("system-dbpath", "", "System db path")
 	pflags.String("system-target", "", "System rootpath")
 	pflags.String("system-engine", "", "System DB engine")
+	pflags.String("system-platform", "", "Platform of the target system (os/arch[/variant]), defaults to the host one")
 
 	pflags.String("solver-type", "", "Solver strategy ( Defaults none, available: "+solver.AvailableResolvers+" )")
 	pflags.Float32("solver-rate", 0.7, "Solver learning rate")
 	pflags.Float32("solver-discount", 1.0, "Solver discount rate")
 	pflags.Int("solver-attempts", 9000, "Solver maximum attempts")
+	pflags.Bool("solver-cache", false, "Cache solver solutions on disk")
 	pflags.Bool("live-output", true, "Show live output during build")
 
 	pflags.Bool("same-owner", true, "Maintain same owner on uncompress.")
@@ -287,10 +290,12 @@ func InitViper(RootCmd *cobra.Command) {
 	viper.BindPFlag("system.database_path", pflags.Lookup("system-dbpath"))
 	viper.BindPFlag("system.rootfs", pflags.Lookup("system-target"))
 	viper.BindPFlag("system.database_engine", pflags.Lookup("system-engine"))
+	viper.BindPFlag("system.platform", pflags.Lookup("system-platform"))
 	viper.BindPFlag("solver.type", pflags.Lookup("solver-type"))
 	viper.BindPFlag("solver.discount", pflags.Lookup("solver-discount"))
 	viper.BindPFlag("solver.rate", pflags.Lookup("solver-rate"))
 	viper.BindPFlag("solver.max_attempts", pflags.Lookup("solver-attempts"))
+	viper.BindPFlag("solver.cache", pflags.Lookup("solver-cache"))
 
 	viper.BindPFlag("logging.color", pflags.Lookup("color"))
 	viper.BindPFlag("logging.enable_emoji", pflags.Lookup("emoji"))
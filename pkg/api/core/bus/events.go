@@ -29,6 +29,8 @@ var (
 	EventPackagePostBuildArtifact pluggable.EventType = "package.post.build_artifact"
 	// EventPackagePostBuild is the event fired after a package was built
 	EventPackagePostBuild pluggable.EventType = "package.post.build"
+	// EventPackageBuildLog is the event fired when the build log of a package is complete, whether the build succeeded or not
+	EventPackageBuildLog pluggable.EventType = "package.build.log"
 
 	// Image build
 
@@ -72,6 +74,7 @@ var Manager *Bus = &Bus{
 			EventPackagePreBuildArtifact,
 			EventPackagePostBuildArtifact,
 			EventPackagePostBuild,
+			EventPackageBuildLog,
 			EventRepositoryPreBuild,
 			EventRepositoryPostBuild,
 			EventImagePreBuild,
@@ -19,13 +19,13 @@ import (
 	"io"
 	"os"
 
-	containerdCompression "github.com/containerd/containerd/archive/compression"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	"github.com/mudler/luet/pkg/api/core/types"
+	"github.com/mudler/luet/pkg/helpers"
 	"github.com/pkg/errors"
 )
 
@@ -82,7 +82,7 @@ func CreateTar(srctar, dstimageTar, imagename string, platform types.Platform) e
 		if err != nil {
 			return nil, errors.Wrap(err, "Cannot open "+srctar)
 		}
-		decompressed, err := containerdCompression.DecompressStream(f)
+		decompressed, err := helpers.DecompressStream(f)
 		if err != nil {
 			return nil, errors.Wrap(err, "Cannot open "+srctar)
 		}
@@ -0,0 +1,72 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package image
+
+import (
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	ggcrtypes "github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/mudler/luet/pkg/api/core/types"
+	"github.com/pkg/errors"
+)
+
+// PlatformImage is a remote image built for a platform
+type PlatformImage struct {
+	Platform types.Platform
+	Image    string
+}
+
+// PushIndex pushes to target a manifest list referring to the given images,
+// which must be already available in the remote registry.
+// Credentials are read from the docker configuration, as when pushing images.
+func PushIndex(target string, images []PlatformImage, opts ...remote.Option) error {
+	ref, err := name.ParseReference(target)
+	if err != nil {
+		return errors.Wrapf(err, "invalid reference '%s'", target)
+	}
+	opts = append([]remote.Option{remote.WithAuthFromKeychain(authn.DefaultKeychain)}, opts...)
+
+	idx := mutate.IndexMediaType(empty.Index, ggcrtypes.DockerManifestList)
+	for _, i := range images {
+		imgRef, err := name.ParseReference(i.Image)
+		if err != nil {
+			return errors.Wrapf(err, "invalid reference '%s'", i.Image)
+		}
+		img, err := remote.Image(imgRef, opts...)
+		if err != nil {
+			return errors.Wrapf(err, "while fetching '%s'", i.Image)
+		}
+		idx = mutate.AppendManifests(idx, mutate.IndexAddendum{
+			Add: img,
+			Descriptor: v1.Descriptor{
+				Platform: &v1.Platform{
+					OS:           i.Platform.OS,
+					Architecture: i.Platform.Arch,
+					Variant:      i.Platform.Variant,
+				},
+			},
+		})
+	}
+
+	if err := remote.WriteIndex(ref, idx, opts...); err != nil {
+		return errors.Wrapf(err, "while pushing '%s'", target)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package image_test
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/mudler/luet/pkg/api/core/image"
+	types "github.com/mudler/luet/pkg/api/core/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PushIndex", func() {
+	It("pushes a manifest list of the platform images", func() {
+		s := httptest.NewServer(registry.New())
+		defer s.Close()
+		host := strings.TrimPrefix(s.URL, "http://")
+
+		platforms := []types.Platform{
+			{OS: "linux", Arch: "amd64"},
+			{OS: "linux", Arch: "arm", Variant: "v7"},
+		}
+
+		var images []PlatformImage
+		digests := map[string]v1.Hash{}
+		for _, p := range platforms {
+			img, err := random.Image(64, 1)
+			Expect(err).ToNot(HaveOccurred())
+			ref := fmt.Sprintf("%s/repo:pkg-%s", host, p.DirName())
+			r, err := name.ParseReference(ref)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(remote.Write(r, img)).To(Succeed())
+
+			digests[p.String()], err = img.Digest()
+			Expect(err).ToNot(HaveOccurred())
+			images = append(images, PlatformImage{Platform: p, Image: ref})
+		}
+
+		target := fmt.Sprintf("%s/repo:pkg", host)
+		Expect(PushIndex(target, images)).To(Succeed())
+
+		r, err := name.ParseReference(target)
+		Expect(err).ToNot(HaveOccurred())
+		for _, p := range platforms {
+			img, err := remote.Image(r, remote.WithPlatform(v1.Platform{OS: p.OS, Architecture: p.Arch, Variant: p.Variant}))
+			Expect(err).ToNot(HaveOccurred())
+			d, err := img.Digest()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(d).To(Equal(digests[p.String()]))
+		}
+	})
+})
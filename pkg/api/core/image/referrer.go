@@ -0,0 +1,68 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package image
+
+import (
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	ggcrtypes "github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/pkg/errors"
+)
+
+// PushReferrer attaches a file to a remote image, pushing it as an OCI
+// artifact of the given media type which refers to the image as its subject.
+// Registries without the referrers API get the fallback referrers tag.
+// Credentials are read from the docker configuration, as when pushing images.
+func PushReferrer(subject string, dat []byte, mediaType string, opts ...remote.Option) error {
+	ref, err := name.ParseReference(subject)
+	if err != nil {
+		return errors.Wrapf(err, "invalid reference '%s'", subject)
+	}
+	opts = append([]remote.Option{remote.WithAuthFromKeychain(authn.DefaultKeychain)}, opts...)
+
+	desc, err := remote.Head(ref, opts...)
+	if err != nil {
+		return errors.Wrapf(err, "while fetching '%s'", subject)
+	}
+
+	img, err := mutate.Append(
+		mutate.MediaType(empty.Image, ggcrtypes.OCIManifestSchema1),
+		mutate.Addendum{Layer: static.NewLayer(dat, ggcrtypes.MediaType(mediaType))},
+	)
+	if err != nil {
+		return err
+	}
+	img = mutate.ConfigMediaType(img, ggcrtypes.MediaType(mediaType))
+	img = mutate.Subject(img, v1.Descriptor{
+		MediaType: desc.MediaType,
+		Digest:    desc.Digest,
+		Size:      desc.Size,
+	}).(v1.Image)
+
+	digest, err := img.Digest()
+	if err != nil {
+		return err
+	}
+	if err := remote.Write(ref.Context().Digest(digest.String()), img, opts...); err != nil {
+		return errors.Wrapf(err, "while pushing referrer of '%s'", subject)
+	}
+	return nil
+}
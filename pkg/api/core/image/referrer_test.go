@@ -0,0 +1,56 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package image_test
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/mudler/luet/pkg/api/core/image"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PushReferrer", func() {
+	It("attaches a file to an image", func() {
+		s := httptest.NewServer(registry.New())
+		defer s.Close()
+		host := strings.TrimPrefix(s.URL, "http://")
+
+		img, err := random.Image(64, 1)
+		Expect(err).ToNot(HaveOccurred())
+		ref := fmt.Sprintf("%s/repo:pkg", host)
+		r, err := name.ParseReference(ref)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(remote.Write(r, img)).To(Succeed())
+
+		Expect(PushReferrer(ref, []byte(`{"spdxVersion":"SPDX-2.3"}`), "application/spdx+json")).To(Succeed())
+
+		d, err := img.Digest()
+		Expect(err).ToNot(HaveOccurred())
+		idx, err := remote.Referrers(r.Context().Digest(d.String()))
+		Expect(err).ToNot(HaveOccurred())
+		m, err := idx.IndexManifest()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(m.Manifests).To(HaveLen(1))
+		Expect(string(m.Manifests[0].ArtifactType)).To(Equal("application/spdx+json"))
+	})
+})
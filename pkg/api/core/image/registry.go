@@ -0,0 +1,93 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package image
+
+import (
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/pkg/errors"
+)
+
+// Tags returns the tags of a remote repository, which has none if it
+// doesn't exist yet.
+// Credentials are read from the docker configuration, as when pushing images.
+func Tags(repository string, opts ...remote.Option) ([]string, error) {
+	repo, err := name.NewRepository(repository)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid repository '%s'", repository)
+	}
+	opts = append([]remote.Option{remote.WithAuthFromKeychain(authn.DefaultKeychain)}, opts...)
+
+	tags, err := remote.List(repo, opts...)
+	if err != nil {
+		var terr *transport.Error
+		if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+			return []string{}, nil
+		}
+		return nil, errors.Wrapf(err, "while listing tags of '%s'", repository)
+	}
+	return tags, nil
+}
+
+// ErrSharedManifest is returned by Delete when the image can only be
+// deleted along with other tags of the repository
+var ErrSharedManifest = errors.New("manifest shared with other tags")
+
+// Delete removes a remote image. Registries which don't allow to delete
+// tags get the image manifest deleted, along with all its tags: in such
+// case, the image isn't deleted if other tags of the repository point to
+// its manifest, and ErrSharedManifest is returned.
+// Credentials are read from the docker configuration, as when pushing images.
+func Delete(target string, opts ...remote.Option) error {
+	ref, err := name.ParseReference(target)
+	if err != nil {
+		return errors.Wrapf(err, "invalid reference '%s'", target)
+	}
+	opts = append([]remote.Option{remote.WithAuthFromKeychain(authn.DefaultKeychain)}, opts...)
+
+	desc, err := remote.Head(ref, opts...)
+	if err != nil {
+		return errors.Wrapf(err, "while fetching '%s'", target)
+	}
+	if err := remote.Delete(ref, opts...); err == nil {
+		return nil
+	}
+
+	tags, err := Tags(ref.Context().Name(), opts...)
+	if err != nil {
+		return err
+	}
+	for _, t := range tags {
+		if t == ref.Identifier() {
+			continue
+		}
+		other, err := remote.Head(ref.Context().Tag(t), opts...)
+		if err != nil {
+			return errors.Wrapf(err, "while fetching '%s'", ref.Context().Tag(t))
+		}
+		if other.Digest == desc.Digest {
+			return errors.Wrapf(ErrSharedManifest, "'%s' can't be deleted without '%s'", target, ref.Context().Tag(t))
+		}
+	}
+	if err := remote.Delete(ref.Context().Digest(desc.Digest.String()), opts...); err != nil {
+		return errors.Wrapf(err, "while deleting '%s'", target)
+	}
+	return nil
+}
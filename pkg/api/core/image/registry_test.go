@@ -0,0 +1,117 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package image_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/mudler/luet/pkg/api/core/image"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Registry", func() {
+	var s *httptest.Server
+	var host string
+
+	BeforeEach(func() {
+		s = httptest.NewServer(registry.New())
+		host = strings.TrimPrefix(s.URL, "http://")
+	})
+
+	AfterEach(func() {
+		s.Close()
+	})
+
+	reference := func(ref string) name.Reference {
+		r, err := name.ParseReference(ref)
+		Expect(err).ToNot(HaveOccurred())
+		return r
+	}
+
+	push := func(ref string) {
+		img, err := random.Image(64, 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(remote.Write(reference(ref), img)).To(Succeed())
+	}
+
+	It("lists and deletes tags", func() {
+		push(fmt.Sprintf("%s/repo:foo", host))
+		push(fmt.Sprintf("%s/repo:bar", host))
+
+		tags, err := Tags(fmt.Sprintf("%s/repo", host))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tags).To(ConsistOf("foo", "bar"))
+
+		Expect(Delete(fmt.Sprintf("%s/repo:foo", host))).To(Succeed())
+
+		tags, err = Tags(fmt.Sprintf("%s/repo", host))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tags).To(ConsistOf("bar"))
+	})
+
+	It("has no tags for missing repositories", func() {
+		tags, err := Tags(fmt.Sprintf("%s/missing", host))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tags).To(BeEmpty())
+	})
+
+	It("doesn't delete manifests shared with other tags", func() {
+		// Registries refusing to delete tags get the manifest deleted
+		s.Close()
+		r := registry.New()
+		s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Method == http.MethodDelete && !strings.Contains(req.URL.Path, "sha256:") {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			r.ServeHTTP(w, req)
+		}))
+		host = strings.TrimPrefix(s.URL, "http://")
+
+		img, err := random.Image(64, 1)
+		Expect(err).ToNot(HaveOccurred())
+		digest, err := img.Digest()
+		Expect(err).ToNot(HaveOccurred())
+		for _, t := range []string{"foo", "bar"} {
+			Expect(remote.Write(reference(fmt.Sprintf("%s/repo:%s", host, t)), img)).To(Succeed())
+		}
+		push(fmt.Sprintf("%s/repo:baz", host))
+		baz, err := remote.Head(reference(host + "/repo:baz"))
+		Expect(err).ToNot(HaveOccurred())
+
+		err = Delete(fmt.Sprintf("%s/repo:foo", host))
+		Expect(errors.Is(err, ErrSharedManifest)).To(BeTrue())
+		_, err = remote.Head(reference(host + "/repo@" + digest.String()))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(Delete(fmt.Sprintf("%s/repo:baz", host))).To(Succeed())
+		_, err = remote.Head(reference(host + "/repo@" + baz.Digest.String()))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("fails deleting missing images", func() {
+		Expect(Delete(fmt.Sprintf("%s/repo:missing", host))).ToNot(Succeed())
+	})
+})
@@ -0,0 +1,289 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+// Package sbom generates SPDX bills of materials of luet packages
+package sbom
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mudler/luet/pkg/api/core/types"
+	"github.com/pkg/errors"
+)
+
+const (
+	SPDXVersion = "SPDX-2.3"
+	DataLicense = "CC0-1.0"
+	NoAssertion = "NOASSERTION"
+	// MediaType is the media type of SPDX documents in JSON form
+	MediaType = "application/spdx+json"
+
+	documentID = "SPDXRef-DOCUMENT"
+)
+
+type Checksum struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"checksumValue"`
+}
+
+type CreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type ExternalRef struct {
+	Category string `json:"referenceCategory"`
+	Type     string `json:"referenceType"`
+	Locator  string `json:"referenceLocator"`
+}
+
+type VerificationCode struct {
+	Value string `json:"packageVerificationCodeValue"`
+}
+
+type Package struct {
+	SPDXID           string            `json:"SPDXID"`
+	Name             string            `json:"name"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	Homepage         string            `json:"homepage,omitempty"`
+	Summary          string            `json:"summary,omitempty"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	VerificationCode *VerificationCode `json:"packageVerificationCode,omitempty"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	CopyrightText    string            `json:"copyrightText"`
+	ExternalRefs     []ExternalRef     `json:"externalRefs,omitempty"`
+	HasFiles         []string          `json:"hasFiles,omitempty"`
+}
+
+type File struct {
+	SPDXID           string     `json:"SPDXID"`
+	FileName         string     `json:"fileName"`
+	Checksums        []Checksum `json:"checksums"`
+	LicenseConcluded string     `json:"licenseConcluded"`
+	CopyrightText    string     `json:"copyrightText"`
+}
+
+type Relationship struct {
+	Element string `json:"spdxElementId"`
+	Type    string `json:"relationshipType"`
+	Related string `json:"relatedSpdxElement"`
+}
+
+// Document is an SPDX document in its JSON form
+type Document struct {
+	SPDXVersion       string         `json:"spdxVersion"`
+	DataLicense       string         `json:"dataLicense"`
+	SPDXID            string         `json:"SPDXID"`
+	Name              string         `json:"name"`
+	DocumentNamespace string         `json:"documentNamespace"`
+	CreationInfo      CreationInfo   `json:"creationInfo"`
+	DocumentDescribes []string       `json:"documentDescribes,omitempty"`
+	Packages          []Package      `json:"packages"`
+	Files             []File         `json:"files,omitempty"`
+	Relationships     []Relationship `json:"relationships,omitempty"`
+}
+
+// FileHash is a file shipped by a package, with its content hashes
+type FileHash struct {
+	Name   string
+	SHA1   string
+	SHA256 string
+}
+
+// HashFile computes the hashes of the content read from r
+func HashFile(name string, r io.Reader) (FileHash, error) {
+	s1, s256 := sha1.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(s1, s256), r); err != nil {
+		return FileHash{}, errors.Wrapf(err, "while hashing %s", name)
+	}
+	return FileHash{
+		Name:   name,
+		SHA1:   hex.EncodeToString(s1.Sum(nil)),
+		SHA256: hex.EncodeToString(s256.Sum(nil)),
+	}, nil
+}
+
+// Entry is a package to describe in a document, with the files it ships
+type Entry struct {
+	Package *types.Package
+	Files   []FileHash
+}
+
+var invalidIDChars = regexp.MustCompile(`[^a-zA-Z0-9.-]+`)
+
+func spdxID(kind string, parts ...string) string {
+	return "SPDXRef-" + kind + "-" + invalidIDChars.ReplaceAllString(strings.Join(parts, "-"), "-")
+}
+
+func packageName(p *types.Package) string {
+	if p.GetCategory() == "" {
+		return p.GetName()
+	}
+	return p.GetCategory() + "/" + p.GetName()
+}
+
+func orNoAssertion(s string) string {
+	if s == "" {
+		return NoAssertion
+	}
+	return s
+}
+
+// New returns an SPDX document describing the given packages. The runtime
+// requirements of the packages are expressed as DEPENDS_ON relationships,
+// with the ones not described by the document added as packages on their own.
+func New(name string, created time.Time, entries ...Entry) *Document {
+	d := &Document{
+		SPDXVersion: SPDXVersion,
+		DataLicense: DataLicense,
+		SPDXID:      documentID,
+		Name:        name,
+		CreationInfo: CreationInfo{
+			Created:  created.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: luet"},
+		},
+		Packages: []Package{},
+	}
+
+	described := map[string]string{}
+	for _, e := range entries {
+		id := d.addPackage(e.Package, e.Files)
+		described[packageName(e.Package)] = id
+		d.DocumentDescribes = append(d.DocumentDescribes, id)
+		d.Relationships = append(d.Relationships, Relationship{Element: documentID, Type: "DESCRIBES", Related: id})
+	}
+
+	external := map[string]string{}
+	for _, e := range entries {
+		from := described[packageName(e.Package)]
+		for _, r := range e.Package.GetRequires() {
+			to, ok := described[packageName(r)]
+			if !ok {
+				if to, ok = external[r.HumanReadableString()]; !ok {
+					to = spdxID("Requirement", packageName(r), r.GetVersion())
+					d.Packages = append(d.Packages, Package{
+						SPDXID:           to,
+						Name:             packageName(r),
+						VersionInfo:      r.GetVersion(),
+						DownloadLocation: NoAssertion,
+						LicenseConcluded: NoAssertion,
+						LicenseDeclared:  NoAssertion,
+						CopyrightText:    NoAssertion,
+					})
+					external[r.HumanReadableString()] = to
+				}
+			}
+			d.Relationships = append(d.Relationships, Relationship{Element: from, Type: "DEPENDS_ON", Related: to})
+		}
+	}
+
+	d.DocumentNamespace = fmt.Sprintf("https://luet.io/spdx/%s-%s", invalidIDChars.ReplaceAllString(name, "-"), d.digest())
+	return d
+}
+
+func (d *Document) addPackage(p *types.Package, files []FileHash) string {
+	id := spdxID("Package", packageName(p), p.GetVersion())
+	sp := Package{
+		SPDXID:           id,
+		Name:             packageName(p),
+		VersionInfo:      p.GetVersion(),
+		DownloadLocation: NoAssertion,
+		Summary:          p.GetDescription(),
+		FilesAnalyzed:    len(files) > 0,
+		LicenseConcluded: NoAssertion,
+		LicenseDeclared:  orNoAssertion(p.GetLicense()),
+		CopyrightText:    NoAssertion,
+		ExternalRefs: []ExternalRef{{
+			Category: "PACKAGE-MANAGER",
+			Type:     "purl",
+			Locator:  fmt.Sprintf("pkg:luet/%s@%s", packageName(p), p.GetVersion()),
+		}},
+	}
+	if len(p.GetURI()) > 0 {
+		sp.DownloadLocation = p.GetURI()[0]
+		sp.Homepage = p.GetURI()[0]
+		for _, u := range p.GetURI()[1:] {
+			sp.ExternalRefs = append(sp.ExternalRefs, ExternalRef{Category: "OTHER", Type: "url", Locator: u})
+		}
+	}
+
+	sorted := append([]FileHash{}, files...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	var sha1s []string
+	for i, f := range sorted {
+		fid := fmt.Sprintf("%s-File-%d", id, i)
+		name := f.Name
+		if !strings.HasPrefix(name, "/") && !strings.HasPrefix(name, "./") {
+			name = "./" + name
+		}
+		d.Files = append(d.Files, File{
+			SPDXID:   fid,
+			FileName: name,
+			Checksums: []Checksum{
+				{Algorithm: "SHA1", Value: f.SHA1},
+				{Algorithm: "SHA256", Value: f.SHA256},
+			},
+			LicenseConcluded: NoAssertion,
+			CopyrightText:    NoAssertion,
+		})
+		sp.HasFiles = append(sp.HasFiles, fid)
+		sha1s = append(sha1s, f.SHA1)
+	}
+
+	if sp.FilesAnalyzed {
+		// See the SPDX specification on how the verification code is computed
+		sort.Strings(sha1s)
+		sum := sha1.Sum([]byte(strings.Join(sha1s, "")))
+		sp.VerificationCode = &VerificationCode{Value: hex.EncodeToString(sum[:])}
+	}
+
+	d.Packages = append(d.Packages, sp)
+	return id
+}
+
+// digest returns a hash of the document content, used to give it an unique
+// namespace
+func (d *Document) digest() string {
+	dat, _ := json.Marshal(d)
+	sum := sha256.Sum256(dat)
+	return hex.EncodeToString(sum[:8])
+}
+
+// JSON returns the document in the SPDX JSON form
+func (d *Document) JSON() ([]byte, error) {
+	return json.MarshalIndent(d, "", "  ")
+}
+
+// Write writes the document in the SPDX JSON form to the given path
+func (d *Document) Write(path string) error {
+	dat, err := d.JSON()
+	if err != nil {
+		return errors.Wrap(err, "while marshalling SBOM")
+	}
+	return os.WriteFile(path, dat, 0644)
+}
@@ -0,0 +1,162 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package sbom_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mudler/luet/pkg/api/core/sbom"
+	"github.com/mudler/luet/pkg/api/core/types"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SBOM", func() {
+	created := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	foo := &types.Package{
+		Name: "foo", Category: "app", Version: "1.0",
+		License:     "GPL-2.0",
+		Description: "The foo app",
+		Uri:         []string{"https://foo.example.com", "https://mirror.example.com/foo"},
+		PackageRequires: []*types.Package{
+			{Name: "bar", Category: "lib", Version: "2.0"},
+			{Name: "baz", Category: "lib", Version: ">=1.0"},
+		},
+	}
+	bar := &types.Package{Name: "bar", Category: "lib", Version: "2.0"}
+
+	hash := func(name, content string) sbom.FileHash {
+		h, err := sbom.HashFile(name, strings.NewReader(content))
+		Expect(err).ToNot(HaveOccurred())
+		return h
+	}
+
+	It("hashes files with SHA1 and SHA256", func() {
+		h := hash("usr/bin/foo", "foo")
+		Expect(h).To(Equal(sbom.FileHash{
+			Name:   "usr/bin/foo",
+			SHA1:   "0beec7b5ea3f0fdbc95d0dd47f3c5bc275da8a33",
+			SHA256: "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+		}))
+	})
+
+	It("describes the packages with their files", func() {
+		d := sbom.New("app/foo-1.0", created, sbom.Entry{Package: foo, Files: []sbom.FileHash{hash("usr/bin/foo", "foo"), hash("/etc/foo.conf", "conf")}})
+
+		Expect(d.SPDXVersion).To(Equal(sbom.SPDXVersion))
+		Expect(d.DataLicense).To(Equal(sbom.DataLicense))
+		Expect(d.SPDXID).To(Equal("SPDXRef-DOCUMENT"))
+		Expect(d.Name).To(Equal("app/foo-1.0"))
+		Expect(d.DocumentNamespace).To(HavePrefix("https://luet.io/spdx/app-foo-1.0-"))
+		Expect(d.CreationInfo).To(Equal(sbom.CreationInfo{Created: "2022-01-02T03:04:05Z", Creators: []string{"Tool: luet"}}))
+		Expect(d.DocumentDescribes).To(Equal([]string{"SPDXRef-Package-app-foo-1.0"}))
+
+		p := d.Packages[0]
+		Expect(p.SPDXID).To(Equal("SPDXRef-Package-app-foo-1.0"))
+		Expect(p.Name).To(Equal("app/foo"))
+		Expect(p.VersionInfo).To(Equal("1.0"))
+		Expect(p.Summary).To(Equal("The foo app"))
+		Expect(p.LicenseDeclared).To(Equal("GPL-2.0"))
+		Expect(p.LicenseConcluded).To(Equal(sbom.NoAssertion))
+		Expect(p.DownloadLocation).To(Equal("https://foo.example.com"))
+		Expect(p.Homepage).To(Equal("https://foo.example.com"))
+		Expect(p.ExternalRefs).To(Equal([]sbom.ExternalRef{
+			{Category: "PACKAGE-MANAGER", Type: "purl", Locator: "pkg:luet/app/foo@1.0"},
+			{Category: "OTHER", Type: "url", Locator: "https://mirror.example.com/foo"},
+		}))
+
+		// Files are sorted by name, and relative ones prefixed with ./
+		Expect(p.FilesAnalyzed).To(BeTrue())
+		Expect(p.HasFiles).To(Equal([]string{"SPDXRef-Package-app-foo-1.0-File-0", "SPDXRef-Package-app-foo-1.0-File-1"}))
+		Expect(d.Files).To(HaveLen(2))
+		Expect(d.Files[0].FileName).To(Equal("/etc/foo.conf"))
+		Expect(d.Files[1].FileName).To(Equal("./usr/bin/foo"))
+		Expect(d.Files[1].Checksums).To(Equal([]sbom.Checksum{
+			{Algorithm: "SHA1", Value: "0beec7b5ea3f0fdbc95d0dd47f3c5bc275da8a33"},
+			{Algorithm: "SHA256", Value: "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"},
+		}))
+		Expect(p.VerificationCode).ToNot(BeNil())
+		Expect(p.VerificationCode.Value).To(HaveLen(40))
+	})
+
+	It("marks packages without files and license as not analyzed", func() {
+		d := sbom.New("lib/bar-2.0", created, sbom.Entry{Package: bar})
+		p := d.Packages[0]
+		Expect(p.FilesAnalyzed).To(BeFalse())
+		Expect(p.VerificationCode).To(BeNil())
+		Expect(p.HasFiles).To(BeEmpty())
+		Expect(p.LicenseDeclared).To(Equal(sbom.NoAssertion))
+		Expect(p.DownloadLocation).To(Equal(sbom.NoAssertion))
+		Expect(d.Files).To(BeEmpty())
+	})
+
+	It("relates the packages to their requirements", func() {
+		d := sbom.New("system", created, sbom.Entry{Package: foo}, sbom.Entry{Package: bar})
+
+		Expect(d.DocumentDescribes).To(Equal([]string{"SPDXRef-Package-app-foo-1.0", "SPDXRef-Package-lib-bar-2.0"}))
+		// Requirements not described are added as packages on their own
+		Expect(d.Packages).To(HaveLen(3))
+		Expect(d.Packages[2].SPDXID).To(Equal("SPDXRef-Requirement-lib-baz--1.0"))
+		Expect(d.Packages[2].Name).To(Equal("lib/baz"))
+		Expect(d.Packages[2].VersionInfo).To(Equal(">=1.0"))
+
+		Expect(d.Relationships).To(Equal([]sbom.Relationship{
+			{Element: "SPDXRef-DOCUMENT", Type: "DESCRIBES", Related: "SPDXRef-Package-app-foo-1.0"},
+			{Element: "SPDXRef-DOCUMENT", Type: "DESCRIBES", Related: "SPDXRef-Package-lib-bar-2.0"},
+			{Element: "SPDXRef-Package-app-foo-1.0", Type: "DEPENDS_ON", Related: "SPDXRef-Package-lib-bar-2.0"},
+			{Element: "SPDXRef-Package-app-foo-1.0", Type: "DEPENDS_ON", Related: "SPDXRef-Requirement-lib-baz--1.0"},
+		}))
+	})
+
+	It("is reproducible", func() {
+		files := []sbom.FileHash{hash("usr/bin/foo", "foo")}
+		a := sbom.New("app/foo-1.0", created, sbom.Entry{Package: foo, Files: files})
+		b := sbom.New("app/foo-1.0", created, sbom.Entry{Package: foo, Files: files})
+		Expect(a).To(Equal(b))
+
+		c := sbom.New("app/foo-1.0", created.Add(time.Second), sbom.Entry{Package: foo, Files: files})
+		Expect(c.DocumentNamespace).ToNot(Equal(a.DocumentNamespace))
+	})
+
+	It("writes the SPDX JSON form", func() {
+		dir, err := os.MkdirTemp("", "sbom")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		d := sbom.New("app/foo-1.0", created, sbom.Entry{Package: foo, Files: []sbom.FileHash{hash("usr/bin/foo", "foo")}})
+		file := filepath.Join(dir, "foo.spdx.json")
+		Expect(d.Write(file)).To(Succeed())
+
+		dat, err := os.ReadFile(file)
+		Expect(err).ToNot(HaveOccurred())
+		raw := map[string]interface{}{}
+		Expect(json.Unmarshal(dat, &raw)).To(Succeed())
+		Expect(raw).To(HaveKeyWithValue("spdxVersion", "SPDX-2.3"))
+		Expect(raw).To(HaveKeyWithValue("SPDXID", "SPDXRef-DOCUMENT"))
+		Expect(raw).To(HaveKey("documentNamespace"))
+		Expect(raw["creationInfo"]).To(HaveKeyWithValue("created", "2022-01-02T03:04:05Z"))
+
+		read := &sbom.Document{}
+		Expect(json.Unmarshal(dat, read)).To(Succeed())
+		Expect(read).To(Equal(d))
+	})
+})
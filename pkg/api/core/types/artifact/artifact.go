@@ -20,24 +20,29 @@ import (
 	"bufio"
 	"bytes"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
 	"io"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	zstd "github.com/klauspost/compress/zstd"
 	gzip "github.com/klauspost/pgzip"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
 
 	//"strconv"
 	"strings"
+	"time"
 
-	containerdCompression "github.com/containerd/containerd/archive/compression"
 	bus "github.com/mudler/luet/pkg/api/core/bus"
 	config "github.com/mudler/luet/pkg/api/core/config"
 	"github.com/mudler/luet/pkg/api/core/image"
+	"github.com/mudler/luet/pkg/api/core/sbom"
 	"github.com/mudler/luet/pkg/api/core/types"
 	backend "github.com/mudler/luet/pkg/compiler/backend"
 	"github.com/mudler/luet/pkg/helpers"
@@ -61,6 +66,15 @@ type PackageArtifact struct {
 	Files             []string                        `json:"files"`
 	PackageCacheImage string                          `json:"package_cacheimage"`
 	Runtime           *types.Package                  `json:"runtime,omitempty"`
+	// BuildLog is the path of the build output of the package, relative
+	// to the directory holding the artifact.
+	BuildLog string `json:"build_log,omitempty"`
+	// SBOM is the path of the SPDX bill of materials of the artifact,
+	// relative to the directory holding the artifact.
+	SBOM string `json:"sbom,omitempty"`
+	// RuntimeDeps are the runtime requires added to the package for the
+	// shared libraries it links, which are merged in the repository tree.
+	RuntimeDeps []*types.Package `json:"runtime_deps,omitempty"`
 
 	// Platform is the target platform this artifact was built for.
 	// The zero value means unspecified, in which case the host platform is
@@ -72,7 +86,7 @@ type PackageArtifact struct {
 	Platform types.Platform `json:"platform,omitzero" yaml:"platform,omitempty"`
 }
 
-func ImageToArtifact(ctx types.Context, img v1.Image, t types.CompressionImplementation, output string, filter func(h *tar.Header) (bool, error)) (*PackageArtifact, error) {
+func ImageToArtifact(ctx types.Context, img v1.Image, t types.CompressionImplementation, output string, filter func(h *tar.Header) (bool, error), o ...func(o *opts)) (*PackageArtifact, error) {
 	_, tmpdiffs, err := image.Extract(ctx, img, filter)
 	if err != nil {
 		return nil, errors.Wrap(err, "Error met while creating tempdir for rootfs")
@@ -81,7 +95,7 @@ func ImageToArtifact(ctx types.Context, img v1.Image, t types.CompressionImpleme
 
 	a := NewPackageArtifact(output)
 	a.CompressionType = t
-	err = a.Compress(tmpdiffs, 1)
+	err = a.Compress(tmpdiffs, 1, o...)
 	if err != nil {
 		return nil, errors.Wrap(err, "Error met while creating package archive")
 	}
@@ -120,7 +134,10 @@ func (a *PackageArtifact) Verify() error {
 }
 
 type opts struct {
-	runtimePackage *types.Package
+	runtimePackage   *types.Package
+	sourceDateEpoch  time.Time
+	compressionLevel int
+	zstdLongWindow   bool
 }
 
 func WithRuntimePackage(p *types.Package) func(o *opts) {
@@ -129,6 +146,31 @@ func WithRuntimePackage(p *types.Package) func(o *opts) {
 	}
 }
 
+// WithCompressionLevel sets the compression level of the archives: from 1
+// to 9 for gzip, xz and lz4, and from 1 to 22 for zstd. 0 keeps the default
+// of the compression type.
+func WithCompressionLevel(level int) func(o *opts) {
+	return func(o *opts) {
+		o.compressionLevel = level
+	}
+}
+
+// WithZstdLongWindow enables the zstd long distance matching, using a 128MiB
+// window which gives better ratios on large archives
+func WithZstdLongWindow(enabled bool) func(o *opts) {
+	return func(o *opts) {
+		o.zstdLongWindow = enabled
+	}
+}
+
+// WithSourceDateEpoch makes the generated archives and SBOMs reproducible,
+// using t in place of the current time. The zero time leaves it disabled.
+func WithSourceDateEpoch(t time.Time) func(o *opts) {
+	return func(o *opts) {
+		o.sourceDateEpoch = t
+	}
+}
+
 func (a *PackageArtifact) WriteYAML(dst string, o ...func(o *opts)) error {
 	opts := &opts{}
 
@@ -167,6 +209,8 @@ func (a *PackageArtifact) WriteYAML(dst string, o ...func(o *opts)) error {
 	//p := a.CompileSpec.GetPackage().GetPath()
 
 	mangle.CompileSpec.GetPackage().SetPath("")
+	// Secrets are only for the build host
+	mangle.CompileSpec.Secrets = nil
 	for _, ass := range mangle.CompileSpec.GetSourceAssertion() {
 		ass.Package.SetPath("")
 	}
@@ -186,6 +230,156 @@ func (a *PackageArtifact) WriteYAML(dst string, o ...func(o *opts)) error {
 	return nil
 }
 
+// FileHashes returns the regular files of the artifact, with their content
+// hashes
+func (a *PackageArtifact) FileHashes() ([]sbom.FileHash, error) {
+	var files []sbom.FileHash
+
+	archiveFile, err := os.Open(a.Path)
+	if err != nil {
+		return files, errors.Wrap(err, "Cannot open "+a.Path)
+	}
+	defer archiveFile.Close()
+
+	decompressed, err := helpers.DecompressStream(archiveFile)
+	if err != nil {
+		return files, errors.Wrap(err, "Cannot open "+a.Path)
+	}
+	defer decompressed.Close()
+	tr := tar.NewReader(decompressed)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		f, err := sbom.HashFile(hdr.Name, tr)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// Diff compares the archive of the artifact with the one of b, and returns
+// the names of the entries found in only one of them, or whose type, mode,
+// ownership, modification time, link target or content differ.
+func (a *PackageArtifact) Diff(b *PackageArtifact) ([]string, error) {
+	entriesA, err := a.archiveEntries()
+	if err != nil {
+		return nil, err
+	}
+	entriesB, err := b.archiveEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	var diff []string
+	for name, e := range entriesA {
+		if other, ok := entriesB[name]; !ok || other != e {
+			diff = append(diff, name)
+		}
+	}
+	for name := range entriesB {
+		if _, ok := entriesA[name]; !ok {
+			diff = append(diff, name)
+		}
+	}
+	sort.Strings(diff)
+	return diff, nil
+}
+
+// archiveEntries returns a description of each entry of the artifact
+// archive, indexed by name
+func (a *PackageArtifact) archiveEntries() (map[string]string, error) {
+	entries := map[string]string{}
+
+	archiveFile, err := os.Open(a.Path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot open "+a.Path)
+	}
+	defer archiveFile.Close()
+
+	decompressed, err := helpers.DecompressStream(archiveFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot open "+a.Path)
+	}
+	defer decompressed.Close()
+	tr := tar.NewReader(decompressed)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, tr); err != nil {
+			return nil, err
+		}
+		entries[path.Clean(hdr.Name)] = fmt.Sprintf("%c %o %d:%d %s:%s %d %s %x",
+			hdr.Typeflag, hdr.Mode, hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname,
+			hdr.ModTime.Unix(), hdr.Linkname, h.Sum(nil))
+	}
+	return entries, nil
+}
+
+// WriteSBOM writes in dst the SPDX bill of materials of the artifact,
+// describing the runtime package and the files it ships, and references it
+// from the artifact. As for WriteYAML, the runtime package can be given with
+// WithRuntimePackage.
+func (a *PackageArtifact) WriteSBOM(dst string, o ...func(o *opts)) error {
+	opts := &opts{}
+	for _, oo := range o {
+		oo(opts)
+	}
+
+	runtime := opts.runtimePackage
+	if runtime == nil {
+		var err error
+		runtime, err = a.CompileSpec.GetPackage().GetRuntimePackage()
+		if err != nil {
+			return errors.Wrapf(err, "getting runtime package for '%s'", a.CompileSpec.GetPackage().HumanReadableString())
+		}
+	}
+
+	files, err := a.FileHashes()
+	if err != nil {
+		return errors.Wrap(err, "while hashing artifact files")
+	}
+
+	created := time.Now()
+	if !opts.sourceDateEpoch.IsZero() {
+		created = opts.sourceDateEpoch
+	}
+
+	doc := sbom.New(runtime.HumanReadableString(), created, sbom.Entry{Package: runtime, Files: files})
+	name := a.CompileSpec.GetPackage().GetSBOMFilePath()
+	if err := doc.Write(filepath.Join(dst, name)); err != nil {
+		return errors.Wrap(err, "while writing SBOM")
+	}
+	a.SBOM = name
+	return nil
+}
+
+// SBOMRepositoryPath returns the path of the artifact SBOM relative to the
+// root of a repository, or an empty string if the artifact has none
+func (a *PackageArtifact) SBOMRepositoryPath() string {
+	if a.SBOM == "" {
+		return ""
+	}
+	return path.Join(path.Dir(a.RepositoryPath()), a.SBOM)
+}
+
 func (a *PackageArtifact) GetFileName() string {
 	return path.Base(a.Path)
 }
@@ -233,6 +427,24 @@ func (a *PackageArtifact) TargetPlatform() types.Platform {
 	return a.Platform
 }
 
+// RepositoryPath returns the path of the artifact relative to the root of a
+// repository. Artifacts built for a platform are stored in a folder named
+// after it, e.g. linux-arm64/foo-bar-1.0.package.tar.zst
+func (a *PackageArtifact) RepositoryPath() string {
+	return path.Join(a.Platform.DirName(), filepath.Base(a.Path))
+}
+
+// ImageTag returns the tag of the final image of the artifact. Images built
+// for a platform are tagged with it as a suffix, and grouped in a manifest
+// list tagged with the package image ID when pushed to a docker repository.
+func (a *PackageArtifact) ImageTag() string {
+	tag := a.CompileSpec.GetPackage().ImageID()
+	if !a.Platform.IsZero() {
+		tag += "-" + a.Platform.DirName()
+	}
+	return tag
+}
+
 // GenerateFinalImage takes an artifact and builds a Docker image with its content
 func (a *PackageArtifact) GenerateFinalImage(ctx types.Context, imageName string, b ImageBuilder, keepPerms bool) error {
 
@@ -255,87 +467,224 @@ func (a *PackageArtifact) GenerateFinalImage(ctx types.Context, imageName string
 // Compress is responsible to archive and compress to the artifact Path.
 // It accepts a source path, which is the content to be archived/compressed
 // and a concurrency parameter.
-func (a *PackageArtifact) Compress(src string, concurrency int) error {
-	switch a.CompressionType {
+// Compress creates the artifact archive from src, compressing it with the
+// artifact compression type. With WithSourceDateEpoch the archive is
+// reproducible: the same content always gives the same checksum.
+func (a *PackageArtifact) Compress(src string, concurrency int, o ...func(o *opts)) error {
+	opts := &opts{}
+	for _, oo := range o {
+		oo(opts)
+	}
 
-	case types.Zstandard:
-		err := helpers.Tar(src, a.Path)
-		if err != nil {
-			return err
+	tarball := func(dest string) error {
+		if !opts.sourceDateEpoch.IsZero() {
+			return helpers.TarReproducible(src, dest, opts.sourceDateEpoch)
 		}
-		original, err := os.Open(a.Path)
-		if err != nil {
+		return helpers.Tar(src, dest)
+	}
+
+	switch a.CompressionType {
+	case types.Zstandard, types.GZip, types.XZ, types.LZ4:
+		if err := tarball(a.Path); err != nil {
 			return err
 		}
-		defer original.Close()
+		return a.compressTarball(concurrency, opts)
+	// Defaults to tar only (covers when "none" is supplied)
+	default:
+		return tarball(a.getCompressedName())
+	}
+}
 
-		zstdFile := a.getCompressedName()
-		bufferedReader := bufio.NewReader(original)
+// Recompress converts the artifact archive to the compression type t, and
+// updates the artifact path and checksums. The previous archive is removed.
+func (a *PackageArtifact) Recompress(t types.CompressionImplementation, concurrency int, o ...func(o *opts)) error {
+	opts := &opts{}
+	for _, oo := range o {
+		oo(opts)
+	}
+	if err := ValidateCompression(t, opts.compressionLevel); err != nil {
+		return err
+	}
 
-		// Open a file for writing.
-		dst, err := os.Create(zstdFile)
-		if err != nil {
-			return err
-		}
+	original := a.Path
+	converted := &PackageArtifact{Path: a.GetUncompressedName(), CompressionType: t}
 
-		enc, err := zstd.NewWriter(dst)
-		if err != nil {
-			return err
-		}
-		_, err = io.Copy(enc, bufferedReader)
-		if err != nil {
-			enc.Close()
-			return err
+	// Compressed archives are decompressed next to the original first
+	if converted.Path != original {
+		if err := decompressFile(original, converted.Path); err != nil {
+			os.RemoveAll(converted.Path)
+			return errors.Wrap(err, "while decompressing "+original)
 		}
-		if err := enc.Close(); err != nil {
+	}
+
+	switch t {
+	case types.Zstandard, types.GZip, types.XZ, types.LZ4:
+		if err := converted.compressTarball(concurrency, opts); err != nil {
+			if converted.Path != original {
+				os.RemoveAll(converted.Path)
+			}
 			return err
 		}
+	}
+
+	if converted.Path != original {
+		os.RemoveAll(original)
+	}
+	a.Path = converted.Path
+	a.CompressionType = t
+	a.Checksums = Checksums{}
+	return a.Hash()
+}
+
+func decompressFile(src, dst string) error {
+	archiveFile, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "Cannot open "+src)
+	}
+	defer archiveFile.Close()
+
+	decompressed, err := helpers.DecompressStream(archiveFile)
+	if err != nil {
+		return errors.Wrap(err, "Cannot open "+src)
+	}
+	defer decompressed.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, decompressed)
+	return err
+}
+
+// compressTarball compresses the tarball at the artifact path with the
+// artifact compression type, replacing it with the compressed archive
+func (a *PackageArtifact) compressTarball(concurrency int, opts *opts) error {
+	original, err := os.Open(a.Path)
+	if err != nil {
+		return err
+	}
+	defer original.Close()
+
+	compressed := a.getCompressedName()
+	dst, err := os.Create(compressed)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	w, err := a.compressor(dst, concurrency, opts)
+	if err != nil {
+		os.RemoveAll(compressed)
+		return err
+	}
+	if _, err := io.Copy(w, bufio.NewReader(original)); err != nil {
+		w.Close()
+		os.RemoveAll(compressed)
+		return err
+	}
+	if err := w.Close(); err != nil {
+		os.RemoveAll(compressed)
+		return err
+	}
+
+	os.RemoveAll(a.Path) // Remove original
+	a.Path = compressed
+	return nil
+}
 
-		os.RemoveAll(a.Path) // Remove original
-		//	Debug("Removed artifact", a.Path)
+// xzDictCaps are the dictionary sizes of the xz presets, by level
+var xzDictCaps = []int{256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
 
-		a.Path = zstdFile
+// lz4Levels are the lz4 compression levels, by level
+var lz4Levels = []lz4.CompressionLevel{lz4.Fast, lz4.Level1, lz4.Level2, lz4.Level3, lz4.Level4, lz4.Level5, lz4.Level6, lz4.Level7, lz4.Level8, lz4.Level9}
+
+// zstdLongWindowSize is the window of the zstd long distance matching, as
+// for zstd --long
+const zstdLongWindowSize = 1 << 27
+
+// ValidateCompression checks that t is a supported compression type, and
+// level a valid level for it (0 being the default one)
+func ValidateCompression(t types.CompressionImplementation, level int) error {
+	max := 9
+	switch t {
+	case types.None, "":
 		return nil
+	case types.Zstandard:
+		max = 22
+	case types.GZip, types.XZ, types.LZ4:
+	default:
+		return fmt.Errorf("unsupported compression type '%s', available: none,gzip,zstd,xz,lz4", t)
+	}
+	if level < 0 || level > max {
+		return fmt.Errorf("invalid %s compression level %d, it must be between 0 (default) and %d", t, level, max)
+	}
+	return nil
+}
+
+// compressor returns a writer compressing to w with the artifact
+// compression type. In reproducible mode, the encoders run on a single
+// thread with pinned settings, so the output only depends on the input.
+func (a *PackageArtifact) compressor(w io.Writer, concurrency int, opts *opts) (io.WriteCloser, error) {
+	if err := ValidateCompression(a.CompressionType, opts.compressionLevel); err != nil {
+		return nil, err
+	}
+	reproducible := !opts.sourceDateEpoch.IsZero()
+	if reproducible {
+		concurrency = 1
+	}
+
+	switch a.CompressionType {
+	case types.Zstandard:
+		encoderOpts := []zstd.EOption{}
+		if reproducible {
+			// Pin the encoder settings instead of depending on the host
+			encoderOpts = append(encoderOpts,
+				zstd.WithEncoderConcurrency(1),
+				zstd.WithEncoderLevel(zstd.SpeedDefault),
+			)
+		}
+		if opts.compressionLevel != 0 {
+			encoderOpts = append(encoderOpts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(opts.compressionLevel)))
+		}
+		if opts.zstdLongWindow {
+			encoderOpts = append(encoderOpts, zstd.WithWindowSize(zstdLongWindowSize))
+		}
+		return zstd.NewWriter(w, encoderOpts...)
 	case types.GZip:
-		err := helpers.Tar(src, a.Path)
-		if err != nil {
-			return err
+		level := gzip.DefaultCompression
+		if opts.compressionLevel != 0 {
+			level = opts.compressionLevel
 		}
-		original, err := os.Open(a.Path)
+		gw, err := gzip.NewWriterLevel(w, level)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		defer original.Close()
-
-		gzipfile := a.getCompressedName()
-		bufferedReader := bufio.NewReader(original)
-
-		// Open a file for writing.
-		dst, err := os.Create(gzipfile)
-		if err != nil {
-			return err
+		if reproducible {
+			gw.ModTime = opts.sourceDateEpoch
 		}
-		// Create gzip writer.
-		w := gzip.NewWriter(dst)
-		w.SetConcurrency(1<<20, concurrency)
-		defer w.Close()
-		defer dst.Close()
-		_, err = io.Copy(w, bufferedReader)
-		if err != nil {
-			return err
+		gw.SetConcurrency(1<<20, concurrency)
+		return gw, nil
+	case types.XZ:
+		config := xz.WriterConfig{}
+		if opts.compressionLevel != 0 {
+			config.DictCap = xzDictCaps[opts.compressionLevel]
 		}
-		w.Close()
-		os.RemoveAll(a.Path) // Remove original
-		//	Debug("Removed artifact", a.Path)
-		//	a.CompressedPath = gzipfile
-		a.Path = gzipfile
-		return nil
-		//a.Path = gzipfile
-
-	// Defaults to tar only (covers when "none" is supplied)
-	default:
-		return helpers.Tar(src, a.getCompressedName())
+		return config.NewWriter(w)
+	case types.LZ4:
+		lw := lz4.NewWriter(w)
+		lz4Opts := []lz4.Option{lz4.ConcurrencyOption(concurrency)}
+		if opts.compressionLevel != 0 {
+			lz4Opts = append(lz4Opts, lz4.CompressionLevelOption(lz4Levels[opts.compressionLevel]))
+		}
+		if err := lw.Apply(lz4Opts...); err != nil {
+			return nil, err
+		}
+		return lw, nil
 	}
+	return nil, fmt.Errorf("unsupported compression type '%s'", a.CompressionType)
 }
 
 func (a *PackageArtifact) getCompressedName() string {
@@ -345,6 +694,12 @@ func (a *PackageArtifact) getCompressedName() string {
 
 	case types.GZip:
 		return a.Path + ".gz"
+
+	case types.XZ:
+		return a.Path + ".xz"
+
+	case types.LZ4:
+		return a.Path + ".lz4"
 	}
 	return a.Path
 }
@@ -352,7 +707,7 @@ func (a *PackageArtifact) getCompressedName() string {
 // GetUncompressedName returns the artifact path without the extension suffix
 func (a *PackageArtifact) GetUncompressedName() string {
 	switch a.CompressionType {
-	case types.Zstandard, types.GZip:
+	case types.Zstandard, types.GZip, types.XZ, types.LZ4:
 		return strings.TrimSuffix(a.Path, filepath.Ext(a.Path))
 	}
 	return a.Path
@@ -572,7 +927,7 @@ func (a *PackageArtifact) Unpack(ctx types.Context, dst string, keepPerms bool,
 	}
 	defer archiveFile.Close()
 
-	decompressed, err := containerdCompression.DecompressStream(archiveFile)
+	decompressed, err := helpers.DecompressStream(archiveFile)
 	if err != nil {
 		return errors.Wrap(err, "Cannot open "+a.Path)
 	}
@@ -607,7 +962,7 @@ func (a *PackageArtifact) FileList() ([]string, error) {
 	}
 	defer archiveFile.Close()
 
-	decompressed, err := containerdCompression.DecompressStream(archiveFile)
+	decompressed, err := helpers.DecompressStream(archiveFile)
 	if err != nil {
 		return files, errors.Wrap(err, "Cannot open "+a.Path)
 	}
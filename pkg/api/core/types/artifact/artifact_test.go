@@ -17,8 +17,10 @@ package artifact_test
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	ghodssyaml "github.com/ghodss/yaml"
 	yamlv3 "gopkg.in/yaml.v3"
@@ -29,6 +31,7 @@ import (
 
 	"github.com/mudler/luet/pkg/api/core/context"
 	"github.com/mudler/luet/pkg/api/core/image"
+	"github.com/mudler/luet/pkg/api/core/sbom"
 	. "github.com/mudler/luet/pkg/api/core/types/artifact"
 	backend "github.com/mudler/luet/pkg/compiler/backend"
 
@@ -228,12 +231,123 @@ RUN echo bar > /test2`))
 			a.CompressionType = types.Zstandard
 			Expect(a.GetUncompressedName()).To(Equal("foo.tar"))
 
+			a = NewPackageArtifact("foo.tar.xz")
+			a.CompressionType = types.XZ
+			Expect(a.GetUncompressedName()).To(Equal("foo.tar"))
+
+			a = NewPackageArtifact("foo.tar.lz4")
+			a.CompressionType = types.LZ4
+			Expect(a.GetUncompressedName()).To(Equal("foo.tar"))
+
 			a = NewPackageArtifact("foo.tar")
 			a.CompressionType = types.None
 			Expect(a.GetUncompressedName()).To(Equal("foo.tar"))
 		})
 	})
 
+	Context("Compression", func() {
+		var src, dst string
+
+		BeforeEach(func() {
+			var err error
+			src, err = os.MkdirTemp("", "src")
+			Expect(err).ToNot(HaveOccurred())
+			dst, err = os.MkdirTemp("", "dst")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(os.MkdirAll(filepath.Join(src, "usr", "bin"), os.ModePerm)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(src, "usr", "bin", "foo"), []byte("foo"), os.ModePerm)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(src)
+			os.RemoveAll(dst)
+		})
+
+		for _, t := range []types.CompressionImplementation{types.GZip, types.Zstandard, types.XZ, types.LZ4} {
+			t := t
+			It("compresses and unpacks archives with "+string(t), func() {
+				a := NewPackageArtifact(filepath.Join(dst, "foo.package.tar"))
+				a.CompressionType = t
+				Expect(a.Compress(src, 2, WithCompressionLevel(9))).To(Succeed())
+				Expect(a.Path).To(HavePrefix(filepath.Join(dst, "foo.package.tar.")))
+				Expect(fileHelper.Exists(filepath.Join(dst, "foo.package.tar"))).To(BeFalse())
+
+				files, err := a.FileList()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(files).To(ContainElement("usr/bin/foo"))
+
+				result := filepath.Join(dst, "result")
+				Expect(os.MkdirAll(result, os.ModePerm)).To(Succeed())
+				Expect(a.Unpack(context.NewContext(), result, false)).To(Succeed())
+				content, err := fileHelper.Read(filepath.Join(result, "usr", "bin", "foo"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(content).To(Equal("foo"))
+			})
+		}
+
+		It("compresses with the zstd long window", func() {
+			a := NewPackageArtifact(filepath.Join(dst, "foo.package.tar"))
+			a.CompressionType = types.Zstandard
+			Expect(a.Compress(src, 1, WithCompressionLevel(19), WithZstdLongWindow(true))).To(Succeed())
+
+			files, err := a.FileList()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(ContainElement("usr/bin/foo"))
+		})
+
+		for _, level := range []int{1, 9} {
+			level := level
+			It(fmt.Sprintf("compresses archives with lz4 level %d", level), func() {
+				a := NewPackageArtifact(filepath.Join(dst, "foo.package.tar"))
+				a.CompressionType = types.LZ4
+				Expect(a.Compress(src, 1, WithCompressionLevel(level))).To(Succeed())
+
+				files, err := a.FileList()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(files).To(ContainElement("usr/bin/foo"))
+			})
+		}
+
+		It("rejects invalid levels", func() {
+			a := NewPackageArtifact(filepath.Join(dst, "foo.package.tar"))
+			a.CompressionType = types.GZip
+			Expect(a.Compress(src, 1, WithCompressionLevel(10))).ToNot(Succeed())
+
+			Expect(ValidateCompression(types.Zstandard, 22)).To(Succeed())
+			Expect(ValidateCompression(types.Zstandard, 23)).To(MatchError(ContainSubstring("between 0 (default) and 22")))
+			Expect(ValidateCompression(types.None, 0)).To(Succeed())
+			Expect(ValidateCompression("bzip2", 0)).ToNot(Succeed())
+		})
+
+		It("recompresses archives to another type", func() {
+			a := NewPackageArtifact(filepath.Join(dst, "foo.package.tar"))
+			a.CompressionType = types.GZip
+			Expect(a.Compress(src, 1)).To(Succeed())
+			Expect(a.Hash()).To(Succeed())
+			gzipped := a.Path
+			checksums := a.Checksums
+
+			Expect(a.Recompress(types.XZ, 1)).To(Succeed())
+			Expect(a.Path).To(Equal(filepath.Join(dst, "foo.package.tar.xz")))
+			Expect(a.CompressionType).To(Equal(types.XZ))
+			Expect(a.Checksums).ToNot(Equal(checksums))
+			Expect(a.Verify()).To(Succeed())
+			Expect(fileHelper.Exists(gzipped)).To(BeFalse())
+			Expect(fileHelper.Exists(filepath.Join(dst, "foo.package.tar"))).To(BeFalse())
+
+			files, err := a.FileList()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(ContainElement("usr/bin/foo"))
+
+			Expect(a.Recompress(types.None, 1)).To(Succeed())
+			Expect(a.Path).To(Equal(filepath.Join(dst, "foo.package.tar")))
+			files, err = a.FileList()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(ContainElement("usr/bin/foo"))
+		})
+	})
+
 	Context("TargetPlatform", func() {
 		It("falls back to the host when unset", func() {
 			a := NewPackageArtifact("/tmp/foo.tar")
@@ -285,4 +399,160 @@ RUN echo bar > /test2`))
 			Expect(decoded.Platform).To(Equal(p))
 		})
 	})
+
+	Context("SBOM", func() {
+		It("describes the package and the files of the artifact", func() {
+			src, err := os.MkdirTemp("", "src")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(src)
+			dst, err := os.MkdirTemp("", "dst")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dst)
+
+			Expect(os.MkdirAll(filepath.Join(src, "usr", "bin"), os.ModePerm)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(src, "usr", "bin", "foo"), []byte("foo"), os.ModePerm)).To(Succeed())
+
+			p := &types.Package{Name: "foo", Category: "bar", Version: "1.0", License: "GPL-2.0"}
+			a := NewPackageArtifact(filepath.Join(dst, "foo.package.tar"))
+			a.CompileSpec = &types.LuetCompilationSpec{Package: p}
+			Expect(a.Compress(src, 1)).To(Succeed())
+
+			Expect(a.WriteSBOM(dst, WithRuntimePackage(p))).To(Succeed())
+			Expect(a.SBOM).To(Equal(p.GetSBOMFilePath()))
+			Expect(a.SBOMRepositoryPath()).To(Equal(p.GetSBOMFilePath()))
+
+			dat, err := os.ReadFile(filepath.Join(dst, a.SBOM))
+			Expect(err).ToNot(HaveOccurred())
+			doc := &sbom.Document{}
+			Expect(json.Unmarshal(dat, doc)).To(Succeed())
+
+			Expect(doc.Packages).To(HaveLen(1))
+			Expect(doc.Packages[0].Name).To(Equal("bar/foo"))
+			Expect(doc.Packages[0].VersionInfo).To(Equal("1.0"))
+			Expect(doc.Packages[0].LicenseDeclared).To(Equal("GPL-2.0"))
+			Expect(doc.Files).To(HaveLen(1))
+			Expect(doc.Files[0].FileName).To(Equal("./usr/bin/foo"))
+			Expect(doc.Files[0].Checksums).To(ContainElement(sbom.Checksum{
+				Algorithm: "SHA256",
+				Value:     "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+			}))
+		})
+	})
+
+	Context("Shared libraries", func() {
+		It("lists the libraries linked and shipped by the artifact", func() {
+			dst, err := os.MkdirTemp("", "dst")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dst)
+
+			a := NewPackageArtifact(filepath.Join(dst, "foo.package.tar"))
+			Expect(a.Compress("../../../../../tests/fixtures/runtimedeps/bar/rootfs", 1)).To(Succeed())
+			libs, err := a.SharedLibraries()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(libs.Needed).To(Equal(map[string][]string{
+				"libfoo.so.1": {"usr/bin/bar"},
+				"libc.so.6":   {"usr/bin/bar"},
+			}))
+			Expect(libs.Missing()).To(Equal([]string{"libc.so.6", "libfoo.so.1"}))
+
+			b := NewPackageArtifact(filepath.Join(dst, "bar.package.tar"))
+			Expect(b.Compress("../../../../../tests/fixtures/runtimedeps/foo/rootfs", 1)).To(Succeed())
+			libs, err = b.SharedLibraries()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(libs.Provided).To(HaveKey("libfoo.so.1"))
+			Expect(libs.Missing()).To(BeEmpty())
+		})
+
+		It("scans all the ELF files of the artifact", func() {
+			src, err := os.MkdirTemp("", "src")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(src)
+			dst, err := os.MkdirTemp("", "dst")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dst)
+
+			Expect(fileHelper.CopyDir("../../../../../tests/fixtures/runtimedeps/bar/rootfs/usr/bin", filepath.Join(src, "usr", "bin"))).To(Succeed())
+			Expect(fileHelper.CopyDir("../../../../../tests/fixtures/runtimedeps/foo/rootfs/usr/lib", filepath.Join(src, "usr", "lib"))).To(Succeed())
+
+			a := NewPackageArtifact(filepath.Join(dst, "foobar.package.tar"))
+			Expect(a.Compress(src, 1)).To(Succeed())
+			libs, err := a.SharedLibraries()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(libs.Provided).To(HaveKey("libfoo.so.1"))
+			Expect(libs.Missing()).To(Equal([]string{"libc.so.6"}))
+		})
+	})
+
+	Context("Metadata", func() {
+		It("does not contain the build secrets", func() {
+			src, err := os.MkdirTemp("", "src")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(src)
+			dst, err := os.MkdirTemp("", "dst")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dst)
+
+			p := &types.Package{Name: "foo", Category: "bar", Version: "1.0"}
+			a := NewPackageArtifact(filepath.Join(dst, "foo.package.tar"))
+			a.CompileSpec = &types.LuetCompilationSpec{
+				Package: p,
+				Secrets: []types.Secret{{ID: "token", Env: "GIT_TOKEN"}},
+			}
+			Expect(a.Compress(src, 1)).To(Succeed())
+			Expect(a.WriteYAML(dst, WithRuntimePackage(p))).To(Succeed())
+
+			dat, err := os.ReadFile(filepath.Join(dst, p.GetMetadataFilePath()))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(dat)).ToNot(ContainSubstring("GIT_TOKEN"))
+			Expect(a.CompileSpec.Secrets).To(HaveLen(1))
+		})
+	})
+
+	Context("Reproducible archives", func() {
+		epoch := time.Unix(1600000000, 0)
+
+		build := func(src, dst string, t types.CompressionImplementation) *PackageArtifact {
+			a := NewPackageArtifact(filepath.Join(dst, "foo.package.tar"))
+			a.CompressionType = t
+			Expect(a.Compress(src, 4, WithSourceDateEpoch(epoch))).To(Succeed())
+			Expect(a.Hash()).To(Succeed())
+			return a
+		}
+
+		for _, t := range []types.CompressionImplementation{types.None, types.GZip, types.Zstandard} {
+			t := t
+			It("gives the same checksum from the same content with "+string(t), func() {
+				first, err := os.MkdirTemp("", "first")
+				Expect(err).ToNot(HaveOccurred())
+				defer os.RemoveAll(first)
+				second, err := os.MkdirTemp("", "second")
+				Expect(err).ToNot(HaveOccurred())
+				defer os.RemoveAll(second)
+				src, err := os.MkdirTemp("", "src")
+				Expect(err).ToNot(HaveOccurred())
+				defer os.RemoveAll(src)
+
+				Expect(os.MkdirAll(filepath.Join(src, "usr", "bin"), os.ModePerm)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(src, "usr", "bin", "foo"), []byte("foo"), os.ModePerm)).To(Succeed())
+
+				a := build(src, first, t)
+
+				now := time.Now().Add(time.Hour)
+				Expect(os.Chtimes(filepath.Join(src, "usr", "bin", "foo"), now, now)).To(Succeed())
+				b := build(src, second, t)
+				Expect(a.Checksums).To(Equal(b.Checksums))
+
+				Expect(os.WriteFile(filepath.Join(src, "usr", "bin", "foo"), []byte("bar"), os.ModePerm)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(src, "usr", "bin", "baz"), []byte("baz"), os.ModePerm)).To(Succeed())
+				Expect(os.RemoveAll(second)).To(Succeed())
+				Expect(os.MkdirAll(second, os.ModePerm)).To(Succeed())
+				b = build(src, second, t)
+				Expect(a.Checksums).ToNot(Equal(b.Checksums))
+
+				diff, err := a.Diff(b)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(diff).To(Equal([]string{"usr/bin/baz", "usr/bin/foo"}))
+			})
+		}
+	})
 })
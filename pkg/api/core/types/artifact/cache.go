@@ -38,6 +38,9 @@ func (c *ArtifactCache) cacheID(a *PackageArtifact) [64]byte {
 	if a.CompileSpec != nil && a.CompileSpec.Package != nil {
 		fingerprint = a.CompileSpec.Package.GetFingerPrint()
 	}
+	if !a.Platform.IsZero() {
+		fingerprint += "@" + a.Platform.String()
+	}
 	if len(a.Checksums) > 0 {
 		for _, cs := range a.Checksums.List() {
 			t := cs[0]
@@ -0,0 +1,135 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package artifact
+
+import (
+	"archive/tar"
+	"bytes"
+	"debug/elf"
+	"io"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/mudler/luet/pkg/helpers"
+	"github.com/pkg/errors"
+)
+
+var elfMagic = []byte(elf.ELFMAG)
+
+// SharedLibraries are the shared libraries linked by the ELF files of an
+// artifact, and the ones it ships
+type SharedLibraries struct {
+	// Needed maps the DT_NEEDED sonames to the files of the artifact
+	// linking them
+	Needed map[string][]string
+	// Provided are the names the libraries of the artifact can be loaded
+	// with: their DT_SONAME, and the names of all the files and symlinks.
+	Provided map[string]bool
+}
+
+// Missing returns the sonames needed by the artifact, and not provided by
+// itself, sorted
+func (s *SharedLibraries) Missing() []string {
+	var missing []string
+	for soname := range s.Needed {
+		if !s.Provided[soname] {
+			missing = append(missing, soname)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// SharedLibraries scans the ELF files of the artifact archive, returning the
+// shared libraries they need and provide. Files which are not ELF, or can't
+// be parsed, are skipped.
+// The ELF files are spooled one at a time to a temporary file, as parsing
+// needs random access, so they are never held in memory.
+func (a *PackageArtifact) SharedLibraries() (*SharedLibraries, error) {
+	libs := &SharedLibraries{Needed: map[string][]string{}, Provided: map[string]bool{}}
+
+	spool, err := os.CreateTemp("", "elf")
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot create spool file")
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	archiveFile, err := os.Open(a.Path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot open "+a.Path)
+	}
+	defer archiveFile.Close()
+
+	decompressed, err := helpers.DecompressStream(archiveFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot open "+a.Path)
+	}
+	defer decompressed.Close()
+	tr := tar.NewReader(decompressed)
+
+	magic := make([]byte, len(elfMagic))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.FileInfo().IsDir() {
+			continue
+		}
+		libs.Provided[path.Base(hdr.Name)] = true
+
+		if hdr.Typeflag != tar.TypeReg || hdr.Size < int64(len(elfMagic)) {
+			continue
+		}
+		if _, err := io.ReadFull(tr, magic); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(magic, elfMagic) {
+			continue
+		}
+
+		if err := spool.Truncate(0); err != nil {
+			return nil, err
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(spool, io.MultiReader(bytes.NewReader(magic), tr)); err != nil {
+			return nil, errors.Wrap(err, "Cannot spool "+hdr.Name)
+		}
+		f, err := elf.NewFile(spool)
+		if err != nil {
+			continue
+		}
+
+		needed, _ := f.ImportedLibraries()
+		for _, soname := range needed {
+			libs.Needed[soname] = append(libs.Needed[soname], path.Clean(hdr.Name))
+		}
+		if sonames, err := f.DynString(elf.DT_SONAME); err == nil {
+			for _, soname := range sonames {
+				libs.Provided[soname] = true
+			}
+		}
+		f.Close()
+	}
+	return libs, nil
+}
@@ -17,13 +17,20 @@ package types
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/mitchellh/hashstructure/v2"
 
 	"github.com/ghodss/yaml"
 	"github.com/otiai10/copy"
+	"github.com/pkg/errors"
 	dirhash "golang.org/x/mod/sumdb/dirhash"
 )
 
@@ -92,12 +99,160 @@ type CopyField struct {
 	Destination string   `json:"destination"`
 }
 
+// Source is an upstream source of a package. It is fetched on the host,
+// verified against its checksum and made available in the build context
+// before the build runs.
+type Source struct {
+	URL    string `json:"url" yaml:"url"`
+	SHA256 string `json:"sha256" yaml:"sha256"`
+	// Destination is the path of the source in the build context, relative
+	// to /luetbuild. Defaults to the file name of the URL.
+	Destination string `json:"destination,omitempty" yaml:"destination,omitempty"`
+	// Extract unpacks the source archive in Destination
+	Extract bool `json:"extract,omitempty" yaml:"extract,omitempty"`
+}
+
+// GetDestination returns the path of the source in the build context
+func (s Source) GetDestination() string {
+	if s.Destination != "" {
+		return s.Destination
+	}
+	if u, err := url.Parse(s.URL); err == nil && u.Path != "" {
+		return path.Base(u.Path)
+	}
+	return path.Base(s.URL)
+}
+
+// BuildLimits are the limits applied to the builds of a package. They can
+// be set in the build spec of the package and as compiler defaults.
+type BuildLimits struct {
+	// Timeout is the maximum time each image build of the package can take,
+	// as a duration (e.g. 30m)
+	Timeout string `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	// Memory limits the memory of the build containers (e.g. 2g)
+	Memory string `json:"memory,omitempty" yaml:"memory,omitempty"`
+	// CPUs limits the number of CPUs the build containers can use (e.g. 1.5)
+	CPUs string `json:"cpus,omitempty" yaml:"cpus,omitempty"`
+	// Network, when false, runs the build containers without network
+	Network *bool `json:"network,omitempty" yaml:"network,omitempty"`
+}
+
+// Merge returns the limits, taking the unset ones from defaults
+func (l BuildLimits) Merge(defaults BuildLimits) BuildLimits {
+	if l.Timeout == "" {
+		l.Timeout = defaults.Timeout
+	}
+	if l.Memory == "" {
+		l.Memory = defaults.Memory
+	}
+	if l.CPUs == "" {
+		l.CPUs = defaults.CPUs
+	}
+	if l.Network == nil {
+		l.Network = defaults.Network
+	}
+	return l
+}
+
+// GetTimeout returns the build timeout, zero meaning none
+func (l BuildLimits) GetTimeout() (time.Duration, error) {
+	if l.Timeout == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(l.Timeout)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid timeout '%s'", l.Timeout)
+	}
+	return d, nil
+}
+
+// GetCPUs returns the number of CPUs the builds can use, zero meaning no
+// limit
+func (l BuildLimits) GetCPUs() (float64, error) {
+	if l.CPUs == "" {
+		return 0, nil
+	}
+	cpus, err := strconv.ParseFloat(l.CPUs, 64)
+	if err != nil || cpus < 0 {
+		return 0, fmt.Errorf("invalid cpus '%s'", l.CPUs)
+	}
+	return cpus, nil
+}
+
+// NetworkDisabled returns true if the builds have to run without network
+func (l BuildLimits) NetworkDisabled() bool {
+	return l.Network != nil && !*l.Network
+}
+
+// Secret is a secret made available to the RUN steps of a build. Its value
+// is read from the build host, and never lands in the images, the package
+// hash or the package metadata.
+type Secret struct {
+	ID string `json:"id" yaml:"id"`
+	// Env is the environment variable of the build host holding the secret
+	Env string `json:"env,omitempty" yaml:"env,omitempty"`
+	// File is the file of the build host holding the secret
+	File string `json:"file,omitempty" yaml:"file,omitempty"`
+	// Target is where the secret is mounted in the RUN steps. Defaults to
+	// /run/secrets/<id>
+	Target string `json:"target,omitempty" yaml:"target,omitempty"`
+}
+
+var secretIDRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Validate checks that the id and the target of the secret can be used in
+// the RUN mount options and in the build flags of the backends
+func (s Secret) Validate() error {
+	if s.ID == "" {
+		return errors.New("secrets require an id")
+	}
+	if !secretIDRegexp.MatchString(s.ID) {
+		return fmt.Errorf("invalid secret id '%s', it can contain only letters, digits, '.', '_' and '-'", s.ID)
+	}
+	if s.Target == "" {
+		return nil
+	}
+	if !path.IsAbs(s.Target) {
+		return fmt.Errorf("target '%s' of secret '%s' must be an absolute path", s.Target, s.ID)
+	}
+	if strings.ContainsAny(s.Target, ", \t\n\r") {
+		return fmt.Errorf("target '%s' of secret '%s' can't contain commas or whitespaces", s.Target, s.ID)
+	}
+	return nil
+}
+
+// Mount returns the RUN mount option of the secret
+func (s Secret) Mount() string {
+	mount := "--mount=type=secret,id=" + s.ID
+	if s.Target != "" {
+		mount += ",target=" + s.Target
+	}
+	return mount
+}
+
 type CompressionImplementation string
 
 const (
 	None      CompressionImplementation = "none" // e.g. tar for standard packages
 	GZip      CompressionImplementation = "gzip"
 	Zstandard CompressionImplementation = "zstd"
+	XZ        CompressionImplementation = "xz"
+	LZ4       CompressionImplementation = "lz4"
+)
+
+// RuntimeDepsMode tells what to do with the runtime dependencies detected
+// from the shared libraries linked by the artifacts
+type RuntimeDepsMode string
+
+const (
+	// RuntimeDepsNone disables the detection
+	RuntimeDepsNone RuntimeDepsMode = ""
+	// RuntimeDepsWarn warns about the dependencies missing from the runtime
+	// package requires
+	RuntimeDepsWarn RuntimeDepsMode = "warn"
+	// RuntimeDepsAdd adds the missing dependencies to the runtime package
+	// requires
+	RuntimeDepsAdd RuntimeDepsMode = "add"
 )
 
 type SubPackage struct {
@@ -120,6 +275,12 @@ type LuetCompilationSpec struct {
 
 	Retrieve []string `json:"retrieve"`
 
+	Sources []Source `json:"sources,omitempty" yaml:"sources,omitempty"`
+
+	// Secrets are not part of the signature, nor written in the artifacts
+	// metadata
+	Secrets []Secret `json:"secrets,omitempty" yaml:"secrets,omitempty"`
+
 	OutputPath string   `json:"-"` // Where the build processfiles go
 	Unpack     bool     `json:"unpack"`
 	Includes   []string `json:"includes"`
@@ -130,6 +291,8 @@ type LuetCompilationSpec struct {
 	Copy []CopyField `json:"copy"`
 
 	RequiresFinalImages bool `json:"requires_final_images" yaml:"requires_final_images"`
+
+	BuildLimits `json:",inline" yaml:",inline"`
 }
 
 // Signature is a portion of the spec that yields a signature for the hash
@@ -141,6 +304,7 @@ type Signature struct {
 	Seed                string
 	Env                 []string
 	Retrieve            []string
+	Sources             []Source
 	Unpack              bool
 	Includes            []string
 	Excludes            []string
@@ -150,12 +314,28 @@ type Signature struct {
 	Dockerfile          string
 }
 
+// HashInclude implements hashstructure.Includable. The fields added to the
+// signature later on are hashed only when set, so that the hashes of the
+// specs not using them, and thus their images, stay the same.
+func (s Signature) HashInclude(field string, v interface{}) (bool, error) {
+	switch field {
+	case "Sources":
+		return len(s.Sources) != 0, nil
+	}
+	return true, nil
+}
+
 type CompilerOptions struct {
 	PushImageRepository      string
 	PullImageRepository      []string
 	PullFirst, KeepImg, Push bool
 	Concurrency              int
 	CompressionType          CompressionImplementation
+	// CompressionLevel is the level of the artifacts compression, 0 keeps
+	// the default of the compression type
+	CompressionLevel int
+	// ZstdLongWindow enables the zstd long distance matching
+	ZstdLongWindow bool
 
 	Wait            bool
 	OnlyDeps        bool
@@ -184,6 +364,26 @@ type CompilerOptions struct {
 	PushFinalImagesRepository string
 	RuntimeDatabase           PackageDatabase
 
+	// Platform is the platform packages are built for. When unset,
+	// packages are built for the host and artifacts carry no platform.
+	Platform Platform
+
+	// SourceDateEpoch, when set, makes the generated artifacts reproducible:
+	// it is used as build timestamp and archive entries are normalized
+	// against it. The zero value leaves it disabled.
+	SourceDateEpoch time.Time
+
+	// BuildLimits are the default limits of the package builds
+	BuildLimits BuildLimits
+
+	// RuntimeDeps tells how to handle the runtime dependencies detected
+	// from the shared libraries linked by the artifacts
+	RuntimeDeps RuntimeDepsMode
+
+	// SourcesCachePath is where the sources of the packages are
+	// downloaded to, indexed by their checksum
+	SourcesCachePath string
+
 	Context Context
 }
 
@@ -210,6 +410,7 @@ func (cs *LuetCompilationSpec) signature() Signature {
 		Seed:                cs.Seed,
 		Env:                 cs.Env,
 		Retrieve:            cs.Retrieve,
+		Sources:             cs.Sources,
 		Unpack:              cs.Unpack,
 		Includes:            cs.Includes,
 		Excludes:            cs.Excludes,
@@ -296,6 +497,14 @@ func (cs *LuetCompilationSpec) GetRetrieve() []string {
 	return cs.Retrieve
 }
 
+func (cs *LuetCompilationSpec) GetSources() []Source {
+	return cs.Sources
+}
+
+func (cs *LuetCompilationSpec) GetSecrets() []Secret {
+	return cs.Secrets
+}
+
 // IsVirtual returns true if the spec is virtual.
 // A spec is virtual if the package is empty, and it has no image source to unpack from.
 func (cs *LuetCompilationSpec) IsVirtual() bool {
@@ -417,21 +626,42 @@ ADD ` + s + ` /luetbuild/`
 ENV ` + s
 	}
 
+	run := "RUN "
+	for _, secret := range cs.Secrets {
+		run += secret.Mount() + " "
+	}
+
 	for _, s := range steps {
 		spec = spec + `
-RUN ` + s
+` + run + s
 	}
 	return spec
 }
 
+// validateSecrets checks the secrets mounted in the RUN steps
+func (cs *LuetCompilationSpec) validateSecrets() error {
+	for _, s := range cs.Secrets {
+		if err := s.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // RenderBuildImage renders the dockerfile of the image used as a pre-build step
 func (cs *LuetCompilationSpec) RenderBuildImage() (string, error) {
+	if err := cs.validateSecrets(); err != nil {
+		return "", err
+	}
 	return cs.genDockerfile(cs.GetSeedImage(), cs.GetPreBuildSteps()), nil
 
 }
 
 // RenderStepImage renders the dockerfile used for the image used for building the package
 func (cs *LuetCompilationSpec) RenderStepImage(image string) (string, error) {
+	if err := cs.validateSecrets(); err != nil {
+		return "", err
+	}
 	return cs.genDockerfile(image, cs.BuildSteps()), nil
 }
 
@@ -449,8 +679,18 @@ func (cs *LuetCompilationSpec) WriteStepImageDefinition(fromimage, path string)
 	var data string
 	var err error
 	if cs.Package.OriginDockerfile != "" {
-		// pre-rendered
-		data = cs.Package.OriginDockerfile
+		// pre-rendered, only the stages based on packages of the tree are
+		// pointed to the image holding them
+		df, err := ParseDockerfile(cs.Package.OriginDockerfile)
+		if err != nil {
+			return err
+		}
+		data = df.RewriteFrom(cs.Package.OriginDockerfile, func(image string) string {
+			if IsRequiredImage(cs.Package, image) {
+				return fromimage
+			}
+			return ""
+		})
 	} else {
 		data, err = cs.RenderStepImage(fromimage)
 		if err != nil {
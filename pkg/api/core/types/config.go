@@ -71,6 +71,10 @@ type LuetSolverOptions struct {
 	Discount       float32    `yaml:"discount,omitempty" mapstructure:"discount"`
 	MaxAttempts    int        `yaml:"max_attempts,omitempty" mapstructure:"max_attempts"`
 	Implementation SolverType `yaml:"implementation,omitempty" mapstructure:"implementation"`
+
+	// Cache stores solutions on disk, keyed by the repository revisions,
+	// the installed system, the request and the solver options.
+	Cache bool `yaml:"cache,omitempty" mapstructure:"cache"`
 }
 
 // CompactString returns a compact string to display solver options over CLI
@@ -88,6 +92,9 @@ type LuetSystemConfig struct {
 	Rootfs         string `yaml:"rootfs" mapstructure:"rootfs"`
 	PkgsCachePath  string `yaml:"pkgs_cache_path" mapstructure:"pkgs_cache_path"`
 	TmpDirBase     string `yaml:"tmpdir_base" mapstructure:"tmpdir_base"`
+	// Platform is the platform of the target system, in os/arch[/variant]
+	// form. Defaults to the host platform.
+	Platform string `yaml:"platform,omitempty" mapstructure:"platform"`
 }
 
 // Init reads the config and replace user-defined paths with
@@ -111,6 +118,12 @@ func (c *LuetConfig) Init() error {
 }
 
 func (s *LuetSystemConfig) init() error {
+	if s.Platform != "" {
+		if _, err := ParsePlatform(s.Platform); err != nil {
+			return errors.Wrap(err, "invalid system platform")
+		}
+	}
+
 	if err := s.setRootfs(); err != nil {
 		return err
 	}
@@ -145,6 +158,21 @@ func (s LuetSystemConfig) GetRepoDatabaseDirPath(name string) string {
 	return dbpath
 }
 
+// GetPlatform returns the platform of the target system. Packages are
+// installed from the artifacts built for it.
+func (s LuetSystemConfig) GetPlatform() Platform {
+	if p, err := ParsePlatform(s.Platform); err == nil {
+		return p
+	}
+	return HostPlatform()
+}
+
+// GetSolverCacheDirPath returns the path where solver solutions are cached
+// in the system target
+func (s LuetSystemConfig) GetSolverCacheDirPath() string {
+	return filepath.Join(s.DatabasePath, "solvercache")
+}
+
 func (s *LuetSystemConfig) setDBPath() error {
 	dbpath := filepath.Join(
 		s.Rootfs,
@@ -0,0 +1,243 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	dockerfile "github.com/asottile/dockerfile"
+	"github.com/pkg/errors"
+)
+
+// DockerfileLabelPrefix is the prefix of the LABELs carrying the package
+// metadata of a Dockerfile package, e.g. org.luet.version
+const DockerfileLabelPrefix = "org.luet."
+
+// DockerfileStage is a FROM instruction of a Dockerfile
+type DockerfileStage struct {
+	// Image is the image as written in the FROM instruction
+	Image string
+	// Name is the stage name given with AS, if any
+	Name string
+	// Line is the line of the FROM instruction, starting from 1
+	Line int
+}
+
+// DockerfileSpec holds the metadata parsed from a Dockerfile package
+type DockerfileSpec struct {
+	Stages []DockerfileStage
+	Labels map[string]string
+}
+
+// ParseDockerfile reads the stages and the labels of a Dockerfile
+func ParseDockerfile(dat string) (*DockerfileSpec, error) {
+	cmds, err := dockerfile.ParseReader(bytes.NewBufferString(dat))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not decode Dockerfile")
+	}
+
+	spec := &DockerfileSpec{Labels: map[string]string{}}
+	for _, c := range cmds {
+		switch strings.ToUpper(c.Cmd) {
+		case "FROM":
+			if len(c.Value) == 0 {
+				continue
+			}
+			stage := DockerfileStage{Image: c.Value[0], Line: c.StartLine}
+			if len(c.Value) == 3 && strings.EqualFold(c.Value[1], "as") {
+				stage.Name = c.Value[2]
+			}
+			spec.Stages = append(spec.Stages, stage)
+		case "LABEL":
+			for i := 0; i+1 < len(c.Value); i += 2 {
+				spec.Labels[unquote(c.Value[i])] = unquote(c.Value[i+1])
+			}
+		}
+	}
+
+	return spec, nil
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' && s[len(s)-1] == '"' || s[0] == '\'' && s[len(s)-1] == '\'') {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// Label returns the value of a luet label, e.g. Label("version")
+func (d *DockerfileSpec) Label(name string) string {
+	return d.Labels[DockerfileLabelPrefix+name]
+}
+
+func (d *DockerfileSpec) listLabel(name string) []string {
+	var res []string
+	for _, f := range strings.Split(d.Label(name), ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+func (d *DockerfileSpec) packagesLabel(name string) ([]*Package, error) {
+	var res []*Package
+	for _, s := range d.listLabel(name) {
+		p, err := packageFromLabel(s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s%s label", DockerfileLabelPrefix, name)
+		}
+		res = append(res, p)
+	}
+	return res, nil
+}
+
+// packageFromLabel parses a package reference in the form
+// category/name or category/name@version, where version can be a selector.
+func packageFromLabel(s string) (*Package, error) {
+	version := ">=0"
+	if i := strings.Index(s, "@"); i != -1 {
+		s, version = s[:i], s[i+1:]
+	}
+	cat, name, ok := strings.Cut(s, "/")
+	if !ok || cat == "" || name == "" || version == "" {
+		return nil, fmt.Errorf("'%s' is not in the category/name[@version] form", s)
+	}
+	return &Package{Category: cat, Name: name, Version: version}, nil
+}
+
+// Package returns the package described by the Dockerfile labels. The name
+// defaults to the given one when no org.luet.name label is set.
+// The package requirements are the runtime ones, set with org.luet.requires.
+func (d *DockerfileSpec) Package(defaultName string) (*Package, error) {
+	p := &Package{
+		Name:        defaultName,
+		Category:    d.Label("category"),
+		Version:     d.Label("version"),
+		Description: d.Label("description"),
+		License:     d.Label("license"),
+		Uri:         d.listLabel("uri"),
+	}
+	if n := d.Label("name"); n != "" {
+		p.Name = n
+	}
+
+	var err error
+	if p.PackageRequires, err = d.packagesLabel("requires"); err != nil {
+		return nil, err
+	}
+	if p.PackageConflicts, err = d.packagesLabel("conflicts"); err != nil {
+		return nil, err
+	}
+	if p.Provides, err = d.packagesLabel("provides"); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// BuildRequires returns the packages required at build time with
+// org.luet.build-requires
+func (d *DockerfileSpec) BuildRequires() ([]*Package, error) {
+	return d.packagesLabel("build-requires")
+}
+
+// Includes returns the org.luet.includes label
+func (d *DockerfileSpec) Includes() []string { return d.listLabel("includes") }
+
+// Excludes returns the org.luet.excludes label
+func (d *DockerfileSpec) Excludes() []string { return d.listLabel("excludes") }
+
+// Unpack returns true if org.luet.unpack is set to true
+func (d *DockerfileSpec) Unpack() bool { return d.Label("unpack") == "true" }
+
+// ExternalStages returns the stages which are not based on an earlier stage
+// of the same Dockerfile
+func (d *DockerfileSpec) ExternalStages() []DockerfileStage {
+	var res []DockerfileStage
+	names := map[string]bool{}
+	for _, s := range d.Stages {
+		if !names[s.Image] {
+			res = append(res, s)
+		}
+		if s.Name != "" {
+			names[s.Name] = true
+		}
+	}
+	return res
+}
+
+// BaseImage returns the image the final stage is built from, following
+// the stages it is based on
+func (d *DockerfileSpec) BaseImage() string {
+	if len(d.Stages) == 0 {
+		return ""
+	}
+	img := d.Stages[len(d.Stages)-1].Image
+	for i := len(d.Stages) - 2; i >= 0; i-- {
+		if d.Stages[i].Name == img {
+			img = d.Stages[i].Image
+		}
+	}
+	return img
+}
+
+// ImagePackage returns the package a FROM image refers to, if it is in
+// the category/name[:version] form
+func ImagePackage(image string) (*Package, bool) {
+	ref, version := image, ">=0"
+	if i := strings.LastIndex(image, ":"); i != -1 && !strings.Contains(image[i:], "/") {
+		ref, version = image[:i], image[i+1:]
+		if version == "latest" {
+			version = ">=0"
+		}
+	}
+	parts := strings.Split(ref, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" || strings.Contains(ref, "@") {
+		return nil, false
+	}
+	return &Package{Category: parts[0], Name: parts[1], Version: version}, true
+}
+
+// IsRequiredImage returns true if a FROM image refers to one of the
+// requirements of p
+func IsRequiredImage(p *Package, image string) bool {
+	ip, ok := ImagePackage(image)
+	if !ok {
+		return false
+	}
+	for _, r := range p.GetRequires() {
+		if r.GetPackageName() == ip.GetPackageName() {
+			return true
+		}
+	}
+	return false
+}
+
+// RewriteFrom replaces the images of the FROM instructions for which
+// replace returns a non-empty string
+func (d *DockerfileSpec) RewriteFrom(dat string, replace func(image string) string) string {
+	lines := strings.Split(dat, "\n")
+	for _, s := range d.Stages {
+		r := replace(s.Image)
+		if r == "" || s.Line < 1 || s.Line > len(lines) {
+			continue
+		}
+		lines[s.Line-1] = strings.Replace(lines[s.Line-1], s.Image, r, 1)
+	}
+	return strings.Join(lines, "\n")
+}
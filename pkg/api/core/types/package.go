@@ -47,6 +47,7 @@ const (
 
 const (
 	PackageMetaSuffix     = "metadata.yaml"
+	PackageSBOMSuffix     = "sbom.spdx.json"
 	PackageCollectionFile = "collection.yaml"
 	PackageDefinitionFile = "definition.yaml"
 )
@@ -221,6 +222,11 @@ func (d *Package) GetMetadataFilePath() string {
 	return fmt.Sprintf("%s.%s", d.GetFingerPrint(), PackageMetaSuffix)
 }
 
+// GetSBOMFilePath returns the canonical name of an artifact SBOM file
+func (d *Package) GetSBOMFilePath() string {
+	return fmt.Sprintf("%s.%s", d.GetFingerPrint(), PackageSBOMSuffix)
+}
+
 // Package represent a standard package definition
 type Package struct {
 	ID               int        `storm:"id,increment" json:"id"` // primary key with auto increment
@@ -747,9 +753,12 @@ func (p *Package) GetRuntimePackage() (*Package, error) {
 			}
 		}
 	} else if p.OriginDockerfile != "" {
-		// XXX: There are no runtime metadata at the moment available except package name in this case
-		// This needs to be adapted and aligned up with the tree parser
-		return &Package{Name: p.Name}, nil
+		// Runtime metadata are carried by the Dockerfile labels
+		spec, err := ParseDockerfile(p.OriginDockerfile)
+		if err != nil {
+			return r, err
+		}
+		return spec.Package(p.Name)
 	} else {
 		definitionFile := filepath.Join(p.Path, PackageDefinitionFile)
 		dat, err := os.ReadFile(definitionFile)
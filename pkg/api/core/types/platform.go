@@ -85,3 +85,37 @@ func (p Platform) String() string {
 func (p Platform) IsZero() bool {
 	return p.OS == "" && p.Arch == "" && p.Variant == ""
 }
+
+// ParsePlatforms parses a comma separated list of platforms, as accepted by
+// luet build --platform.
+func ParsePlatforms(s string) ([]Platform, error) {
+	var res []Platform
+	for _, f := range strings.Split(s, ",") {
+		p, err := ParsePlatform(strings.TrimSpace(f))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, p)
+	}
+	return res, nil
+}
+
+// DirName returns the platform in a form usable as a path component or an
+// image tag suffix, e.g. "linux-arm-v7", or "" for the zero Platform.
+func (p Platform) DirName() string {
+	return strings.ReplaceAll(p.String(), "/", "-")
+}
+
+// Matches reports whether content built for p can be used on target.
+//
+// The zero Platform matches any target: artifacts produced before platforms
+// were recorded carry none. Variants are compared only when both are set.
+func (p Platform) Matches(target Platform) bool {
+	if p.IsZero() || target.IsZero() {
+		return true
+	}
+	if p.OS != target.OS || p.Arch != target.Arch {
+		return false
+	}
+	return p.Variant == "" || target.Variant == "" || p.Variant == target.Variant
+}
@@ -92,6 +92,44 @@ var _ = Describe("Platform", func() {
 		})
 	})
 
+	Context("ParsePlatforms", func() {
+		It("parses a comma separated list", func() {
+			p, err := types.ParsePlatforms("linux/amd64, linux/arm64")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p).To(Equal([]types.Platform{{OS: "linux", Arch: "amd64"}, {OS: "linux", Arch: "arm64"}}))
+		})
+
+		It("rejects invalid entries", func() {
+			_, err := types.ParsePlatforms("linux/amd64,arm64")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("DirName", func() {
+		It("joins the components with dashes", func() {
+			Expect(types.Platform{OS: "linux", Arch: "arm", Variant: "v7"}.DirName()).To(Equal("linux-arm-v7"))
+			Expect(types.Platform{}.DirName()).To(Equal(""))
+		})
+	})
+
+	Context("Matches", func() {
+		It("matches any target when unspecified", func() {
+			Expect(types.Platform{}.Matches(types.Platform{OS: "linux", Arch: "arm64"})).To(BeTrue())
+		})
+
+		It("compares os and arch", func() {
+			amd64 := types.Platform{OS: "linux", Arch: "amd64"}
+			Expect(amd64.Matches(types.Platform{OS: "linux", Arch: "amd64"})).To(BeTrue())
+			Expect(amd64.Matches(types.Platform{OS: "linux", Arch: "arm64"})).To(BeFalse())
+		})
+
+		It("compares variants only when both are set", func() {
+			v7 := types.Platform{OS: "linux", Arch: "arm", Variant: "v7"}
+			Expect(v7.Matches(types.Platform{OS: "linux", Arch: "arm"})).To(BeTrue())
+			Expect(v7.Matches(types.Platform{OS: "linux", Arch: "arm", Variant: "v6"})).To(BeFalse())
+		})
+	})
+
 	Context("HostPlatform", func() {
 		It("reports the running host", func() {
 			p := types.HostPlatform()
@@ -38,6 +38,12 @@ type LuetRepository struct {
 	Arch           string            `json:"arch,omitempty" yaml:"arch,omitempty" mapstructure:"arch"`
 
 	ReferenceID string `json:"reference,omitempty" yaml:"reference,omitempty" mapstructure:"reference"`
+	// Snapshot pins the repository to the state of one of its snapshots,
+	// identified by the ID given to create-repo --snapshot-id.
+	Snapshot string `json:"snapshot,omitempty" yaml:"snapshot,omitempty" mapstructure:"snapshot"`
+	// CABundle is the path of a PEM file with the certificates of the
+	// authorities trusted by http repositories, besides the system ones.
+	CABundle string `json:"ca_bundle,omitempty" yaml:"ca_bundle,omitempty" mapstructure:"ca_bundle"`
 
 	// Incremented value that identify revision of the repository in a user-friendly way.
 	Revision int `json:"revision,omitempty" yaml:"-" mapstructure:"-"`
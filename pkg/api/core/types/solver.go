@@ -46,6 +46,10 @@ type PackageSolver interface {
 
 	SetResolver(PackageResolver)
 
+	// Changes reports the installed packages the last Upgrade or
+	// UpgradeUniverse removed or downgraded.
+	Changes() ChangeReport
+
 	Solve() (PackagesAssertions, error)
 	//	BestInstall(c Packages) (PackagesAssertions, error)
 }
@@ -70,6 +74,30 @@ type SolverOptions struct {
 	// The pass costs one extra SAT solve per attempted improvement, which is why
 	// it is opt-in.
 	Optimize bool `yaml:"optimize,omitempty"`
+
+	// MinimizeChanges enables a post-solve pass for upgrades that tries to
+	// keep every installed package at its version or newer.
+	//
+	// A satisfying world is not necessarily a conservative one: nothing in the
+	// formula says that replacing or dropping an unrelated installed package
+	// is worse than keeping it. Like Optimize, the pass asks the plain solver a
+	// series of "is this still satisfiable if I also keep X?" questions and
+	// keeps every answer that is yes. What is left over is reported by
+	// PackageSolver.Changes as unavoidable.
+	MinimizeChanges bool `yaml:"minimize_changes,omitempty"`
+}
+
+// ChangeReport lists the installed packages that an upgrade solution removes
+// or replaces with an older version.
+type ChangeReport struct {
+	Removed    Packages
+	Downgraded Packages
+}
+
+// Empty returns true if the solution keeps every installed package at its
+// version or newer.
+func (r ChangeReport) Empty() bool {
+	return len(r.Removed) == 0 && len(r.Downgraded) == 0
 }
 
 // PackageResolver assists PackageSolver on unsat cases
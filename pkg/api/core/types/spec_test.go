@@ -18,6 +18,7 @@ package types_test
 import (
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/mudler/luet/pkg/api/core/types"
 	. "github.com/mudler/luet/pkg/api/core/types"
@@ -129,6 +130,93 @@ var _ = ginkgo.Describe("Spec", func() {
 			Expect(hash).ToNot(Equal(hash3))
 			Expect(hash).To(Equal(hashagain))
 		})
+
+		ginkgo.It("Changes when the sources change", func() {
+			spec := &LuetCompilationSpec{
+				Steps:   []string{"foo"},
+				Package: &Package{Name: "foo", Category: "Bar"},
+				Sources: []Source{{URL: "https://example.org/foo-1.0.tar.gz", SHA256: "aaaa"}},
+			}
+			hash, err := spec.Hash()
+			Expect(err).ToNot(HaveOccurred())
+
+			spec.Sources[0].SHA256 = "bbbb"
+			hash2, err := spec.Hash()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hash).ToNot(Equal(hash2))
+
+			Expect(spec.Sources[0].GetDestination()).To(Equal("foo-1.0.tar.gz"))
+			spec.Sources[0].Destination = "src"
+			Expect(spec.Sources[0].GetDestination()).To(Equal("src"))
+		})
+
+		ginkgo.It("Reads the build limits", func() {
+			spec, err := NewLuetCompilationSpec([]byte(`
+steps:
+- make
+timeout: 10m
+memory: 2g
+network: false
+`), &Package{Name: "foo", Category: "Bar"})
+			Expect(err).ToNot(HaveOccurred())
+
+			network := true
+			limits := spec.BuildLimits.Merge(BuildLimits{CPUs: "2", Memory: "1g", Network: &network})
+			timeout, err := limits.GetTimeout()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(timeout).To(Equal(10 * time.Minute))
+			cpus, err := limits.GetCPUs()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cpus).To(Equal(2.0))
+			Expect(limits.Memory).To(Equal("2g"))
+			Expect(limits.NetworkDisabled()).To(BeTrue())
+
+			_, err = BuildLimits{Timeout: "forever"}.GetTimeout()
+			Expect(err).To(HaveOccurred())
+		})
+
+		ginkgo.It("Mounts secrets only in the RUN steps, without changing the hash", func() {
+			spec := &LuetCompilationSpec{
+				Steps:   []string{"make"},
+				Package: &Package{Name: "foo", Category: "Bar"},
+			}
+			hash, err := spec.Hash()
+			Expect(err).ToNot(HaveOccurred())
+
+			spec.Secrets = []Secret{{ID: "token", Env: "TOKEN"}, {ID: "key", File: "key", Target: "/root/.ssh/id_rsa"}}
+			hash2, err := spec.Hash()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hash).To(Equal(hash2))
+
+			dockerfile, err := spec.RenderStepImage("alpine")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dockerfile).To(ContainSubstring("\nRUN --mount=type=secret,id=token --mount=type=secret,id=key,target=/root/.ssh/id_rsa make"))
+			Expect(dockerfile).ToNot(ContainSubstring("TOKEN"))
+		})
+
+		ginkgo.It("refuses secrets which can't be mounted", func() {
+			Expect(Secret{ID: "npm.token_2-a"}.Validate()).To(Succeed())
+			Expect(Secret{ID: "key", Target: "/root/.ssh/id_rsa"}.Validate()).To(Succeed())
+
+			for _, s := range []Secret{
+				{},
+				{ID: "token,src=/etc/shadow"},
+				{ID: "to ken"},
+				{ID: "token\nRUN id"},
+				{ID: "key", Target: "root/.ssh/id_rsa"},
+				{ID: "key", Target: "/root/key,uid=0"},
+				{ID: "key", Target: "/root/my key"},
+				{ID: "key", Target: "/root/key\nRUN id"},
+			} {
+				Expect(s.Validate()).ToNot(Succeed(), s.ID+" "+s.Target)
+			}
+
+			spec := &LuetCompilationSpec{Steps: []string{"make"}, Secrets: []Secret{{ID: "key", Target: "/root/key,uid=0"}}}
+			_, err := spec.RenderStepImage("alpine")
+			Expect(err).To(HaveOccurred())
+			_, err = spec.RenderBuildImage()
+			Expect(err).To(HaveOccurred())
+		})
 	})
 
 	ginkgo.Context("Simple package build definition", func() {
@@ -0,0 +1,280 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mudler/luet/pkg/api/core/types"
+	"github.com/mudler/luet/pkg/api/core/types/artifact"
+	installer "github.com/mudler/luet/pkg/installer"
+	version "github.com/mudler/luet/pkg/versioner"
+)
+
+// PackageSummary is a package in the listings of the API
+type PackageSummary struct {
+	Category    string            `json:"category"`
+	Name        string            `json:"name"`
+	Version     string            `json:"version"`
+	Description string            `json:"description,omitempty"`
+	License     string            `json:"license,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Hidden      bool              `json:"hidden,omitempty"`
+}
+
+// ArtifactInfo is an artifact of a package version
+type ArtifactInfo struct {
+	Path     string   `json:"path"`
+	Platform string   `json:"platform,omitempty"`
+	Files    []string `json:"files"`
+}
+
+// PackageVersion details a version of a package. Dependencies are given as
+// package strings, with their version selectors.
+type PackageVersion struct {
+	PackageSummary
+	URI       []string       `json:"uri,omitempty"`
+	Requires  []string       `json:"requires"`
+	Conflicts []string       `json:"conflicts"`
+	Provides  []string       `json:"provides"`
+	Revdeps   []string       `json:"revdeps"`
+	Artifacts []ArtifactInfo `json:"artifacts"`
+}
+
+// PackageInfo lists the versions of a package available in the repository,
+// oldest first
+type PackageInfo struct {
+	Category string           `json:"category"`
+	Name     string           `json:"name"`
+	Versions []PackageVersion `json:"versions"`
+}
+
+// PackageList is a list of packages returned by the API
+type PackageList struct {
+	Repository string           `json:"repository"`
+	Revision   int              `json:"revision"`
+	Packages   []PackageSummary `json:"packages"`
+}
+
+// FileOwner is an artifact containing a file
+type FileOwner struct {
+	PackageSummary
+	Artifact string `json:"artifact"`
+	Platform string `json:"platform,omitempty"`
+}
+
+// FileOwners lists the artifacts containing a file
+type FileOwners struct {
+	Path     string      `json:"path"`
+	Packages []FileOwner `json:"packages"`
+}
+
+// APIError is returned by the API on failures
+type APIError struct {
+	Error string `json:"error"`
+}
+
+func (s *Server) listPackages(w http.ResponseWriter, r *http.Request) {
+	repo, ok := s.repository(w)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, packageList(repo, repo.GetTree().GetDatabase().World()))
+}
+
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		writeError(w, http.StatusBadRequest, "missing query parameter 'q'")
+		return
+	}
+	// FindPackageMatch panics on invalid expressions
+	if _, err := regexp.Compile(q); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	repo, ok := s.repository(w)
+	if !ok {
+		return
+	}
+	matches, err := repo.GetTree().GetDatabase().FindPackageMatch(q)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, packageList(repo, matches))
+}
+
+func (s *Server) packageInfo(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(r.PathValue("package"), "/")
+	category, name := path.Split(name)
+	category = strings.TrimSuffix(category, "/")
+	if category == "" || name == "" {
+		writeError(w, http.StatusBadRequest, "packages are requested as <category>/<name>")
+		return
+	}
+	repo, ok := s.repository(w)
+	if !ok {
+		return
+	}
+
+	db := repo.GetTree().GetDatabase()
+	versions := types.Packages{}
+	for _, p := range db.World() {
+		if p.GetCategory() == category && p.GetName() == name {
+			versions = append(versions, p)
+		}
+	}
+	if len(versions) == 0 {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("package '%s/%s' not found", category, name))
+		return
+	}
+	sortPackages(versions)
+
+	artifacts := artifactsByPackage(repo)
+	info := PackageInfo{Category: category, Name: name}
+	for _, p := range versions {
+		revdeps, _ := db.GetRevdeps(p)
+		sortPackages(revdeps)
+		v := PackageVersion{
+			PackageSummary: summary(p),
+			URI:            p.GetURI(),
+			Requires:       packageStrings(p.GetRequires()),
+			Conflicts:      packageStrings(p.GetConflicts()),
+			Provides:       packageStrings(p.GetProvides()),
+			Revdeps:        packageStrings(revdeps),
+			Artifacts:      []ArtifactInfo{},
+		}
+		for _, a := range artifacts[p.GetFingerPrint()] {
+			files := a.Files
+			if files == nil {
+				files = []string{}
+			}
+			v.Artifacts = append(v.Artifacts, ArtifactInfo{Path: a.RepositoryPath(), Platform: a.Platform.String(), Files: files})
+		}
+		info.Versions = append(info.Versions, v)
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (s *Server) files(w http.ResponseWriter, r *http.Request) {
+	file := strings.TrimPrefix(r.URL.Query().Get("path"), "/")
+	if file == "" {
+		writeError(w, http.StatusBadRequest, "missing query parameter 'path'")
+		return
+	}
+	repo, ok := s.repository(w)
+	if !ok {
+		return
+	}
+
+	res := FileOwners{Path: file, Packages: []FileOwner{}}
+	for _, a := range repo.GetIndex() {
+		for _, f := range a.Files {
+			if strings.TrimPrefix(f, "/") == file {
+				res.Packages = append(res.Packages, FileOwner{
+					PackageSummary: summary(a.CompileSpec.GetPackage()),
+					Artifact:       a.RepositoryPath(),
+					Platform:       a.Platform.String(),
+				})
+				break
+			}
+		}
+	}
+	sort.SliceStable(res.Packages, func(i, j int) bool {
+		return res.Packages[i].Artifact < res.Packages[j].Artifact
+	})
+	writeJSON(w, http.StatusOK, res)
+}
+
+// repository returns the repository served, or replies with an error if it
+// can't be loaded
+func (s *Server) repository(w http.ResponseWriter) (*installer.LuetSystemRepository, bool) {
+	repo, err := s.Repository()
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return nil, false
+	}
+	return repo, true
+}
+
+func packageList(repo *installer.LuetSystemRepository, pp types.Packages) PackageList {
+	sortPackages(pp)
+	l := PackageList{Repository: repo.GetName(), Revision: repo.GetRevision(), Packages: []PackageSummary{}}
+	for _, p := range pp {
+		l.Packages = append(l.Packages, summary(p))
+	}
+	return l
+}
+
+func summary(p *types.Package) PackageSummary {
+	return PackageSummary{
+		Category:    p.GetCategory(),
+		Name:        p.GetName(),
+		Version:     p.GetVersion(),
+		Description: p.GetDescription(),
+		License:     p.GetLicense(),
+		Labels:      p.GetLabels(),
+		Hidden:      p.IsHidden(),
+	}
+}
+
+func packageStrings(pp []*types.Package) []string {
+	res := []string{}
+	for _, p := range pp {
+		res = append(res, p.HumanReadableString())
+	}
+	return res
+}
+
+// artifactsByPackage groups the artifacts of the repository index by the
+// fingerprint of their package
+func artifactsByPackage(repo *installer.LuetSystemRepository) map[string][]*artifact.PackageArtifact {
+	res := map[string][]*artifact.PackageArtifact{}
+	for _, a := range repo.GetIndex() {
+		fp := a.CompileSpec.GetPackage().GetFingerPrint()
+		res[fp] = append(res[fp], a)
+	}
+	return res
+}
+
+// sortPackages sorts the packages by name, then by version
+func sortPackages(pp types.Packages) {
+	v := version.DefaultVersioner()
+	sort.SliceStable(pp, func(i, j int) bool {
+		if a, b := pp[i].GetPackageName(), pp[j].GetPackageName(); a != b {
+			return a < b
+		}
+		a, b := pp[i].GetVersion(), pp[j].GetVersion()
+		return a != b && v.Sort([]string{a, b})[0] == a
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, APIError{Error: msg})
+}
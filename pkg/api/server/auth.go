@@ -0,0 +1,143 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Auth authenticates the requests to a server, either with the basic
+// credentials of the users in an htpasswd file, or with the bearer tokens
+// in a tokens file
+type Auth struct {
+	users  map[string]string
+	tokens []string
+}
+
+// NewAuth returns an Auth without users nor tokens
+func NewAuth() *Auth {
+	return &Auth{users: map[string]string{}}
+}
+
+// LoadHtpasswd loads the users of the htpasswd file. Passwords have to be
+// hashed with bcrypt (htpasswd -B) or SHA1 (htpasswd -s).
+func (a *Auth) LoadHtpasswd(file string) error {
+	return readLines(file, func(line string) error {
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return fmt.Errorf("invalid htpasswd entry '%s'", line)
+		}
+		if !strings.HasPrefix(hash, "$2") && !strings.HasPrefix(hash, "{SHA}") {
+			return fmt.Errorf("unsupported password hash for user '%s', only bcrypt and SHA1 are supported", user)
+		}
+		a.users[user] = hash
+		return nil
+	})
+}
+
+// LoadTokens loads the bearer tokens of the file, one per line
+func (a *Auth) LoadTokens(file string) error {
+	return readLines(file, func(line string) error {
+		a.tokens = append(a.tokens, line)
+		return nil
+	})
+}
+
+// Authenticate returns the user authenticated by the credentials of the
+// request, "token" for bearer tokens. Tokens are accepted with the
+// "Bearer" and the "token" schemes.
+func (a *Auth) Authenticate(r *http.Request) (string, bool) {
+	if user, password, ok := r.BasicAuth(); ok {
+		return user, a.checkPassword(user, password)
+	}
+
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || (!strings.EqualFold(scheme, "bearer") && !strings.EqualFold(scheme, "token")) {
+		return "", false
+	}
+	found := false
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			found = true
+		}
+	}
+	return "token", found
+}
+
+func (a *Auth) checkPassword(user, password string) bool {
+	hash, ok := a.users[user]
+	if !ok {
+		return false
+	}
+	if sha, ok := strings.CutPrefix(hash, "{SHA}"); ok {
+		sum := sha1.Sum([]byte(password))
+		return subtle.ConstantTimeCompare([]byte(sha), []byte(base64.StdEncoding.EncodeToString(sum[:]))) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// Handler returns a handler serving with h only the authenticated requests
+func (a *Auth) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := a.Authenticate(r)
+		if !ok {
+			if len(a.users) > 0 {
+				w.Header().Add("WWW-Authenticate", `Basic realm="luet"`)
+			}
+			if len(a.tokens) > 0 {
+				w.Header().Add("WWW-Authenticate", `Bearer realm="luet"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if info := requestInfoFrom(r); info != nil {
+			info.user = user
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// readLines calls f with the lines of file, skipping empty lines and
+// comments
+func readLines(file string, f func(string) error) error {
+	fd, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := f(line); err != nil {
+			return errors.Wrapf(err, "while reading '%s'", file)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,111 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/mudler/luet/pkg/api/core/context"
+	. "github.com/mudler/luet/pkg/api/server"
+	"github.com/mudler/luet/pkg/installer/client"
+	"golang.org/x/crypto/bcrypt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server authentication", func() {
+	var tmpdir string
+	var srv *httptest.Server
+	var ctx *context.Context
+
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = os.MkdirTemp("", "auth")
+		Expect(err).ToNot(HaveOccurred())
+		ctx = context.NewContext()
+		ctx.Config.System.PkgsCachePath = filepath.Join(tmpdir, "cache")
+
+		hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.WriteFile(filepath.Join(tmpdir, "htpasswd"), []byte(
+			"# users\nalice:"+string(hash)+"\nbob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n",
+		), os.ModePerm)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(tmpdir, "tokens"), []byte("\nagent-token\n"), os.ModePerm)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(tmpdir, "test.txt"), []byte("test"), os.ModePerm)).To(Succeed())
+
+		auth := NewAuth()
+		Expect(auth.LoadHtpasswd(filepath.Join(tmpdir, "htpasswd"))).To(Succeed())
+		Expect(auth.LoadTokens(filepath.Join(tmpdir, "tokens"))).To(Succeed())
+		srv = httptest.NewServer(AccessLog(ctx, auth.Handler(http.FileServer(http.Dir(tmpdir)))))
+	})
+
+	AfterEach(func() {
+		srv.Close()
+		os.RemoveAll(tmpdir)
+	})
+
+	status := func(set func(r *http.Request)) int {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/test.txt", nil)
+		Expect(err).ToNot(HaveOccurred())
+		set(req)
+		resp, err := http.DefaultClient.Do(req)
+		Expect(err).ToNot(HaveOccurred())
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	It("refuses requests without valid credentials", func() {
+		resp, err := http.Get(srv.URL + "/test.txt")
+		Expect(err).ToNot(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+		Expect(resp.Header.Values("WWW-Authenticate")).To(ConsistOf(`Basic realm="luet"`, `Bearer realm="luet"`))
+
+		Expect(status(func(r *http.Request) { r.SetBasicAuth("alice", "wrong") })).To(Equal(http.StatusUnauthorized))
+		Expect(status(func(r *http.Request) { r.SetBasicAuth("carol", "secret") })).To(Equal(http.StatusUnauthorized))
+		Expect(status(func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") })).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("accepts users and tokens", func() {
+		Expect(status(func(r *http.Request) { r.SetBasicAuth("alice", "secret") })).To(Equal(http.StatusOK))
+		Expect(status(func(r *http.Request) { r.SetBasicAuth("bob", "password") })).To(Equal(http.StatusOK))
+		Expect(status(func(r *http.Request) { r.Header.Set("Authorization", "Bearer agent-token") })).To(Equal(http.StatusOK))
+		Expect(status(func(r *http.Request) { r.Header.Set("Authorization", "token agent-token") })).To(Equal(http.StatusOK))
+	})
+
+	It("authenticates the http clients of repositories", func() {
+		for _, auth := range []map[string]string{
+			{"username": "alice", "password": "secret"},
+			{"basic": "bob:password"},
+			{"token": "agent-token"},
+		} {
+			c := client.NewHttpClient(client.RepoData{Urls: []string{srv.URL}, Authentication: auth}, ctx)
+			path, err := c.DownloadFile("test.txt")
+			Expect(err).ToNot(HaveOccurred())
+			os.RemoveAll(path)
+		}
+	})
+
+	It("refuses unsupported password hashes", func() {
+		file := filepath.Join(tmpdir, "md5")
+		Expect(os.WriteFile(file, []byte("alice:$apr1$abc$def\n"), os.ModePerm)).To(Succeed())
+		Expect(NewAuth().LoadHtpasswd(file)).To(MatchError(ContainSubstring("unsupported password hash")))
+	})
+})
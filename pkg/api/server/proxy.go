@@ -0,0 +1,404 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cavaliercoder/grab"
+	"github.com/mudler/luet/pkg/api/core/types"
+	"github.com/mudler/luet/pkg/api/core/types/artifact"
+	fileHelper "github.com/mudler/luet/pkg/helpers/file"
+	installer "github.com/mudler/luet/pkg/installer"
+	"github.com/pkg/errors"
+)
+
+// ProxyOptions configures the cache of a Proxy
+type ProxyOptions struct {
+	// CacheDir is the folder where the files of the upstream repository
+	// are cached
+	CacheDir string
+	// TTL is how long the files without checksums, such as the repository
+	// specs, are served from the cache before being fetched again
+	TTL time.Duration
+	// MaxSize bounds the size of the cache in bytes, 0 for no bound. The
+	// least recently used files are evicted first.
+	MaxSize int64
+}
+
+// Proxy serves the files of an upstream repository through a local cache.
+// The files referenced by the repository specs, such as the artifacts in
+// their index, are verified against their checksums when fetched and
+// served from the cache thereafter, while the other files expire after
+// the TTL.
+type Proxy struct {
+	ctx      types.Context
+	upstream *installer.LuetSystemRepository
+	client   installer.Client
+	o        ProxyOptions
+
+	sync.Mutex
+	known map[string]artifact.Checksums
+	specs map[string]time.Time
+	locks map[string]*fetchLock
+
+	evicting sync.Mutex
+}
+
+// NewProxy returns a caching proxy for the upstream repository
+func NewProxy(ctx types.Context, upstream *installer.LuetSystemRepository, o ProxyOptions) (*Proxy, error) {
+	if upstream.GetType() == installer.DockerRepositoryType {
+		return nil, errors.New("docker repositories can't be proxied")
+	}
+	c := upstream.Client(ctx)
+	if c == nil {
+		return nil, errors.New("no client could be generated from repository")
+	}
+	if err := os.MkdirAll(o.CacheDir, os.ModePerm); err != nil {
+		return nil, errors.Wrapf(err, "while creating '%s'", o.CacheDir)
+	}
+	return &Proxy{
+		ctx:      ctx,
+		upstream: upstream,
+		client:   c,
+		o:        o,
+		known:    map[string]artifact.Checksums{},
+		specs:    map[string]time.Time{},
+		locks:    map[string]*fetchLock{},
+	}, nil
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	file, err := p.Get(name)
+	if err != nil {
+		p.ctx.Warning("Failed proxying", name, err.Error())
+		status := http.StatusBadGateway
+		if notFound(err) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	http.ServeFile(w, r, file)
+}
+
+// Get returns the cached copy of the file name of the upstream repository,
+// fetching it if needed
+func (p *Proxy) Get(name string) (string, error) {
+	// The checksums of the files are known once the spec referencing them
+	// is fetched, which clients do first unless they use a cached spec.
+	if _, ok := p.checksums(name); !ok && !isSpecFile(name) {
+		if _, err := p.Get(installer.REPOSITORY_SPECFILE); err != nil {
+			p.ctx.Debug("Failed fetching the repository spec", err.Error())
+		}
+	}
+
+	defer p.lock(name)()
+
+	if sums, ok := p.checksums(name); ok {
+		return p.object(name, sums)
+	}
+
+	file, err := p.file(name)
+	if err != nil || !isSpecFile(name) {
+		return file, err
+	}
+	if err := p.register(name, file); err != nil {
+		p.ctx.Warning("Failed reading", name, err.Error())
+	}
+	return file, nil
+}
+
+// object returns the cached copy of the file name with the checksums sums,
+// fetching and verifying it if needed
+func (p *Proxy) object(name string, sums artifact.Checksums) (string, error) {
+	dst := filepath.Join(p.o.CacheDir, "objects", objectKey(sums))
+	if fileHelper.Exists(dst) {
+		now := time.Now()
+		os.Chtimes(dst, now, now)
+		return dst, nil
+	}
+
+	tmp, err := p.client.DownloadFile(name)
+	if err != nil {
+		return "", errors.Wrapf(err, "while fetching '%s'", name)
+	}
+	defer os.RemoveAll(tmp)
+
+	a := artifact.NewPackageArtifact(tmp)
+	a.Checksums = sums
+	if err := a.Verify(); err != nil {
+		return "", errors.Wrapf(err, "file integrity check failure of '%s'", name)
+	}
+	if err := p.store(tmp, dst); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+// file returns the cached copy of the file name, fetching it if it's older
+// than the TTL. The cached copy is served if it can't be fetched.
+func (p *Proxy) file(name string) (string, error) {
+	dst := filepath.Join(p.o.CacheDir, "files", filepath.FromSlash(name))
+	info, statErr := os.Stat(dst)
+	if statErr == nil && time.Since(info.ModTime()) < p.o.TTL {
+		return dst, nil
+	}
+
+	tmp, err := p.client.DownloadFile(name)
+	if err != nil {
+		if statErr == nil {
+			p.ctx.Warning("Serving cached", name, "as it can't be fetched:", err.Error())
+			return dst, nil
+		}
+		return "", errors.Wrapf(err, "while fetching '%s'", name)
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := p.store(tmp, dst); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+// register records the checksums of the files referenced by the spec file
+// name, cached in file, and of the artifacts in its index
+func (p *Proxy) register(name, file string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	p.Lock()
+	registered := p.specs[name].Equal(info.ModTime())
+	p.Unlock()
+	if registered {
+		return nil
+	}
+
+	spec, err := p.upstream.ReadSpecFile(file)
+	if err != nil {
+		return err
+	}
+	sums := map[string]artifact.Checksums{}
+	for _, f := range spec.RepositoryFiles {
+		if len(f.GetChecksums()) > 0 {
+			sums[f.GetFileName()] = f.GetChecksums()
+		}
+	}
+
+	meta, err := spec.GetRepositoryFile(installer.REPOFILE_META_KEY)
+	if err != nil {
+		return err
+	}
+	unlock := p.lock(meta.GetFileName())
+	metaFile, err := p.object(meta.GetFileName(), meta.GetChecksums())
+	unlock()
+	if err != nil {
+		return err
+	}
+	index, err := p.index(metaFile, meta.GetCompressionType())
+	if err != nil {
+		return err
+	}
+	for _, a := range index {
+		if len(a.Checksums) > 0 {
+			sums[a.RepositoryPath()] = a.Checksums
+		}
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	for f, s := range sums {
+		p.known[f] = s
+	}
+	p.specs[name] = info.ModTime()
+	return nil
+}
+
+// index reads the index of the artifacts in the metadata archive file
+func (p *Proxy) index(file string, t types.CompressionImplementation) ([]*artifact.PackageArtifact, error) {
+	metafs, err := p.ctx.TempDir("metafs")
+	if err != nil {
+		return nil, errors.Wrap(err, "Error met while creating tempdir for metafs")
+	}
+	defer os.RemoveAll(metafs)
+
+	a := artifact.NewPackageArtifact(file)
+	a.CompressionType = t
+	if err := a.Unpack(p.ctx, metafs, false); err != nil {
+		return nil, errors.Wrap(err, "Error met while unpacking metadata")
+	}
+	meta, err := installer.NewLuetSystemRepositoryMetadata(filepath.Join(metafs, installer.REPOSITORY_METAFILE), false)
+	if err != nil {
+		return nil, errors.Wrap(err, "While processing "+installer.REPOSITORY_METAFILE)
+	}
+	return meta.ToArtifactIndex(), nil
+}
+
+// store copies src to dst in the cache, then evicts the least recently
+// used files if the cache exceeds its size
+func (p *Proxy) store(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".tmp-")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err := fileHelper.CopyFile(src, tmp.Name()); err != nil {
+		return errors.Wrapf(err, "while caching '%s'", dst)
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return errors.Wrapf(err, "while caching '%s'", dst)
+	}
+
+	if p.o.MaxSize > 0 {
+		p.evict(dst)
+	}
+	return nil
+}
+
+type cachedFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// evict removes the least recently used files until the cache fits its
+// size, besides the file keep which was just cached
+func (p *Proxy) evict(keep string) {
+	p.evicting.Lock()
+	defer p.evicting.Unlock()
+
+	var files []cachedFile
+	var total int64
+	filepath.Walk(p.o.CacheDir, func(currentpath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || strings.HasPrefix(info.Name(), ".tmp-") {
+			return nil
+		}
+		files = append(files, cachedFile{path: currentpath, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if total <= p.o.MaxSize {
+		return
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, f := range files {
+		if total <= p.o.MaxSize {
+			break
+		}
+		if f.path == keep {
+			continue
+		}
+		p.ctx.Debug("Evicting", f.path, "from the cache")
+		if err := os.Remove(f.path); err != nil {
+			p.ctx.Warning("Failed evicting", f.path, err.Error())
+			continue
+		}
+		total -= f.size
+	}
+}
+
+func (p *Proxy) checksums(name string) (artifact.Checksums, bool) {
+	p.Lock()
+	defer p.Unlock()
+	sums, ok := p.known[name]
+	return sums, ok
+}
+
+// fetchLock serializes the fetches of a file, counting the requests
+// holding or waiting for it
+type fetchLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock acquires the lock serializing the fetches of the file name, returning
+// the function releasing it. The lock is dropped once no request needs it.
+func (p *Proxy) lock(name string) func() {
+	p.Lock()
+	l, ok := p.locks[name]
+	if !ok {
+		l = &fetchLock{}
+		p.locks[name] = l
+	}
+	l.refs++
+	p.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		p.Lock()
+		defer p.Unlock()
+		if l.refs--; l.refs == 0 {
+			delete(p.locks, name)
+		}
+	}
+}
+
+// notFound returns true if err reports a file missing upstream
+func notFound(err error) bool {
+	var status grab.StatusCodeError
+	if errors.As(err, &status) {
+		return int(status) == http.StatusNotFound
+	}
+	return errors.Is(err, os.ErrNotExist)
+}
+
+// isSpecFile returns true for the repository spec and the specs of its
+// snapshots
+func isSpecFile(name string) bool {
+	return name == installer.REPOSITORY_SPECFILE || strings.HasSuffix(name, "-"+installer.REPOSITORY_SPECFILE)
+}
+
+// objectKey returns the name of the cached copy of the files with the
+// given checksums
+func objectKey(sums artifact.Checksums) string {
+	kinds := []string{}
+	for t := range sums {
+		kinds = append(kinds, t)
+	}
+	sort.Strings(kinds)
+
+	h := sha256.New()
+	for _, t := range kinds {
+		fmt.Fprintf(h, "%s:%s\n", t, sums[t])
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
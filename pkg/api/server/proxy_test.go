@@ -0,0 +1,197 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package server_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mudler/luet/pkg/api/core/context"
+	"github.com/mudler/luet/pkg/api/core/types"
+	. "github.com/mudler/luet/pkg/api/server"
+	installer "github.com/mudler/luet/pkg/installer"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Repository proxy", func() {
+	var upstream, cache, pkgCache string
+	var srv *httptest.Server
+	var ctx *context.Context
+
+	BeforeEach(func() {
+		var err error
+		upstream, err = os.MkdirTemp("", "upstream")
+		Expect(err).ToNot(HaveOccurred())
+		cache, err = os.MkdirTemp("", "proxy")
+		Expect(err).ToNot(HaveOccurred())
+		pkgCache, err = os.MkdirTemp("", "cache")
+		Expect(err).ToNot(HaveOccurred())
+		ctx = context.NewContext()
+		ctx.Config.System.PkgsCachePath = pkgCache
+
+		packArtifacts(upstream)
+		generateRepo(ctx, upstream)
+	})
+
+	AfterEach(func() {
+		if srv != nil {
+			srv.Close()
+		}
+		os.RemoveAll(upstream)
+		os.RemoveAll(cache)
+		os.RemoveAll(pkgCache)
+	})
+
+	serve := func(o ProxyOptions) {
+		o.CacheDir = cache
+		p, err := NewProxy(ctx, installer.NewSystemRepository(types.LuetRepository{
+			Name: "upstream",
+			Type: installer.DiskRepositoryType,
+			Urls: []string{upstream},
+		}), o)
+		Expect(err).ToNot(HaveOccurred())
+		srv = httptest.NewServer(p)
+	}
+
+	get := func(name string) (int, string) {
+		resp, err := http.Get(srv.URL + "/" + name)
+		Expect(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+		dat, err := io.ReadAll(resp.Body)
+		Expect(err).ToNot(HaveOccurred())
+		return resp.StatusCode, string(dat)
+	}
+
+	artifactPath := func(name string) string {
+		repo, err := installer.LoadLocalRepository(ctx, upstream)
+		Expect(err).ToNot(HaveOccurred())
+		for _, a := range repo.GetIndex() {
+			if a.CompileSpec.GetPackage().GetName() == name {
+				return a.RepositoryPath()
+			}
+		}
+		Fail("no artifact for " + name)
+		return ""
+	}
+
+	It("serves a repository which clients can install from", func() {
+		serve(ProxyOptions{TTL: time.Minute})
+
+		report, err := installer.CheckRepository(ctx, installer.NewSystemRepository(types.LuetRepository{
+			Name: "proxied",
+			Type: installer.HttpRepositoryType,
+			Urls: []string{srv.URL},
+		}), installer.CheckOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.Problems).To(BeEmpty())
+		Expect(report.Artifacts).To(Equal(3))
+	})
+
+	It("serves the artifacts from the cache once fetched", func() {
+		serve(ProxyOptions{TTL: time.Minute})
+		name := artifactPath("a")
+		expected, err := os.ReadFile(filepath.Join(upstream, name))
+		Expect(err).ToNot(HaveOccurred())
+
+		status, body := get(name)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(Equal(string(expected)))
+
+		Expect(os.Remove(filepath.Join(upstream, name))).To(Succeed())
+		status, body = get(name)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(Equal(string(expected)))
+	})
+
+	It("doesn't serve artifacts not matching their checksums", func() {
+		serve(ProxyOptions{TTL: time.Minute})
+		name := artifactPath("b")
+		Expect(os.WriteFile(filepath.Join(upstream, name), []byte("corrupted"), os.ModePerm)).To(Succeed())
+
+		status, body := get(name)
+		Expect(status).To(Equal(http.StatusBadGateway))
+		Expect(body).To(ContainSubstring("integrity check failure"))
+	})
+
+	It("returns not found for the files missing upstream", func() {
+		serve(ProxyOptions{TTL: time.Minute})
+		status, _ := get("missing.tar.gz")
+		Expect(status).To(Equal(http.StatusNotFound))
+
+		files := httptest.NewServer(http.FileServer(http.Dir(upstream)))
+		defer files.Close()
+		p, err := NewProxy(ctx, installer.NewSystemRepository(types.LuetRepository{
+			Name: "upstream",
+			Type: installer.HttpRepositoryType,
+			Urls: []string{files.URL},
+		}), ProxyOptions{CacheDir: cache, TTL: time.Minute})
+		Expect(err).ToNot(HaveOccurred())
+		srv.Close()
+		srv = httptest.NewServer(p)
+		status, _ = get("missing.tar.gz")
+		Expect(status).To(Equal(http.StatusNotFound))
+	})
+
+	It("fetches the repository spec again after the TTL", func() {
+		serve(ProxyOptions{TTL: time.Hour})
+		_, body := get(installer.REPOSITORY_SPECFILE)
+		Expect(body).To(ContainSubstring("revision: 1"))
+
+		generateRepo(ctx, upstream)
+		_, body = get(installer.REPOSITORY_SPECFILE)
+		Expect(body).To(ContainSubstring("revision: 1"))
+
+		srv.Close()
+		serve(ProxyOptions{TTL: time.Nanosecond})
+		_, body = get(installer.REPOSITORY_SPECFILE)
+		Expect(body).To(ContainSubstring("revision: 2"))
+	})
+
+	It("evicts the least recently used files", func() {
+		serve(ProxyOptions{TTL: time.Minute, MaxSize: 1})
+		a, b := artifactPath("a"), artifactPath("b")
+
+		status, _ := get(a)
+		Expect(status).To(Equal(http.StatusOK))
+		status, _ = get(b)
+		Expect(status).To(Equal(http.StatusOK))
+
+		objects, err := os.ReadDir(filepath.Join(cache, "objects"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(objects).To(HaveLen(1))
+
+		expected, err := os.ReadFile(filepath.Join(upstream, b))
+		Expect(err).ToNot(HaveOccurred())
+		cached, err := os.ReadFile(filepath.Join(cache, "objects", objects[0].Name()))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cached).To(Equal(expected))
+	})
+
+	It("refuses docker repositories", func() {
+		_, err := NewProxy(ctx, installer.NewSystemRepository(types.LuetRepository{
+			Name: "upstream",
+			Type: installer.DockerRepositoryType,
+			Urls: []string{"quay.io/luet/repo"},
+		}), ProxyOptions{CacheDir: cache})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,138 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mudler/luet/pkg/api/core/types"
+)
+
+// ServeOptions configures how a handler is served
+type ServeOptions struct {
+	// CertFile and KeyFile enable TLS
+	CertFile, KeyFile string
+	// ShutdownTimeout is how long the requests in flight are waited for
+	// when shutting down
+	ShutdownTimeout time.Duration
+}
+
+// ListenAndServe serves h on address until ctx is done, then shuts down
+// gracefully
+func ListenAndServe(ctx context.Context, address string, h http.Handler, o ServeOptions) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return Serve(ctx, l, h, o)
+}
+
+// Serve serves h on the listener l until ctx is done, then stops accepting
+// connections and waits for the requests in flight, up to the shutdown
+// timeout
+func Serve(ctx context.Context, l net.Listener, h http.Handler, o ServeOptions) error {
+	srv := &http.Server{Handler: h}
+	errs := make(chan error, 1)
+	go func() {
+		if o.CertFile != "" || o.KeyFile != "" {
+			errs <- srv.ServeTLS(l, o.CertFile, o.KeyFile)
+		} else {
+			errs <- srv.Serve(l)
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), o.ShutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+type requestInfoKey struct{}
+
+// requestInfo collects the details of a request logged once served
+type requestInfo struct {
+	user string
+}
+
+func requestInfoFrom(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// accessRecorder records the status and the size of a response
+type accessRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (a *accessRecorder) WriteHeader(status int) {
+	a.status = status
+	a.ResponseWriter.WriteHeader(status)
+}
+
+func (a *accessRecorder) Write(b []byte) (int, error) {
+	if a.status == 0 {
+		a.status = http.StatusOK
+	}
+	n, err := a.ResponseWriter.Write(b)
+	a.bytes += int64(n)
+	return n, err
+}
+
+func (a *accessRecorder) Unwrap() http.ResponseWriter {
+	return a.ResponseWriter
+}
+
+// AccessLog returns a handler serving with h and logging each request with
+// the logger of ctx, as key=value pairs
+func AccessLog(ctx types.Context, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{user: "-"}
+		rec := &accessRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		remote := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(remote); err == nil {
+			remote = host
+		}
+		ctx.Info(strings.Join([]string{
+			"access",
+			fmt.Sprintf("remote=%s", remote),
+			fmt.Sprintf("user=%q", info.user),
+			fmt.Sprintf("method=%s", r.Method),
+			fmt.Sprintf("path=%q", r.URL.RequestURI()),
+			fmt.Sprintf("status=%d", rec.status),
+			fmt.Sprintf("bytes=%d", rec.bytes),
+			fmt.Sprintf("duration=%s", time.Since(start).Round(time.Microsecond)),
+			fmt.Sprintf("agent=%q", r.UserAgent()),
+		}, " "))
+	})
+}
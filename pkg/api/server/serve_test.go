@@ -0,0 +1,140 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package server_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	luetcontext "github.com/mudler/luet/pkg/api/core/context"
+	. "github.com/mudler/luet/pkg/api/server"
+	"github.com/mudler/luet/pkg/installer/client"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// writeCertificate writes a self-signed certificate for 127.0.0.1 and its
+// key in dir
+func writeCertificate(dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "luet"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	Expect(err).ToNot(HaveOccurred())
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	Expect(os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), os.ModePerm)).To(Succeed())
+	Expect(os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), os.ModePerm)).To(Succeed())
+	return certFile, keyFile
+}
+
+var _ = Describe("Serving", func() {
+	var tmpdir string
+	var l net.Listener
+
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = os.MkdirTemp("", "serve")
+		Expect(err).ToNot(HaveOccurred())
+		l, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpdir)
+	})
+
+	It("serves over TLS", func() {
+		certFile, keyFile := writeCertificate(tmpdir)
+		Expect(os.WriteFile(filepath.Join(tmpdir, "test.txt"), []byte("test"), os.ModePerm)).To(Succeed())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- Serve(ctx, l, http.FileServer(http.Dir(tmpdir)), ServeOptions{CertFile: certFile, KeyFile: keyFile, ShutdownTimeout: time.Second})
+		}()
+
+		luetCtx := luetcontext.NewContext()
+		luetCtx.Config.System.PkgsCachePath = filepath.Join(tmpdir, "cache")
+		c := client.NewHttpClient(client.RepoData{Urls: []string{"https://" + l.Addr().String()}, CABundle: certFile}, luetCtx)
+		path, err := c.DownloadFile("test.txt")
+		Expect(err).ToNot(HaveOccurred())
+		dat, err := os.ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(dat)).To(Equal("test"))
+		os.RemoveAll(path)
+
+		cancel()
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	It("waits for the requests in flight when shutting down", func() {
+		started := make(chan bool)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- Serve(ctx, l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				started <- true
+				time.Sleep(200 * time.Millisecond)
+				w.Write([]byte("done"))
+			}), ServeOptions{ShutdownTimeout: 5 * time.Second})
+		}()
+
+		body := make(chan string)
+		go func() {
+			defer GinkgoRecover()
+			resp, err := http.Get("http://" + l.Addr().String())
+			Expect(err).ToNot(HaveOccurred())
+			defer resp.Body.Close()
+			dat, err := io.ReadAll(resp.Body)
+			Expect(err).ToNot(HaveOccurred())
+			body <- string(dat)
+		}()
+
+		Eventually(started).Should(Receive())
+		cancel()
+		Eventually(body).Should(Receive(Equal("done")))
+		Eventually(done).Should(Receive(BeNil()))
+
+		_, err := http.Get("http://" + l.Addr().String())
+		Expect(err).To(HaveOccurred())
+	})
+})
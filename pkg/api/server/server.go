@@ -0,0 +1,105 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mudler/luet/pkg/api/core/types"
+	installer "github.com/mudler/luet/pkg/installer"
+	"github.com/pkg/errors"
+)
+
+// Server serves the files of a repository generated in a folder, along with
+// a JSON API to query its packages
+type Server struct {
+	ctx types.Context
+	dir string
+	mux *http.ServeMux
+
+	sync.RWMutex
+	repo    *installer.LuetSystemRepository
+	modTime time.Time
+	size    int64
+}
+
+// New returns a server for the repository generated in dir. The repository
+// is loaded on the first API request, and reloaded whenever its
+// repository.yaml changes.
+func New(ctx types.Context, dir string) *Server {
+	s := &Server{ctx: ctx, dir: dir, mux: http.NewServeMux()}
+
+	s.mux.HandleFunc("GET /api/v1/packages", s.listPackages)
+	s.mux.HandleFunc("GET /api/v1/packages/{package...}", s.packageInfo)
+	s.mux.HandleFunc("GET /api/v1/search", s.search)
+	s.mux.HandleFunc("GET /api/v1/files", s.files)
+	s.mux.Handle("/", http.FileServer(http.Dir(dir)))
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Repository returns the repository served, reloading it if its spec file
+// changed since it was loaded. If reloading fails, the repository
+// previously loaded is kept.
+func (s *Server) Repository() (*installer.LuetSystemRepository, error) {
+	info, err := os.Stat(filepath.Join(s.dir, installer.REPOSITORY_SPECFILE))
+	if err != nil {
+		return s.loaded(errors.Wrap(err, "no repository found"))
+	}
+
+	s.RLock()
+	current := s.repo != nil && info.ModTime().Equal(s.modTime) && info.Size() == s.size
+	repo := s.repo
+	s.RUnlock()
+	if current {
+		return repo, nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if s.repo != nil && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.repo, nil
+	}
+	repo, err = installer.LoadLocalRepositoryTree(s.ctx, s.dir)
+	if err != nil {
+		err = errors.Wrapf(err, "while loading the repository in '%s'", s.dir)
+		if s.repo == nil {
+			return nil, err
+		}
+		s.ctx.Warning(err.Error())
+		return s.repo, nil
+	}
+	s.ctx.Info("Loaded repository", repo.GetName(), "revision", repo.GetRevision())
+	s.repo, s.modTime, s.size = repo, info.ModTime(), info.Size()
+	return repo, nil
+}
+
+// loaded returns the repository previously loaded, if any, or err
+func (s *Server) loaded(err error) (*installer.LuetSystemRepository, error) {
+	s.RLock()
+	defer s.RUnlock()
+	if s.repo == nil {
+		return nil, err
+	}
+	return s.repo, nil
+}
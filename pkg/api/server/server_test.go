@@ -0,0 +1,191 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package server_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/mudler/luet/pkg/api/core/context"
+	"github.com/mudler/luet/pkg/api/core/types"
+	artifact "github.com/mudler/luet/pkg/api/core/types/artifact"
+	. "github.com/mudler/luet/pkg/api/server"
+	pkg "github.com/mudler/luet/pkg/database"
+	installer "github.com/mudler/luet/pkg/installer"
+	"github.com/mudler/luet/pkg/tree"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const treeDir = "../../../tests/fixtures/simple_dep"
+
+// packArtifacts packs the packages of the tree in dst, with a file named
+// after each of them
+func packArtifacts(dst string) {
+	recipe := tree.NewCompilerRecipe(pkg.NewInMemoryDatabase(false))
+	Expect(recipe.Load(treeDir)).To(Succeed())
+
+	for _, p := range recipe.GetDatabase().World() {
+		src, err := os.MkdirTemp("", "src")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(src)
+		Expect(os.WriteFile(filepath.Join(src, p.GetName()), []byte(p.HumanReadableString()), os.ModePerm)).To(Succeed())
+
+		a := artifact.NewPackageArtifact(filepath.Join(dst, p.GetFingerPrint()+".package.tar"))
+		a.CompileSpec = &types.LuetCompilationSpec{Package: p}
+		Expect(a.Compress(src, 1)).To(Succeed())
+		files, err := a.FileList()
+		Expect(err).ToNot(HaveOccurred())
+		a.Files = files
+		Expect(a.WriteYAML(dst)).To(Succeed())
+	}
+}
+
+// generateRepo generates a disk repository in dst with the artifacts there
+func generateRepo(ctx types.Context, dst string) {
+	repo, err := installer.GenerateRepository(
+		installer.WithName("test"),
+		installer.WithDescription("description"),
+		installer.WithType("disk"),
+		installer.WithUrls(dst),
+		installer.WithPriority(1),
+		installer.WithSource(dst),
+		installer.FromMetadata(true),
+		installer.WithTree(treeDir),
+		installer.WithContext(ctx),
+		installer.WithDatabase(pkg.NewInMemoryDatabase(false)),
+	)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(repo.Write(ctx, dst, false, true)).To(Succeed())
+}
+
+var _ = Describe("Repository server", func() {
+	var tmpdir string
+	var srv *httptest.Server
+	ctx := context.NewContext()
+
+	get := func(url string, status int, v interface{}) {
+		resp, err := http.Get(srv.URL + url)
+		Expect(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(status))
+		if v != nil {
+			Expect(resp.Header.Get("Content-Type")).To(Equal("application/json"))
+			Expect(json.NewDecoder(resp.Body).Decode(v)).To(Succeed())
+		}
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = os.MkdirTemp("", "repo")
+		Expect(err).ToNot(HaveOccurred())
+		packArtifacts(tmpdir)
+		generateRepo(ctx, tmpdir)
+		srv = httptest.NewServer(New(ctx, tmpdir))
+	})
+
+	AfterEach(func() {
+		srv.Close()
+		os.RemoveAll(tmpdir)
+	})
+
+	It("serves the repository files", func() {
+		resp, err := http.Get(srv.URL + "/" + installer.REPOSITORY_SPECFILE)
+		Expect(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		dat, err := io.ReadAll(resp.Body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(dat)).To(ContainSubstring("revision: 1"))
+	})
+
+	It("lists and searches packages", func() {
+		var l PackageList
+		get("/api/v1/packages", http.StatusOK, &l)
+		Expect(l.Repository).To(Equal("test"))
+		Expect(l.Revision).To(Equal(1))
+		Expect(l.Packages).To(Equal([]PackageSummary{
+			{Category: "test", Name: "a", Version: "1.2"},
+			{Category: "test", Name: "b", Version: "1.1"},
+			{Category: "test", Name: "c", Version: "1.0"},
+		}))
+
+		get("/api/v1/search?q=test/[ab]", http.StatusOK, &l)
+		Expect(l.Packages).To(HaveLen(2))
+
+		var e APIError
+		get("/api/v1/search?q=[", http.StatusBadRequest, &e)
+		Expect(e.Error).ToNot(BeEmpty())
+		get("/api/v1/search", http.StatusBadRequest, &e)
+	})
+
+	It("returns the details of a package", func() {
+		var info PackageInfo
+		get("/api/v1/packages/test/a", http.StatusOK, &info)
+		Expect(info.Category).To(Equal("test"))
+		Expect(info.Name).To(Equal("a"))
+		Expect(info.Versions).To(HaveLen(1))
+		Expect(info.Versions[0].Version).To(Equal("1.2"))
+		Expect(info.Versions[0].Revdeps).To(Equal([]string{"test/c-1.0"}))
+		Expect(info.Versions[0].Artifacts).To(HaveLen(1))
+		Expect(info.Versions[0].Artifacts[0].Files).To(Equal([]string{"a"}))
+
+		get("/api/v1/packages/test/c", http.StatusOK, &info)
+		Expect(info.Versions[0].Requires).To(Equal([]string{"test/a->=0.1"}))
+		Expect(info.Versions[0].Revdeps).To(BeEmpty())
+
+		var e APIError
+		get("/api/v1/packages/test/d", http.StatusNotFound, &e)
+		Expect(e.Error).To(ContainSubstring("test/d"))
+	})
+
+	It("finds the packages owning a file", func() {
+		var owners FileOwners
+		get("/api/v1/files?path=/b", http.StatusOK, &owners)
+		Expect(owners.Path).To(Equal("b"))
+		Expect(owners.Packages).To(HaveLen(1))
+		Expect(owners.Packages[0].Name).To(Equal("b"))
+		Expect(owners.Packages[0].Artifact).ToNot(BeEmpty())
+
+		get("/api/v1/files?path=missing", http.StatusOK, &owners)
+		Expect(owners.Packages).To(BeEmpty())
+	})
+
+	It("reloads the repository when it changes", func() {
+		var l PackageList
+		get("/api/v1/packages", http.StatusOK, &l)
+		Expect(l.Packages).To(HaveLen(3))
+
+		Expect(os.Remove(filepath.Join(tmpdir, "c-test-1.0.metadata.yaml"))).To(Succeed())
+		generateRepo(ctx, tmpdir)
+
+		get("/api/v1/packages", http.StatusOK, &l)
+		Expect(l.Revision).To(Equal(2))
+		Expect(l.Packages).To(HaveLen(2))
+	})
+
+	It("fails when no repository is served", func() {
+		Expect(os.Remove(filepath.Join(tmpdir, installer.REPOSITORY_SPECFILE))).To(Succeed())
+		var e APIError
+		get("/api/v1/packages", http.StatusServiceUnavailable, &e)
+		Expect(e.Error).To(ContainSubstring("no repository found"))
+	})
+})
@@ -16,7 +16,15 @@
 package backend
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/mudler/luet/pkg/api/core/types"
 	"github.com/pkg/errors"
@@ -37,27 +45,125 @@ type Options struct {
 	Destination    string
 	Context        string
 	BackendArgs    []string
+	// LogFile, if set, receives a copy of the combined output of the
+	// backend commands run for this image. Output is appended.
+	LogFile string
+	// Platform, if set, is the platform the image is built for, in the
+	// os/arch[/variant] form
+	Platform string
+
+	// Timeout, if set, is the maximum time the image build can take
+	Timeout time.Duration
+	// Memory, if set, limits the memory of the build containers (e.g. 2g)
+	Memory string
+	// CPUs, if set, limits the number of CPUs the build containers can use
+	CPUs float64
+	// NoNetwork runs the build containers without network access
+	NoNetwork bool
+	// Secrets are mounted in the RUN steps of the build
+	Secrets []Secret
+}
+
+// Secret is a build secret, read from the Src file of the build host
+type Secret struct {
+	ID  string
+	Src string
+}
+
+// secretValues returns the values of the secrets, which have to be scrubbed
+// from the build output
+func secretValues(secrets []Secret) ([]string, error) {
+	var values []string
+	for _, s := range secrets {
+		dat, err := os.ReadFile(s.Src)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed reading secret '%s'", s.ID)
+		}
+		if v := strings.TrimSpace(string(dat)); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
+
+// TimeoutError is returned when a backend command is killed for taking
+// longer than its timeout
+type TimeoutError struct {
+	Timeout time.Duration
+	Output  string
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s: %s", e.Timeout, e.Output)
 }
 
-func runCommand(ctx types.Context, cmd *exec.Cmd) error {
+// IsTimeout returns true if err is, or wraps, a TimeoutError
+func IsTimeout(err error) bool {
+	var timeoutErr *TimeoutError
+	return errors.As(err, &timeoutErr)
+}
+
+func runCommand(ctx types.Context, cmd *exec.Cmd, opts Options) error {
 	output := ""
+	timeout := opts.Timeout
 	buffered := !ctx.GetConfig().General.ShowBuildOutput
 	writer := NewBackendWriter(buffered, ctx)
 
-	cmd.Stdout = writer
-	cmd.Stderr = writer
+	var out io.Writer = writer
+	if opts.LogFile != "" {
+		f, err := os.OpenFile(opts.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return errors.Wrap(err, "Failed opening log file")
+		}
+		defer f.Close()
+		out = io.MultiWriter(writer, f)
+	}
+
+	secrets, err := secretValues(opts.Secrets)
+	if err != nil {
+		return err
+	}
+	var scrubber *ScrubWriter
+	if len(secrets) > 0 {
+		scrubber = NewScrubWriter(out, secrets)
+		out = scrubber
+	}
+
+	cmd.Stdout = out
+	cmd.Stderr = out
+	// Run in its own process group, so on timeout the whole tree is killed
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
 	if buffered {
 		ctx.Spinner()
 		defer ctx.SpinnerStop()
 	}
 
-	err := cmd.Start()
+	deadline := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		deadline, cancel = context.WithTimeout(deadline, timeout)
+		defer cancel()
+	}
+
+	err = cmd.Start()
 	if err != nil {
 		return errors.Wrap(err, "Failed starting command")
 	}
 
-	err = cmd.Wait()
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err = <-done:
+	case <-deadline.Done():
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		scrubber.Flush()
+		return &TimeoutError{Timeout: timeout, Output: writer.GetCombinedOutput()}
+	}
+
+	scrubber.Flush()
 	if err != nil {
 		output = writer.GetCombinedOutput()
 		return errors.Wrapf(err, "Failed running command: %s", output)
@@ -72,6 +178,26 @@ func genBuildCommand(opts Options) []string {
 	if context == "" {
 		context = "."
 	}
-	buildarg := append(opts.BackendArgs, "-f", opts.DockerFileName, "-t", opts.ImageName, context)
-	return append([]string{"build"}, buildarg...)
+	buildarg := append([]string{"build"}, opts.BackendArgs...)
+	if opts.Platform != "" {
+		buildarg = append(buildarg, "--platform", opts.Platform)
+	}
+	if opts.Memory != "" {
+		buildarg = append(buildarg, "--memory", opts.Memory)
+	}
+	if opts.CPUs > 0 {
+		// --cpus is not available when building, express it as a CFS quota
+		period := 100000
+		buildarg = append(buildarg,
+			"--cpu-period", strconv.Itoa(period),
+			"--cpu-quota", strconv.Itoa(int(opts.CPUs*float64(period))),
+		)
+	}
+	if opts.NoNetwork {
+		buildarg = append(buildarg, "--network", "none")
+	}
+	for _, secret := range opts.Secrets {
+		buildarg = append(buildarg, "--secret", "id="+secret.ID+",src="+secret.Src)
+	}
+	return append(buildarg, "-f", opts.DockerFileName, "-t", opts.ImageName, context)
 }
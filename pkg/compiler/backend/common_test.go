@@ -0,0 +1,147 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package backend_test
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mudler/luet/pkg/api/core/context"
+	. "github.com/mudler/luet/pkg/compiler/backend"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Build limits", func() {
+	ctx := context.NewContext()
+	var tmpdir, oldPath string
+
+	// A fake docker, recording its arguments and then running the given
+	// script
+	fakeDocker := func(script string) {
+		Expect(os.WriteFile(filepath.Join(tmpdir, "docker"), []byte("#!/bin/sh\necho \"$@\" > "+filepath.Join(tmpdir, "args")+"\n"+script+"\n"), 0755)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = os.MkdirTemp("", "limits")
+		Expect(err).ToNot(HaveOccurred())
+		oldPath = os.Getenv("PATH")
+		os.Setenv("PATH", tmpdir+string(os.PathListSeparator)+oldPath)
+	})
+
+	AfterEach(func() {
+		os.Setenv("PATH", oldPath)
+		os.RemoveAll(tmpdir)
+	})
+
+	It("passes the limits to the build", func() {
+		fakeDocker("echo \"BUILDKIT=$DOCKER_BUILDKIT\" > " + filepath.Join(tmpdir, "env"))
+
+		b := NewSimpleDockerBackend(ctx)
+		Expect(b.BuildImage(Options{
+			ImageName:      "foo",
+			SourcePath:     tmpdir,
+			DockerFileName: "Dockerfile",
+			Memory:         "2g",
+			CPUs:           1.5,
+			NoNetwork:      true,
+		})).To(Succeed())
+
+		args, err := os.ReadFile(filepath.Join(tmpdir, "args"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(args)).To(Equal("build --memory 2g --cpu-period 100000 --cpu-quota 150000 --network none -f Dockerfile -t foo .\n"))
+
+		// BuildKit ignores the limits
+		env, err := os.ReadFile(filepath.Join(tmpdir, "env"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(env)).To(Equal("BUILDKIT=0\n"))
+	})
+
+	It("refuses limits with secrets, which require BuildKit", func() {
+		secret := filepath.Join(tmpdir, "secret")
+		Expect(os.WriteFile(secret, []byte("s3cr3t\n"), 0600)).To(Succeed())
+		fakeDocker("exit 0")
+
+		b := NewSimpleDockerBackend(ctx)
+		Expect(b.BuildImage(Options{
+			ImageName:      "foo",
+			SourcePath:     tmpdir,
+			DockerFileName: "Dockerfile",
+			Memory:         "2g",
+			Secrets:        []Secret{{ID: "token", Src: secret}},
+		})).ToNot(Succeed())
+		Expect(filepath.Join(tmpdir, "args")).ToNot(BeAnExistingFile())
+	})
+
+	It("mounts secrets with BuildKit and scrubs them from the output", func() {
+		secret := filepath.Join(tmpdir, "secret")
+		Expect(os.WriteFile(secret, []byte("s3cr3t\n"), 0600)).To(Succeed())
+		logFile := filepath.Join(tmpdir, "build.log")
+		fakeDocker("echo \"BUILDKIT=$DOCKER_BUILDKIT\"; echo \"token is s3cr3t\"")
+
+		b := NewSimpleDockerBackend(ctx)
+		Expect(b.BuildImage(Options{
+			ImageName:      "foo",
+			SourcePath:     tmpdir,
+			DockerFileName: "Dockerfile",
+			LogFile:        logFile,
+			Secrets:        []Secret{{ID: "token", Src: secret}},
+		})).To(Succeed())
+
+		args, err := os.ReadFile(filepath.Join(tmpdir, "args"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(args)).To(Equal("build --secret id=token,src=" + secret + " -f Dockerfile -t foo .\n"))
+
+		log, err := os.ReadFile(logFile)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(log)).To(ContainSubstring("BUILDKIT=1"))
+		Expect(string(log)).To(ContainSubstring("token is ***"))
+		Expect(string(log)).ToNot(ContainSubstring("s3cr3t"))
+	})
+
+	It("kills builds taking longer than their timeout", func() {
+		fakeDocker("sleep 10")
+
+		b := NewSimpleDockerBackend(ctx)
+		start := time.Now()
+		err := b.BuildImage(Options{
+			ImageName:      "foo",
+			SourcePath:     tmpdir,
+			DockerFileName: "Dockerfile",
+			Timeout:        200 * time.Millisecond,
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(IsTimeout(err)).To(BeTrue())
+		Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+	})
+
+	It("tells failures apart from timeouts", func() {
+		fakeDocker("exit 1")
+
+		b := NewSimpleDockerBackend(ctx)
+		err := b.BuildImage(Options{
+			ImageName:      "foo",
+			SourcePath:     tmpdir,
+			DockerFileName: "Dockerfile",
+			Timeout:        time.Minute,
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(IsTimeout(err)).To(BeFalse())
+	})
+})
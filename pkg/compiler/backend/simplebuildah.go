@@ -53,7 +53,7 @@ func (s *SimpleBuildah) BuildImage(opts Options) error {
 
 	cmd := exec.Command("buildah", buildarg...)
 	cmd.Dir = opts.SourcePath
-	if err := runCommand(s.ctx, cmd); err != nil {
+	if err := runCommand(s.ctx, cmd, opts); err != nil {
 		return err
 	}
 
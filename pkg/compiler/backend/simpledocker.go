@@ -17,6 +17,7 @@ package backend
 
 import (
 	"io"
+	"os"
 	"os/exec"
 
 	"github.com/google/go-containerregistry/pkg/crane"
@@ -49,7 +50,19 @@ func (s *SimpleDocker) BuildImage(opts Options) error {
 	s.ctx.Info(":whale2: Building image " + name)
 	cmd := exec.Command("docker", buildarg...)
 	cmd.Dir = opts.SourcePath
-	err := runCommand(s.ctx, cmd)
+	limited := opts.Memory != "" || opts.CPUs > 0
+	switch {
+	case len(opts.Secrets) > 0 && limited:
+		return errors.New("memory and cpus limits are ignored by docker with BuildKit, which is required by secrets: use the buildah backend to build packages with both")
+	case len(opts.Secrets) > 0:
+		// Secrets are supported only by BuildKit
+		cmd.Env = append(os.Environ(), "DOCKER_BUILDKIT=1")
+	case limited:
+		// Memory and cpus limits are applied only by the legacy builder,
+		// BuildKit ignores them
+		cmd.Env = append(os.Environ(), "DOCKER_BUILDKIT=0")
+	}
+	err := runCommand(s.ctx, cmd, opts)
 	if err != nil {
 		return err
 	}
@@ -100,9 +100,13 @@ RUN echo bar > /test2`))
 				SourcePath:     tmpdir,
 				DockerFileName: "LuetDockerfile",
 				Destination:    filepath.Join(tmpdir, "output2.tar"),
+				LogFile:        filepath.Join(tmpdir2, "build.log"),
 			}
 
 			Expect(b.BuildImage(opts2)).ToNot(HaveOccurred())
+			buildLog, err := fileHelper.Read(filepath.Join(tmpdir2, "build.log"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(buildLog).ToNot(BeEmpty())
 			Expect(b.ExportImage(opts2)).ToNot(HaveOccurred())
 			Expect(fileHelper.Exists(filepath.Join(tmpdir, "output2.tar"))).To(BeTrue())
 
@@ -18,6 +18,9 @@ package backend
 
 import (
 	"bytes"
+	"io"
+	"sort"
+	"strings"
 
 	"github.com/mudler/luet/pkg/api/core/types"
 )
@@ -48,3 +51,79 @@ func (b *BackendWriter) Write(p []byte) (int, error) {
 
 func (b *BackendWriter) Close() error              { return nil }
 func (b *BackendWriter) GetCombinedOutput() string { return b.Buffer.String() }
+
+// scrubBufferSize is the size after which the ScrubWriter writes out its
+// buffered output, even without line endings
+const scrubBufferSize = 64 * 1024
+
+// minScrubLineLength is the length under which the lines of multi-line
+// secrets are not scrubbed, as they would mangle most of the output
+const minScrubLineLength = 4
+
+// ScrubWriter replaces the given secrets with *** in what it writes to the
+// underlying writer. Output is processed by lines, so secrets are scrubbed
+// even when split across writes, and each line of multi-line secrets is
+// scrubbed on its own. Flush writes out the last partial line.
+type ScrubWriter struct {
+	w       io.Writer
+	secrets [][]byte
+	pending []byte
+	// keep is the number of bytes held back when writing out an unterminated
+	// line, which could be the beginning of a secret
+	keep int
+}
+
+func NewScrubWriter(w io.Writer, secrets []string) *ScrubWriter {
+	s := &ScrubWriter{w: w}
+	for _, secret := range secrets {
+		lines := strings.Split(secret, "\n")
+		for _, l := range lines {
+			l = strings.TrimSpace(l)
+			if l == "" || (len(lines) > 1 && len(l) < minScrubLineLength) {
+				continue
+			}
+			s.secrets = append(s.secrets, []byte(l))
+			if len(l)-1 > s.keep {
+				s.keep = len(l) - 1
+			}
+		}
+	}
+	// Longer secrets first, so the ones containing others are fully scrubbed
+	sort.SliceStable(s.secrets, func(i, j int) bool { return len(s.secrets[i]) > len(s.secrets[j]) })
+	return s
+}
+
+func (s *ScrubWriter) Write(p []byte) (int, error) {
+	s.pending = s.scrub(append(s.pending, p...))
+
+	end := bytes.LastIndexAny(s.pending, "\r\n") + 1
+	if len(s.pending) > scrubBufferSize && len(s.pending)-s.keep > end {
+		end = len(s.pending) - s.keep
+	}
+	if end == 0 {
+		return len(p), nil
+	}
+
+	if _, err := s.w.Write(s.pending[:end]); err != nil {
+		return 0, err
+	}
+	s.pending = append([]byte{}, s.pending[end:]...)
+	return len(p), nil
+}
+
+// Flush writes out the buffered output. It is a no-op on a nil ScrubWriter.
+func (s *ScrubWriter) Flush() error {
+	if s == nil || len(s.pending) == 0 {
+		return nil
+	}
+	_, err := s.w.Write(s.scrub(s.pending))
+	s.pending = nil
+	return err
+}
+
+func (s *ScrubWriter) scrub(p []byte) []byte {
+	for _, secret := range s.secrets {
+		p = bytes.ReplaceAll(p, secret, []byte("***"))
+	}
+	return p
+}
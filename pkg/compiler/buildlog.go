@@ -0,0 +1,105 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package compiler
+
+import (
+	"os"
+	"path/filepath"
+
+	bus "github.com/mudler/luet/pkg/api/core/bus"
+	"github.com/mudler/luet/pkg/api/core/types"
+	fileHelper "github.com/mudler/luet/pkg/helpers/file"
+	"github.com/pkg/errors"
+)
+
+// BuildLogDir is the folder, inside the build destination, where the
+// build output of each package is stored
+const BuildLogDir = "logs"
+
+// BuildError is returned when building a package fails. It carries the
+// package which failed and the path of its build log, if any.
+type BuildError struct {
+	Package *types.Package
+	LogFile string
+	Err     error
+}
+
+func (e *BuildError) Error() string { return e.Err.Error() }
+func (e *BuildError) Unwrap() error { return e.Err }
+
+// asBuildError makes sure err is, or wraps, a BuildError. Failures which
+// happen before building any image are attributed to the requested package.
+func asBuildError(err error, p *types.LuetCompilationSpec) error {
+	var buildErr *BuildError
+	if errors.As(err, &buildErr) {
+		return err
+	}
+	return &BuildError{Package: p.GetPackage(), Err: err}
+}
+
+// buildLogRel returns the path of the build log of a package, relative to
+// the build destination
+func buildLogRel(p *types.LuetCompilationSpec) string {
+	return filepath.Join(BuildLogDir, p.GetPackage().GetFingerPrint()+".log")
+}
+
+// buildLogPath returns the path of the build log of a package
+func buildLogPath(p *types.LuetCompilationSpec) string {
+	return p.Rel(buildLogRel(p))
+}
+
+// newBuildLog creates an empty build log for the package, dropping the
+// output of previous builds. The output is not captured, and an empty path
+// returned, for packages without an output path.
+func newBuildLog(p *types.LuetCompilationSpec) (string, error) {
+	if p.GetOutputPath() == "" {
+		return "", nil
+	}
+	logFile := buildLogPath(p)
+	if err := os.MkdirAll(filepath.Dir(logFile), os.ModePerm); err != nil {
+		return "", errors.Wrap(err, "Could not create build log folder")
+	}
+	f, err := os.Create(logFile)
+	if err != nil {
+		return "", errors.Wrap(err, "Could not create build log")
+	}
+	return logFile, f.Close()
+}
+
+// existingBuildLog returns the relative path of the package build log, or
+// an empty string if the package has none
+func existingBuildLog(p *types.LuetCompilationSpec) string {
+	if p.GetOutputPath() == "" || !fileHelper.Exists(buildLogPath(p)) {
+		return ""
+	}
+	return buildLogRel(p)
+}
+
+func publishBuildLog(p *types.LuetCompilationSpec, logFile string, buildErr error) {
+	msg := ""
+	if buildErr != nil {
+		msg = buildErr.Error()
+	}
+	bus.Manager.Publish(bus.EventPackageBuildLog, struct {
+		CompileSpec *types.LuetCompilationSpec
+		LogFile     string
+		Error       string
+	}{
+		CompileSpec: p,
+		LogFile:     logFile,
+		Error:       msg,
+	})
+}
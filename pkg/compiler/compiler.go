@@ -16,7 +16,6 @@
 package compiler
 
 import (
-	"bytes"
 	"crypto/md5"
 	"fmt"
 	"io"
@@ -30,7 +29,6 @@ import (
 	"sync"
 	"time"
 
-	dockerfile "github.com/asottile/dockerfile"
 	"github.com/imdario/mergo"
 	bus "github.com/mudler/luet/pkg/api/core/bus"
 	"github.com/mudler/luet/pkg/api/core/context"
@@ -57,24 +55,38 @@ func (i ArtifactIndex) CleanPath() ArtifactIndex {
 	newIndex := ArtifactIndex{}
 	for _, art := range i {
 		copy := art.ShallowCopy()
-		copy.Path = path.Base(art.Path)
+		copy.Path = art.RepositoryPath()
 		newIndex = append(newIndex, copy)
 	}
 	return newIndex
 }
 
+// ForPlatform returns the artifacts which can be installed on the given
+// platform. Artifacts which don't declare a platform are always included.
+func (i ArtifactIndex) ForPlatform(p types.Platform) ArtifactIndex {
+	newIndex := ArtifactIndex{}
+	for _, art := range i {
+		if art.Platform.Matches(p) {
+			newIndex = append(newIndex, art)
+		}
+	}
+	return newIndex
+}
+
 type LuetCompiler struct {
 	//*tree.CompilerRecipe
 	Backend  CompilerBackend
 	Database types.PackageDatabase
 	Options  types.CompilerOptions
+
+	recorder *buildRecorder
 }
 
 func NewCompiler(p ...types.CompilerOption) *LuetCompiler {
 	c := newDefaultCompiler()
 	c.Apply(p...)
 
-	return &LuetCompiler{Options: *c}
+	return &LuetCompiler{Options: *c, recorder: newBuildRecorder()}
 }
 
 func NewLuetCompiler(backend CompilerBackend, db types.PackageDatabase, compilerOpts ...types.CompilerOption) *LuetCompiler {
@@ -95,9 +107,12 @@ func (cs *LuetCompiler) compilerWorker(i int, wg *sync.WaitGroup, cspecs chan *t
 	defer wg.Done()
 
 	for s := range cspecs {
+		start := time.Now()
 		ar, err := cs.compile(concurrency, keepPermissions, nil, nil, s)
+		cs.recorder.add(cs, s, ar, time.Since(start), err)
 		if err != nil {
-			errors <- err
+			errors <- asBuildError(err, s)
+			continue
 		}
 
 		m.Lock()
@@ -114,14 +129,33 @@ func (cs *LuetCompiler) CompileWithReverseDeps(keepPermissions bool, ps *types.L
 	}
 
 	cs.Options.Context.Info(":ant: Resolving reverse dependencies")
+	uniques, revdepsErr := cs.ComputeReverseDeps(ps)
+	if revdepsErr != nil {
+		return nil, append(err, revdepsErr)
+	}
+	for _, u := range uniques.All() {
+		cs.Options.Context.Info(" :arrow_right_hook:", u.GetPackage().GetName(), ":leaves:", u.GetPackage().GetVersion(), "(", u.GetPackage().GetCategory(), ")")
+	}
+
+	artifacts2, err := cs.CompileParallel(keepPermissions, uniques)
+	return append(artifacts, artifacts2...), err
+}
+
+// ComputeReverseDeps returns the specs of the packages depending at build
+// time on the supplied ones, excluding them. They are written in the same
+// output path of the supplied specs.
+func (cs *LuetCompiler) ComputeReverseDeps(ps *types.LuetCompilationspecs) (*types.LuetCompilationspecs, error) {
 	toCompile := types.NewLuetCompilationspecs()
-	for _, a := range artifacts {
+	if ps.Len() == 0 {
+		return toCompile, nil
+	}
 
-		revdeps := a.CompileSpec.GetPackage().Revdeps(cs.Database)
+	for _, sp := range ps.All() {
+		revdeps := sp.GetPackage().Revdeps(cs.Database)
 		for _, r := range revdeps {
-			spec, asserterr := cs.FromPackage(r)
+			spec, err := cs.FromPackage(r)
 			if err != nil {
-				return nil, append(err, asserterr)
+				return nil, err
 			}
 			spec.SetOutputPath(ps.All()[0].GetOutputPath())
 
@@ -129,13 +163,7 @@ func (cs *LuetCompiler) CompileWithReverseDeps(keepPermissions bool, ps *types.L
 		}
 	}
 
-	uniques := toCompile.Unique().Remove(ps)
-	for _, u := range uniques.All() {
-		cs.Options.Context.Info(" :arrow_right_hook:", u.GetPackage().GetName(), ":leaves:", u.GetPackage().GetVersion(), "(", u.GetPackage().GetCategory(), ")")
-	}
-
-	artifacts2, err := cs.CompileParallel(keepPermissions, uniques)
-	return append(artifacts, artifacts2...), err
+	return toCompile.Unique().Remove(ps), nil
 }
 
 // CompileParallel compiles the supplied compilationspecs in parallel
@@ -265,7 +293,11 @@ func (cs *LuetCompiler) unpackFs(concurrency int, keepPermissions bool, p *types
 	a := artifact.NewPackageArtifact(p.Rel(p.GetPackage().GetFingerPrint() + ".package.tar"))
 	a.CompressionType = cs.Options.CompressionType
 
-	if err := a.Compress(toUnpack, concurrency); err != nil {
+	if err := a.Compress(toUnpack, concurrency,
+		artifact.WithSourceDateEpoch(cs.Options.SourceDateEpoch),
+		artifact.WithCompressionLevel(cs.Options.CompressionLevel),
+		artifact.WithZstdLongWindow(cs.Options.ZstdLongWindow),
+	); err != nil {
 		return nil, errors.Wrap(err, "Error met while creating package archive")
 	}
 
@@ -327,6 +359,9 @@ func (cs *LuetCompiler) unpackDelta(concurrency int, keepPermissions bool, p *ty
 		cs.Options.CompressionType,
 		p.Rel(fmt.Sprintf("%s%s", p.GetPackage().GetFingerPrint(), ".package.tar")),
 		filter,
+		artifact.WithSourceDateEpoch(cs.Options.SourceDateEpoch),
+		artifact.WithCompressionLevel(cs.Options.CompressionLevel),
+		artifact.WithZstdLongWindow(cs.Options.ZstdLongWindow),
 	)
 	if err != nil {
 		return nil, err
@@ -336,6 +371,91 @@ func (cs *LuetCompiler) unpackDelta(concurrency int, keepPermissions bool, p *ty
 	return a, nil
 }
 
+// CompileKeepGoing compiles the supplied compilationspecs level by level,
+// following the order given by BuildTree. Packages which fail do not stop the
+// build: only the packages depending on them are skipped.
+// The outcome of every package is available afterwards from Report().
+func (cs *LuetCompiler) CompileKeepGoing(keepPermissions bool, ps *types.LuetCompilationspecs) ([]*artifact.PackageArtifact, []error) {
+	bt, err := cs.BuildTree(*ps)
+	if err != nil {
+		return nil, []error{errors.Wrap(err, "failed computing build tree")}
+	}
+
+	treeKey := func(p *types.Package) string {
+		return fmt.Sprintf("%s/%s", p.GetCategory(), p.GetName())
+	}
+
+	levels := map[int]*types.LuetCompilationspecs{}
+	for _, sp := range ps.All() {
+		l := bt.Level(treeKey(sp.GetPackage()))
+		if _, ok := levels[l]; !ok {
+			levels[l] = types.NewLuetCompilationspecs()
+		}
+		levels[l].Add(sp)
+	}
+
+	var artifacts []*artifact.PackageArtifact
+	var allErrors []error
+	failed := map[string]string{}
+
+	for _, l := range bt.AllLevels() {
+		specs, ok := levels[l]
+		if !ok {
+			continue
+		}
+
+		toBuild := types.NewLuetCompilationspecs()
+	SPECS:
+		for _, sp := range specs.All() {
+			if len(failed) != 0 {
+				deps, err := cs.ComputeDepTree(sp, cs.Database)
+				if err != nil {
+					cs.recorder.add(cs, sp, nil, 0, err)
+					allErrors = append(allErrors, asBuildError(err, sp))
+					continue
+				}
+				for _, d := range deps {
+					if f, ok := failed[treeKey(d.Package)]; ok && d.Value && treeKey(d.Package) != treeKey(sp.GetPackage()) {
+						cs.Options.Context.Warning(":fast_forward: Skipping", sp.GetPackage().HumanReadableString(), "as it depends on", f, "which failed")
+						cs.recorder.skip(cs, sp, "dependency "+f+" failed to build")
+						continue SPECS
+					}
+				}
+			}
+			toBuild.Add(sp)
+		}
+
+		if toBuild.Len() == 0 {
+			continue
+		}
+
+		a, errs := cs.CompileParallel(keepPermissions, toBuild)
+		artifacts = append(artifacts, a...)
+		for _, e := range errs {
+			var buildErr *BuildError
+			if errors.As(e, &buildErr) && buildErr.Package != nil {
+				failed[treeKey(buildErr.Package)] = buildErr.Package.HumanReadableString()
+			}
+		}
+		// A spec failing because of one of its dependencies has to be
+		// considered failed as well
+		for _, sp := range toBuild.All() {
+			built := false
+			for _, ar := range a {
+				if ar.CompileSpec != nil && ar.CompileSpec.GetPackage().Matches(sp.GetPackage()) {
+					built = true
+				}
+			}
+			if !built {
+				failed[treeKey(sp.GetPackage())] = sp.GetPackage().HumanReadableString()
+			}
+		}
+		allErrors = append(allErrors, errs...)
+	}
+
+	return artifacts, allErrors
+}
+
 func (cs *LuetCompiler) buildPackageImage(image, buildertaggedImage, packageImage string,
 	concurrency int, keepPermissions bool,
 	p *types.LuetCompilationSpec) (backend.Options, backend.Options, error) {
@@ -372,7 +492,17 @@ func (cs *LuetCompiler) buildPackageImage(image, buildertaggedImage, packageImag
 		}
 	}
 
+	// Fetch the upstream sources in the build context
+	if err := cs.FetchSources(p, buildDir); err != nil {
+		return builderOpts, runnerOpts, err
+	}
+
 	// First we create the builder image
+	logFile, err := newBuildLog(p)
+	if err != nil {
+		return builderOpts, runnerOpts, err
+	}
+
 	if err := p.WriteBuildImageDefinition(filepath.Join(buildDir, p.GetPackage().ImageID()+"-builder.dockerfile")); err != nil {
 		return builderOpts, runnerOpts, errors.Wrap(err, "Could not generate image definition")
 	}
@@ -392,12 +522,35 @@ func (cs *LuetCompiler) buildPackageImage(image, buildertaggedImage, packageImag
 		return builderOpts, runnerOpts, errors.Wrap(err, "Could not generate image definition")
 	}
 
+	limits := p.BuildLimits.Merge(cs.Options.BuildLimits)
+	timeout, err := limits.GetTimeout()
+	if err != nil {
+		return builderOpts, runnerOpts, err
+	}
+	cpus, err := limits.GetCPUs()
+	if err != nil {
+		return builderOpts, runnerOpts, err
+	}
+
+	secrets, cleanupSecrets, err := cs.resolveSecrets(p)
+	if err != nil {
+		return builderOpts, runnerOpts, errors.Wrapf(err, "invalid secrets of '%s'", p.GetPackage().HumanReadableString())
+	}
+	defer cleanupSecrets()
+
 	builderOpts = backend.Options{
 		ImageName:      buildertaggedImage,
 		SourcePath:     buildDir,
 		DockerFileName: p.GetPackage().ImageID() + "-builder.dockerfile",
 		Destination:    p.Rel(p.GetPackage().GetFingerPrint() + "-builder.image.tar"),
 		BackendArgs:    cs.Options.BackendArgs,
+		LogFile:        logFile,
+		Platform:       cs.Options.Platform.String(),
+		Timeout:        timeout,
+		Memory:         limits.Memory,
+		CPUs:           cpus,
+		NoNetwork:      limits.NetworkDisabled(),
+		Secrets:        secrets,
 	}
 	runnerOpts = backend.Options{
 		ImageName:      packageImage,
@@ -405,6 +558,13 @@ func (cs *LuetCompiler) buildPackageImage(image, buildertaggedImage, packageImag
 		DockerFileName: p.GetPackage().ImageID() + ".dockerfile",
 		Destination:    p.Rel(p.GetPackage().GetFingerPrint() + ".image.tar"),
 		BackendArgs:    cs.Options.BackendArgs,
+		LogFile:        logFile,
+		Platform:       cs.Options.Platform.String(),
+		Timeout:        timeout,
+		Memory:         limits.Memory,
+		CPUs:           cpus,
+		NoNetwork:      limits.NetworkDisabled(),
+		Secrets:        secrets,
 	}
 
 	buildAndPush := func(opts backend.Options) error {
@@ -422,6 +582,7 @@ func (cs *LuetCompiler) buildPackageImage(image, buildertaggedImage, packageImag
 			if err := cs.Backend.BuildImage(opts); err != nil {
 				return errors.Wrapf(err, "Could not build image: %s %s", image, opts.DockerFileName)
 			}
+			cs.recorder.markBuilt(p.GetPackage(), cs.Options.Platform)
 			if cs.Options.Push {
 				if err = cs.Backend.Push(opts); err != nil {
 					return errors.Wrapf(err, "Could not push image: %s %s", image, opts.DockerFileName)
@@ -468,13 +629,21 @@ func (cs *LuetCompiler) genArtifact(p *types.LuetCompilationSpec, builderOpts, r
 
 		a := artifact.NewPackageArtifact(fakePackage)
 		a.CompressionType = cs.Options.CompressionType
+		a.Platform = cs.Options.Platform
 
-		if err := a.Compress(rootfs, concurrency); err != nil {
+		if err := a.Compress(rootfs, concurrency,
+			artifact.WithSourceDateEpoch(cs.Options.SourceDateEpoch),
+			artifact.WithCompressionLevel(cs.Options.CompressionLevel),
+			artifact.WithZstdLongWindow(cs.Options.ZstdLongWindow),
+		); err != nil {
 			return nil, errors.Wrap(err, "Error met while creating package archive")
 		}
 
 		a.CompileSpec = p
-		a.CompileSpec.GetPackage().SetBuildTimestamp(time.Now().String())
+		a.CompileSpec.GetPackage().SetBuildTimestamp(cs.buildTimestamp())
+		if err := a.WriteSBOM(p.GetOutputPath(), artifact.WithSourceDateEpoch(cs.Options.SourceDateEpoch)); err != nil {
+			return a, errors.Wrap(err, "Failed while writing SBOM")
+		}
 		err = a.WriteYAML(p.GetOutputPath())
 		if err != nil {
 			return a, errors.Wrap(err, "Failed while writing metadata file")
@@ -509,9 +678,22 @@ func (cs *LuetCompiler) genArtifact(p *types.LuetCompilationSpec, builderOpts, r
 		a.Files = filelist
 	}
 
-	a.CompileSpec.GetPackage().SetBuildTimestamp(time.Now().String())
+	// Look for runtime dependencies from the linked shared libraries. When
+	// they are added, the runtime package is updated
+	runtime, err := cs.DetectRuntimeDeps(a, p)
+	if err != nil {
+		return a, errors.Wrapf(err, "Failed detecting runtime dependencies of '%s'", p.GetPackage().HumanReadableString())
+	}
+
+	a.CompileSpec.GetPackage().SetBuildTimestamp(cs.buildTimestamp())
+	a.BuildLog = existingBuildLog(p)
+	a.Platform = cs.Options.Platform
 
-	err = a.WriteYAML(p.GetOutputPath())
+	if err := a.WriteSBOM(p.GetOutputPath(), artifact.WithRuntimePackage(runtime), artifact.WithSourceDateEpoch(cs.Options.SourceDateEpoch)); err != nil {
+		return a, errors.Wrap(err, "Failed while writing SBOM")
+	}
+
+	err = a.WriteYAML(p.GetOutputPath(), artifact.WithRuntimePackage(runtime))
 	if err != nil {
 		return a, errors.Wrap(err, "Failed while writing metadata file")
 	}
@@ -553,15 +735,24 @@ func (cs *LuetCompiler) buildSubPackage(a *artifact.PackageArtifact, sub *types.
 
 	subArtifact := artifact.NewPackageArtifact(subP)
 	subArtifact.CompressionType = cs.Options.CompressionType
+	subArtifact.Platform = cs.Options.Platform
 
-	if err := subArtifact.Compress(subArtifactDir, concurrency); err != nil {
+	if err := subArtifact.Compress(subArtifactDir, concurrency,
+		artifact.WithSourceDateEpoch(cs.Options.SourceDateEpoch),
+		artifact.WithCompressionLevel(cs.Options.CompressionLevel),
+		artifact.WithZstdLongWindow(cs.Options.ZstdLongWindow),
+	); err != nil {
 		return errors.Wrap(err, "Error met while creating package archive")
 	}
 
 	subArtifact.CompileSpec = spec
 	subArtifact.CompileSpec.Package = sub.Package
 	subArtifact.Runtime = sub.Package
-	subArtifact.CompileSpec.GetPackage().SetBuildTimestamp(time.Now().String())
+	subArtifact.CompileSpec.GetPackage().SetBuildTimestamp(cs.buildTimestamp())
+
+	if err := subArtifact.WriteSBOM(spec.GetOutputPath(), artifact.WithRuntimePackage(sub.Package), artifact.WithSourceDateEpoch(cs.Options.SourceDateEpoch)); err != nil {
+		return errors.Wrap(err, "Failed while writing SBOM")
+	}
 
 	err = subArtifact.WriteYAML(spec.GetOutputPath(), artifact.WithRuntimePackage(sub.Package))
 	if err != nil {
@@ -576,6 +767,15 @@ func (cs *LuetCompiler) buildSubPackage(a *artifact.PackageArtifact, sub *types.
 	return nil
 }
 
+// buildTimestamp returns the build timestamp to record in the artifacts,
+// which is fixed to the source date epoch on reproducible builds
+func (cs *LuetCompiler) buildTimestamp() string {
+	if !cs.Options.SourceDateEpoch.IsZero() {
+		return cs.Options.SourceDateEpoch.String()
+	}
+	return time.Now().String()
+}
+
 // finalizeImages finalizes images and generates final artifacts (push them as well if necessary).
 func (cs *LuetCompiler) finalizeImages(a *artifact.PackageArtifact, p *types.LuetCompilationSpec, keepPermissions bool) error {
 
@@ -588,8 +788,12 @@ func (cs *LuetCompiler) finalizeImages(a *artifact.PackageArtifact, p *types.Lue
 		return nil
 	}
 
-	imageID := fmt.Sprintf("%s:%s", cs.Options.PushFinalImagesRepository, a.CompileSpec.Package.ImageID())
-	metadataImageID := fmt.Sprintf("%s:%s", cs.Options.PushFinalImagesRepository, helpers.SanitizeImageString(a.CompileSpec.GetPackage().GetMetadataFilePath()))
+	imageID := fmt.Sprintf("%s:%s", cs.Options.PushFinalImagesRepository, a.ImageTag())
+	metadataFile := a.CompileSpec.GetPackage().GetMetadataFilePath()
+	if !a.Platform.IsZero() {
+		metadataFile = path.Join(a.Platform.DirName(), metadataFile)
+	}
+	metadataImageID := fmt.Sprintf("%s:%s", cs.Options.PushFinalImagesRepository, helpers.FileImageTag(metadataFile))
 
 	// Do generate image only, might be required for local iteration without pushing to remote repository
 	if cs.Options.GenerateFinalImages && !cs.Options.PushFinalImages {
@@ -807,8 +1011,13 @@ func (cs *LuetCompiler) compileWithImage(image, builderHash string, packageTagHa
 
 	// always going to point at the destination from the repo defined
 	builderOpts, runnerOpts, err := cs.buildPackageImage(image, builderResolved, packageImage, concurrency, keepPermissions, p)
+	publishBuildLog(p, runnerOpts.LogFile, err)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed building package image")
+		return nil, &BuildError{
+			Package: p.GetPackage(),
+			LogFile: runnerOpts.LogFile,
+			Err:     errors.Wrap(err, "failed building package image"),
+		}
 	}
 
 	if !keepImg {
@@ -1552,22 +1761,26 @@ func (cs *LuetCompiler) FromPackage(p *types.Package) (*types.LuetCompilationSpe
 
 	// If the input is a dockerfile, just consume it and parse any image source from it
 	if pack.OriginDockerfile != "" {
-		img := ""
-		// TODO: Carry this info and parse Dockerfile from somewhere else?
-		cmds, err := dockerfile.ParseReader(bytes.NewBufferString(pack.OriginDockerfile))
+		df, err := types.ParseDockerfile(pack.OriginDockerfile)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not decode Dockerfile")
+			return nil, err
 		}
-		for _, c := range cmds {
-			if c.Cmd == "FROM" &&
-				len(c.Value) > 0 && !strings.Contains(strings.ToLower(fmt.Sprint(c.Value)), "as") {
-				img = c.Value[0]
+
+		// A Dockerfile based on other packages of the tree is built from
+		// the image of its requirements, like any other package with requires
+		img := df.BaseImage()
+		for _, st := range df.ExternalStages() {
+			if types.IsRequiredImage(pack, st.Image) {
+				img = ""
 			}
 		}
 
 		compilationSpec := &types.LuetCompilationSpec{
 			Image:        img,
 			Package:      pack,
+			Unpack:       df.Unpack(),
+			Includes:     df.Includes(),
+			Excludes:     df.Excludes(),
 			BuildOptions: &types.CompilerOptions{},
 		}
 		cs.inheritSpecBuildOptions(compilationSpec)
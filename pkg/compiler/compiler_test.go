@@ -1359,4 +1359,56 @@ var _ = Describe("Compiler", func() {
 			Expect(fileHelper.Exists(filepath.Join(tmpdir5, "etc"))).ToNot(BeTrue())
 		})
 	})
+
+	Context("Dockerfile packages", func() {
+		It("Builds multi-stage Dockerfiles from the images of their requirements", func() {
+			generalRecipe := tree.NewCompilerRecipe(pkg.NewInMemoryDatabase(false), tree.BuildDockerfileParser)
+			Expect(generalRecipe.Load("../../tests/fixtures/dockerfiles_labels")).ToNot(HaveOccurred())
+
+			compiler := NewLuetCompiler(sd.NewSimpleDockerBackend(ctx), generalRecipe.GetDatabase())
+
+			base, err := compiler.FromPackage(&types.Package{Name: "base", Category: "test", Version: "1.0"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(base.GetImage()).To(Equal("alpine"))
+
+			app, err := compiler.FromPackage(&types.Package{Name: "app", Category: "test", Version: "2.0"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(app.GetImage()).To(Equal(""))
+			Expect(app.HasImageSource()).To(BeTrue())
+			Expect(app.GetIncludes()).To(Equal([]string{"/app"}))
+
+			tmpdir, err := os.MkdirTemp("", "dockerfile")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(tmpdir)
+
+			Expect(app.WriteStepImageDefinition("luet/cache:builder", filepath.Join(tmpdir, "Dockerfile"))).ToNot(HaveOccurred())
+			dockerfile, err := fileHelper.Read(filepath.Join(tmpdir, "Dockerfile"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dockerfile).To(ContainSubstring("FROM luet/cache:builder AS build\n"))
+			Expect(dockerfile).To(ContainSubstring("FROM alpine\n"))
+		})
+	})
+
+	Context("Reverse dependencies", func() {
+		It("Computes the packages depending on the given ones", func() {
+			generalRecipe := tree.NewCompilerRecipe(pkg.NewInMemoryDatabase(false))
+			Expect(generalRecipe.Load("../../tests/fixtures/buildableseed")).ToNot(HaveOccurred())
+
+			compiler := NewLuetCompiler(sd.NewSimpleDockerBackend(ctx), generalRecipe.GetDatabase(), compiler.WithContext(context.NewContext()))
+
+			spec, err := compiler.FromPackage(&types.Package{Name: "b", Category: "test", Version: "1.0"})
+			Expect(err).ToNot(HaveOccurred())
+			spec.SetOutputPath("/tmp/out")
+
+			revdeps, err := compiler.ComputeReverseDeps(types.NewLuetCompilationspecs(spec))
+			Expect(err).ToNot(HaveOccurred())
+
+			var names []string
+			for _, r := range revdeps.All() {
+				names = append(names, r.GetPackage().GetName())
+				Expect(r.GetOutputPath()).To(Equal("/tmp/out"))
+			}
+			Expect(names).To(ConsistOf("a", "c", "d"))
+		})
+	})
 })
@@ -137,6 +137,10 @@ func (ht *ImageHashTree) resolve(cs *LuetCompiler, p *types.LuetCompilationSpec)
 			if err != nil {
 				return nil, errors.Wrap(err, "failed computing hash")
 			}
+			if !cs.Options.Platform.IsZero() {
+				// Images built for different platforms must not share tags
+				hash += "@" + cs.Options.Platform.String()
+			}
 			salts[assertion.Package.GetFingerPrint()] = hash
 		}
 	}
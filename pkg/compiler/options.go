@@ -17,6 +17,7 @@ package compiler
 
 import (
 	"runtime"
+	"time"
 
 	"github.com/mudler/luet/pkg/api/core/types"
 )
@@ -32,6 +33,7 @@ func newDefaultCompiler() *types.CompilerOptions {
 		OnlyDeps:            false,
 		NoDeps:              false,
 		SolverOptions:       types.LuetSolverOptions{SolverOptions: types.SolverOptions{Concurrency: 1, Type: types.SolverSingleCoreSimple}},
+		SourcesCachePath:    defaultSourcesCachePath(),
 	}
 }
 
@@ -193,6 +195,24 @@ func WithCompressionType(t types.CompressionImplementation) func(cfg *types.Comp
 	}
 }
 
+// CompressionLevel sets the compression level of the artifacts, 0 keeps the
+// default of the compression type
+func CompressionLevel(l int) func(cfg *types.CompilerOptions) error {
+	return func(cfg *types.CompilerOptions) error {
+		cfg.CompressionLevel = l
+		return nil
+	}
+}
+
+// ZstdLongWindow enables the zstd long distance matching when compressing
+// the artifacts
+func ZstdLongWindow(b bool) func(cfg *types.CompilerOptions) error {
+	return func(cfg *types.CompilerOptions) error {
+		cfg.ZstdLongWindow = b
+		return nil
+	}
+}
+
 func WithSolverOptions(c types.LuetSolverOptions) func(cfg *types.CompilerOptions) error {
 	return func(cfg *types.CompilerOptions) error {
 		cfg.SolverOptions = c
@@ -206,3 +226,47 @@ func WithContext(c types.Context) func(cfg *types.CompilerOptions) error {
 		return nil
 	}
 }
+
+func WithPlatform(p types.Platform) func(cfg *types.CompilerOptions) error {
+	return func(cfg *types.CompilerOptions) error {
+		cfg.Platform = p
+		return nil
+	}
+}
+
+// SourceDateEpoch makes the generated artifacts reproducible, using t as
+// the build time of all of them
+func SourceDateEpoch(t time.Time) func(cfg *types.CompilerOptions) error {
+	return func(cfg *types.CompilerOptions) error {
+		cfg.SourceDateEpoch = t
+		return nil
+	}
+}
+
+// WithSourcesCachePath sets where the package sources are cached
+func WithSourcesCachePath(p string) func(cfg *types.CompilerOptions) error {
+	return func(cfg *types.CompilerOptions) error {
+		if p != "" {
+			cfg.SourcesCachePath = p
+		}
+		return nil
+	}
+}
+
+// WithBuildLimits sets the default limits of the package builds, used
+// when not set by the packages
+func WithBuildLimits(l types.BuildLimits) func(cfg *types.CompilerOptions) error {
+	return func(cfg *types.CompilerOptions) error {
+		cfg.BuildLimits = l
+		return nil
+	}
+}
+
+// WithRuntimeDeps sets how the runtime dependencies detected from the
+// shared libraries linked by the artifacts are handled
+func WithRuntimeDeps(m types.RuntimeDepsMode) func(cfg *types.CompilerOptions) error {
+	return func(cfg *types.CompilerOptions) error {
+		cfg.RuntimeDeps = m
+		return nil
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package compiler
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mudler/luet/pkg/api/core/types"
+	"github.com/mudler/luet/pkg/api/core/types/artifact"
+	"github.com/mudler/luet/pkg/compiler/backend"
+	"github.com/pkg/errors"
+)
+
+type BuildStatus string
+
+const (
+	BuildSuccess  BuildStatus = "success"
+	BuildFailed   BuildStatus = "failed"
+	BuildTimedOut BuildStatus = "timeout"
+	BuildSkipped  BuildStatus = "skipped"
+)
+
+// PackageBuildReport is the outcome of building a single package
+type PackageBuildReport struct {
+	Package     string      `json:"package"`
+	Category    string      `json:"category"`
+	Name        string      `json:"name"`
+	Version     string      `json:"version"`
+	Fingerprint string      `json:"fingerprint"`
+	Platform    string      `json:"platform,omitempty"`
+	Status      BuildStatus `json:"status"`
+	// Duration is the build time in seconds
+	Duration float64 `json:"duration"`
+	// CacheHit is true when the package image was not built in this run,
+	// but reused from the local host or a remote repository
+	CacheHit bool     `json:"cache_hit"`
+	Images   []string `json:"images,omitempty"`
+	Artifact string   `json:"artifact,omitempty"`
+	LogFile  string   `json:"log,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// BuildReport collects the outcome of all the packages built by a compiler
+type BuildReport struct {
+	Packages []PackageBuildReport `json:"packages"`
+}
+
+// Failed returns the number of packages which failed to build
+func (r *BuildReport) Failed() int {
+	return r.count(BuildFailed)
+}
+
+// TimedOut returns the number of packages which builds were killed for
+// exceeding their timeout
+func (r *BuildReport) TimedOut() int {
+	return r.count(BuildTimedOut)
+}
+
+// Skipped returns the number of packages which were not built because a
+// dependency failed
+func (r *BuildReport) Skipped() int {
+	return r.count(BuildSkipped)
+}
+
+func (r *BuildReport) count(s BuildStatus) (n int) {
+	for _, p := range r.Packages {
+		if p.Status == s {
+			n++
+		}
+	}
+	return
+}
+
+// WriteJSON writes the report as JSON to the given path
+func (r *BuildReport) WriteJSON(path string) error {
+	dat, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, dat, 0644)
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitTestSuite struct {
+	XMLName  xml.Name        `xml:"testsuite"`
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+// WriteJUnit writes the report as JUnit XML to the given path. Each
+// package is a test case, classed by its category.
+func (r *BuildReport) WriteJUnit(path string) error {
+	suite := junitTestSuite{
+		Name:     "luet build",
+		Tests:    len(r.Packages),
+		Failures: r.Failed() + r.TimedOut(),
+		Skipped:  r.Skipped(),
+	}
+
+	total := 0.0
+	for _, p := range r.Packages {
+		total += p.Duration
+		tc := junitTestCase{
+			ClassName: p.Category,
+			Name:      fmt.Sprintf("%s-%s", p.Name, p.Version),
+			Time:      fmt.Sprintf("%.3f", p.Duration),
+		}
+		if p.Platform != "" {
+			tc.Name += " (" + p.Platform + ")"
+		}
+		switch p.Status {
+		case BuildFailed:
+			tc.Failure = &junitFailure{Message: "build failed", Body: p.Error}
+		case BuildTimedOut:
+			tc.Failure = &junitFailure{Message: "build timed out", Body: p.Error}
+		case BuildSkipped:
+			tc.Skipped = &junitSkipped{Message: p.Error}
+		}
+		if p.LogFile != "" {
+			tc.SystemOut = "Build log: " + p.LogFile
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	suite.Time = fmt.Sprintf("%.3f", total)
+
+	dat, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(xml.Header), dat...), 0644)
+}
+
+// buildRecorder tracks the builds done by a compiler. It is shared between
+// the copies of a compiler, so it is safe for concurrent use.
+type buildRecorder struct {
+	sync.Mutex
+	built  map[string]bool
+	report BuildReport
+}
+
+func newBuildRecorder() *buildRecorder {
+	return &buildRecorder{built: map[string]bool{}}
+}
+
+func builtKey(p *types.Package, platform types.Platform) string {
+	return p.GetFingerPrint() + "@" + platform.String()
+}
+
+// markBuilt records that the package image of p was built for platform,
+// not reused
+func (r *buildRecorder) markBuilt(p *types.Package, platform types.Platform) {
+	r.Lock()
+	defer r.Unlock()
+	r.built[builtKey(p, platform)] = true
+}
+
+func (r *buildRecorder) add(cs *LuetCompiler, p *types.LuetCompilationSpec, a *artifact.PackageArtifact, d time.Duration, buildErr error) {
+	pack := p.GetPackage()
+	entry := PackageBuildReport{
+		Package:     pack.HumanReadableString(),
+		Category:    pack.GetCategory(),
+		Name:        pack.GetName(),
+		Version:     pack.GetVersion(),
+		Fingerprint: pack.GetFingerPrint(),
+		Platform:    cs.Options.Platform.String(),
+		Status:      BuildSuccess,
+		Duration:    d.Seconds(),
+	}
+
+	if buildErr != nil {
+		entry.Status = BuildFailed
+		if backend.IsTimeout(buildErr) {
+			entry.Status = BuildTimedOut
+		}
+		entry.Error = buildErr.Error()
+		var be *BuildError
+		if errors.As(buildErr, &be) {
+			entry.LogFile = be.LogFile
+		}
+	}
+
+	if a != nil {
+		entry.Artifact = a.Path
+		if a.BuildLog != "" {
+			entry.LogFile = p.Rel(a.BuildLog)
+		}
+		if a.PackageCacheImage != "" {
+			entry.Images = append(entry.Images, fmt.Sprintf("%s:%s", cs.Options.PushImageRepository, a.PackageCacheImage))
+		}
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	entry.CacheHit = buildErr == nil && !r.built[builtKey(pack, cs.Options.Platform)]
+	r.report.Packages = append(r.report.Packages, entry)
+}
+
+func (r *buildRecorder) skip(cs *LuetCompiler, p *types.LuetCompilationSpec, reason string) {
+	pack := p.GetPackage()
+	r.Lock()
+	defer r.Unlock()
+	r.report.Packages = append(r.report.Packages, PackageBuildReport{
+		Package:     pack.HumanReadableString(),
+		Category:    pack.GetCategory(),
+		Name:        pack.GetName(),
+		Version:     pack.GetVersion(),
+		Fingerprint: pack.GetFingerPrint(),
+		Platform:    cs.Options.Platform.String(),
+		Status:      BuildSkipped,
+		Error:       reason,
+	})
+}
+
+// Report returns the outcome of the packages built so far, sorted by
+// package name and platform
+func (cs *LuetCompiler) Report() *BuildReport {
+	cs.recorder.Lock()
+	defer cs.recorder.Unlock()
+
+	report := &BuildReport{Packages: append([]PackageBuildReport{}, cs.recorder.report.Packages...)}
+	sort.SliceStable(report.Packages, func(i, j int) bool {
+		if report.Packages[i].Package == report.Packages[j].Package {
+			return report.Packages[i].Platform < report.Packages[j].Platform
+		}
+		return report.Packages[i].Package < report.Packages[j].Package
+	})
+	return report
+}
@@ -0,0 +1,109 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package compiler_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/mudler/luet/pkg/api/core/context"
+	"github.com/mudler/luet/pkg/api/core/types"
+	. "github.com/mudler/luet/pkg/compiler"
+	sd "github.com/mudler/luet/pkg/compiler/backend"
+	pkg "github.com/mudler/luet/pkg/database"
+	fileHelper "github.com/mudler/luet/pkg/helpers/file"
+	"github.com/mudler/luet/pkg/tree"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Build reports", func() {
+	ctx := context.NewContext()
+
+	Context("Keep going", func() {
+		It("Skips only the packages depending on failed ones", func() {
+			generalRecipe := tree.NewCompilerRecipe(pkg.NewInMemoryDatabase(false))
+			Expect(generalRecipe.Load("../../tests/fixtures/keepgoing")).ToNot(HaveOccurred())
+
+			tmpdir, err := os.MkdirTemp("", "keepgoing")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(tmpdir)
+
+			compiler := NewLuetCompiler(sd.NewSimpleDockerBackend(ctx), generalRecipe.GetDatabase(), Concurrency(1))
+
+			specs := types.NewLuetCompilationspecs()
+			for _, name := range []string{"broken", "dependent", "virtual"} {
+				spec, err := compiler.FromPackage(&types.Package{Name: name, Category: "test", Version: "1.0"})
+				Expect(err).ToNot(HaveOccurred())
+				spec.SetOutputPath(tmpdir)
+				specs.Add(spec)
+			}
+
+			artifacts, errs := compiler.CompileKeepGoing(false, specs)
+			Expect(errs).To(HaveLen(1))
+			Expect(artifacts).To(HaveLen(1))
+
+			report := compiler.Report()
+			Expect(report.Packages).To(HaveLen(3))
+			status := map[string]BuildStatus{}
+			for _, p := range report.Packages {
+				status[p.Name] = p.Status
+			}
+			Expect(status).To(Equal(map[string]BuildStatus{
+				"broken":    BuildFailed,
+				"dependent": BuildSkipped,
+				"virtual":   BuildSuccess,
+			}))
+			Expect(report.Failed()).To(Equal(1))
+			Expect(report.Skipped()).To(Equal(1))
+		})
+	})
+
+	Context("Report formats", func() {
+		report := &BuildReport{Packages: []PackageBuildReport{
+			{Package: "test/a-1.0", Category: "test", Name: "a", Version: "1.0", Status: BuildSuccess, Duration: 1.5, CacheHit: true},
+			{Package: "test/b-1.0", Category: "test", Name: "b", Version: "1.0", Status: BuildFailed, Error: "boom", LogFile: "logs/b.log"},
+			{Package: "test/c-1.0", Category: "test", Name: "c", Version: "1.0", Status: BuildSkipped, Error: "dependency test/b-1.0 failed to build"},
+		}}
+
+		It("Writes JUnit XML", func() {
+			tmpdir, err := os.MkdirTemp("", "report")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(tmpdir)
+
+			Expect(report.WriteJUnit(filepath.Join(tmpdir, "report.xml"))).ToNot(HaveOccurred())
+			dat, err := fileHelper.Read(filepath.Join(tmpdir, "report.xml"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dat).To(ContainSubstring(`<testsuite name="luet build" tests="3" failures="1" skipped="1" time="1.500">`))
+			Expect(dat).To(ContainSubstring(`<failure message="build failed">boom</failure>`))
+			Expect(dat).To(ContainSubstring(`<system-out>Build log: logs/b.log</system-out>`))
+		})
+
+		It("Writes JSON", func() {
+			tmpdir, err := os.MkdirTemp("", "report")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(tmpdir)
+
+			Expect(report.WriteJSON(filepath.Join(tmpdir, "report.json"))).ToNot(HaveOccurred())
+			dat, err := os.ReadFile(filepath.Join(tmpdir, "report.json"))
+			Expect(err).ToNot(HaveOccurred())
+			read := &BuildReport{}
+			Expect(json.Unmarshal(dat, read)).ToNot(HaveOccurred())
+			Expect(read).To(Equal(report))
+		})
+	})
+})
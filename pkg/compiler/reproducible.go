@@ -0,0 +1,105 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package compiler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mudler/luet/pkg/api/core/types/artifact"
+	"github.com/pkg/errors"
+)
+
+// ReproducibilityMismatch is an artifact whose archive differs between two
+// builds of the same package
+type ReproducibilityMismatch struct {
+	Package       string
+	First, Second string
+	// Entries are the archive entries which differ. When empty, the two
+	// archives have the same content and differ only in their encoding.
+	Entries []string
+	// Missing is set when only one of the builds produced the artifact
+	Missing bool
+}
+
+func (m ReproducibilityMismatch) String() string {
+	if m.Missing {
+		return fmt.Sprintf("%s: built only once (%s%s)", m.Package, m.First, m.Second)
+	}
+	if len(m.Entries) == 0 {
+		return fmt.Sprintf("%s: %s and %s have the same content but differ in their encoding", m.Package, m.First, m.Second)
+	}
+	return fmt.Sprintf("%s: %s and %s differ in %s", m.Package, m.First, m.Second, strings.Join(m.Entries, ", "))
+}
+
+func reproducibilityKey(a *artifact.PackageArtifact) string {
+	key := a.CompileSpec.GetPackage().HumanReadableString()
+	if !a.Platform.IsZero() {
+		key += "@" + a.Platform.String()
+	}
+	return key
+}
+
+// CompareArtifacts pairs the artifacts of two builds by package and
+// platform, and returns the ones whose archives differ. Artifacts without a
+// counterpart in the other build are reported as well.
+func CompareArtifacts(first, second []*artifact.PackageArtifact) ([]ReproducibilityMismatch, error) {
+	others := map[string]*artifact.PackageArtifact{}
+	for _, a := range second {
+		if a.CompileSpec == nil || a.Path == "" {
+			continue
+		}
+		others[reproducibilityKey(a)] = a
+	}
+
+	var mismatches []ReproducibilityMismatch
+	for _, a := range first {
+		if a.CompileSpec == nil || a.Path == "" {
+			continue
+		}
+		key := reproducibilityKey(a)
+		b, ok := others[key]
+		if !ok {
+			mismatches = append(mismatches, ReproducibilityMismatch{Package: key, First: a.Path, Missing: true})
+			continue
+		}
+		delete(others, key)
+
+		if err := a.Hash(); err != nil {
+			return nil, errors.Wrapf(err, "while hashing %s", a.Path)
+		}
+		if err := b.Hash(); err != nil {
+			return nil, errors.Wrapf(err, "while hashing %s", b.Path)
+		}
+		if a.Checksums.Compare(b.Checksums) == nil {
+			continue
+		}
+
+		entries, err := a.Diff(b)
+		if err != nil {
+			return nil, errors.Wrapf(err, "while comparing %s and %s", a.Path, b.Path)
+		}
+		mismatches = append(mismatches, ReproducibilityMismatch{Package: key, First: a.Path, Second: b.Path, Entries: entries})
+	}
+
+	for key, b := range others {
+		mismatches = append(mismatches, ReproducibilityMismatch{Package: key, Second: b.Path, Missing: true})
+	}
+
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].Package < mismatches[j].Package })
+	return mismatches, nil
+}
@@ -0,0 +1,69 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package compiler_test
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mudler/luet/pkg/api/core/types"
+	"github.com/mudler/luet/pkg/api/core/types/artifact"
+	. "github.com/mudler/luet/pkg/compiler"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reproducibility", func() {
+	var tmpdir string
+
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = os.MkdirTemp("", "reproducible")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpdir)
+	})
+
+	build := func(dir, name, content string) *artifact.PackageArtifact {
+		src := filepath.Join(tmpdir, dir, "src")
+		Expect(os.MkdirAll(src, os.ModePerm)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(src, "file"), []byte(content), os.ModePerm)).To(Succeed())
+
+		a := artifact.NewPackageArtifact(filepath.Join(tmpdir, dir, name+".package.tar"))
+		a.CompileSpec = &types.LuetCompilationSpec{Package: &types.Package{Name: name, Category: "test", Version: "1.0"}}
+		Expect(a.Compress(src, 1, artifact.WithSourceDateEpoch(time.Unix(0, 0)))).To(Succeed())
+		return a
+	}
+
+	It("reports the artifacts which differ between two builds", func() {
+		first := []*artifact.PackageArtifact{build("a1", "same", "foo"), build("b1", "changed", "foo"), build("c1", "once", "foo")}
+		second := []*artifact.PackageArtifact{build("a2", "same", "foo"), build("b2", "changed", "bar")}
+
+		mismatches, err := CompareArtifacts(first, second)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mismatches).To(HaveLen(2))
+
+		Expect(mismatches[0].Package).To(Equal("test/changed-1.0"))
+		Expect(mismatches[0].Entries).To(Equal([]string{"file"}))
+		Expect(mismatches[0].Missing).To(BeFalse())
+
+		Expect(mismatches[1].Package).To(Equal("test/once-1.0"))
+		Expect(mismatches[1].Missing).To(BeTrue())
+	})
+})
@@ -0,0 +1,195 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package compiler
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mudler/luet/pkg/api/core/types"
+	"github.com/mudler/luet/pkg/api/core/types/artifact"
+	"github.com/pkg/errors"
+)
+
+// libraryProviders maps the file names to the packages shipping them
+type libraryProviders map[string]types.Packages
+
+// libraryProviders indexes the files of the artifacts found in dir, which
+// are the ones built so far in the output folder, or a local repository.
+// Only packages of the tree are considered.
+func (cs *LuetCompiler) libraryProviders(dir string) (libraryProviders, error) {
+	providers := libraryProviders{}
+	seen := map[string]bool{}
+
+	err := filepath.Walk(dir, func(currentpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !strings.HasSuffix(info.Name(), "."+types.PackageMetaSuffix) {
+			return nil
+		}
+
+		dat, err := os.ReadFile(currentpath)
+		if err != nil {
+			return errors.Wrap(err, "Error reading file "+currentpath)
+		}
+		a, err := artifact.NewPackageArtifactFromYaml(dat)
+		if err != nil {
+			return errors.Wrap(err, "Error reading yaml "+currentpath)
+		}
+		if a.CompileSpec == nil || a.CompileSpec.GetPackage() == nil {
+			return nil
+		}
+
+		p, err := cs.Database.FindPackage(a.CompileSpec.GetPackage())
+		if err != nil || seen[p.HumanReadableString()] {
+			return nil
+		}
+		seen[p.HumanReadableString()] = true
+
+		names := map[string]bool{}
+		for _, f := range a.Files {
+			names[path.Base(f)] = true
+		}
+		for name := range names {
+			providers[name] = append(providers[name], p)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	for _, packs := range providers {
+		sort.Slice(packs, func(i, j int) bool { return packs[i].HumanReadableString() < packs[j].HumanReadableString() })
+	}
+	return providers, nil
+}
+
+// runtimeRequires returns the names of the packages required at runtime by
+// p, directly or through other packages. Without a runtime database, only
+// the direct requires are returned.
+func (cs *LuetCompiler) runtimeRequires(p *types.Package) map[string]bool {
+	required := map[string]bool{}
+	queue := append([]*types.Package{}, p.GetRequires()...)
+
+	for len(queue) > 0 {
+		r := queue[0]
+		queue = queue[1:]
+		if required[r.GetPackageName()] {
+			continue
+		}
+		required[r.GetPackageName()] = true
+
+		if cs.Options.RuntimeDatabase == nil {
+			continue
+		}
+		matches, err := cs.Options.RuntimeDatabase.FindPackages(r)
+		if err != nil {
+			continue
+		}
+		for _, m := range matches {
+			if full, err := cs.Options.RuntimeDatabase.FindPackage(m); err == nil {
+				queue = append(queue, full.GetRequires()...)
+			}
+		}
+	}
+	return required
+}
+
+// DetectRuntimeDeps looks for the packages providing the shared libraries
+// linked by the artifact, and not required by its runtime package. They are
+// reported as warnings, or added to the runtime package requires, depending
+// on the RuntimeDeps option. The runtime package is returned when modified.
+func (cs *LuetCompiler) DetectRuntimeDeps(a *artifact.PackageArtifact, p *types.LuetCompilationSpec) (*types.Package, error) {
+	mode := cs.Options.RuntimeDeps
+	if mode == types.RuntimeDepsNone {
+		return nil, nil
+	}
+
+	libs, err := a.SharedLibraries()
+	if err != nil {
+		return nil, errors.Wrap(err, "while scanning shared libraries")
+	}
+	missing := libs.Missing()
+	if len(missing) == 0 {
+		return nil, nil
+	}
+
+	providers, err := cs.libraryProviders(p.GetOutputPath())
+	if err != nil {
+		return nil, errors.Wrap(err, "while indexing the built packages")
+	}
+
+	runtime, err := p.GetPackage().GetRuntimePackage()
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting runtime package for '%s'", p.GetPackage().HumanReadableString())
+	}
+	required := cs.runtimeRequires(runtime)
+
+	added := false
+	for _, soname := range missing {
+		var candidates types.Packages
+		satisfied := false
+		for _, c := range providers[soname] {
+			if c.GetPackageName() == runtime.GetPackageName() {
+				continue
+			}
+			if required[c.GetPackageName()] {
+				satisfied = true
+				break
+			}
+			candidates = append(candidates, c)
+		}
+		if satisfied {
+			continue
+		}
+		if len(candidates) == 0 {
+			cs.Options.Context.Debug(fmt.Sprintf("%s: no package provides %s", runtime.HumanReadableString(), soname))
+			continue
+		}
+
+		dep := candidates[0]
+		switch mode {
+		case types.RuntimeDepsAdd:
+			cs.Options.Context.Info(fmt.Sprintf("[%9s] %s/%s-%s: Adding runtime dep %s/%s for %s (needed by %s)",
+				"runtime",
+				runtime.GetCategory(), runtime.GetName(), runtime.GetVersion(),
+				dep.GetCategory(), dep.GetName(),
+				soname, strings.Join(libs.Needed[soname], ", ")))
+			req := &types.Package{Category: dep.GetCategory(), Name: dep.GetName(), Version: ">=0"}
+			runtime.Requires(append(runtime.GetRequires(), req))
+			a.RuntimeDeps = append(a.RuntimeDeps, req)
+			required[dep.GetPackageName()] = true
+			added = true
+		default:
+			cs.Options.Context.Warning(fmt.Sprintf("[%9s] %s/%s-%s: Missing runtime dep %s/%s for %s (needed by %s)",
+				"runtime",
+				runtime.GetCategory(), runtime.GetName(), runtime.GetVersion(),
+				dep.GetCategory(), dep.GetName(),
+				soname, strings.Join(libs.Needed[soname], ", ")))
+		}
+	}
+
+	if !added {
+		return nil, nil
+	}
+	return runtime, nil
+}
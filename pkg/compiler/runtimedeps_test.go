@@ -0,0 +1,103 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package compiler_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/mudler/luet/pkg/api/core/context"
+	"github.com/mudler/luet/pkg/api/core/types"
+	"github.com/mudler/luet/pkg/api/core/types/artifact"
+	. "github.com/mudler/luet/pkg/compiler"
+	pkg "github.com/mudler/luet/pkg/database"
+	"github.com/mudler/luet/pkg/tree"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Runtime dependencies", func() {
+	const fixture = "../../tests/fixtures/runtimedeps"
+	var out string
+
+	// pack creates the artifact of the package from its rootfs folder in the
+	// fixture
+	pack := func(c *LuetCompiler, name, category string) (*artifact.PackageArtifact, *types.LuetCompilationSpec) {
+		spec, err := c.FromPackage(&types.Package{Name: name, Category: category, Version: "1.0"})
+		Expect(err).ToNot(HaveOccurred())
+		spec.SetOutputPath(out)
+
+		a := artifact.NewPackageArtifact(filepath.Join(out, name+".package.tar"))
+		Expect(a.Compress(filepath.Join(fixture, name, "rootfs"), 1)).To(Succeed())
+		a.CompileSpec = spec
+		a.Files, err = a.FileList()
+		Expect(err).ToNot(HaveOccurred())
+		return a, spec
+	}
+
+	newCompiler := func(mode types.RuntimeDepsMode) *LuetCompiler {
+		generalRecipe := tree.NewCompilerRecipe(pkg.NewInMemoryDatabase(false))
+		Expect(generalRecipe.Load(fixture)).To(Succeed())
+		return NewLuetCompiler(nil, generalRecipe.GetDatabase(), WithContext(context.NewContext()), WithRuntimeDeps(mode))
+	}
+
+	BeforeEach(func() {
+		var err error
+		out, err = os.MkdirTemp("", "runtimedeps")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(out)
+	})
+
+	It("adds the packages providing the linked libraries to the requires", func() {
+		c := newCompiler(types.RuntimeDepsAdd)
+
+		foo, _ := pack(c, "foo", "lib")
+		Expect(foo.WriteYAML(out)).To(Succeed())
+
+		bar, spec := pack(c, "bar", "app")
+		runtime, err := c.DetectRuntimeDeps(bar, spec)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(runtime).ToNot(BeNil())
+		Expect(runtime.GetRequires()).To(HaveLen(1))
+		Expect(runtime.GetRequires()[0].GetCategory()).To(Equal("lib"))
+		Expect(runtime.GetRequires()[0].GetName()).To(Equal("foo"))
+		Expect(bar.RuntimeDeps).To(Equal(runtime.GetRequires()))
+	})
+
+	It("leaves the runtime package untouched when only warning", func() {
+		c := newCompiler(types.RuntimeDepsWarn)
+
+		foo, _ := pack(c, "foo", "lib")
+		Expect(foo.WriteYAML(out)).To(Succeed())
+
+		bar, spec := pack(c, "bar", "app")
+		runtime, err := c.DetectRuntimeDeps(bar, spec)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(runtime).To(BeNil())
+	})
+
+	It("ignores libraries no known package provides", func() {
+		c := newCompiler(types.RuntimeDepsAdd)
+
+		bar, spec := pack(c, "bar", "app")
+		runtime, err := c.DetectRuntimeDeps(bar, spec)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(runtime).To(BeNil())
+	})
+})
@@ -0,0 +1,92 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package compiler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mudler/luet/pkg/api/core/types"
+	"github.com/mudler/luet/pkg/compiler/backend"
+	fileHelper "github.com/mudler/luet/pkg/helpers/file"
+	"github.com/pkg/errors"
+)
+
+// resolveSecrets returns the secrets of the spec as files of the build host
+// that the backends can mount. Secrets read from the environment are
+// written in a private temporary folder, outside of the build context, which
+// is removed by the returned cleanup function.
+func (cs *LuetCompiler) resolveSecrets(p *types.LuetCompilationSpec) ([]backend.Secret, func(), error) {
+	var secrets []backend.Secret
+	tmpDir := ""
+	cleanup := func() {
+		if tmpDir != "" {
+			os.RemoveAll(tmpDir)
+		}
+	}
+
+	for i, s := range p.GetSecrets() {
+		if err := s.Validate(); err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+
+		switch {
+		case s.File != "" && s.Env != "":
+			cleanup()
+			return nil, nil, fmt.Errorf("secret '%s' can't be read both from a file and the environment", s.ID)
+		case s.File != "":
+			src, err := filepath.Abs(s.File)
+			if err != nil {
+				cleanup()
+				return nil, nil, err
+			}
+			if !fileHelper.Exists(src) {
+				cleanup()
+				return nil, nil, fmt.Errorf("file '%s' of secret '%s' not found", s.File, s.ID)
+			}
+			secrets = append(secrets, backend.Secret{ID: s.ID, Src: src})
+		case s.Env != "":
+			value, ok := os.LookupEnv(s.Env)
+			if !ok {
+				cleanup()
+				return nil, nil, fmt.Errorf("environment variable '%s' of secret '%s' is not set", s.Env, s.ID)
+			}
+			if tmpDir == "" {
+				dir, err := cs.Options.Context.TempDir("secrets")
+				if err != nil {
+					return nil, nil, err
+				}
+				tmpDir = dir
+				if err := os.Chmod(tmpDir, 0700); err != nil {
+					cleanup()
+					return nil, nil, err
+				}
+			}
+			src := filepath.Join(tmpDir, fmt.Sprintf("secret-%d", i))
+			if err := os.WriteFile(src, []byte(value), 0600); err != nil {
+				cleanup()
+				return nil, nil, errors.Wrapf(err, "failed writing secret '%s'", s.ID)
+			}
+			secrets = append(secrets, backend.Secret{ID: s.ID, Src: src})
+		default:
+			cleanup()
+			return nil, nil, fmt.Errorf("secret '%s' needs either a file or an environment variable", s.ID)
+		}
+	}
+	return secrets, cleanup, nil
+}
@@ -0,0 +1,203 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package compiler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mudler/luet/pkg/api/core/types"
+	"github.com/mudler/luet/pkg/helpers"
+	fileHelper "github.com/mudler/luet/pkg/helpers/file"
+	"github.com/pkg/errors"
+)
+
+func defaultSourcesCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "luet", "sources")
+}
+
+// sourceCachePath returns the path where the source with the given checksum
+// is stored in the cache. Sources are addressed by their content, so the
+// same file referenced by several packages, or from different URLs, is
+// downloaded only once.
+func (cs *LuetCompiler) sourceCachePath(sum string) string {
+	return filepath.Join(cs.Options.SourcesCachePath, "sha256", sum)
+}
+
+// FetchSources downloads the sources of the spec in the sources cache,
+// verifying their checksums, and places them in dst, which is the build
+// context of the package. Sources already in the cache are not downloaded
+// again, allowing offline builds.
+func (cs *LuetCompiler) FetchSources(p *types.LuetCompilationSpec, dst string) error {
+	for _, s := range p.GetSources() {
+		cached, err := cs.fetchSource(s)
+		if err != nil {
+			return errors.Wrapf(err, "failed fetching source '%s' of '%s'", s.URL, p.GetPackage().HumanReadableString())
+		}
+
+		target, err := sourceDestination(dst, s)
+		if err != nil {
+			return errors.Wrapf(err, "invalid source '%s' of '%s'", s.URL, p.GetPackage().HumanReadableString())
+		}
+
+		if s.Extract {
+			if err := os.MkdirAll(target, os.ModePerm); err != nil {
+				return err
+			}
+			if err := helpers.Untar(cached, target); err != nil {
+				return errors.Wrapf(err, "failed extracting source '%s'", s.URL)
+			}
+			continue
+		}
+
+		if err := fileHelper.EnsureDir(target); err != nil {
+			return err
+		}
+		if err := fileHelper.CopyFile(cached, target); err != nil {
+			return errors.Wrapf(err, "failed copying source '%s'", s.URL)
+		}
+	}
+	return nil
+}
+
+// sourceDestination returns the path of the source in the build context,
+// refusing the ones pointing outside of it
+func sourceDestination(buildDir string, s types.Source) (string, error) {
+	dest := s.GetDestination()
+	if filepath.IsAbs(dest) {
+		return "", fmt.Errorf("destination '%s' must be relative to the build context", dest)
+	}
+	target := filepath.Join(buildDir, dest)
+	if target != buildDir && !strings.HasPrefix(target, buildDir+string(os.PathSeparator)) {
+		return "", fmt.Errorf("destination '%s' is outside of the build context", dest)
+	}
+	return target, nil
+}
+
+func (cs *LuetCompiler) fetchSource(s types.Source) (string, error) {
+	sum := strings.ToLower(s.SHA256)
+	if len(sum) != sha256.Size*2 {
+		return "", errors.New("a valid sha256 checksum is required")
+	}
+
+	cached := cs.sourceCachePath(sum)
+	if fileHelper.Exists(cached) {
+		if got, err := fileSHA256(cached); err == nil && got == sum {
+			cs.Options.Context.Debug("Source", s.URL, "found in cache")
+			return cached, nil
+		}
+		// Corrupted, download it again
+		os.RemoveAll(cached)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cached), os.ModePerm); err != nil {
+		return "", err
+	}
+
+	cs.Options.Context.Info(":arrow_down: Fetching source", s.URL)
+
+	tmp, err := os.CreateTemp(filepath.Dir(cached), "download")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp.Name())
+
+	h := sha256.New()
+	if err := download(cs.httpClient(), s.URL, io.MultiWriter(tmp, h)); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	if got := hex.EncodeToString(h.Sum(nil)); got != sum {
+		return "", fmt.Errorf("checksum mismatch: expected sha256 %s, got %s", sum, got)
+	}
+
+	if err := os.Rename(tmp.Name(), cached); err != nil {
+		return "", err
+	}
+	return cached, nil
+}
+
+// httpClient returns the client fetching the sources, with the timeout of
+// the repository downloads
+func (cs *LuetCompiler) httpClient() *http.Client {
+	return &http.Client{
+		Timeout: time.Duration(cs.Options.Context.GetConfig().General.HTTPTimeout) * time.Second,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+		},
+	}
+}
+
+func download(c *http.Client, uri string, w io.Writer) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return err
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		resp, err := c.Get(uri)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status '%s'", resp.Status)
+		}
+		_, err = io.Copy(w, resp.Body)
+		return err
+	case "file":
+		f, err := os.Open(u.Path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	default:
+		return fmt.Errorf("unsupported scheme '%s'", u.Scheme)
+	}
+}
+
+func fileSHA256(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,141 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package compiler_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mudler/luet/pkg/api/core/context"
+	"github.com/mudler/luet/pkg/api/core/types"
+	. "github.com/mudler/luet/pkg/compiler"
+	pkg "github.com/mudler/luet/pkg/database"
+	"github.com/mudler/luet/pkg/helpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sources", func() {
+	var tmpdir, served, buildDir string
+	var server *httptest.Server
+	var compiler *LuetCompiler
+
+	sum := func(f string) string {
+		dat, err := os.ReadFile(f)
+		Expect(err).ToNot(HaveOccurred())
+		h := sha256.Sum256(dat)
+		return hex.EncodeToString(h[:])
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = os.MkdirTemp("", "sources")
+		Expect(err).ToNot(HaveOccurred())
+
+		served = filepath.Join(tmpdir, "served")
+		buildDir = filepath.Join(tmpdir, "build")
+		Expect(os.MkdirAll(filepath.Join(served, "foo-1.0"), os.ModePerm)).To(Succeed())
+		Expect(os.MkdirAll(buildDir, os.ModePerm)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(served, "foo-1.0", "configure"), []byte("#!/bin/sh"), os.ModePerm)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(served, "patch.diff"), []byte("diff"), os.ModePerm)).To(Succeed())
+		Expect(helpers.Tar(filepath.Join(served, "foo-1.0"), filepath.Join(served, "foo-1.0.tar"))).To(Succeed())
+
+		server = httptest.NewServer(http.FileServer(http.Dir(served)))
+
+		compiler = NewLuetCompiler(nil, pkg.NewInMemoryDatabase(false),
+			WithContext(context.NewContext()),
+			WithSourcesCachePath(filepath.Join(tmpdir, "cache")),
+		)
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(tmpdir)
+	})
+
+	It("times out when the sources are served too slowly", func() {
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(3 * time.Second)
+		}))
+		defer slow.Close()
+
+		ctx := context.NewContext()
+		ctx.Config.General.HTTPTimeout = 1
+		compiler = NewLuetCompiler(nil, pkg.NewInMemoryDatabase(false),
+			WithContext(ctx),
+			WithSourcesCachePath(filepath.Join(tmpdir, "cache")),
+		)
+		spec := &types.LuetCompilationSpec{
+			Package: &types.Package{Name: "foo", Category: "test", Version: "1.0"},
+			Sources: []types.Source{
+				{URL: slow.URL + "/patch.diff", SHA256: sum(filepath.Join(served, "patch.diff"))},
+			},
+		}
+
+		err := compiler.FetchSources(spec, buildDir)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("Client.Timeout"))
+	})
+
+	It("fetches and extracts the sources in the build context", func() {
+		spec := &types.LuetCompilationSpec{
+			Package: &types.Package{Name: "foo", Category: "test", Version: "1.0"},
+			Sources: []types.Source{
+				{URL: server.URL + "/patch.diff", SHA256: sum(filepath.Join(served, "patch.diff"))},
+				{URL: server.URL + "/foo-1.0.tar", SHA256: sum(filepath.Join(served, "foo-1.0.tar")), Destination: "src", Extract: true},
+			},
+		}
+
+		Expect(compiler.FetchSources(spec, buildDir)).To(Succeed())
+		Expect(filepath.Join(buildDir, "patch.diff")).To(BeARegularFile())
+		Expect(filepath.Join(buildDir, "src", "configure")).To(BeARegularFile())
+
+		// Served from the cache afterwards
+		server.Close()
+		other := filepath.Join(tmpdir, "other")
+		Expect(os.MkdirAll(other, os.ModePerm)).To(Succeed())
+		Expect(compiler.FetchSources(spec, other)).To(Succeed())
+		Expect(filepath.Join(other, "patch.diff")).To(BeARegularFile())
+	})
+
+	It("fails on checksum mismatches", func() {
+		spec := &types.LuetCompilationSpec{
+			Package: &types.Package{Name: "foo", Category: "test", Version: "1.0"},
+			Sources: []types.Source{
+				{URL: server.URL + "/patch.diff", SHA256: sum(filepath.Join(served, "foo-1.0.tar"))},
+			},
+		}
+		err := compiler.FetchSources(spec, buildDir)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("checksum mismatch"))
+		Expect(filepath.Join(buildDir, "patch.diff")).ToNot(BeAnExistingFile())
+	})
+
+	It("refuses destinations outside of the build context", func() {
+		spec := &types.LuetCompilationSpec{
+			Package: &types.Package{Name: "foo", Category: "test", Version: "1.0"},
+			Sources: []types.Source{
+				{URL: server.URL + "/patch.diff", SHA256: sum(filepath.Join(served, "patch.diff")), Destination: "../patch.diff"},
+			},
+		}
+		Expect(compiler.FetchSources(spec, buildDir)).ToNot(Succeed())
+	})
+})
@@ -47,3 +47,14 @@ func Tar(src, dest string) error {
 	}
 	return err
 }
+
+// Untar extracts the archive src, which can be compressed, in dest
+func Untar(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return archive.Untar(in, dest, &archive.TarOptions{NoLchown: true})
+}
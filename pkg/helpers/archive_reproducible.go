@@ -0,0 +1,170 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package helpers
+
+import (
+	"archive/tar"
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// SourceDateEpochEnv is the environment variable defined by
+// https://reproducible-builds.org/specs/source-date-epoch/
+const SourceDateEpochEnv = "SOURCE_DATE_EPOCH"
+
+const paxSchilyXattr = "SCHILY.xattr."
+
+// SourceDateEpoch returns the time set with SOURCE_DATE_EPOCH, and whether
+// it was set at all
+func SourceDateEpoch() (time.Time, bool, error) {
+	v, ok := os.LookupEnv(SourceDateEpochEnv)
+	if !ok || v == "" {
+		return time.Time{}, false, nil
+	}
+	sec, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, false, errors.Wrapf(err, "invalid %s '%s'", SourceDateEpochEnv, v)
+	}
+	return time.Unix(sec, 0).UTC(), true, nil
+}
+
+// TarReproducible archives src into dest so that the same content always
+// produces the same archive: entries are sorted by name, modification times
+// are clamped to epoch, access and change times are dropped and the owner
+// user and group names are left out, keeping only the numeric ids.
+func TarReproducible(src, dest string, epoch time.Time) error {
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	buf := bufio.NewWriter(out)
+	tw := tar.NewWriter(buf)
+
+	var paths []string
+	err = filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != src {
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "while walking %s", src)
+	}
+	sort.Strings(paths)
+
+	// inode -> first archived name, to store hardlinks as such
+	links := map[uint64]string{}
+
+	for _, p := range paths {
+		if err := addReproducibleEntry(tw, src, p, epoch, links); err != nil {
+			return errors.Wrapf(err, "while archiving %s", p)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	return out.Sync()
+}
+
+func addReproducibleEntry(tw *tar.Writer, src, p string, epoch time.Time, links map[uint64]string) error {
+	info, err := os.Lstat(p)
+	if err != nil {
+		return err
+	}
+
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		if link, err = os.Readlink(p); err != nil {
+			return err
+		}
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(src, p)
+	if err != nil {
+		return err
+	}
+	hdr.Name = filepath.ToSlash(rel)
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+
+	hdr.Uname = ""
+	hdr.Gname = ""
+	hdr.AccessTime = time.Time{}
+	hdr.ChangeTime = time.Time{}
+	hdr.ModTime = hdr.ModTime.Truncate(time.Second)
+	if hdr.ModTime.After(epoch) {
+		hdr.ModTime = epoch
+	}
+	hdr.Format = tar.FormatPAX
+
+	// File capabilities are kept, as the default archiver does
+	if size, err := unix.Lgetxattr(p, "security.capability", nil); err == nil && size > 0 {
+		data := make([]byte, size)
+		if n, err := unix.Lgetxattr(p, "security.capability", data); err == nil {
+			hdr.PAXRecords = map[string]string{paxSchilyXattr + "security.capability": string(data[:n])}
+		}
+	}
+
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && info.Mode().IsRegular() && st.Nlink > 1 {
+		if first, ok := links[uint64(st.Ino)]; ok {
+			hdr.Typeflag = tar.TypeLink
+			hdr.Linkname = first
+			hdr.Size = 0
+		} else {
+			links[uint64(st.Ino)] = hdr.Name
+		}
+	}
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	if hdr.Typeflag != tar.TypeReg {
+		return nil
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
@@ -0,0 +1,114 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package helpers_test
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/mudler/luet/pkg/helpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reproducible archives", func() {
+	epoch := time.Unix(1600000000, 0).UTC()
+
+	populate := func(dir string, names []string, mtime time.Time) {
+		for _, n := range names {
+			f := filepath.Join(dir, n)
+			Expect(os.MkdirAll(filepath.Dir(f), os.ModePerm)).To(Succeed())
+			Expect(os.WriteFile(f, []byte(n), 0644)).To(Succeed())
+			Expect(os.Chtimes(f, mtime, mtime)).To(Succeed())
+		}
+	}
+
+	It("gives the same archive regardless of creation order and times", func() {
+		tmp, err := os.MkdirTemp("", "reproducible")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(tmp)
+
+		first, second := filepath.Join(tmp, "first"), filepath.Join(tmp, "second")
+		populate(first, []string{"b/c", "a", "b/a"}, time.Now())
+		populate(second, []string{"a", "b/a", "b/c"}, time.Now().Add(time.Hour))
+		Expect(os.Symlink("a", filepath.Join(first, "link"))).To(Succeed())
+		Expect(os.Symlink("a", filepath.Join(second, "link"))).To(Succeed())
+
+		Expect(TarReproducible(first, filepath.Join(tmp, "first.tar"), epoch)).To(Succeed())
+		Expect(TarReproducible(second, filepath.Join(tmp, "second.tar"), epoch)).To(Succeed())
+
+		dat1, err := os.ReadFile(filepath.Join(tmp, "first.tar"))
+		Expect(err).ToNot(HaveOccurred())
+		dat2, err := os.ReadFile(filepath.Join(tmp, "second.tar"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(dat1).To(Equal(dat2))
+
+		f, err := os.Open(filepath.Join(tmp, "first.tar"))
+		Expect(err).ToNot(HaveOccurred())
+		defer f.Close()
+
+		var names []string
+		tr := tar.NewReader(f)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			Expect(err).ToNot(HaveOccurred())
+			names = append(names, hdr.Name)
+			Expect(hdr.ModTime.Unix()).To(Equal(epoch.Unix()))
+			Expect(hdr.Uname).To(BeEmpty())
+			Expect(hdr.Gname).To(BeEmpty())
+		}
+		Expect(names).To(Equal([]string{"a", "b/", "b/a", "b/c", "link"}))
+	})
+
+	It("keeps the times older than the epoch", func() {
+		tmp, err := os.MkdirTemp("", "reproducible")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(tmp)
+
+		src := filepath.Join(tmp, "src")
+		old := time.Unix(1500000000, 0)
+		populate(src, []string{"old"}, old)
+
+		Expect(TarReproducible(src, filepath.Join(tmp, "src.tar"), epoch)).To(Succeed())
+
+		f, err := os.Open(filepath.Join(tmp, "src.tar"))
+		Expect(err).ToNot(HaveOccurred())
+		defer f.Close()
+		hdr, err := tar.NewReader(f).Next()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hdr.ModTime.Unix()).To(Equal(old.Unix()))
+	})
+
+	It("reads SOURCE_DATE_EPOCH", func() {
+		os.Setenv(SourceDateEpochEnv, "1600000000")
+		defer os.Unsetenv(SourceDateEpochEnv)
+
+		t, set, err := SourceDateEpoch()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(set).To(BeTrue())
+		Expect(t).To(Equal(epoch))
+
+		os.Setenv(SourceDateEpochEnv, "foo")
+		_, _, err = SourceDateEpoch()
+		Expect(err).To(HaveOccurred())
+	})
+})
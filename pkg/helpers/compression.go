@@ -0,0 +1,54 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package helpers
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	containerdCompression "github.com/containerd/containerd/archive/compression"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+var (
+	xzMagic  = []byte{0xFD, '7', 'z', 'X', 'Z', 0x00}
+	lz4Magic = []byte{0x04, 0x22, 0x4D, 0x18}
+)
+
+// DecompressStream returns the decompressed content of r, detecting its
+// compression. On top of the formats handled by containerd (gzip and
+// zstd), it supports xz and lz4. Uncompressed content is returned as is.
+func DecompressStream(r io.Reader) (io.ReadCloser, error) {
+	buf := bufio.NewReader(r)
+	magic, err := buf.Peek(len(xzMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, xzMagic):
+		xzReader, err := xz.NewReader(buf)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xzReader), nil
+	case bytes.HasPrefix(magic, lz4Magic):
+		return io.NopCloser(lz4.NewReader(buf)), nil
+	}
+	return containerdCompression.DecompressStream(buf)
+}
@@ -16,9 +16,23 @@
 package helpers
 
 import (
+	"path"
 	"strings"
 )
 
 func SanitizeImageString(s string) string {
 	return strings.ReplaceAll(s, "+", "-")
 }
+
+// FileImageTag returns the tag of the image of a repository file, given its
+// path relative to the repository. The files in the folder of a platform
+// have its name as suffix, as the package images built for it, so the ones
+// of different platforms don't overwrite each other.
+func FileImageTag(name string) string {
+	dir, file := path.Split(name)
+	tag := SanitizeImageString(file)
+	if dir = strings.Trim(dir, "/"); dir != "" {
+		tag += "-" + strings.ReplaceAll(dir, "/", "-")
+	}
+	return tag
+}
@@ -26,5 +26,10 @@ var _ = Describe("Helpers", func() {
 		It("strips invalid chars", func() {
 			Expect(SanitizeImageString("foo+bar")).To(Equal("foo-bar"))
 		})
+
+		It("tags the files of platform folders with their name", func() {
+			Expect(FileImageTag("foo+bar.metadata.yaml")).To(Equal("foo-bar.metadata.yaml"))
+			Expect(FileImageTag("linux-amd64/foo+bar.metadata.yaml")).To(Equal("foo-bar.metadata.yaml-linux-amd64"))
+		})
 	})
 })
@@ -72,6 +72,20 @@ func printUpgradeList(install, uninstall types.Packages) {
 
 }
 
+func printChangeReport(changes types.ChangeReport) {
+	fmt.Println()
+
+	d := pterm.TableData{{"Package", "Change"}}
+	for _, m := range changes.Removed {
+		d = append(d, []string{pterm.LightRed(m.HumanReadableString()), "removed"})
+	}
+	for _, m := range changes.Downgraded {
+		d = append(d, []string{pterm.LightYellow(m.HumanReadableString()), "downgraded"})
+	}
+	pterm.DefaultTable.WithHasHeader().WithData(d).Render()
+	fmt.Println()
+}
+
 func printMatchUpgrade(artefacts map[string]ArtifactMatch, uninstall types.Packages) {
 	p := types.Packages{}
 
@@ -98,12 +98,16 @@ func (c *DockerClient) DownloadArtifact(a *artifact.PackageArtifact) (*artifact.
 		return nil, err
 	}
 	defer os.RemoveAll(tempArtifact.Name())
+	platform := c.RepoData.Platform
+	if platform.IsZero() {
+		platform = luettypes.HostPlatform()
+	}
 	for _, uri := range c.RepoData.Urls {
 
 		imageName := fmt.Sprintf("%s:%s", uri, a.CompileSpec.GetPackage().ImageID())
 		c.context.Info("Downloading image", imageName)
 
-		info, err := docker.DownloadAndExtractDockerImage(c.context, imageName, temp, c.auth, c.RepoData.Verify, luettypes.HostPlatform())
+		info, err := docker.DownloadAndExtractDockerImage(c.context, imageName, temp, c.auth, c.RepoData.Verify, platform)
 		if err != nil {
 			lastErr = err
 			c.context.Warning(fmt.Sprintf(errImageDownloadMsg, imageName, err.Error()))
@@ -171,10 +175,12 @@ func (c *DockerClient) DownloadFile(name string) (string, error) {
 			continue
 		}
 
-		imageName := fmt.Sprintf("%s:%s", uri, helpers.SanitizeImageString(name))
+		imageName := fmt.Sprintf("%s:%s", uri, helpers.FileImageTag(name))
 		c.context.Info("Downloading", imageName)
 
-		info, err := docker.DownloadAndExtractDockerImage(c.context, imageName, temp, c.auth, c.RepoData.Verify, luettypes.HostPlatform())
+		// The images of files have only the file, and are the same for any
+		// platform
+		info, err := docker.DownloadAndExtractDockerImage(c.context, imageName, temp, c.auth, c.RepoData.Verify, luettypes.Platform{})
 		if err != nil {
 			lastErr = err
 			c.context.Warning(fmt.Sprintf(errImageDownloadMsg, imageName, err.Error()))
@@ -184,8 +190,8 @@ func (c *DockerClient) DownloadFile(name string) (string, error) {
 		c.context.Info(fmt.Sprintf("Pulled: %s", info.Target.Digest))
 		c.context.Info(fmt.Sprintf("Size: %s", units.BytesSize(float64(info.Target.Size))))
 
-		c.context.Debug("\nCopying file ", filepath.Join(temp, name), "to", file.Name())
-		err = fileHelper.CopyFile(filepath.Join(temp, name), file.Name())
+		c.context.Debug("\nCopying file ", filepath.Join(temp, path.Base(name)), "to", file.Name())
+		err = fileHelper.CopyFile(filepath.Join(temp, path.Base(name)), file.Name())
 		if err != nil {
 			lastErr = err
 			continue
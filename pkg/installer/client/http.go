@@ -16,6 +16,9 @@
 package client
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"fmt"
 	"math"
 	"net/http"
@@ -23,6 +26,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/mudler/luet/pkg/api/core/types"
@@ -59,6 +63,34 @@ func NewGrabClient(timeout int) *grab.Client {
 	}
 }
 
+// grabClient returns the client used to download from the repository,
+// trusting the certificate authorities of its CA bundle
+func (c *HttpClient) grabClient() (*grab.Client, error) {
+	client := NewGrabClient(c.context.GetConfig().General.HTTPTimeout)
+	if c.RepoData.CABundle == "" {
+		return client, nil
+	}
+
+	pem, err := os.ReadFile(c.RepoData.CABundle)
+	if err != nil {
+		return nil, errors.Wrap(err, "while reading the CA bundle")
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in the CA bundle '%s'", c.RepoData.CABundle)
+	}
+	client.HTTPClient.(*http.Client).Transport.(*http.Transport).TLSClientConfig = &tls.Config{RootCAs: pool}
+	return client, nil
+}
+
+// prepareReq returns the request downloading url to dst, authenticated
+// with the credentials of the repository: a token sent with the "token"
+// scheme ("token") or the "Bearer" one ("bearer"), basic credentials
+// either encoded or as user:password ("basic"), or a username and a
+// password
 func (c *HttpClient) prepareReq(dst, url string) (*grab.Request, error) {
 
 	req, err := grab.NewRequest(dst, url)
@@ -66,10 +98,18 @@ func (c *HttpClient) prepareReq(dst, url string) (*grab.Request, error) {
 		return nil, err
 	}
 
-	if val, ok := c.RepoData.Authentication["token"]; ok {
+	auth := c.RepoData.Authentication
+	if val, ok := auth["token"]; ok {
 		req.HTTPRequest.Header.Set("Authorization", "token "+val)
-	} else if val, ok := c.RepoData.Authentication["basic"]; ok {
+	} else if val, ok := auth["bearer"]; ok {
+		req.HTTPRequest.Header.Set("Authorization", "Bearer "+val)
+	} else if val, ok := auth["basic"]; ok {
+		if strings.Contains(val, ":") {
+			val = base64.StdEncoding.EncodeToString([]byte(val))
+		}
 		req.HTTPRequest.Header.Set("Authorization", "Basic "+val)
+	} else if user, ok := auth["username"]; ok {
+		req.HTTPRequest.SetBasicAuth(user, auth["password"])
 	}
 
 	return req, err
@@ -97,7 +137,10 @@ func (c *HttpClient) DownloadFile(p string) (string, error) {
 	}
 	defer os.RemoveAll(temp)
 
-	client := NewGrabClient(c.context.GetConfig().General.HTTPTimeout)
+	client, err := c.grabClient()
+	if err != nil {
+		return "", err
+	}
 
 	for _, uri := range c.RepoData.Urls {
 		file, err = c.context.TempFile("HttpClient")
@@ -195,7 +238,7 @@ func (c *HttpClient) CacheGet(a *artifact.PackageArtifact) (*artifact.PackageArt
 }
 
 func (c *HttpClient) DownloadArtifact(a *artifact.PackageArtifact) (*artifact.PackageArtifact, error) {
-	artifactName := path.Base(a.Path)
+	artifactName := a.RepositoryPath()
 
 	newart, err := c.CacheGet(a)
 	// Check if file is already in cache
@@ -16,6 +16,7 @@
 package client_test
 
 import (
+	"encoding/pem"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -69,5 +70,49 @@ var _ = Describe("Http client", func() {
 			os.RemoveAll(path.Path)
 		})
 
+		It("Authenticates with the repository credentials", func() {
+			var header string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				header = r.Header.Get("Authorization")
+				w.Write([]byte("test"))
+			}))
+			defer ts.Close()
+
+			for auth, expected := range map[string]map[string]string{
+				"token secret":               {"token": "secret"},
+				"Bearer secret":              {"bearer": "secret"},
+				"Basic dXNlcjpwYXNz":         {"basic": "user:pass"},
+				"Basic ZW5jb2RlZA==":         {"basic": "ZW5jb2RlZA=="},
+				"Basic dXNlcjpwYXNzd29yZA==": {"username": "user", "password": "password"},
+			} {
+				c := NewHttpClient(RepoData{Urls: []string{ts.URL}, Authentication: expected}, ctx)
+				path, err := c.DownloadFile("test.txt")
+				Expect(err).ToNot(HaveOccurred())
+				os.RemoveAll(path)
+				Expect(header).To(Equal(auth))
+			}
+		})
+
+		It("Trusts the certificates of the CA bundle", func() {
+			tmpdir, err := os.MkdirTemp("", "test")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(tmpdir)
+			ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("test"))
+			}))
+			defer ts.Close()
+
+			c := NewHttpClient(RepoData{Urls: []string{ts.URL}}, ctx)
+			_, err = c.DownloadFile("test.txt")
+			Expect(err).To(HaveOccurred())
+
+			bundle := filepath.Join(tmpdir, "ca.pem")
+			Expect(os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), os.ModePerm)).To(Succeed())
+			c = NewHttpClient(RepoData{Urls: []string{ts.URL}, CABundle: bundle}, ctx)
+			path, err := c.DownloadFile("test.txt")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fileHelper.Read(path)).To(Equal("test"))
+			os.RemoveAll(path)
+		})
 	})
 })
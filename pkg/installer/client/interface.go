@@ -15,8 +15,16 @@
 
 package client
 
+import luettypes "github.com/mudler/luet/pkg/api/core/types"
+
 type RepoData struct {
 	Urls           []string
 	Authentication map[string]string
 	Verify         bool
+	// Platform is the platform of the artifacts to download, defaults to
+	// the host one
+	Platform luettypes.Platform
+	// CABundle is the path of a PEM file with additional certificate
+	// authorities to trust
+	CABundle string
 }
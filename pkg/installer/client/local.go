@@ -17,7 +17,6 @@ package client
 
 import (
 	"os"
-	"path"
 	"path/filepath"
 
 	"github.com/mudler/luet/pkg/api/core/types"
@@ -43,7 +42,7 @@ func NewLocalClient(r RepoData, ctx types.Context) *LocalClient {
 func (c *LocalClient) DownloadArtifact(a *artifact.PackageArtifact) (*artifact.PackageArtifact, error) {
 	var err error
 
-	artifactName := path.Base(a.Path)
+	artifactName := a.RepositoryPath()
 
 	newart, err := c.CacheGet(a)
 	// Check if file is already in cache
@@ -87,22 +87,36 @@ func (l *LuetInstaller) computeUpgrade(syncedRepos Repositories, s *System) (typ
 	// compute a "big" world
 	solv := solver.NewResolver(
 		types.SolverOptions{
-			Type:        l.Options.SolverOptions.Implementation,
-			Concurrency: l.Options.Concurrency},
+			Type:            l.Options.SolverOptions.Implementation,
+			Concurrency:     l.Options.Concurrency,
+			MinimizeChanges: l.Options.SolverOptions.MinimizeChanges},
 		s.Database, allRepos, pkg.NewInMemoryDatabaseNoIndex(),
 		solver.NewSolverFromOptions(l.Options.SolverOptions))
 	var solution types.PackagesAssertions
+	var changes types.ChangeReport
 
-	if l.Options.SolverUpgrade {
-		uninstall, solution, err = solv.UpgradeUniverse(l.Options.RemoveUnavailableOnUpgrade)
-		if err != nil {
-			return uninstall, toInstall, errors.Wrap(err, "Failed solving solution for upgrade")
-		}
+	cacheKey := l.solverCacheKey("upgrade", syncedRepos, s, types.Packages{})
+	if cached, ok := l.cachedSolve(cacheKey); ok {
+		uninstall, solution, changes = cached.Uninstall, cached.Assertions, cached.Changes
 	} else {
-		uninstall, solution, err = solv.Upgrade(l.Options.FullUninstall, true)
-		if err != nil {
-			return uninstall, toInstall, errors.Wrap(err, "Failed solving solution for upgrade")
+		if l.Options.SolverUpgrade {
+			uninstall, solution, err = solv.UpgradeUniverse(l.Options.RemoveUnavailableOnUpgrade)
+			if err != nil {
+				return uninstall, toInstall, errors.Wrap(err, "Failed solving solution for upgrade")
+			}
+		} else {
+			uninstall, solution, err = solv.Upgrade(l.Options.FullUninstall, true)
+			if err != nil {
+				return uninstall, toInstall, errors.Wrap(err, "Failed solving solution for upgrade")
+			}
 		}
+		changes = solv.Changes()
+		l.cacheSolve(cacheKey, cachedSolution{Uninstall: uninstall, Assertions: solution, Changes: changes})
+	}
+
+	if l.Options.SolverOptions.MinimizeChanges && !changes.Empty() {
+		l.Options.Context.Warning(":warning: The upgrade could not avoid the following changes to installed packages:")
+		printChangeReport(changes)
 	}
 
 	for _, assertion := range solution {
@@ -773,14 +787,22 @@ func (l *LuetInstaller) computeInstall(o Option, syncedRepos Repositories, cp ty
 			solver.NewSolverFromOptions(l.Options.SolverOptions),
 		)
 
-		if l.Options.Relaxed {
-			solution, err = solv.RelaxedInstall(p)
+		cacheKey := l.solverCacheKey("install", syncedRepos, s, p)
+		if cached, ok := l.cachedSolve(cacheKey); ok {
+			solution = cached.Assertions
 		} else {
-			solution, err = solv.Install(p)
-		}
-		/// TODO: PackageAssertions needs to be a map[fingerprint]pack so lookup is in O(1)
-		if err != nil && !o.Force {
-			return toInstall, p, solution, allRepos, errors.Wrap(err, "Failed solving solution for package")
+			if l.Options.Relaxed {
+				solution, err = solv.RelaxedInstall(p)
+			} else {
+				solution, err = solv.Install(p)
+			}
+			/// TODO: PackageAssertions needs to be a map[fingerprint]pack so lookup is in O(1)
+			if err != nil && !o.Force {
+				return toInstall, p, solution, allRepos, errors.Wrap(err, "Failed solving solution for package")
+			}
+			if err == nil {
+				l.cacheSolve(cacheKey, cachedSolution{Assertions: solution})
+			}
 		}
 		// Gathers things to install
 		for _, assertion := range solution {
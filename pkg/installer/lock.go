@@ -0,0 +1,224 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/ghodss/yaml"
+	"github.com/mudler/luet/pkg/api/core/types"
+	artifact "github.com/mudler/luet/pkg/api/core/types/artifact"
+	pkg "github.com/mudler/luet/pkg/database"
+	"github.com/pkg/errors"
+)
+
+const LockFileVersion = 1
+
+// LockFile pins the exact artifacts of a solved install, so that it can be
+// replayed without running the solver again.
+type LockFile struct {
+	Version  int             `json:"version"`
+	Packages []LockedPackage `json:"packages"`
+}
+
+// LockedPackage is a single artifact in a LockFile, along with the
+// repository state it was taken from.
+type LockedPackage struct {
+	Fingerprint string             `json:"fingerprint"`
+	Repository  string             `json:"repository"`
+	Revision    int                `json:"revision"`
+	Artifact    string             `json:"artifact"`
+	Checksums   artifact.Checksums `json:"checksums"`
+}
+
+// ReadLockFile reads a lock file from disk
+func ReadLockFile(file string) (*LockFile, error) {
+	dat, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading file "+file)
+	}
+	lock := &LockFile{}
+	if err := yaml.Unmarshal(dat, lock); err != nil {
+		return nil, errors.Wrap(err, "Error parsing lock file "+file)
+	}
+	if lock.Version != LockFileVersion {
+		return nil, fmt.Errorf("unsupported lock file version %d", lock.Version)
+	}
+	return lock, nil
+}
+
+// WriteFile writes the lock file to the given path
+func (lf *LockFile) WriteFile(path string) error {
+	data, err := yaml.Marshal(lf)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Lock solves the given packages against the system repositories and returns
+// a lock file holding every artifact of the solution.
+//
+// The solution is computed for an empty system, so the lock file describes a
+// complete package set regardless of what is installed on the host running
+// the command.
+func (l *LuetInstaller) Lock(cp types.Packages) (*LockFile, error) {
+	syncedRepos, err := l.SyncRepositories()
+	if err != nil {
+		return nil, err
+	}
+
+	o := Option{
+		NoDeps: l.Options.NoDeps,
+		Force:  l.Options.Force,
+	}
+	empty := &System{Database: pkg.NewInMemoryDatabase(false)}
+	match, _, _, _, err := l.computeInstall(o, syncedRepos, cp, empty)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &LockFile{Version: LockFileVersion}
+	for _, m := range match {
+		repo, ok := m.Repository.(*LuetSystemRepository)
+		if !ok {
+			return nil, errors.New("cannot lock " + m.Package.HumanReadableString() + ": unknown repository")
+		}
+		lock.Packages = append(lock.Packages, LockedPackage{
+			Fingerprint: m.Package.GetFingerPrint(),
+			Repository:  repo.GetName(),
+			Revision:    repo.GetRevision(),
+			Artifact:    m.Artifact.Path,
+			Checksums:   m.Artifact.Checksums,
+		})
+	}
+
+	sort.Slice(lock.Packages, func(i, j int) bool {
+		return lock.Packages[i].Fingerprint < lock.Packages[j].Fingerprint
+	})
+
+	return lock, nil
+}
+
+// computeLocked matches the entries of a lock file against the synced
+// repositories. It fails if a repository is missing or at a different
+// revision, or if an artifact is gone, has no locked checksums or its
+// checksums changed. Installed packages are skipped, unless only
+// downloading.
+func (l *LuetInstaller) computeLocked(syncedRepos Repositories, lock *LockFile, s *System) (map[string]ArtifactMatch, types.PackageDatabase, error) {
+	toInstall := map[string]ArtifactMatch{}
+	allRepos := pkg.NewInMemoryDatabase(false)
+	syncedRepos.SyncDatabase(allRepos)
+
+	repos := map[string]*LuetSystemRepository{}
+	for _, r := range syncedRepos {
+		repos[r.GetName()] = r
+	}
+
+	for _, locked := range lock.Packages {
+		repo, ok := repos[locked.Repository]
+		if !ok {
+			return nil, nil, fmt.Errorf("repository '%s' required by %s is not available", locked.Repository, locked.Fingerprint)
+		}
+		if repo.GetRevision() != locked.Revision {
+			return nil, nil, fmt.Errorf("repository '%s' is at revision %d, lock file requires %d", locked.Repository, repo.GetRevision(), locked.Revision)
+		}
+
+		var found *artifact.PackageArtifact
+		for _, a := range repo.GetIndex() {
+			if a.CompileSpec.GetPackage() != nil && a.CompileSpec.GetPackage().GetFingerPrint() == locked.Fingerprint {
+				found = a
+				break
+			}
+		}
+		if found == nil {
+			return nil, nil, fmt.Errorf("artifact for %s not found in repository '%s'", locked.Fingerprint, locked.Repository)
+		}
+		if found.Path != locked.Artifact {
+			return nil, nil, fmt.Errorf("artifact for %s is '%s', lock file requires '%s'", locked.Fingerprint, found.Path, locked.Artifact)
+		}
+		if len(locked.Checksums) == 0 {
+			return nil, nil, fmt.Errorf("no checksums locked for %s", locked.Fingerprint)
+		}
+		for t, sum := range locked.Checksums {
+			if found.Checksums[t] != sum {
+				return nil, nil, fmt.Errorf("%s checksum mismatch for %s", t, locked.Fingerprint)
+			}
+		}
+
+		p, err := repo.GetTree().GetDatabase().FindPackage(found.CompileSpec.GetPackage())
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "package "+locked.Fingerprint+" not found in repository tree")
+		}
+		p.SetBuildTimestamp(found.CompileSpec.GetPackage().GetBuildTimestamp())
+
+		if _, err := s.Database.FindPackage(p); err == nil && !l.Options.DownloadOnly {
+			// Already installed
+			continue
+		}
+		toInstall[p.GetFingerPrint()] = ArtifactMatch{Package: p, Artifact: found, Repository: repo}
+	}
+
+	return toInstall, allRepos, nil
+}
+
+// InstallLocked installs exactly the artifacts of a lock file, without
+// solving. Downloaded artifacts are verified against the checksums that were
+// matched with the lock file.
+func (l *LuetInstaller) InstallLocked(lock *LockFile, s *System) error {
+	l.Options.Context.Screen("Install")
+	syncedRepos, err := l.SyncRepositories()
+	if err != nil {
+		return err
+	}
+
+	match, allRepos, err := l.computeLocked(syncedRepos, lock, s)
+	if err != nil {
+		return errors.Wrap(err, "lock file does not match the repositories")
+	}
+
+	if len(match) == 0 {
+		l.Options.Context.Info("No packages to install")
+		return nil
+	}
+
+	l.Options.Context.Info("Packages that are going to be installed in the system:")
+
+	printMatches(match)
+
+	// The lock file already carries the whole dependency set: finalizers
+	// are run for the matched packages only, as with --nodeps. Nothing is
+	// installed when only downloading, so there can't be file conflicts.
+	o := Option{
+		NoDeps:             true,
+		Force:              l.Options.Force,
+		CheckFileConflicts: !l.Options.DownloadOnly,
+		RunFinalizers:      true,
+	}
+
+	if l.Options.Ask {
+		l.Options.Context.Info("By going forward, you are also accepting the licenses of the packages that you are going to install in your system.")
+		if l.Options.Context.Ask() {
+			l.Options.Ask = false // Don't prompt anymore
+			return l.install(o, syncedRepos, match, types.Packages{}, types.PackagesAssertions{}, allRepos, s)
+		} else {
+			return errors.New("Aborted by user")
+		}
+	}
+	return l.install(o, syncedRepos, match, types.Packages{}, types.PackagesAssertions{}, allRepos, s)
+}
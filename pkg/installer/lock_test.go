@@ -0,0 +1,158 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/mudler/luet/pkg/api/core/context"
+	"github.com/mudler/luet/pkg/api/core/types"
+	artifact "github.com/mudler/luet/pkg/api/core/types/artifact"
+	pkg "github.com/mudler/luet/pkg/database"
+	. "github.com/mudler/luet/pkg/installer"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lock file", func() {
+	var tmpdir string
+	lock := &LockFile{
+		Version: LockFileVersion,
+		Packages: []LockedPackage{
+			{
+				Fingerprint: "a-test-1.0",
+				Repository:  "test",
+				Revision:    3,
+				Artifact:    "a-test-1.0.package.tar",
+				Checksums:   artifact.Checksums{"sha256": "abcd"},
+			},
+		},
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = os.MkdirTemp("", "lock")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpdir)
+	})
+
+	It("can be written and read back", func() {
+		f := filepath.Join(tmpdir, "luet.lock")
+		Expect(lock.WriteFile(f)).ToNot(HaveOccurred())
+
+		read, err := ReadLockFile(f)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(read).To(Equal(lock))
+	})
+
+	It("refuses unknown versions", func() {
+		f := filepath.Join(tmpdir, "luet.lock")
+		Expect(os.WriteFile(f, []byte("version: 99\n"), os.ModePerm)).ToNot(HaveOccurred())
+
+		_, err := ReadLockFile(f)
+		Expect(err).To(HaveOccurred())
+	})
+
+	Context("with a repository", func() {
+		var repodir string
+		var ctx *context.Context
+		var inst *LuetInstaller
+		var s *System
+
+		BeforeEach(func() {
+			var err error
+			ctx = context.NewContext()
+			repodir, err = os.MkdirTemp("", "repo")
+			Expect(err).ToNot(HaveOccurred())
+			ctx.Config.System.PkgsCachePath, err = os.MkdirTemp("", "cache")
+			Expect(err).ToNot(HaveOccurred())
+			ctx.Config.System.DatabasePath, err = os.MkdirTemp("", "db")
+			Expect(err).ToNot(HaveOccurred())
+			diskRepo(ctx, "../../tests/fixtures/simple_dep", repodir, types.GZip)
+
+			inst = NewLuetInstaller(LuetInstallerOptions{
+				Concurrency: 1, Context: ctx,
+				PackageRepositories: types.LuetRepositories{{Name: "test", Type: DiskRepositoryType, Urls: []string{repodir}, Enable: true}},
+			})
+			s = &System{Database: pkg.NewInMemoryDatabase(false), Target: tmpdir}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(repodir)
+			os.RemoveAll(ctx.Config.System.PkgsCachePath)
+			os.RemoveAll(ctx.Config.System.DatabasePath)
+		})
+
+		lockC := func() *LockFile {
+			l, err := inst.Lock(types.Packages{{Name: "c", Category: "test", Version: ">=0"}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(l.Packages).ToNot(BeEmpty())
+			return l
+		}
+
+		It("installs the locked packages", func() {
+			Expect(inst.InstallLocked(lockC(), s)).To(Succeed())
+			_, err := s.Database.FindPackage(&types.Package{Name: "c", Category: "test", Version: "1.0"})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("refuses lock entries without checksums", func() {
+			l := lockC()
+			l.Packages[0].Checksums = artifact.Checksums{}
+
+			err := inst.InstallLocked(l, s)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("no checksums locked"))
+		})
+
+		It("only downloads the locked packages", func() {
+			// Installed packages are downloaded too
+			_, err := s.Database.CreatePackage(&types.Package{Name: "a", Category: "test", Version: "1.2"})
+			Expect(err).ToNot(HaveOccurred())
+
+			inst.Options.DownloadOnly = true
+			l := lockC()
+			Expect(inst.InstallLocked(l, s)).To(Succeed())
+			Expect(s.Database.World()).To(HaveLen(1))
+
+			repo, err := LoadLocalRepository(ctx, repodir)
+			Expect(err).ToNot(HaveOccurred())
+			cache := artifact.NewCache(ctx.Config.System.PkgsCachePath)
+			for _, locked := range l.Packages {
+				for _, a := range repo.GetIndex() {
+					if a.CompileSpec.GetPackage().GetFingerPrint() == locked.Fingerprint {
+						_, err := cache.Get(a)
+						Expect(err).ToNot(HaveOccurred())
+					}
+				}
+			}
+		})
+	})
+
+	It("fails installing if a locked repository is not available", func() {
+		inst := NewLuetInstaller(LuetInstallerOptions{Concurrency: 1, Context: context.NewContext()})
+		s := &System{Database: pkg.NewInMemoryDatabase(false), Target: tmpdir}
+
+		err := inst.InstallLocked(lock, s)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("repository 'test'"))
+	})
+})
@@ -45,12 +45,16 @@ import (
 const (
 	REPOSITORY_METAFILE  = "repository.meta.yaml"
 	REPOSITORY_SPECFILE  = "repository.yaml"
+	REPOSITORY_DELTAFILE = "repository.delta.yaml"
+	REPOSITORY_INDEXFILE = "repository.index"
 	TREE_TARBALL         = "tree.tar"
 	COMPILERTREE_TARBALL = "compilertree.tar"
 
 	REPOFILE_TREE_KEY          = "tree"
 	REPOFILE_COMPILER_TREE_KEY = "compilertree"
 	REPOFILE_META_KEY          = "meta"
+	REPOFILE_INDEX_KEY         = "index"
+	REPOFILE_DELTA_KEY_PREFIX  = "delta-"
 
 	DiskRepositoryType   = "disk"
 	HttpRepositoryType   = "http"
@@ -74,6 +78,7 @@ type LuetSystemRepository struct {
 	ForcePush       bool                          `json:"-"`
 
 	imagePrefix, snapshotID string
+	deltas                  int
 }
 
 type LuetSystemRepositoryMetadata struct {
@@ -302,7 +307,7 @@ func GenerateRepository(p ...RepositoryOption) (*LuetSystemRepository, error) {
 
 	// Pick only atoms in db which have a real metadata for runtime db (tr)
 	for _, p := range tempTree.World() {
-		if _, err := os.Stat(filepath.Join(c.Src, p.GetMetadataFilePath())); err == nil {
+		if metadataExists(c.Src, p) {
 			runtimeTree.CreatePackage(p)
 		}
 	}
@@ -350,6 +355,10 @@ func GenerateRepository(p ...RepositoryOption) (*LuetSystemRepository, error) {
 		filepath.Walk(c.Src, ff)
 	}
 
+	if err := addRuntimeDeps(c.context, c.Src, runtimeTree); err != nil {
+		return nil, errors.Wrap(err, "while adding the runtime dependencies of the artifacts")
+	}
+
 	repo := &LuetSystemRepository{
 		LuetRepository:  types.NewLuetRepository(c.Name, c.Type, c.Description, c.Urls, c.Priority, true, false),
 		Tree:            tree.NewInstallerRecipe(runtimeTree, c.runtimeParser...),
@@ -448,6 +457,12 @@ func (r *LuetSystemRepository) SetSnapshotID(i string) {
 	r.snapshotID = i
 }
 
+// SetDeltas sets the number of revision deltas published along with the
+// repository, 0 disables them
+func (r *LuetSystemRepository) SetDeltas(n int) {
+	r.deltas = n
+}
+
 func (r *LuetSystemRepository) GetVerify() bool {
 	return r.LuetRepository.Verify
 }
@@ -464,6 +479,22 @@ func (r *LuetSystemRepository) SetReferenceID(ref string) {
 	r.LuetRepository.ReferenceID = ref
 }
 
+func (r *LuetSystemRepository) GetSnapshot() string {
+	return r.LuetRepository.Snapshot
+}
+
+func (r *LuetSystemRepository) SetSnapshot(id string) {
+	r.LuetRepository.Snapshot = id
+}
+
+func (r *LuetSystemRepository) GetCABundle() string {
+	return r.LuetRepository.CABundle
+}
+
+func (r *LuetSystemRepository) SetCABundle(f string) {
+	r.LuetRepository.CABundle = f
+}
+
 func (r *LuetSystemRepository) GetBackend() compiler.CompilerBackend {
 	return r.Backend
 }
@@ -630,10 +661,17 @@ func (r *LuetSystemRepository) Snapshot(id, dst string) (artifacts []*artifact.P
 	if err != nil {
 		return
 	}
+	// Snapshots never change, they don't need deltas
+	newRepoIndex.removeDeltas()
 
-	for _, key := range []string{REPOFILE_META_KEY, REPOFILE_TREE_KEY, REPOFILE_COMPILER_TREE_KEY} {
+	for _, key := range []string{REPOFILE_META_KEY, REPOFILE_TREE_KEY, REPOFILE_COMPILER_TREE_KEY, REPOFILE_INDEX_KEY} {
 		var luetFile LuetRepositoryFile
 		luetFile, err = r.GetRepositoryFile(key)
+		if err != nil && key == REPOFILE_INDEX_KEY {
+			// The binary index is optional
+			err = nil
+			continue
+		}
 		if err != nil {
 			return
 		}
@@ -774,6 +812,7 @@ func (r *LuetSystemRepository) Client(ctx types.Context) Client {
 			client.RepoData{
 				Urls:           r.GetUrls(),
 				Authentication: r.GetAuthentication(),
+				CABundle:       r.GetCABundle(),
 			}, ctx)
 
 	case DockerRepositoryType:
@@ -782,6 +821,7 @@ func (r *LuetSystemRepository) Client(ctx types.Context) Client {
 				Urls:           r.GetUrls(),
 				Authentication: r.GetAuthentication(),
 				Verify:         r.Verify,
+				Platform:       ctx.GetConfig().System.GetPlatform(),
 			}, ctx)
 	}
 	return nil
@@ -852,8 +892,13 @@ func (r *LuetSystemRepository) SyncBuildMetadata(ctx types.Context, path string)
 	}
 
 	for _, ai := range repo.GetTree().GetDatabase().World() {
+		// Metadata of artifacts built for a platform are stored in its folder
+		metadataFile := ai.GetMetadataFilePath()
+		if a, err := repo.SearchArtefact(ai); err == nil {
+			metadataFile = filepath.Join(a.Platform.DirName(), metadataFile)
+		}
 		// Retrieve remote repository.yaml for retrieve revision and date
-		file, err := c.DownloadFile(ai.GetMetadataFilePath())
+		file, err := c.DownloadFile(metadataFile)
 		if err != nil {
 			return errors.Wrapf(err, "while downloading metadata for %s", ai.HumanReadableString())
 		}
@@ -865,10 +910,63 @@ func (r *LuetSystemRepository) SyncBuildMetadata(ctx types.Context, path string)
 	return nil
 }
 
-func (r *LuetSystemRepository) referenceID() string {
+// addRuntimeDeps adds to the packages of the runtime tree db the requires
+// detected from the shared libraries linked by their artifacts in src, which
+// aren't in the tree definitions
+func addRuntimeDeps(ctx types.Context, src string, db types.PackageDatabase) error {
+	artifacts, err := readMetadataFiles(src)
+	if err != nil {
+		return err
+	}
+	for _, a := range artifacts {
+		if len(a.RuntimeDeps) == 0 {
+			continue
+		}
+		p, err := db.FindPackage(a.CompileSpec.GetPackage())
+		if err != nil {
+			continue
+		}
+		required := map[string]bool{}
+		for _, r := range p.GetRequires() {
+			required[r.GetPackageName()] = true
+		}
+		requires := p.GetRequires()
+		for _, r := range a.RuntimeDeps {
+			if !required[r.GetPackageName()] {
+				ctx.Debug("Adding runtime dep", r.HumanReadableString(), "to", p.HumanReadableString())
+				requires = append(requires, r)
+				required[r.GetPackageName()] = true
+			}
+		}
+		if len(requires) == len(p.GetRequires()) {
+			continue
+		}
+		p.Requires(requires)
+		if err := db.UpdatePackage(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// metadataExists returns true if the metadata file of the package is in src,
+// or in one of the platform folders of src
+func metadataExists(src string, p *types.Package) bool {
+	if _, err := os.Stat(filepath.Join(src, p.GetMetadataFilePath())); err == nil {
+		return true
+	}
+	matches, _ := filepath.Glob(filepath.Join(src, "*", p.GetMetadataFilePath()))
+	return len(matches) > 0
+}
+
+// ReferenceFile returns the name of the repository spec file synced by
+// clients, which is the one of the snapshot the repository is pinned to, if any
+func (r *LuetSystemRepository) ReferenceFile() string {
 	repositoryReferenceID := REPOSITORY_SPECFILE
 	if r.ReferenceID != "" {
 		repositoryReferenceID = r.ReferenceID
+	} else if r.GetSnapshot() != "" {
+		repositoryReferenceID = snapshotSpecFile(r.GetSnapshot())
 	}
 	return repositoryReferenceID
 }
@@ -898,7 +996,14 @@ func (r *LuetSystemRepository) Sync(ctx types.Context, force bool) (*LuetSystemR
 		return nil, errors.New("no client could be generated from repository")
 	}
 
-	repositoryReferenceID := r.referenceID()
+	repositoryReferenceID := r.ReferenceFile()
+
+	// The cached tree and metadata are the ones of the spec file they were
+	// synced from, they have to be synced again when pinning another snapshot
+	referenceFile := filepath.Join(repobasedir, "REFERENCE")
+	if dat, err := os.ReadFile(referenceFile); err == nil && r.Cached && string(dat) != repositoryReferenceID {
+		force = true
+	}
 
 	var downloadedRepoMeta *LuetSystemRepository
 	var file string
@@ -928,9 +1033,10 @@ func (r *LuetSystemRepository) Sync(ctx types.Context, force bool) (*LuetSystemR
 		repoUpdated = true
 	}
 
+	var localRepo *LuetSystemRepository
 	if r.Cached {
 		if !force {
-			localRepo, _ := r.ReadSpecFile(filepath.Join(repobasedir, repositoryReferenceID))
+			localRepo, _ = r.ReadSpecFile(filepath.Join(repobasedir, repositoryReferenceID))
 			if localRepo != nil {
 				if localRepo.GetRevision() == downloadedRepoMeta.GetRevision() &&
 					localRepo.GetLastUpdate() == downloadedRepoMeta.GetLastUpdate() {
@@ -960,8 +1066,24 @@ func (r *LuetSystemRepository) Sync(ctx types.Context, force bool) (*LuetSystemR
 		}
 	}
 
+	// Repositories a few revisions behind apply the deltas to the cached
+	// tree and metadata, falling back to download them again
+	deltaSynced := false
+	if !repoUpdated && localRepo != nil && len(downloadedRepoMeta.deltaRevisions()) > 0 {
+		err := downloadedRepoMeta.syncDeltas(ctx, c, localRepo, repoFile, treefs, metafs)
+		if err == nil {
+			err = fileHelper.CopyFile(file, repoFile)
+		}
+		if err == nil {
+			ctx.Debug("Deltas of the repository " + r.GetName() + " applied correctly.")
+			deltaSynced = true
+		} else {
+			ctx.Debug("Failed applying the deltas of the repository", r.GetName(), "syncing it entirely:", err.Error())
+		}
+	}
+
 	// treeFile and metaFile must be present, they aren't optional
-	if !repoUpdated {
+	if !repoUpdated && !deltaSynced {
 
 		treeFileArtifact, err := downloadedRepoMeta.getRepoFile(c, REPOFILE_TREE_KEY)
 		if err != nil {
@@ -985,6 +1107,9 @@ func (r *LuetSystemRepository) Sync(ctx types.Context, force bool) (*LuetSystemR
 			if err != nil {
 				return nil, errors.Wrap(err, "Error on update "+repositoryReferenceID)
 			}
+			if err := os.WriteFile(referenceFile, []byte(repositoryReferenceID), os.ModePerm); err != nil {
+				return nil, errors.Wrap(err, "Error on update "+referenceFile)
+			}
 			// Remove previous tree
 			os.RemoveAll(treefs)
 			// Remove previous meta dir
@@ -1009,6 +1134,20 @@ func (r *LuetSystemRepository) Sync(ctx types.Context, force bool) (*LuetSystemR
 			return nil, errors.Wrap(err, "Error met while unpacking metadata")
 		}
 
+		// The binary index is optional, the YAML files are loaded without it
+		if _, err := downloadedRepoMeta.GetRepositoryFile(REPOFILE_INDEX_KEY); err == nil {
+			indexFileArtifact, err := downloadedRepoMeta.getRepoFile(c, REPOFILE_INDEX_KEY)
+			if err == nil {
+				defer os.Remove(indexFileArtifact.Path)
+				err = indexFileArtifact.Unpack(ctx, metafs, false)
+			}
+			if err != nil {
+				ctx.Debug("Failed fetching the index of the repository", r.GetName(), err.Error())
+			}
+		}
+	}
+
+	if !repoUpdated {
 		tsec, _ := strconv.ParseInt(downloadedRepoMeta.GetLastUpdate(), 10, 64)
 
 		ctx.Info(
@@ -1016,24 +1155,11 @@ func (r *LuetSystemRepository) Sync(ctx types.Context, force bool) (*LuetSystemR
 				downloadedRepoMeta.GetName(),
 				downloadedRepoMeta.GetRevision(),
 				time.Unix(tsec, 0).String()))
-
-	}
-
-	meta, err := NewLuetSystemRepositoryMetadata(
-		filepath.Join(metafs, REPOSITORY_METAFILE), false,
-	)
-	if err != nil {
-		return nil, errors.Wrap(err, "While processing "+REPOSITORY_METAFILE)
 	}
-	downloadedRepoMeta.SetIndex(meta.ToArtifactIndex())
 
-	reciper := tree.NewInstallerRecipe(pkg.NewInMemoryDatabase(false))
-	err = reciper.Load(treefs)
-	if err != nil {
-		return nil, errors.Wrap(err, "Error met while unpacking rootfs")
+	if err := downloadedRepoMeta.loadSyncedTree(ctx, treefs, metafs, r.Cached); err != nil {
+		return nil, err
 	}
-
-	downloadedRepoMeta.SetTree(reciper)
 	downloadedRepoMeta.SetTreePath(treefs)
 
 	// Copy the local available data to the one which was synced
@@ -1059,6 +1185,8 @@ func (r *LuetSystemRepository) fill(r2 *LuetSystemRepository) {
 	r2.SetName(r.GetName())
 	r2.SetVerify(r.GetVerify())
 	r2.SetReferenceID(r.GetReferenceID())
+	r2.SetSnapshot(r.GetSnapshot())
+	r2.SetCABundle(r.GetCABundle())
 }
 
 func (r *LuetSystemRepository) Serialize() (*LuetSystemRepositoryMetadata, LuetSystemRepository) {
@@ -1074,7 +1202,7 @@ func (r *LuetSystemRepository) Serialize() (*LuetSystemRepositoryMetadata, LuetS
 	for _, a := range r.Index {
 		cp := *a
 		copy := &cp
-		copy.Path = filepath.Base(copy.Path)
+		copy.Path = copy.RepositoryPath()
 		meta.Index = append(meta.Index, copy)
 	}
 
@@ -0,0 +1,222 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/mudler/luet/pkg/api/core/image"
+	"github.com/mudler/luet/pkg/api/core/types"
+	"github.com/mudler/luet/pkg/api/core/types/artifact"
+	"github.com/mudler/luet/pkg/compiler"
+	pkg "github.com/mudler/luet/pkg/database"
+	fileHelper "github.com/mudler/luet/pkg/helpers/file"
+	"github.com/mudler/luet/pkg/tree"
+	"github.com/pkg/errors"
+)
+
+// Kinds of the problems found by CheckRepository
+const (
+	CheckRepositoryFile = "repository_file"
+	CheckArtifact       = "artifact"
+	CheckTree           = "tree"
+	CheckRequires       = "requires"
+)
+
+// CheckOptions configures the checks of CheckRepository
+type CheckOptions struct {
+	// Dependencies are the repositories providing the packages required by
+	// the checked one which aren't in it. They have to be synced already.
+	Dependencies Repositories
+}
+
+// CheckProblem is an inconsistency found in a repository
+type CheckProblem struct {
+	Kind    string `json:"kind"`
+	Package string `json:"package,omitempty"`
+	File    string `json:"file,omitempty"`
+	Message string `json:"message"`
+}
+
+// CheckReport is the result of the checks of a repository
+type CheckReport struct {
+	Repository string         `json:"repository"`
+	Revision   int            `json:"revision"`
+	Packages   int            `json:"packages"`
+	Artifacts  int            `json:"artifacts"`
+	Problems   []CheckProblem `json:"problems"`
+}
+
+// OK returns true if no problems were found
+func (r *CheckReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *CheckReport) add(kind, p, file, msg string) {
+	r.Problems = append(r.Problems, CheckProblem{Kind: kind, Package: p, File: file, Message: msg})
+}
+
+// CheckRepository verifies the consistency of the repository, fetched with
+// its client: the checksums of its files and of the artifacts in its index,
+// that the packages of its tree and its index match, and that the runtime
+// requirements of its packages are available in it or in its dependencies.
+// Artifacts of docker repositories are only checked to be available, as
+// images are verified when pulled.
+// Inconsistencies are reported as problems, while errors are returned only
+// if the repository can't be read at all.
+func CheckRepository(ctx types.Context, r *LuetSystemRepository, o CheckOptions) (*CheckReport, error) {
+	c := r.Client(ctx)
+	if c == nil {
+		return nil, errors.New("no client could be generated from repository")
+	}
+	file, err := c.DownloadFile(r.ReferenceFile())
+	if err != nil {
+		return nil, errors.Wrap(err, "while downloading "+r.ReferenceFile())
+	}
+	defer os.RemoveAll(file)
+	spec, err := r.ReadSpecFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &CheckReport{Repository: r.GetName(), Revision: spec.GetRevision(), Problems: []CheckProblem{}}
+
+	keys := []string{}
+	for k := range spec.RepositoryFiles {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	files := map[string]*artifact.PackageArtifact{}
+	for _, k := range keys {
+		a, err := spec.getRepoFile(c, k)
+		if err != nil {
+			f := spec.RepositoryFiles[k]
+			report.add(CheckRepositoryFile, "", f.GetFileName(), err.Error())
+			continue
+		}
+		defer os.RemoveAll(a.Path)
+		files[k] = a
+	}
+
+	var index compiler.ArtifactIndex
+	if a, ok := files[REPOFILE_META_KEY]; ok {
+		if index, err = metaIndex(ctx, a); err != nil {
+			report.add(CheckRepositoryFile, "", a.GetFileName(), err.Error())
+		}
+	}
+	var db types.PackageDatabase
+	if a, ok := files[REPOFILE_TREE_KEY]; ok {
+		if db, err = loadTreeArchive(ctx, a); err != nil {
+			report.add(CheckRepositoryFile, "", a.GetFileName(), err.Error())
+		}
+	}
+	report.Artifacts = len(index)
+
+	for _, a := range index {
+		if err := r.checkArtifact(c, a); err != nil {
+			report.add(CheckArtifact, a.CompileSpec.GetPackage().HumanReadableString(), a.RepositoryPath(), err.Error())
+		}
+	}
+
+	if db == nil {
+		return report, nil
+	}
+	report.Packages = len(db.World())
+
+	indexed := map[string]bool{}
+	for _, a := range index {
+		p := a.CompileSpec.GetPackage()
+		indexed[p.GetFingerPrint()] = true
+		if _, err := db.GetPackage(p.GetFingerPrint()); err != nil {
+			report.add(CheckTree, p.HumanReadableString(), a.RepositoryPath(), "artifact of a package which isn't in the tree")
+		}
+	}
+
+	dbs := []types.PackageDatabase{db}
+	for _, d := range o.Dependencies {
+		dbs = append(dbs, d.GetTree().GetDatabase())
+	}
+	for _, p := range db.World() {
+		if !indexed[p.GetFingerPrint()] {
+			report.add(CheckTree, p.HumanReadableString(), "", "package without artifacts")
+			continue
+		}
+		for _, req := range p.GetRequires() {
+			if !available(dbs, req) {
+				report.add(CheckRequires, p.HumanReadableString(), "",
+					fmt.Sprintf("requires %s, which isn't available", req.HumanReadableString()))
+			}
+		}
+	}
+	return report, nil
+}
+
+// checkArtifact verifies that the artifact is available in the repository
+// with the expected checksums
+func (r *LuetSystemRepository) checkArtifact(c Client, a *artifact.PackageArtifact) error {
+	switch r.GetType() {
+	case DockerRepositoryType:
+		img := fmt.Sprintf("%s:%s", r.GetUrls()[0], a.ImageTag())
+		if !image.Available(img) {
+			return fmt.Errorf("image '%s' not found", img)
+		}
+		return nil
+	case DiskRepositoryType:
+		local := a.ShallowCopy()
+		local.Path = filepath.Join(r.GetUrls()[0], a.RepositoryPath())
+		if !fileHelper.Exists(local.Path) {
+			return errors.New("file not found")
+		}
+		return local.Verify()
+	}
+
+	downloaded, err := c.DownloadArtifact(a)
+	if err != nil {
+		return err
+	}
+	return downloaded.Verify()
+}
+
+// loadTreeArchive loads the runtime tree in the archive a
+func loadTreeArchive(ctx types.Context, a *artifact.PackageArtifact) (types.PackageDatabase, error) {
+	treefs, err := ctx.TempDir("treefs")
+	if err != nil {
+		return nil, errors.Wrap(err, "Error met while creating tempdir for treefs")
+	}
+	defer os.RemoveAll(treefs)
+
+	if err := a.Unpack(ctx, treefs, false); err != nil {
+		return nil, errors.Wrap(err, "Error met while unpacking tree")
+	}
+	recipe := tree.NewInstallerRecipe(pkg.NewInMemoryDatabase(false))
+	if err := recipe.Load(treefs); err != nil {
+		return nil, errors.Wrap(err, "Error met while loading tree")
+	}
+	return recipe.GetDatabase(), nil
+}
+
+// available returns true if a package matching p is in one of the databases
+func available(dbs []types.PackageDatabase, p *types.Package) bool {
+	for _, db := range dbs {
+		if matches, err := db.FindPackages(p); err == nil && len(matches) > 0 {
+			return true
+		}
+	}
+	return false
+}
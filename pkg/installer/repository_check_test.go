@@ -0,0 +1,125 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/mudler/luet/pkg/api/core/context"
+	"github.com/mudler/luet/pkg/api/core/types"
+	fileHelper "github.com/mudler/luet/pkg/helpers/file"
+	. "github.com/mudler/luet/pkg/installer"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Repository check", func() {
+	var tmpdir, cache string
+	var ctx *context.Context
+
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = os.MkdirTemp("", "repo")
+		Expect(err).ToNot(HaveOccurred())
+		cache, err = os.MkdirTemp("", "cache")
+		Expect(err).ToNot(HaveOccurred())
+		ctx = context.NewContext()
+		ctx.Config.System.PkgsCachePath = cache
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpdir)
+		os.RemoveAll(cache)
+	})
+
+	repository := func() *LuetSystemRepository {
+		return NewSystemRepository(types.LuetRepository{Name: "test", Type: DiskRepositoryType, Urls: []string{tmpdir}})
+	}
+
+	It("reports no problems for a consistent repository", func() {
+		diskRepo(ctx, "../../tests/fixtures/simple_dep", tmpdir, types.GZip)
+
+		report, err := CheckRepository(ctx, repository(), CheckOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.Problems).To(BeEmpty())
+		Expect(report.OK()).To(BeTrue())
+		Expect(report.Revision).To(Equal(1))
+		Expect(report.Packages).To(Equal(3))
+		Expect(report.Artifacts).To(Equal(3))
+	})
+
+	It("reports missing and corrupted artifacts", func() {
+		repo := diskRepo(ctx, "../../tests/fixtures/simple_dep", tmpdir, types.GZip)
+
+		paths := map[string]string{}
+		for _, a := range repo.GetIndex() {
+			paths[a.CompileSpec.GetPackage().GetName()] = a.RepositoryPath()
+		}
+		Expect(os.Remove(filepath.Join(tmpdir, paths["a"]))).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(tmpdir, paths["b"]), []byte("corrupted"), os.ModePerm)).To(Succeed())
+
+		report, err := CheckRepository(ctx, repository(), CheckOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.OK()).To(BeFalse())
+		Expect(report.Problems).To(HaveLen(2))
+		for _, p := range report.Problems {
+			Expect(p.Kind).To(Equal(CheckArtifact))
+			Expect(p.File).To(Or(Equal(paths["a"]), Equal(paths["b"])))
+		}
+	})
+
+	It("reports corrupted repository files", func() {
+		repo := diskRepo(ctx, "../../tests/fixtures/simple_dep", tmpdir, types.GZip)
+		treeFile, err := repo.GetRepositoryFile(REPOFILE_TREE_KEY)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.WriteFile(filepath.Join(tmpdir, treeFile.GetFileName()), []byte("corrupted"), os.ModePerm)).To(Succeed())
+
+		report, err := CheckRepository(ctx, repository(), CheckOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.Problems).To(HaveLen(1))
+		Expect(report.Problems[0].Kind).To(Equal(CheckRepositoryFile))
+		Expect(report.Problems[0].File).To(Equal(treeFile.GetFileName()))
+	})
+
+	It("reports requirements available only in the dependencies", func() {
+		treeDir, err := os.MkdirTemp("", "tree")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(treeDir)
+		for _, p := range []string{"b", "c"} {
+			Expect(fileHelper.CopyDir(filepath.Join("../../tests/fixtures/simple_dep", p), filepath.Join(treeDir, p))).To(Succeed())
+		}
+		diskRepo(ctx, treeDir, tmpdir, types.GZip)
+
+		report, err := CheckRepository(ctx, repository(), CheckOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.Problems).To(Equal([]CheckProblem{{
+			Kind:    CheckRequires,
+			Package: "test/c-1.0",
+			Message: "requires test/a->=0.1, which isn't available",
+		}}))
+
+		depdir, err := os.MkdirTemp("", "dep")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(depdir)
+		dep := diskRepo(ctx, "../../tests/fixtures/simple_dep", depdir, types.GZip)
+
+		report, err = CheckRepository(ctx, repository(), CheckOptions{Dependencies: Repositories{dep}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.OK()).To(BeTrue())
+	})
+})
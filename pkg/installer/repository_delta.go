@@ -0,0 +1,492 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/mudler/luet/pkg/api/core/types"
+	artifact "github.com/mudler/luet/pkg/api/core/types/artifact"
+	"github.com/mudler/luet/pkg/compiler"
+	"github.com/pkg/errors"
+)
+
+// RepositoryRevision identifies a revision of a repository. The last update
+// tells apart the revisions with the same number when the revision is reset.
+type RepositoryRevision struct {
+	Revision   int    `json:"revision"`
+	LastUpdate string `json:"last_update"`
+}
+
+// RepositoryDelta is the change of the runtime tree and of the artifacts
+// index of a repository between two consecutive revisions. Clients a few
+// revisions behind apply the chain of deltas to their cached tree and
+// metadata instead of downloading them again.
+type RepositoryDelta struct {
+	From RepositoryRevision `json:"from"`
+	To   RepositoryRevision `json:"to"`
+
+	// TreeDigest is the digest of the runtime tree at the To revision, it
+	// is checked once the delta is applied
+	TreeDigest string `json:"tree_digest"`
+
+	// Files are the content of the files of the runtime tree added or
+	// changed, by path
+	Files        map[string]string `json:"files,omitempty"`
+	RemovedFiles []string          `json:"removed_files,omitempty"`
+
+	// Artifacts are the entries of the index added or changed
+	Artifacts        []*artifact.PackageArtifact `json:"artifacts,omitempty"`
+	RemovedArtifacts []string                    `json:"removed_artifacts,omitempty"`
+}
+
+func deltaKey(revision int) string {
+	return fmt.Sprintf("%s%d", REPOFILE_DELTA_KEY_PREFIX, revision)
+}
+
+// deltaRevisions returns the sorted revisions of the deltas published by
+// the repository
+func (r *LuetSystemRepository) deltaRevisions() (revisions []int) {
+	for key := range r.RepositoryFiles {
+		rev, ok := strings.CutPrefix(key, REPOFILE_DELTA_KEY_PREFIX)
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(rev); err == nil {
+			revisions = append(revisions, n)
+		}
+	}
+	sort.Ints(revisions)
+	return
+}
+
+// removeDeltas removes the deltas from the repository files, e.g. when
+// the tree or the index are rewritten in a way the deltas don't describe
+func (r *LuetSystemRepository) removeDeltas() {
+	for _, rev := range r.deltaRevisions() {
+		delete(r.RepositoryFiles, deltaKey(rev))
+	}
+}
+
+// deltaBase is the previous revision of a repository being generated
+type deltaBase struct {
+	spec *LuetSystemRepository
+	// treefs and index are the runtime tree and the index of the previous
+	// revision, only set when a delta has to be computed against it
+	treefs string
+	index  compiler.ArtifactIndex
+}
+
+func (b *deltaBase) cleanup() {
+	if b != nil && b.treefs != "" {
+		os.RemoveAll(b.treefs)
+	}
+}
+
+// loadDeltaBase loads the previous revision of the repository generated in
+// dst, before its files are overwritten. It returns nil when there is no
+// previous revision.
+func (r *LuetSystemRepository) loadDeltaBase(ctx types.Context, dst string, resetRevision bool) (*deltaBase, error) {
+	repospec := filepath.Join(dst, REPOSITORY_SPECFILE)
+	if _, err := os.Stat(repospec); os.IsNotExist(err) {
+		return nil, nil
+	}
+	spec, err := r.ReadSpecFile(repospec)
+	if err != nil {
+		return nil, err
+	}
+	base := &deltaBase{spec: spec}
+	if r.deltas <= 0 || resetRevision {
+		return base, nil
+	}
+
+	metaArtifact, err := spec.localRepositoryFile(dst, REPOFILE_META_KEY)
+	if err != nil {
+		return nil, err
+	}
+	base.index, err = metaIndex(ctx, metaArtifact)
+	if err != nil {
+		return nil, err
+	}
+
+	treeArtifact, err := spec.localRepositoryFile(dst, REPOFILE_TREE_KEY)
+	if err != nil {
+		return nil, err
+	}
+	base.treefs, err = ctx.TempDir("treefs")
+	if err != nil {
+		return nil, errors.Wrap(err, "Error met while creating tempdir for treefs")
+	}
+	if err := treeArtifact.Unpack(ctx, base.treefs, false); err != nil {
+		base.cleanup()
+		return nil, errors.Wrap(err, "Error met while unpacking tree")
+	}
+	return base, nil
+}
+
+// addDeltas adds to the repository files the delta from the base revision,
+// along with the previous deltas still in the chain. The files of the
+// deltas dropped from the chain are removed from dst.
+func (r *LuetSystemRepository) addDeltas(ctx types.Context, dst string, base *deltaBase) error {
+	if base == nil {
+		return nil
+	}
+
+	if base.treefs != "" {
+		if err := r.addDelta(ctx, dst, base); err != nil {
+			return err
+		}
+		for _, rev := range base.spec.deltaRevisions() {
+			if rev > r.GetRevision()-r.deltas && rev < r.GetRevision() {
+				f, _ := base.spec.GetRepositoryFile(deltaKey(rev))
+				r.SetRepositoryFile(deltaKey(rev), f)
+			}
+		}
+	}
+
+	for _, rev := range base.spec.deltaRevisions() {
+		if _, err := r.GetRepositoryFile(deltaKey(rev)); err == nil {
+			continue
+		}
+		f, _ := base.spec.GetRepositoryFile(deltaKey(rev))
+		if err := os.Remove(filepath.Join(dst, f.GetFileName())); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "while removing '%s'", f.GetFileName())
+		}
+	}
+	return nil
+}
+
+// addDelta computes the delta from the base revision and adds it to the
+// repository files
+func (r *LuetSystemRepository) addDelta(ctx types.Context, dst string, base *deltaBase) error {
+	treefs, err := ctx.TempDir("treefs")
+	if err != nil {
+		return errors.Wrap(err, "Error met while creating tempdir for treefs")
+	}
+	defer os.RemoveAll(treefs)
+	if err := r.GetTree().Save(treefs); err != nil {
+		return errors.Wrap(err, "Error met while saving the tree")
+	}
+
+	delta := &RepositoryDelta{
+		From: RepositoryRevision{Revision: base.spec.GetRevision(), LastUpdate: base.spec.GetLastUpdate()},
+		To:   RepositoryRevision{Revision: r.GetRevision(), LastUpdate: r.GetLastUpdate()},
+	}
+	if err := delta.diffTree(base.treefs, treefs); err != nil {
+		return errors.Wrap(err, "while comparing the trees")
+	}
+	meta, _ := r.Serialize()
+	if err := delta.diffIndex(base.index, meta.Index); err != nil {
+		return errors.Wrap(err, "while comparing the indexes")
+	}
+	delta.TreeDigest, err = treeDigest(treefs)
+	if err != nil {
+		return err
+	}
+
+	deltaTmpDir, err := ctx.TempDir("delta")
+	if err != nil {
+		return errors.Wrap(err, "Error met while creating tempdir for delta")
+	}
+	defer os.RemoveAll(deltaTmpDir)
+
+	data, err := yaml.Marshal(delta)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(deltaTmpDir, REPOSITORY_DELTAFILE), data, os.ModePerm); err != nil {
+		return err
+	}
+
+	key := deltaKey(r.GetRevision())
+	_, err = r.AddRepositoryFile(deltaTmpDir, key, dst, LuetRepositoryFile{
+		FileName:        key + ".tar",
+		CompressionType: types.GZip,
+	})
+	return err
+}
+
+// treeFiles returns the paths of the files in dir, relative to it
+func treeFiles(dir string) ([]string, error) {
+	files := []string{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// treeDigest returns the digest of the paths and the content of the files
+// in dir
+func treeDigest(dir string) (string, error) {
+	files, err := treeFiles(dir)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, f := range files {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(f)))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", f, len(data))
+		h.Write(data)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+func (d *RepositoryDelta) diffTree(from, to string) error {
+	fromFiles, err := treeFiles(from)
+	if err != nil {
+		return err
+	}
+	toFiles, err := treeFiles(to)
+	if err != nil {
+		return err
+	}
+
+	present := map[string]bool{}
+	d.Files = map[string]string{}
+	for _, f := range toFiles {
+		present[f] = true
+		data, err := os.ReadFile(filepath.Join(to, filepath.FromSlash(f)))
+		if err != nil {
+			return err
+		}
+		old, err := os.ReadFile(filepath.Join(from, filepath.FromSlash(f)))
+		if err == nil && bytes.Equal(old, data) {
+			continue
+		}
+		d.Files[f] = string(data)
+	}
+	for _, f := range fromFiles {
+		if !present[f] {
+			d.RemovedFiles = append(d.RemovedFiles, f)
+		}
+	}
+	return nil
+}
+
+func (d *RepositoryDelta) diffIndex(from, to compiler.ArtifactIndex) error {
+	old := map[string][]byte{}
+	for _, a := range from {
+		data, err := yaml.Marshal(a)
+		if err != nil {
+			return err
+		}
+		old[a.Path] = data
+	}
+
+	present := map[string]bool{}
+	for _, a := range to {
+		present[a.Path] = true
+		data, err := yaml.Marshal(a)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(old[a.Path], data) {
+			continue
+		}
+		d.Artifacts = append(d.Artifacts, a)
+	}
+	for _, a := range from {
+		if !present[a.Path] {
+			d.RemovedArtifacts = append(d.RemovedArtifacts, a.Path)
+		}
+	}
+	return nil
+}
+
+// applyTree applies the changes of the delta to the runtime tree in treefs
+func (d *RepositoryDelta) applyTree(treefs string) error {
+	for _, f := range d.RemovedFiles {
+		path, err := deltaPath(treefs, f)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		// Remove the package folders left empty
+		for dir := filepath.Dir(path); dir != filepath.Clean(treefs); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+
+	for f, data := range d.Files {
+		path, err := deltaPath(treefs, f)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyIndex applies the changes of the delta to the index of meta
+func (d *RepositoryDelta) applyIndex(meta *LuetSystemRepositoryMetadata) {
+	changed := map[string]*artifact.PackageArtifact{}
+	for _, a := range d.Artifacts {
+		changed[a.Path] = a
+	}
+	removed := map[string]bool{}
+	for _, p := range d.RemovedArtifacts {
+		removed[p] = true
+	}
+
+	index := []*artifact.PackageArtifact{}
+	for _, a := range meta.Index {
+		if removed[a.Path] {
+			continue
+		}
+		if c, ok := changed[a.Path]; ok {
+			a = c
+			delete(changed, a.Path)
+		}
+		index = append(index, a)
+	}
+	for _, a := range d.Artifacts {
+		if _, ok := changed[a.Path]; ok {
+			index = append(index, a)
+		}
+	}
+	meta.Index = index
+}
+
+// deltaPath returns the path of the file f of a delta in dir, refusing the
+// paths outside of it
+func deltaPath(dir, f string) (string, error) {
+	path := filepath.Join(dir, filepath.FromSlash(f))
+	if !strings.HasPrefix(path, filepath.Clean(dir)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("invalid path '%s' in delta", f)
+	}
+	return path, nil
+}
+
+// readDelta reads the delta in the archive a
+func readDelta(ctx types.Context, a *artifact.PackageArtifact) (*RepositoryDelta, error) {
+	deltafs, err := ctx.TempDir("delta")
+	if err != nil {
+		return nil, errors.Wrap(err, "Error met while creating tempdir for delta")
+	}
+	defer os.RemoveAll(deltafs)
+
+	if err := a.Unpack(ctx, deltafs, false); err != nil {
+		return nil, errors.Wrap(err, "Error met while unpacking delta")
+	}
+	data, err := os.ReadFile(filepath.Join(deltafs, REPOSITORY_DELTAFILE))
+	if err != nil {
+		return nil, err
+	}
+	delta := &RepositoryDelta{}
+	if err := yaml.Unmarshal(data, delta); err != nil {
+		return nil, errors.Wrap(err, "While processing "+REPOSITORY_DELTAFILE)
+	}
+	return delta, nil
+}
+
+// syncDeltas brings the tree and the metadata cached in treefs and metafs
+// from the local revision to the one of the repository, by applying the
+// chain of deltas between them. localSpec is the spec file of the local
+// revision, it is removed before touching the cache so an interrupted sync
+// is never mistaken for a synced one.
+func (r *LuetSystemRepository) syncDeltas(ctx types.Context, c Client, local *LuetSystemRepository, localSpec, treefs, metafs string) error {
+	from, to := local.GetRevision(), r.GetRevision()
+	if from >= to {
+		return fmt.Errorf("local revision %d is not behind revision %d", from, to)
+	}
+	for rev := from + 1; rev <= to; rev++ {
+		if _, err := r.GetRepositoryFile(deltaKey(rev)); err != nil {
+			return fmt.Errorf("no delta available for revision %d", rev)
+		}
+	}
+
+	metaFile := filepath.Join(metafs, REPOSITORY_METAFILE)
+	meta, err := NewLuetSystemRepositoryMetadata(metaFile, false)
+	if err != nil {
+		return errors.Wrap(err, "While processing "+REPOSITORY_METAFILE)
+	}
+
+	// Download all the deltas first, checking they make a chain from the
+	// local revision
+	deltas := []*RepositoryDelta{}
+	current := RepositoryRevision{Revision: from, LastUpdate: local.GetLastUpdate()}
+	for rev := from + 1; rev <= to; rev++ {
+		a, err := r.getRepoFile(c, deltaKey(rev))
+		if err != nil {
+			return errors.Wrapf(err, "while fetching '%s'", deltaKey(rev))
+		}
+		delta, err := readDelta(ctx, a)
+		os.Remove(a.Path)
+		if err != nil {
+			return err
+		}
+		if delta.From != current {
+			return fmt.Errorf("delta of revision %d doesn't apply to revision %d", rev, from)
+		}
+		current = delta.To
+		deltas = append(deltas, delta)
+	}
+	if current.Revision != to || current.LastUpdate != r.GetLastUpdate() {
+		return fmt.Errorf("deltas don't lead to revision %d", to)
+	}
+
+	if err := os.Remove(localSpec); err != nil {
+		return err
+	}
+	for _, delta := range deltas {
+		if err := delta.applyTree(treefs); err != nil {
+			return errors.Wrapf(err, "while applying delta of revision %d", delta.To.Revision)
+		}
+		delta.applyIndex(meta)
+	}
+
+	digest, err := treeDigest(treefs)
+	if err != nil {
+		return err
+	}
+	if digest != deltas[len(deltas)-1].TreeDigest {
+		return errors.New("tree digest mismatch after applying the deltas")
+	}
+	return meta.WriteFile(metaFile)
+}
@@ -0,0 +1,183 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/ghodss/yaml"
+	"github.com/mudler/luet/pkg/api/core/context"
+	"github.com/mudler/luet/pkg/api/core/types"
+	artifact "github.com/mudler/luet/pkg/api/core/types/artifact"
+	pkg "github.com/mudler/luet/pkg/database"
+	. "github.com/mudler/luet/pkg/installer"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Repository deltas", func() {
+	var tmpdir string
+	ctx := context.NewContext()
+
+	// generate generates a new revision of the repository in tmpdir,
+	// without the artifact of the package removed
+	generate := func(removed string, deltas int) *LuetSystemRepository {
+		if removed != "" {
+			Expect(os.Remove(filepath.Join(tmpdir, removed+".metadata.yaml"))).To(Succeed())
+		}
+		repo, err := GenerateRepository(
+			WithName("test"),
+			WithDescription("description"),
+			WithType("disk"),
+			WithUrls(tmpdir),
+			WithPriority(1),
+			WithSource(tmpdir),
+			FromMetadata(true),
+			WithTree("../../tests/fixtures/prune"),
+			WithContext(ctx),
+			WithDatabase(pkg.NewInMemoryDatabase(false)),
+		)
+		Expect(err).ToNot(HaveOccurred())
+		repo.SetDeltas(deltas)
+		Expect(repo.Write(ctx, tmpdir, false, true)).To(Succeed())
+		return repo
+	}
+
+	repository := func() *LuetSystemRepository {
+		return NewSystemRepository(types.LuetRepository{
+			Name:   "test",
+			Type:   DiskRepositoryType,
+			Urls:   []string{tmpdir},
+			Cached: true,
+		})
+	}
+
+	// sync syncs the cached repository, as it happens once the last sync
+	// is old enough
+	sync := func() *LuetSystemRepository {
+		os.Remove(filepath.Join(ctx.Config.System.GetRepoDatabaseDirPath("test"), "SYNCTIME"))
+		synced, err := repository().Sync(ctx, false)
+		Expect(err).ToNot(HaveOccurred())
+		return synced
+	}
+
+	names := func(r *LuetSystemRepository) (res []string) {
+		for _, p := range r.GetTree().GetDatabase().World() {
+			res = append(res, p.HumanReadableString())
+		}
+		return
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = os.MkdirTemp("", "repo")
+		Expect(err).ToNot(HaveOccurred())
+		ctx.Config.System.PkgsCachePath, err = os.MkdirTemp("", "cache")
+		Expect(err).ToNot(HaveOccurred())
+		ctx.Config.System.DatabasePath, err = os.MkdirTemp("", "db")
+		Expect(err).ToNot(HaveOccurred())
+
+		diskRepo(ctx, "../../tests/fixtures/prune", tmpdir, types.GZip)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpdir)
+		os.RemoveAll(ctx.Config.System.PkgsCachePath)
+		os.RemoveAll(ctx.Config.System.DatabasePath)
+	})
+
+	It("publishes the changes of each revision", func() {
+		repo := generate("a-test-1.0", 1)
+		f, err := repo.GetRepositoryFile("delta-2")
+		Expect(err).ToNot(HaveOccurred())
+
+		a := artifact.NewPackageArtifact(filepath.Join(tmpdir, f.GetFileName()))
+		a.CompressionType = f.GetCompressionType()
+		deltafs, err := os.MkdirTemp("", "delta")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(deltafs)
+		Expect(a.Unpack(ctx, deltafs, false)).To(Succeed())
+		data, err := os.ReadFile(filepath.Join(deltafs, REPOSITORY_DELTAFILE))
+		Expect(err).ToNot(HaveOccurred())
+		delta := &RepositoryDelta{}
+		Expect(yaml.Unmarshal(data, delta)).To(Succeed())
+
+		Expect(delta.From.Revision).To(Equal(1))
+		Expect(delta.To.Revision).To(Equal(2))
+		Expect(delta.Files).To(BeEmpty())
+		Expect(delta.RemovedFiles).To(ConsistOf("test/a/1.0/definition.yaml"))
+		Expect(delta.Artifacts).To(BeEmpty())
+		Expect(delta.RemovedArtifacts).To(ConsistOf("a-test-1.0.package.tar.gz"))
+
+		// Only the given number of deltas is kept
+		repo = generate("a-test-1.1", 1)
+		_, err = repo.GetRepositoryFile("delta-2")
+		Expect(err).To(HaveOccurred())
+		_, err = repo.GetRepositoryFile("delta-3")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(filepath.Join(tmpdir, f.GetFileName())).ToNot(BeAnExistingFile())
+	})
+
+	It("syncs cached repositories applying the deltas", func() {
+		Expect(names(sync())).To(HaveLen(4))
+
+		generate("a-test-1.0", 5)
+		repo := generate("a-test-1.1", 5)
+
+		// The tree isn't downloaded again
+		f, err := repo.GetRepositoryFile(REPOFILE_TREE_KEY)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.Remove(filepath.Join(tmpdir, f.GetFileName()))).To(Succeed())
+
+		synced := sync()
+		Expect(synced.GetRevision()).To(Equal(3))
+		Expect(names(synced)).To(ConsistOf("test/a-1.2", "test/b-1.0"))
+		Expect(synced.GetIndex()).To(HaveLen(2))
+
+		// The cache is left as a full sync does
+		synced, err = repository().Sync(ctx, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(synced.GetRevision()).To(Equal(3))
+		Expect(names(synced)).To(ConsistOf("test/a-1.2", "test/b-1.0"))
+	})
+
+	It("syncs cached repositories entirely when deltas are missing", func() {
+		Expect(names(sync())).To(HaveLen(4))
+
+		generate("a-test-1.0", 0)
+		generate("a-test-1.1", 5)
+
+		synced := sync()
+		Expect(synced.GetRevision()).To(Equal(3))
+		Expect(names(synced)).To(ConsistOf("test/a-1.2", "test/b-1.0"))
+		Expect(synced.GetIndex()).To(HaveLen(2))
+	})
+
+	It("syncs cached repositories entirely when the cache doesn't match", func() {
+		Expect(names(sync())).To(HaveLen(4))
+
+		generate("a-test-1.0", 5)
+		treefs := filepath.Join(ctx.Config.System.GetRepoDatabaseDirPath("test"), "treefs")
+		Expect(os.WriteFile(filepath.Join(treefs, "extra.yaml"), []byte("extra"), os.ModePerm)).To(Succeed())
+
+		synced := sync()
+		Expect(synced.GetRevision()).To(Equal(2))
+		Expect(names(synced)).To(HaveLen(3))
+		Expect(filepath.Join(treefs, "extra.yaml")).ToNot(BeAnExistingFile())
+	})
+})
@@ -0,0 +1,240 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/mudler/luet/pkg/api/core/types"
+	"github.com/mudler/luet/pkg/api/core/types/artifact"
+	"github.com/mudler/luet/pkg/compiler"
+	version "github.com/mudler/luet/pkg/versioner"
+	"github.com/pkg/errors"
+)
+
+// RepositoryState is a state of a repository, either its latest revision
+// or one of its snapshots
+type RepositoryState struct {
+	Reference  string `json:"reference"`
+	Revision   int    `json:"revision"`
+	LastUpdate string `json:"last_update"`
+}
+
+// PackageChange is a change of a package between two repository indexes.
+// Versions are empty when the package is missing in one of them.
+type PackageChange struct {
+	Package  string `json:"package"`
+	Platform string `json:"platform,omitempty"`
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+}
+
+// RepositoryDiff lists the changes of the packages between two states of a
+// repository. Rebuilt packages have the same version with different
+// artifacts.
+type RepositoryDiff struct {
+	From     RepositoryState `json:"from"`
+	To       RepositoryState `json:"to"`
+	Added    []PackageChange `json:"added"`
+	Removed  []PackageChange `json:"removed"`
+	Upgraded []PackageChange `json:"upgraded"`
+	Rebuilt  []PackageChange `json:"rebuilt"`
+}
+
+// Empty returns true if there are no changes
+func (d *RepositoryDiff) Empty() bool {
+	return len(d.Added)+len(d.Removed)+len(d.Upgraded)+len(d.Rebuilt) == 0
+}
+
+// Diff compares two states of the repository. Each state is either
+// "latest" or empty, the ID of one of its snapshots, or one of its
+// revisions. from defaults to the revision before the one of to.
+// Revisions are resolved by listing the snapshots, so they can be compared
+// only if a snapshot was created along with them, and http repositories
+// accept only snapshot IDs.
+func (r *LuetSystemRepository) Diff(ctx types.Context, from, to string) (*RepositoryDiff, error) {
+	c := r.Client(ctx)
+	if c == nil {
+		return nil, errors.New("no client could be generated from repository")
+	}
+
+	toSpec, err := r.stateSpecFile(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+	toRepo, err := r.fetchIndex(ctx, c, toSpec)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while fetching '%s'", toSpec)
+	}
+
+	if from == "" {
+		if toRepo.GetRevision() <= 1 {
+			return nil, fmt.Errorf("no revision before %d", toRepo.GetRevision())
+		}
+		from = strconv.Itoa(toRepo.GetRevision() - 1)
+	}
+	fromSpec, err := r.stateSpecFile(ctx, from)
+	if err != nil {
+		if _, atoiErr := strconv.Atoi(from); atoiErr == nil {
+			return nil, errors.Wrap(err, "revisions can be compared only if the repository was generated with --snapshot-id")
+		}
+		return nil, err
+	}
+	fromRepo, err := r.fetchIndex(ctx, c, fromSpec)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while fetching '%s'", fromSpec)
+	}
+
+	d := DiffIndexes(fromRepo.GetIndex(), toRepo.GetIndex())
+	d.From = RepositoryState{Reference: fromSpec, Revision: fromRepo.GetRevision(), LastUpdate: fromRepo.GetLastUpdate()}
+	d.To = RepositoryState{Reference: toSpec, Revision: toRepo.GetRevision(), LastUpdate: toRepo.GetLastUpdate()}
+	return d, nil
+}
+
+// stateSpecFile returns the repository spec file of the state ref of the
+// repository, as accepted by Diff
+func (r *LuetSystemRepository) stateSpecFile(ctx types.Context, ref string) (string, error) {
+	if ref == "" || ref == "latest" {
+		return REPOSITORY_SPECFILE, nil
+	}
+	if r.GetType() == HttpRepositoryType {
+		return snapshotSpecFile(ref), nil
+	}
+
+	snapshots, err := r.Snapshots(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range snapshots {
+		if s.ID == ref {
+			return snapshotSpecFile(s.ID), nil
+		}
+	}
+	if rev, err := strconv.Atoi(ref); err == nil {
+		for i := len(snapshots) - 1; i >= 0; i-- {
+			if snapshots[i].Revision == rev {
+				return snapshotSpecFile(snapshots[i].ID), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no snapshot or revision '%s' found in repository '%s'", ref, r.GetName())
+}
+
+// DiffIndexes compares two indexes of artifacts. For each package, the
+// newest version added is an upgrade from the newest version which was
+// available, if older.
+func DiffIndexes(from, to compiler.ArtifactIndex) *RepositoryDiff {
+	d := &RepositoryDiff{
+		Added:    []PackageChange{},
+		Removed:  []PackageChange{},
+		Upgraded: []PackageChange{},
+		Rebuilt:  []PackageChange{},
+	}
+	fromArtifacts, toArtifacts := indexByPackage(from), indexByPackage(to)
+
+	v := version.DefaultVersioner()
+	keys := map[packageKey]bool{}
+	for k := range fromArtifacts {
+		keys[k] = true
+	}
+	for k := range toArtifacts {
+		keys[k] = true
+	}
+
+	for k := range keys {
+		change := func(from, to string) PackageChange {
+			return PackageChange{Package: k.name, Platform: k.platform, From: from, To: to}
+		}
+		oldVersions, newVersions := fromArtifacts[k], toArtifacts[k]
+
+		added := []string{}
+		for ver, a := range newVersions {
+			old, ok := oldVersions[ver]
+			switch {
+			case !ok:
+				added = append(added, ver)
+			case old.Checksums.Compare(a.Checksums) != nil:
+				d.Rebuilt = append(d.Rebuilt, change(ver, ver))
+			}
+		}
+		// The version upgraded from is reported only as such
+		upgradedFrom := ""
+		if len(added) > 0 {
+			added = v.Sort(added)
+			newest := added[len(added)-1]
+			if len(oldVersions) > 0 {
+				old := []string{}
+				for ver := range oldVersions {
+					old = append(old, ver)
+				}
+				previous := v.Sort(old)[len(old)-1]
+				if sorted := v.Sort([]string{previous, newest}); sorted[1] == newest && previous != newest {
+					d.Upgraded = append(d.Upgraded, change(previous, newest))
+					upgradedFrom = previous
+					added = added[:len(added)-1]
+				}
+			}
+			for _, ver := range added {
+				d.Added = append(d.Added, change("", ver))
+			}
+		}
+
+		for ver := range oldVersions {
+			if _, ok := newVersions[ver]; !ok && ver != upgradedFrom {
+				d.Removed = append(d.Removed, change(ver, ""))
+			}
+		}
+	}
+
+	for _, changes := range [][]PackageChange{d.Added, d.Removed, d.Upgraded, d.Rebuilt} {
+		sortChanges(changes)
+	}
+	return d
+}
+
+type packageKey struct {
+	name, platform string
+}
+
+// indexByPackage groups the artifacts of the index by package and platform,
+// then by version
+func indexByPackage(index compiler.ArtifactIndex) map[packageKey]map[string]*artifact.PackageArtifact {
+	res := map[packageKey]map[string]*artifact.PackageArtifact{}
+	for _, a := range index {
+		p := a.CompileSpec.GetPackage()
+		k := packageKey{name: path.Join(p.GetCategory(), p.GetName()), platform: a.Platform.String()}
+		if _, ok := res[k]; !ok {
+			res[k] = map[string]*artifact.PackageArtifact{}
+		}
+		res[k][p.GetVersion()] = a
+	}
+	return res
+}
+
+func sortChanges(changes []PackageChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Package != changes[j].Package {
+			return changes[i].Package < changes[j].Package
+		}
+		if changes[i].Platform != changes[j].Platform {
+			return changes[i].Platform < changes[j].Platform
+		}
+		return changes[i].From+changes[i].To < changes[j].From+changes[j].To
+	})
+}
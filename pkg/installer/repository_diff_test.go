@@ -0,0 +1,139 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/mudler/luet/pkg/api/core/context"
+	"github.com/mudler/luet/pkg/api/core/types"
+	artifact "github.com/mudler/luet/pkg/api/core/types/artifact"
+	"github.com/mudler/luet/pkg/compiler"
+	. "github.com/mudler/luet/pkg/installer"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Repository diff", func() {
+	art := func(name, version, sum string, platform types.Platform) *artifact.PackageArtifact {
+		a := artifact.NewPackageArtifact(name + "-" + version + ".package.tar")
+		a.CompileSpec = &types.LuetCompilationSpec{Package: &types.Package{Category: "test", Name: name, Version: version}}
+		a.Checksums = artifact.Checksums{"sha256": sum}
+		a.Platform = platform
+		return a
+	}
+	arm := types.Platform{OS: "linux", Arch: "arm64"}
+
+	Context("between indexes", func() {
+		It("reports the package changes", func() {
+			d := DiffIndexes(
+				compiler.ArtifactIndex{
+					art("a", "1.0", "a", types.Platform{}),
+					art("b", "1.0", "b", types.Platform{}),
+					art("c", "1.0", "c", types.Platform{}),
+					art("d", "1.0", "d", types.Platform{}),
+					art("d", "1.0", "d", arm),
+					art("f", "1.0", "f", types.Platform{}),
+				},
+				compiler.ArtifactIndex{
+					art("a", "1.1", "a2", types.Platform{}),
+					art("b", "1.0", "b2", types.Platform{}),
+					art("c", "1.0", "c", types.Platform{}),
+					art("d", "1.0", "d", types.Platform{}),
+					art("d", "1.1", "d2", arm),
+					art("e", "1.0", "e", types.Platform{}),
+				},
+			)
+			Expect(d.Empty()).To(BeFalse())
+			Expect(d.Added).To(Equal([]PackageChange{{Package: "test/e", To: "1.0"}}))
+			// Versions upgraded from aren't reported as removed too
+			Expect(d.Removed).To(Equal([]PackageChange{{Package: "test/f", From: "1.0"}}))
+			Expect(d.Upgraded).To(Equal([]PackageChange{
+				{Package: "test/a", From: "1.0", To: "1.1"},
+				{Package: "test/d", Platform: "linux/arm64", From: "1.0", To: "1.1"},
+			}))
+			Expect(d.Rebuilt).To(Equal([]PackageChange{{Package: "test/b", From: "1.0", To: "1.0"}}))
+		})
+
+		It("doesn't consider older versions as upgrades", func() {
+			d := DiffIndexes(
+				compiler.ArtifactIndex{art("a", "1.2", "a", types.Platform{})},
+				compiler.ArtifactIndex{art("a", "1.2", "a", types.Platform{}), art("a", "1.1", "b", types.Platform{})},
+			)
+			Expect(d.Added).To(Equal([]PackageChange{{Package: "test/a", To: "1.1"}}))
+			Expect(d.Upgraded).To(BeEmpty())
+		})
+
+		It("is empty without changes", func() {
+			index := compiler.ArtifactIndex{art("a", "1.0", "a", types.Platform{})}
+			Expect(DiffIndexes(index, index).Empty()).To(BeTrue())
+		})
+	})
+
+	Context("between repository states", func() {
+		var tmpdir string
+		ctx := context.NewContext()
+		var repo *LuetSystemRepository
+
+		BeforeEach(func() {
+			var err error
+			tmpdir, err = os.MkdirTemp("", "repo")
+			Expect(err).ToNot(HaveOccurred())
+			ctx.Config.System.PkgsCachePath, err = os.MkdirTemp("", "cache")
+			Expect(err).ToNot(HaveOccurred())
+
+			diskRepo(ctx, "../../tests/fixtures/prune", tmpdir, types.GZip)
+			Expect(os.Remove(filepath.Join(tmpdir, "a-test-1.0.metadata.yaml"))).To(Succeed())
+			generateDiskRepo(ctx, "../../tests/fixtures/prune", tmpdir, "release")
+
+			repo = NewSystemRepository(types.LuetRepository{Name: "test", Type: DiskRepositoryType, Urls: []string{tmpdir}})
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(tmpdir)
+			os.RemoveAll(ctx.Config.System.PkgsCachePath)
+		})
+
+		It("compares the latest revision with the previous one", func() {
+			d, err := repo.Diff(ctx, "", "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(d.From.Revision).To(Equal(1))
+			Expect(d.To.Revision).To(Equal(2))
+			Expect(d.To.Reference).To(Equal(REPOSITORY_SPECFILE))
+			Expect(d.Removed).To(Equal([]PackageChange{{Package: "test/a", From: "1.0"}}))
+			Expect(d.Added).To(BeEmpty())
+		})
+
+		It("compares snapshots and revisions", func() {
+			d, err := repo.Diff(ctx, "release", "1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(d.From.Reference).To(Equal("release-" + REPOSITORY_SPECFILE))
+			Expect(d.Added).To(Equal([]PackageChange{{Package: "test/a", To: "1.0"}}))
+			Expect(d.Removed).To(BeEmpty())
+		})
+
+		It("fails on missing states", func() {
+			_, err := repo.Diff(ctx, "missing", "")
+			Expect(err).To(HaveOccurred())
+
+			// Revisions need their snapshot
+			_, err = repo.Diff(ctx, "7", "")
+			Expect(err).To(MatchError(ContainSubstring("--snapshot-id")))
+		})
+	})
+})
@@ -18,13 +18,16 @@ package installer
 import (
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/mudler/luet/pkg/api/core/bus"
 	"github.com/mudler/luet/pkg/api/core/image"
+	"github.com/mudler/luet/pkg/api/core/sbom"
 	"github.com/mudler/luet/pkg/api/core/types"
 	artifact "github.com/mudler/luet/pkg/api/core/types/artifact"
 	compiler "github.com/mudler/luet/pkg/compiler"
@@ -44,6 +47,7 @@ type dockerRepositoryGenerator struct {
 func (l *dockerRepositoryGenerator) Initialize(path string, db types.PackageDatabase) ([]*artifact.PackageArtifact, error) {
 	l.context.Info("Generating docker images for packages in", l.imagePrefix)
 	var art []*artifact.PackageArtifact
+	platformImages := map[string][]image.PlatformImage{}
 	var ff = func(currentpath string, info os.FileInfo, err error) error {
 		if err != nil {
 			l.context.Debug("Skipping", info.Name(), err.Error())
@@ -58,10 +62,6 @@ func (l *dockerRepositoryGenerator) Initialize(path string, db types.PackageData
 			return nil
 		}
 
-		if err := l.pushImageFromArtifact(artifact.NewPackageArtifact(currentpath), l.b, true); err != nil {
-			return errors.Wrap(err, "while pushing metadata file associated to the artifact")
-		}
-
 		dat, err := os.ReadFile(currentpath)
 		if err != nil {
 			return errors.Wrap(err, "Error reading file "+currentpath)
@@ -71,6 +71,12 @@ func (l *dockerRepositoryGenerator) Initialize(path string, db types.PackageData
 		if err != nil {
 			return errors.Wrap(err, "Error reading yaml "+currentpath)
 		}
+
+		metadata := artifact.NewPackageArtifact(currentpath)
+		metadata.Platform = a.Platform
+		if err := l.pushImageFromArtifact(metadata, l.b, true); err != nil {
+			return errors.Wrap(err, "while pushing metadata file associated to the artifact")
+		}
 		// Set the path relative to the file.
 		// The metadata contains the full path where the file was located during buildtime.
 		a.Path = filepath.Join(filepath.Dir(currentpath), filepath.Base(a.Path))
@@ -83,7 +89,7 @@ func (l *dockerRepositoryGenerator) Initialize(path string, db types.PackageData
 			return nil
 		}
 
-		packageImage := fmt.Sprintf("%s:%s", l.imagePrefix, a.CompileSpec.GetPackage().ImageID())
+		packageImage := fmt.Sprintf("%s:%s", l.imagePrefix, a.ImageTag())
 
 		if l.imagePush && l.b.ImageAvailable(packageImage) && !l.force {
 			l.context.Info("Image", packageImage, "already present, skipping. use --force-push to override")
@@ -98,6 +104,14 @@ func (l *dockerRepositoryGenerator) Initialize(path string, db types.PackageData
 			if err := pushImage(l.context, l.b, packageImage, l.force); err != nil {
 				return errors.Wrapf(err, "Failed while pushing image: '%s'", packageImage)
 			}
+			if err := l.pushSBOM(a, filepath.Dir(currentpath), packageImage); err != nil {
+				return err
+			}
+		}
+
+		if !a.Platform.IsZero() {
+			id := a.CompileSpec.GetPackage().ImageID()
+			platformImages[id] = append(platformImages[id], image.PlatformImage{Platform: a.Platform, Image: packageImage})
 		}
 
 		art = append(art, a)
@@ -110,9 +124,53 @@ func (l *dockerRepositoryGenerator) Initialize(path string, db types.PackageData
 		return nil, err
 
 	}
+
+	if err := l.pushManifestLists(platformImages); err != nil {
+		return nil, err
+	}
 	return art, nil
 }
 
+// pushSBOM attaches the SBOM of the artifact, if any, to its package image
+func (l *dockerRepositoryGenerator) pushSBOM(a *artifact.PackageArtifact, dir, packageImage string) error {
+	if a.SBOM == "" {
+		return nil
+	}
+	dat, err := os.ReadFile(filepath.Join(dir, a.SBOM))
+	if err != nil {
+		return errors.Wrapf(err, "Failed reading SBOM of '%s'", a.CompileSpec.GetPackage().HumanReadableString())
+	}
+	l.context.Info("Attaching SBOM to", packageImage)
+	if err := image.PushReferrer(packageImage, dat, sbom.MediaType); err != nil {
+		return errors.Wrapf(err, "Failed while pushing SBOM of image: '%s'", packageImage)
+	}
+	return nil
+}
+
+// pushManifestLists groups the images of the artifacts built for several
+// platforms in a manifest list tagged with the package image ID, so clients
+// pull the one matching their platform.
+func (l *dockerRepositoryGenerator) pushManifestLists(platformImages map[string][]image.PlatformImage) error {
+	ids := []string{}
+	for id := range platformImages {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		target := fmt.Sprintf("%s:%s", l.imagePrefix, id)
+		if !l.imagePush {
+			l.context.Warning("Not pushing images, skipping manifest list", target)
+			continue
+		}
+		l.context.Info("Pushing manifest list", target)
+		if err := image.PushIndex(target, platformImages[id]); err != nil {
+			return errors.Wrapf(err, "Failed while pushing manifest list: '%s'", target)
+		}
+	}
+	return nil
+}
+
 func pushImage(ctx types.Context, b compiler.CompilerBackend, image string, force bool) error {
 	if b.ImageAvailable(image) && !force {
 		ctx.Debug("Image", image, "already present, skipping")
@@ -163,7 +221,11 @@ func (d *dockerRepositoryGenerator) pushImageFromArtifact(a *artifact.PackageArt
 	if err != nil {
 		return errors.Wrap(err, "failed generating checksums for tree")
 	}
-	imageTree := fmt.Sprintf("%s:%s", d.imagePrefix, helpers.SanitizeImageString(a.GetFileName()))
+	name := a.GetFileName()
+	if !a.Platform.IsZero() {
+		name = path.Join(a.Platform.DirName(), name)
+	}
+	imageTree := fmt.Sprintf("%s:%s", d.imagePrefix, helpers.FileImageTag(name))
 	if checkIfExists && d.imagePush && d.b.ImageAvailable(imageTree) && !d.force {
 		d.context.Info("Image", imageTree, "already present, skipping. use --force-push to override")
 		return nil
@@ -0,0 +1,308 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/mudler/luet/pkg/api/core/types"
+	artifact "github.com/mudler/luet/pkg/api/core/types/artifact"
+	"github.com/mudler/luet/pkg/compiler"
+	pkg "github.com/mudler/luet/pkg/database"
+	"github.com/mudler/luet/pkg/tree"
+	"github.com/pkg/errors"
+)
+
+// RepositoryIndexVersion is the version of the format of the binary
+// repository index. Indexes of other versions are ignored, and the YAML
+// metadata is loaded instead.
+const RepositoryIndexVersion = 1
+
+var repositoryIndexMagic = []byte("LUETIDX\x00")
+
+// RepositoryIndex is the runtime tree and the artifacts index of a
+// repository revision, in a compact binary form which is way faster to load
+// than the YAML files of the tree and of the metadata.
+// The YAML files stay the source of truth: the index is published along
+// with them, and clients not supporting it just ignore it.
+//
+// The index is made of the magic string, the format version and of
+// length-prefixed records: a header followed by the packages and then by the
+// artifacts, encoded as JSON to keep the semantic of the YAML files.
+type RepositoryIndex struct {
+	Revision  RepositoryRevision
+	Packages  types.Packages
+	Artifacts compiler.ArtifactIndex
+}
+
+type repositoryIndexHeader struct {
+	Revision  RepositoryRevision `json:"revision"`
+	Packages  int                `json:"packages"`
+	Artifacts int                `json:"artifacts"`
+}
+
+func NewDefaultIndexRepositoryFile() LuetRepositoryFile {
+	return LuetRepositoryFile{
+		FileName:        REPOSITORY_INDEXFILE + ".tar",
+		CompressionType: types.GZip,
+	}
+}
+
+// WriteFile writes the index to path. The file is replaced atomically, as
+// it might be read concurrently.
+func (i *RepositoryIndex) WriteFile(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	w := bufio.NewWriter(f)
+	if err := i.write(w); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (i *RepositoryIndex) write(w io.Writer) error {
+	if _, err := w.Write(repositoryIndexMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, uint16(RepositoryIndexVersion)); err != nil {
+		return err
+	}
+
+	records := []interface{}{repositoryIndexHeader{
+		Revision:  i.Revision,
+		Packages:  len(i.Packages),
+		Artifacts: len(i.Artifacts),
+	}}
+	for _, p := range i.Packages {
+		records = append(records, p)
+	}
+	for _, a := range i.Artifacts {
+		records = append(records, a)
+	}
+
+	buf := make([]byte, binary.MaxVarintLen64)
+	for _, r := range records {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		n := binary.PutUvarint(buf, uint64(len(data)))
+		if _, err := w.Write(buf[:n]); err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadRepositoryIndex reads the index in file. Indexes which are truncated
+// or whose records don't fit in the file are rejected, so they are never
+// used in place of the YAML files.
+func ReadRepositoryIndex(file string) (*RepositoryIndex, error) {
+	dat, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	r := bytes.NewReader(dat)
+
+	magic := make([]byte, len(repositoryIndexMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, repositoryIndexMagic) {
+		return nil, fmt.Errorf("'%s' is not a repository index", file)
+	}
+	var version uint16
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return nil, errors.Wrapf(err, "while reading '%s'", file)
+	}
+	if version != RepositoryIndexVersion {
+		return nil, fmt.Errorf("unsupported repository index version %d", version)
+	}
+
+	record := func(v interface{}) error {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		if n > uint64(r.Len()) {
+			return fmt.Errorf("record of %d bytes exceeds the %d bytes left", n, r.Len())
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		return json.Unmarshal(data, v)
+	}
+
+	header := repositoryIndexHeader{}
+	if err := record(&header); err != nil {
+		return nil, errors.Wrapf(err, "while reading '%s'", file)
+	}
+	// Every record takes at least the byte of its length
+	if header.Packages < 0 || header.Artifacts < 0 || header.Packages+header.Artifacts > r.Len() {
+		return nil, fmt.Errorf("'%s' is corrupted: %d packages and %d artifacts don't fit in %d bytes",
+			file, header.Packages, header.Artifacts, r.Len())
+	}
+	index := &RepositoryIndex{
+		Revision:  header.Revision,
+		Packages:  make(types.Packages, header.Packages),
+		Artifacts: make(compiler.ArtifactIndex, header.Artifacts),
+	}
+	for n := range index.Packages {
+		index.Packages[n] = &types.Package{}
+		if err := record(index.Packages[n]); err != nil {
+			return nil, errors.Wrapf(err, "while reading '%s'", file)
+		}
+	}
+	for n := range index.Artifacts {
+		index.Artifacts[n] = &artifact.PackageArtifact{}
+		if err := record(index.Artifacts[n]); err != nil {
+			return nil, errors.Wrapf(err, "while reading '%s'", file)
+		}
+		if index.Artifacts[n].CompileSpec == nil || index.Artifacts[n].CompileSpec.Package == nil {
+			return nil, fmt.Errorf("'%s' is corrupted: artifact %d has no package", file, n)
+		}
+	}
+	return index, nil
+}
+
+// Tree returns the runtime tree of the index, as loaded from its YAML files
+// unpacked in treefs
+func (i *RepositoryIndex) Tree(treefs string) (tree.Builder, error) {
+	db := pkg.NewInMemoryDatabase(false)
+	for _, p := range i.Packages {
+		// Path is set only internally when tree is loaded from disk
+		p.SetPath(filepath.Join(treefs, p.GetCategory(), p.GetName(), p.GetVersion()))
+		if _, err := db.CreatePackage(p); err != nil {
+			return nil, errors.Wrap(err, "Error creating package "+p.GetName())
+		}
+	}
+	return tree.NewInstallerRecipe(db), nil
+}
+
+// addIndex adds to the repository files the binary index of the runtime
+// tree t and of the repository artifacts
+func (r *LuetSystemRepository) addIndex(ctx types.Context, t tree.Builder, dst string) (*artifact.PackageArtifact, error) {
+	// The packages are the ones clients load from the tree files
+	treefs, err := ctx.TempDir("treefs")
+	if err != nil {
+		return nil, errors.Wrap(err, "Error met while creating tempdir for treefs")
+	}
+	defer os.RemoveAll(treefs)
+	if err := t.Save(treefs); err != nil {
+		return nil, errors.Wrap(err, "Error met while saving the tree")
+	}
+	recipe := tree.NewInstallerRecipe(pkg.NewInMemoryDatabase(false))
+	if err := recipe.Load(treefs); err != nil {
+		return nil, errors.Wrap(err, "Error met while loading tree")
+	}
+	packages := recipe.GetDatabase().World()
+	sort.Slice(packages, func(i, j int) bool {
+		return packages[i].GetFingerPrint() < packages[j].GetFingerPrint()
+	})
+
+	meta, _ := r.Serialize()
+	index := &RepositoryIndex{
+		Revision:  RepositoryRevision{Revision: r.GetRevision(), LastUpdate: r.GetLastUpdate()},
+		Packages:  packages,
+		Artifacts: meta.ToArtifactIndex(),
+	}
+
+	indexTmpDir, err := ctx.TempDir("index")
+	if err != nil {
+		return nil, errors.Wrap(err, "Error met while creating tempdir for index")
+	}
+	defer os.RemoveAll(indexTmpDir)
+	if err := index.WriteFile(filepath.Join(indexTmpDir, REPOSITORY_INDEXFILE)); err != nil {
+		return nil, errors.Wrap(err, "failed writing "+REPOSITORY_INDEXFILE)
+	}
+
+	return r.AddRepositoryFile(indexTmpDir, REPOFILE_INDEX_KEY, dst, NewDefaultIndexRepositoryFile())
+}
+
+// loadSyncedTree sets the tree and the index of the repository synced in
+// treefs and metafs. They are loaded from the binary index if it's there and
+// matches the repository revision, otherwise from the YAML files. In such
+// case, the binary index is written in metafs for the next loads if cache
+// is set.
+func (r *LuetSystemRepository) loadSyncedTree(ctx types.Context, treefs, metafs string, cache bool) error {
+	revision := RepositoryRevision{Revision: r.GetRevision(), LastUpdate: r.GetLastUpdate()}
+	platform := ctx.GetConfig().System.GetPlatform()
+	indexFile := filepath.Join(metafs, REPOSITORY_INDEXFILE)
+
+	index, err := ReadRepositoryIndex(indexFile)
+	switch {
+	case err == nil && index.Revision == revision:
+		t, err := index.Tree(treefs)
+		if err == nil {
+			r.SetIndex(index.Artifacts.ForPlatform(platform))
+			r.SetTree(t)
+			return nil
+		}
+		ctx.Debug("Failed loading the index of the repository", r.GetName(), err.Error())
+	case err == nil:
+		ctx.Debug("The index of the repository", r.GetName(), "is outdated")
+	case !os.IsNotExist(err):
+		ctx.Debug("Failed reading the index of the repository", r.GetName(), err.Error())
+	}
+
+	meta, err := NewLuetSystemRepositoryMetadata(filepath.Join(metafs, REPOSITORY_METAFILE), false)
+	if err != nil {
+		return errors.Wrap(err, "While processing "+REPOSITORY_METAFILE)
+	}
+	r.SetIndex(meta.ToArtifactIndex().ForPlatform(platform))
+
+	reciper := tree.NewInstallerRecipe(pkg.NewInMemoryDatabase(false))
+	if err := reciper.Load(treefs); err != nil {
+		return errors.Wrap(err, "Error met while unpacking rootfs")
+	}
+	r.SetTree(reciper)
+
+	if cache {
+		index := &RepositoryIndex{
+			Revision:  revision,
+			Packages:  reciper.GetDatabase().World(),
+			Artifacts: meta.ToArtifactIndex(),
+		}
+		if err := index.WriteFile(indexFile); err != nil {
+			ctx.Debug("Failed caching the index of the repository", r.GetName(), err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mudler/luet/pkg/api/core/types"
+	artifact "github.com/mudler/luet/pkg/api/core/types/artifact"
+	pkg "github.com/mudler/luet/pkg/database"
+	. "github.com/mudler/luet/pkg/installer"
+	"github.com/mudler/luet/pkg/tree"
+)
+
+// syncedRepository writes in a temporary folder the files of a synced
+// repository of n packages: the YAML tree in treefs, the YAML metadata and
+// the binary index in metafs.
+func syncedRepository(b *testing.B, n int) (treefs, metafs string) {
+	dir := b.TempDir()
+	treefs, metafs = filepath.Join(dir, "treefs"), filepath.Join(dir, "metafs")
+	if err := os.MkdirAll(metafs, os.ModePerm); err != nil {
+		b.Fatal(err)
+	}
+
+	db := pkg.NewInMemoryDatabase(false)
+	meta := &LuetSystemRepositoryMetadata{}
+	for i := 0; i < n; i++ {
+		p := &types.Package{
+			Category: "bench",
+			Name:     fmt.Sprintf("pkg-%d", i),
+			Version:  "1.0",
+			Labels:   map[string]string{"description": "benchmark package"},
+		}
+		if i > 0 {
+			p.PackageRequires = []*types.Package{{Category: "bench", Name: fmt.Sprintf("pkg-%d", i-1), Version: ">=1.0"}}
+		}
+		if _, err := db.CreatePackage(p); err != nil {
+			b.Fatal(err)
+		}
+		a := artifact.NewPackageArtifact(p.GetFingerPrint() + ".package.tar.gz")
+		a.CompressionType = types.GZip
+		a.CompileSpec = &types.LuetCompilationSpec{Package: p}
+		a.Files = []string{"usr/bin/" + p.GetName(), "usr/share/doc/" + p.GetName() + "/README"}
+		meta.Index = append(meta.Index, a)
+	}
+
+	t := tree.NewInstallerRecipe(db)
+	if err := t.Save(treefs); err != nil {
+		b.Fatal(err)
+	}
+	if err := meta.WriteFile(filepath.Join(metafs, REPOSITORY_METAFILE)); err != nil {
+		b.Fatal(err)
+	}
+	index := &RepositoryIndex{Packages: db.World(), Artifacts: meta.ToArtifactIndex()}
+	if err := index.WriteFile(filepath.Join(metafs, REPOSITORY_INDEXFILE)); err != nil {
+		b.Fatal(err)
+	}
+	return
+}
+
+// benchmarkLoadIndex measures loading a synced repository of n packages from
+// its binary index
+func benchmarkLoadIndex(b *testing.B, n int) {
+	treefs, metafs := syncedRepository(b, n)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index, err := ReadRepositoryIndex(filepath.Join(metafs, REPOSITORY_INDEXFILE))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := index.Tree(treefs); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkLoadYAML measures loading a synced repository of n packages from
+// its YAML files, as clients not using the binary index do
+func benchmarkLoadYAML(b *testing.B, n int) {
+	treefs, metafs := syncedRepository(b, n)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewLuetSystemRepositoryMetadata(filepath.Join(metafs, REPOSITORY_METAFILE), false); err != nil {
+			b.Fatal(err)
+		}
+		if err := tree.NewInstallerRecipe(pkg.NewInMemoryDatabase(false)).Load(treefs); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoadIndex100(b *testing.B)  { benchmarkLoadIndex(b, 100) }
+func BenchmarkLoadIndex1000(b *testing.B) { benchmarkLoadIndex(b, 1000) }
+
+func BenchmarkLoadYAML100(b *testing.B)  { benchmarkLoadYAML(b, 100) }
+func BenchmarkLoadYAML1000(b *testing.B) { benchmarkLoadYAML(b, 1000) }
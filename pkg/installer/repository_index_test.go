@@ -0,0 +1,185 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer_test
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+
+	"github.com/mudler/luet/pkg/api/core/context"
+	"github.com/mudler/luet/pkg/api/core/types"
+	artifact "github.com/mudler/luet/pkg/api/core/types/artifact"
+	. "github.com/mudler/luet/pkg/installer"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Repository binary index", func() {
+	var tmpdir string
+	ctx := context.NewContext()
+
+	// index reads the binary index published in tmpdir
+	index := func() *RepositoryIndex {
+		repo, err := LoadLocalRepository(ctx, tmpdir)
+		Expect(err).ToNot(HaveOccurred())
+		f, err := repo.GetRepositoryFile(REPOFILE_INDEX_KEY)
+		Expect(err).ToNot(HaveOccurred())
+
+		a := artifact.NewPackageArtifact(filepath.Join(tmpdir, f.GetFileName()))
+		a.CompressionType = f.GetCompressionType()
+		a.Checksums = f.GetChecksums()
+		Expect(a.Verify()).To(Succeed())
+		indexfs, err := os.MkdirTemp("", "index")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(indexfs)
+		Expect(a.Unpack(ctx, indexfs, false)).To(Succeed())
+
+		i, err := ReadRepositoryIndex(filepath.Join(indexfs, REPOSITORY_INDEXFILE))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(i.Revision.Revision).To(Equal(repo.GetRevision()))
+		Expect(i.Revision.LastUpdate).To(Equal(repo.GetLastUpdate()))
+		return i
+	}
+
+	sync := func() *LuetSystemRepository {
+		synced, err := NewSystemRepository(types.LuetRepository{
+			Name:   "test",
+			Type:   DiskRepositoryType,
+			Urls:   []string{tmpdir},
+			Cached: true,
+		}).Sync(ctx, false)
+		Expect(err).ToNot(HaveOccurred())
+		return synced
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = os.MkdirTemp("", "repo")
+		Expect(err).ToNot(HaveOccurred())
+		ctx.Config.System.PkgsCachePath, err = os.MkdirTemp("", "cache")
+		Expect(err).ToNot(HaveOccurred())
+		ctx.Config.System.DatabasePath, err = os.MkdirTemp("", "db")
+		Expect(err).ToNot(HaveOccurred())
+
+		diskRepo(ctx, "../../tests/fixtures/prune", tmpdir, types.GZip)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpdir)
+		os.RemoveAll(ctx.Config.System.PkgsCachePath)
+		os.RemoveAll(ctx.Config.System.DatabasePath)
+	})
+
+	It("publishes the tree and the artifacts of the repository", func() {
+		i := index()
+		Expect(i.Packages).To(HaveLen(4))
+		Expect(i.Artifacts).To(HaveLen(4))
+
+		t, err := i.Tree("/treefs")
+		Expect(err).ToNot(HaveOccurred())
+		p, err := t.GetDatabase().FindPackage(&types.Package{Category: "test", Name: "b", Version: "1.0"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p.GetPath()).To(Equal("/treefs/test/b/1.0"))
+
+		// Rewriting the repository index rewrites the binary one
+		Expect(RecompressRepository(ctx, tmpdir, types.Zstandard, 1, 3, true)).To(Succeed())
+		i = index()
+		Expect(i.Revision.Revision).To(Equal(2))
+		Expect(i.Artifacts).To(HaveLen(4))
+		for _, a := range i.Artifacts {
+			Expect(a.CompressionType).To(Equal(types.Zstandard))
+		}
+	})
+
+	It("loads cached repositories from the binary index", func() {
+		Expect(sync().GetTree().GetDatabase().World()).To(HaveLen(4))
+
+		// The tree files aren't parsed anymore
+		treefs := filepath.Join(ctx.Config.System.GetRepoDatabaseDirPath("test"), "treefs")
+		Expect(os.RemoveAll(filepath.Join(treefs, "test", "b"))).To(Succeed())
+
+		synced := sync()
+		Expect(synced.GetTree().GetDatabase().World()).To(HaveLen(4))
+		Expect(synced.GetIndex()).To(HaveLen(4))
+	})
+
+	It("rejects indexes with records not fitting in the file", func() {
+		indexFile := filepath.Join(tmpdir, REPOSITORY_INDEXFILE)
+		// corrupted returns an index made of records, each prefixed by its
+		// length unless it's given one of its own
+		corrupted := func(records ...interface{}) []byte {
+			data := []byte("LUETIDX\x00\x00\x01")
+			length := -1
+			for _, r := range records {
+				switch r := r.(type) {
+				case int:
+					length = r
+				case string:
+					if length < 0 {
+						length = len(r)
+					}
+					data = append(binary.AppendUvarint(data, uint64(length)), r...)
+					length = -1
+				}
+			}
+			return data
+		}
+
+		for _, index := range [][]byte{
+			// Truncated header
+			corrupted(16, "{}"),
+			// Record length way larger than the file
+			corrupted(1<<62, "{}"),
+			// Counts of records not fitting in the file
+			corrupted(`{"packages":1000000000,"artifacts":0}`),
+			corrupted(`{"packages":-1,"artifacts":0}`),
+			// Truncated package record
+			corrupted(`{"packages":1,"artifacts":0}`, 16, "{}"),
+			// Artifact without package
+			corrupted(`{"packages":0,"artifacts":1}`, "{}"),
+		} {
+			Expect(os.WriteFile(indexFile, index, os.ModePerm)).To(Succeed())
+			_, err := ReadRepositoryIndex(indexFile)
+			Expect(err).To(HaveOccurred(), string(index))
+		}
+	})
+
+	It("loads the YAML files when the binary index can't be used", func() {
+		Expect(sync().GetTree().GetDatabase().World()).To(HaveLen(4))
+
+		metafs := filepath.Join(ctx.Config.System.GetRepoDatabaseDirPath("test"), "metafs")
+		indexFile := filepath.Join(metafs, REPOSITORY_INDEXFILE)
+		Expect(os.WriteFile(indexFile, []byte("LUETIDX\x00\x00\x02"), os.ModePerm)).To(Succeed())
+		synced := sync()
+		Expect(synced.GetTree().GetDatabase().World()).To(HaveLen(4))
+
+		// Header claiming more packages than the file can hold
+		header := `{"revision":{"revision":1},"packages":1000000000,"artifacts":0}`
+		Expect(os.WriteFile(indexFile, append(binary.AppendUvarint([]byte("LUETIDX\x00\x00\x01"), uint64(len(header))), header...), os.ModePerm)).To(Succeed())
+
+		synced = sync()
+		Expect(synced.GetTree().GetDatabase().World()).To(HaveLen(4))
+		Expect(synced.GetIndex()).To(HaveLen(4))
+
+		// The index is cached again
+		i, err := ReadRepositoryIndex(indexFile)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(i.Revision.Revision).To(Equal(synced.GetRevision()))
+		Expect(i.Packages).To(HaveLen(4))
+	})
+})
@@ -27,6 +27,9 @@ import (
 	artifact "github.com/mudler/luet/pkg/api/core/types/artifact"
 
 	"github.com/mudler/luet/pkg/api/core/bus"
+	"github.com/mudler/luet/pkg/compiler"
+	pkg "github.com/mudler/luet/pkg/database"
+	"github.com/mudler/luet/pkg/tree"
 	"github.com/pkg/errors"
 )
 
@@ -110,6 +113,14 @@ func (g *localRepositoryGenerator) Generate(r *LuetSystemRepository, dst string,
 		Path: dst,
 	})
 
+	// The previous revision is loaded before its files are overwritten
+	base, err := r.loadDeltaBase(g.context, dst, resetRevision)
+	if err != nil {
+		g.context.Warning("Failed loading the previous revision, no delta is published:", err.Error())
+		base = nil
+	}
+	defer base.cleanup()
+
 	if _, err := r.AddTree(g.context, r.GetTree(), dst, REPOFILE_TREE_KEY, NewDefaultTreeRepositoryFile()); err != nil {
 		return errors.Wrap(err, "error met while adding runtime tree to repository")
 	}
@@ -118,6 +129,14 @@ func (g *localRepositoryGenerator) Generate(r *LuetSystemRepository, dst string,
 		return errors.Wrap(err, "error met while adding compiler tree to repository")
 	}
 
+	if err := r.addDeltas(g.context, dst, base); err != nil {
+		return errors.Wrap(err, "failed adding deltas to repository")
+	}
+
+	if _, err := r.addIndex(g.context, r.GetTree(), dst); err != nil {
+		return errors.Wrap(err, "failed adding index to repository")
+	}
+
 	if _, err := r.AddMetadata(g.context, repospec, dst); err != nil {
 		return errors.Wrap(err, "failed adding Metadata file to repository")
 	}
@@ -138,3 +157,179 @@ func (g *localRepositoryGenerator) Generate(r *LuetSystemRepository, dst string,
 	})
 	return nil
 }
+
+// LoadLocalRepository reads the repository generated in dir, along with the
+// index of its artifacts, for all the platforms
+func LoadLocalRepository(ctx types.Context, dir string) (*LuetSystemRepository, error) {
+	r := &LuetSystemRepository{}
+	repo, err := r.ReadSpecFile(filepath.Join(dir, REPOSITORY_SPECFILE))
+	if err != nil {
+		return nil, err
+	}
+
+	metaArtifact, err := repo.localRepositoryFile(dir, REPOFILE_META_KEY)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := metaIndex(ctx, metaArtifact)
+	if err != nil {
+		return nil, err
+	}
+	repo.SetIndex(index)
+	return repo, nil
+}
+
+// LoadLocalRepositoryTree reads the repository generated in dir as
+// LoadLocalRepository does, along with its runtime tree
+func LoadLocalRepositoryTree(ctx types.Context, dir string) (*LuetSystemRepository, error) {
+	repo, err := LoadLocalRepository(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	t, cleanup, err := repo.localTree(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	repo.SetTree(t)
+	return repo, nil
+}
+
+// metaIndex returns the index of the artifacts in the repository metadata
+// archive a
+func metaIndex(ctx types.Context, a *artifact.PackageArtifact) (compiler.ArtifactIndex, error) {
+	metafs, err := ctx.TempDir("metafs")
+	if err != nil {
+		return nil, errors.Wrap(err, "Error met while creating tempdir for metafs")
+	}
+	defer os.RemoveAll(metafs)
+
+	if err := a.Unpack(ctx, metafs, false); err != nil {
+		return nil, errors.Wrap(err, "Error met while unpacking metadata")
+	}
+
+	meta, err := NewLuetSystemRepositoryMetadata(filepath.Join(metafs, REPOSITORY_METAFILE), false)
+	if err != nil {
+		return nil, errors.Wrap(err, "While processing "+REPOSITORY_METAFILE)
+	}
+	return meta.ToArtifactIndex(), nil
+}
+
+// localRepositoryFile returns the verified archive of the repository file
+// key, stored in dir
+func (r *LuetSystemRepository) localRepositoryFile(dir, key string) (*artifact.PackageArtifact, error) {
+	f, err := r.GetRepositoryFile(key)
+	if err != nil {
+		return nil, err
+	}
+	a := artifact.NewPackageArtifact(filepath.Join(dir, f.GetFileName()))
+	a.Checksums = f.GetChecksums()
+	a.CompressionType = f.GetCompressionType()
+	if err := a.Verify(); err != nil {
+		return nil, errors.Wrapf(err, "file integrity check failure of '%s'", f.GetFileName())
+	}
+	return a, nil
+}
+
+// localTree loads the runtime tree of the repository stored in dir. The
+// tree is unpacked in a temporary folder, which has to be removed with the
+// returned function once done with the tree.
+func (r *LuetSystemRepository) localTree(ctx types.Context, dir string) (tree.Builder, func(), error) {
+	a, err := r.localRepositoryFile(dir, REPOFILE_TREE_KEY)
+	if err != nil {
+		return nil, nil, err
+	}
+	treefs, err := ctx.TempDir("treefs")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Error met while creating tempdir for treefs")
+	}
+	cleanup := func() { os.RemoveAll(treefs) }
+
+	if err := a.Unpack(ctx, treefs, false); err != nil {
+		cleanup()
+		return nil, nil, errors.Wrap(err, "Error met while unpacking tree")
+	}
+	recipe := tree.NewInstallerRecipe(pkg.NewInMemoryDatabase(false))
+	if err := recipe.Load(treefs); err != nil {
+		cleanup()
+		return nil, nil, errors.Wrap(err, "Error met while loading tree")
+	}
+	return recipe, cleanup, nil
+}
+
+// resetRepositoryFileName strips the compression extension from the name of
+// the repository file, which is added back when compressing it again
+func (r *LuetSystemRepository) resetRepositoryFileName(key string) error {
+	f, err := r.GetRepositoryFile(key)
+	if err != nil {
+		return err
+	}
+	archive := &artifact.PackageArtifact{Path: f.GetFileName(), CompressionType: f.GetCompressionType()}
+	f.SetFileName(archive.GetUncompressedName())
+	r.SetRepositoryFile(key, f)
+	return nil
+}
+
+// rewriteTree writes in dir the runtime tree of the repository, with only
+// the packages of t having their fingerprint in keep
+func (r *LuetSystemRepository) rewriteTree(ctx types.Context, t tree.Builder, dir string, keep map[string]bool) (*artifact.PackageArtifact, error) {
+	filtered := pkg.NewInMemoryDatabase(false)
+	for _, p := range t.GetDatabase().World() {
+		if keep[p.GetFingerPrint()] {
+			filtered.CreatePackage(p)
+		}
+	}
+
+	if err := r.resetRepositoryFileName(REPOFILE_TREE_KEY); err != nil {
+		return nil, err
+	}
+	a, err := r.AddTree(ctx, tree.NewInstallerRecipe(filtered), dir, REPOFILE_TREE_KEY, NewDefaultTreeRepositoryFile())
+	if err != nil {
+		return nil, errors.Wrap(err, "error met while adding runtime tree to repository")
+	}
+	return a, nil
+}
+
+// rewriteIndex writes in dir the metadata of the repository artifacts,
+// bumping the repository revision so clients sync the changes
+func (r *LuetSystemRepository) rewriteIndex(ctx types.Context, dir string) error {
+	repospec := filepath.Join(dir, REPOSITORY_SPECFILE)
+	if err := r.BumpRevision(repospec, false); err != nil {
+		return err
+	}
+	r.LastUpdate = strconv.FormatInt(time.Now().Unix(), 10)
+
+	if err := r.resetRepositoryFileName(REPOFILE_META_KEY); err != nil {
+		return err
+	}
+
+	if _, err := r.GetRepositoryFile(REPOFILE_INDEX_KEY); err == nil {
+		if err := r.rewriteBinaryIndex(ctx, dir); err != nil {
+			return errors.Wrap(err, "failed adding index to repository")
+		}
+	}
+
+	if _, err := r.AddMetadata(ctx, repospec, dir); err != nil {
+		return errors.Wrap(err, "failed adding Metadata file to repository")
+	}
+
+	return nil
+}
+
+// rewriteBinaryIndex writes in dir the binary index of the runtime tree
+// stored there and of the repository artifacts
+func (r *LuetSystemRepository) rewriteBinaryIndex(ctx types.Context, dir string) error {
+	t, cleanup, err := r.localTree(ctx, dir)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if err := r.resetRepositoryFileName(REPOFILE_INDEX_KEY); err != nil {
+		return err
+	}
+	_, err = r.addIndex(ctx, t, dir)
+	return err
+}
@@ -0,0 +1,437 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ghodss/yaml"
+	"github.com/mudler/luet/pkg/api/core/image"
+	"github.com/mudler/luet/pkg/api/core/types"
+	"github.com/mudler/luet/pkg/api/core/types/artifact"
+	compiler "github.com/mudler/luet/pkg/compiler"
+	pkg "github.com/mudler/luet/pkg/database"
+	fileHelper "github.com/mudler/luet/pkg/helpers/file"
+	"github.com/mudler/luet/pkg/installer/client"
+	"github.com/mudler/luet/pkg/tree"
+	"github.com/pkg/errors"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// MirrorOptions are the options of MirrorRepository
+type MirrorOptions struct {
+	// Type of the destination, DiskRepositoryType (default) or
+	// DockerRepositoryType
+	Type string
+	// Backend pushes the images of docker destinations
+	Backend compiler.CompilerBackend
+	// Packages selects the packages to mirror, along with their runtime
+	// dependencies. All the packages are mirrored when empty.
+	Packages types.Packages
+	// Urls replace the ones advertised by the mirrored repository.yaml
+	Urls []string
+	// Force copies again the artifacts already in the destination
+	Force bool
+}
+
+// MirrorResult sums up what MirrorRepository did
+type MirrorResult struct {
+	Copied, Skipped int
+}
+
+// MirrorRepository copies the repository src to dst, which is a folder
+// for disk destinations and an image prefix for docker ones. Artifacts
+// already in the destination with the same checksums are skipped, so it can
+// be run again to sync the mirror with the source.
+func MirrorRepository(ctx types.Context, src *LuetSystemRepository, dst string, o MirrorOptions) (*MirrorResult, error) {
+	if o.Type == "" {
+		o.Type = DiskRepositoryType
+	}
+	if o.Type != DiskRepositoryType && o.Type != DockerRepositoryType {
+		return nil, fmt.Errorf("invalid mirror type '%s', available: %s,%s", o.Type, DiskRepositoryType, DockerRepositoryType)
+	}
+	if o.Type == DockerRepositoryType && o.Backend == nil {
+		return nil, errors.New("a compiler backend is required to mirror to docker")
+	}
+
+	c := src.Client(ctx)
+	if c == nil {
+		return nil, errors.New("no client could be generated from repository")
+	}
+
+	file, err := c.DownloadFile(src.ReferenceFile())
+	if err != nil {
+		return nil, errors.Wrap(err, "while downloading "+src.ReferenceFile())
+	}
+	defer os.RemoveAll(file)
+	remote, err := src.ReadSpecFile(file)
+	if err != nil {
+		return nil, err
+	}
+	// The deltas and the binary index aren't mirrored, the tree and the
+	// index might be filtered
+	remote.removeDeltas()
+	delete(remote.RepositoryFiles, REPOFILE_INDEX_KEY)
+
+	// The files of the source repository are verified while downloading
+	files := map[string]*artifact.PackageArtifact{}
+	for _, key := range []string{REPOFILE_TREE_KEY, REPOFILE_META_KEY, REPOFILE_COMPILER_TREE_KEY} {
+		if _, err := remote.GetRepositoryFile(key); err != nil && key == REPOFILE_COMPILER_TREE_KEY {
+			continue
+		}
+		a, err := remote.getRepoFile(c, key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "while fetching '%s'", key)
+		}
+		defer os.RemoveAll(a.Path)
+		files[key] = a
+	}
+
+	treefs, err := ctx.TempDir("treefs")
+	if err != nil {
+		return nil, errors.Wrap(err, "Error met while creating tempdir for treefs")
+	}
+	defer os.RemoveAll(treefs)
+	if err := files[REPOFILE_TREE_KEY].Unpack(ctx, treefs, false); err != nil {
+		return nil, errors.Wrap(err, "Error met while unpacking tree")
+	}
+	recipe := tree.NewInstallerRecipe(pkg.NewInMemoryDatabase(false))
+	if err := recipe.Load(treefs); err != nil {
+		return nil, errors.Wrap(err, "Error met while loading tree")
+	}
+
+	metafs, err := ctx.TempDir("metafs")
+	if err != nil {
+		return nil, errors.Wrap(err, "Error met while creating tempdir for metafs")
+	}
+	defer os.RemoveAll(metafs)
+	if err := files[REPOFILE_META_KEY].Unpack(ctx, metafs, false); err != nil {
+		return nil, errors.Wrap(err, "Error met while unpacking metadata")
+	}
+	meta, err := NewLuetSystemRepositoryMetadata(filepath.Join(metafs, REPOSITORY_METAFILE), false)
+	if err != nil {
+		return nil, errors.Wrap(err, "While processing "+REPOSITORY_METAFILE)
+	}
+
+	selected, err := mirrorSelection(recipe.GetDatabase(), o.Packages)
+	if err != nil {
+		return nil, err
+	}
+
+	// Docker destinations are staged on disk before being pushed
+	staging := dst
+	if o.Type == DockerRepositoryType {
+		staging, err = ctx.TempDir("mirror")
+		if err != nil {
+			return nil, errors.Wrap(err, "Error met while creating tempdir for mirror")
+		}
+		defer os.RemoveAll(staging)
+	}
+	if err := os.MkdirAll(staging, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	m := &mirror{ctx: ctx, src: src, client: c, staging: staging, dst: dst, o: o}
+	if o.Type == DockerRepositoryType {
+		m.pusher = &dockerRepositoryGenerator{b: o.Backend, imagePrefix: dst, imagePush: true, force: o.Force, context: ctx}
+	}
+
+	index := compiler.ArtifactIndex{}
+	for _, a := range meta.ToArtifactIndex() {
+		if selected != nil && !selected[a.CompileSpec.GetPackage().GetFingerPrint()] {
+			continue
+		}
+		if err := m.artifact(a); err != nil {
+			return nil, errors.Wrapf(err, "while mirroring '%s'", a.CompileSpec.GetPackage().HumanReadableString())
+		}
+		index = append(index, a)
+	}
+	if m.pusher != nil {
+		if err := m.pusher.pushManifestLists(m.platformImages); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(o.Urls) > 0 {
+		remote.SetUrls(o.Urls)
+	}
+	if o.Type == DockerRepositoryType {
+		remote.SetType(DockerRepositoryType)
+	} else if remote.GetType() == DockerRepositoryType {
+		remote.SetType(DiskRepositoryType)
+	}
+
+	if err := m.repositoryFiles(remote, files, recipe, selected, index); err != nil {
+		return nil, err
+	}
+
+	return &MirrorResult{Copied: m.copied, Skipped: m.skipped}, nil
+}
+
+// mirrorSelection returns the fingerprints of the packages matching the
+// selectors, and of their runtime dependencies. It returns nil when there
+// are no selectors.
+func mirrorSelection(db types.PackageDatabase, selectors types.Packages) (map[string]bool, error) {
+	if len(selectors) == 0 {
+		return nil, nil
+	}
+
+	selected := map[string]bool{}
+	var queue types.Packages
+	for _, s := range selectors {
+		matches, err := db.FindPackages(s)
+		if err != nil || len(matches) == 0 {
+			return nil, fmt.Errorf("package '%s' not found in the repository", s.HumanReadableString())
+		}
+		queue = append(queue, matches...)
+	}
+
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		if selected[p.GetFingerPrint()] {
+			continue
+		}
+		selected[p.GetFingerPrint()] = true
+
+		for _, r := range p.GetRequires() {
+			matches, err := db.FindPackages(r)
+			if err != nil {
+				continue
+			}
+			queue = append(queue, matches...)
+		}
+	}
+	return selected, nil
+}
+
+type mirror struct {
+	ctx             types.Context
+	src             *LuetSystemRepository
+	client          Client
+	staging, dst    string
+	o               MirrorOptions
+	pusher          *dockerRepositoryGenerator
+	platformImages  map[string][]image.PlatformImage
+	copied, skipped int
+	// checksumsChanged is set when the artifacts have to be hashed again,
+	// as the docker client recompresses them
+	checksumsChanged bool
+}
+
+// artifactClient returns the client to download a. Docker clients pull the
+// image of the artifact platform.
+func (m *mirror) artifactClient(a *artifact.PackageArtifact) Client {
+	if m.src.GetType() != DockerRepositoryType {
+		return m.client
+	}
+	return client.NewDockerClient(
+		client.RepoData{
+			Urls:           m.src.GetUrls(),
+			Authentication: m.src.GetAuthentication(),
+			Verify:         m.src.Verify,
+			Platform:       a.TargetPlatform(),
+		}, m.ctx)
+}
+
+// artifact copies a to the destination if needed, along with its metadata
+func (m *mirror) artifact(a *artifact.PackageArtifact) error {
+	target := filepath.Join(m.staging, a.RepositoryPath())
+	p := a.CompileSpec.GetPackage()
+
+	switch {
+	case m.o.Force:
+	case m.pusher != nil && m.o.Backend.ImageAvailable(fmt.Sprintf("%s:%s", m.dst, a.ImageTag())):
+		m.ctx.Debug("Image of", p.HumanReadableString(), "already present, skipping")
+		m.skipped++
+		return nil
+	case m.pusher == nil && fileHelper.Exists(target) && len(a.Checksums) > 0:
+		existing := a.ShallowCopy()
+		existing.Path = target
+		if existing.Verify() == nil {
+			m.ctx.Debug(p.HumanReadableString(), "already present, skipping")
+			a.Path = target
+			m.skipped++
+			return nil
+		}
+	}
+
+	m.ctx.Info(fmt.Sprintf(":arrow_down: Mirroring %s", p.HumanReadableString()))
+	downloaded, err := m.artifactClient(a).DownloadArtifact(a)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+	if err := fileHelper.CopyFile(downloaded.Path, target); err != nil {
+		return err
+	}
+	a.Path = target
+
+	if m.src.GetType() == DockerRepositoryType {
+		a.Checksums = artifact.Checksums{}
+		if err := a.Hash(); err != nil {
+			return err
+		}
+		m.checksumsChanged = true
+	} else if err := a.Verify(); err != nil {
+		os.RemoveAll(target)
+		return errors.Wrap(err, "file integrity check failure")
+	}
+
+	if err := m.sbom(a, filepath.Dir(target)); err != nil {
+		return err
+	}
+
+	metadataFile := filepath.Join(filepath.Dir(target), p.GetMetadataFilePath())
+	data, err := yamlv3.Marshal(a)
+	if err != nil {
+		return errors.Wrap(err, "While marshalling for PackageArtifact YAML")
+	}
+	if err := os.WriteFile(metadataFile, data, os.ModePerm); err != nil {
+		return errors.Wrap(err, "While writing PackageArtifact YAML")
+	}
+	m.copied++
+
+	if m.pusher == nil {
+		return nil
+	}
+	return m.push(a, metadataFile)
+}
+
+// sbom copies the SBOM of a, if any, next to its archive in dir. The SBOMs
+// of docker repositories are attached to the package images, and are not
+// mirrored.
+func (m *mirror) sbom(a *artifact.PackageArtifact, dir string) error {
+	if a.SBOM == "" {
+		return nil
+	}
+	p := a.CompileSpec.GetPackage()
+	if m.src.GetType() == DockerRepositoryType {
+		m.ctx.Warning("SBOM of", p.HumanReadableString(), "is not mirrored from docker repositories")
+		a.SBOM = ""
+		return nil
+	}
+
+	file, err := m.client.DownloadFile(a.SBOMRepositoryPath())
+	if err != nil {
+		return errors.Wrapf(err, "while downloading SBOM of '%s'", p.HumanReadableString())
+	}
+	defer os.RemoveAll(file)
+	return fileHelper.CopyFile(file, filepath.Join(dir, a.SBOM))
+}
+
+// push pushes the image of a, and of its metadata file and SBOM
+func (m *mirror) push(a *artifact.PackageArtifact, metadataFile string) error {
+	metadata := artifact.NewPackageArtifact(metadataFile)
+	metadata.Platform = a.Platform
+	if err := m.pusher.pushImageFromArtifact(metadata, m.o.Backend, !m.o.Force); err != nil {
+		return errors.Wrap(err, "while pushing metadata file associated to the artifact")
+	}
+
+	packageImage := fmt.Sprintf("%s:%s", m.dst, a.ImageTag())
+	m.ctx.Info("Generating final image", packageImage)
+	if err := a.GenerateFinalImage(m.ctx, packageImage, m.o.Backend, true); err != nil {
+		return errors.Wrap(err, "Failed generating final image "+packageImage)
+	}
+	if err := pushImage(m.ctx, m.o.Backend, packageImage, true); err != nil {
+		return errors.Wrapf(err, "Failed while pushing image: '%s'", packageImage)
+	}
+	if err := m.pusher.pushSBOM(a, filepath.Dir(metadataFile), packageImage); err != nil {
+		return err
+	}
+
+	if !a.Platform.IsZero() {
+		if m.platformImages == nil {
+			m.platformImages = map[string][]image.PlatformImage{}
+		}
+		id := a.CompileSpec.GetPackage().ImageID()
+		m.platformImages[id] = append(m.platformImages[id], image.PlatformImage{Platform: a.Platform, Image: packageImage})
+	}
+	return nil
+}
+
+// repositoryFiles writes the tree, compiler tree and metadata files of the
+// mirror, along with its repository.yaml. They are copied as they are,
+// unless the mirror has a subset of the packages or different checksums.
+func (m *mirror) repositoryFiles(remote *LuetSystemRepository, files map[string]*artifact.PackageArtifact, recipe tree.Builder, selected map[string]bool, index compiler.ArtifactIndex) error {
+	repospec := filepath.Join(m.staging, REPOSITORY_SPECFILE)
+	remote.SetIndex(index)
+
+	pushed := []*artifact.PackageArtifact{}
+	for _, key := range []string{REPOFILE_TREE_KEY, REPOFILE_COMPILER_TREE_KEY} {
+		f, ok := files[key]
+		if !ok {
+			continue
+		}
+
+		if key == REPOFILE_TREE_KEY && selected != nil {
+			a, err := remote.rewriteTree(m.ctx, recipe, m.staging, selected)
+			if err != nil {
+				return err
+			}
+			pushed = append(pushed, a)
+			continue
+		}
+
+		repoFile, _ := remote.GetRepositoryFile(key)
+		target := filepath.Join(m.staging, repoFile.GetFileName())
+		if err := fileHelper.CopyFile(f.Path, target); err != nil {
+			return err
+		}
+		pushed = append(pushed, artifact.NewPackageArtifact(target))
+	}
+
+	if selected != nil || m.checksumsChanged {
+		if err := remote.resetRepositoryFileName(REPOFILE_META_KEY); err != nil {
+			return err
+		}
+		a, err := remote.AddMetadata(m.ctx, repospec, m.staging)
+		if err != nil {
+			return errors.Wrap(err, "failed adding Metadata file to repository")
+		}
+		pushed = append(pushed, a)
+	} else {
+		metaFile, _ := remote.GetRepositoryFile(REPOFILE_META_KEY)
+		target := filepath.Join(m.staging, metaFile.GetFileName())
+		if err := fileHelper.CopyFile(files[REPOFILE_META_KEY].Path, target); err != nil {
+			return err
+		}
+		pushed = append(pushed, artifact.NewPackageArtifact(target))
+
+		_, serialized := remote.Serialize()
+		data, err := yaml.Marshal(serialized)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(repospec, data, os.ModePerm); err != nil {
+			return err
+		}
+	}
+
+	if m.pusher == nil {
+		return nil
+	}
+	for _, a := range pushed {
+		if err := m.pusher.pushImageFromArtifact(a, m.o.Backend, false); err != nil {
+			return errors.Wrapf(err, "error met while pushing '%s'", a.GetFileName())
+		}
+	}
+	return m.pusher.pushRepoMetadata(repospec, REPOSITORY_SPECFILE, remote)
+}
@@ -0,0 +1,130 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/mudler/luet/pkg/api/core/context"
+	"github.com/mudler/luet/pkg/api/core/types"
+	artifact "github.com/mudler/luet/pkg/api/core/types/artifact"
+	pkg "github.com/mudler/luet/pkg/database"
+	. "github.com/mudler/luet/pkg/installer"
+	"github.com/mudler/luet/pkg/tree"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Repository mirror", func() {
+	var src, dst string
+	var source *LuetSystemRepository
+	ctx := context.NewContext()
+
+	BeforeEach(func() {
+		var err error
+		src, err = os.MkdirTemp("", "src")
+		Expect(err).ToNot(HaveOccurred())
+		dst, err = os.MkdirTemp("", "dst")
+		Expect(err).ToNot(HaveOccurred())
+		ctx.Config.System.PkgsCachePath, err = os.MkdirTemp("", "cache")
+		Expect(err).ToNot(HaveOccurred())
+
+		diskRepo(ctx, "../../tests/fixtures/simple_dep", src, types.GZip)
+		source = NewSystemRepository(types.LuetRepository{Name: "test", Type: DiskRepositoryType, Urls: []string{src}})
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(src)
+		os.RemoveAll(dst)
+		os.RemoveAll(ctx.Config.System.PkgsCachePath)
+	})
+
+	It("copies the repository and skips the artifacts up to date", func() {
+		res, err := MirrorRepository(ctx, source, dst, MirrorOptions{Urls: []string{"https://mirror.example.com"}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.Copied).To(Equal(3))
+		Expect(res.Skipped).To(Equal(0))
+
+		original, err := LoadLocalRepository(ctx, src)
+		Expect(err).ToNot(HaveOccurred())
+		mirrored, err := LoadLocalRepository(ctx, dst)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mirrored.GetUrls()).To(Equal([]string{"https://mirror.example.com"}))
+		Expect(mirrored.GetRevision()).To(Equal(original.GetRevision()))
+		Expect(mirrored.GetIndex()).To(HaveLen(3))
+		for _, a := range mirrored.GetIndex() {
+			a.Path = filepath.Join(dst, a.Path)
+			Expect(a.Verify()).To(Succeed())
+			Expect(filepath.Join(dst, a.CompileSpec.GetPackage().GetMetadataFilePath())).To(BeAnExistingFile())
+			Expect(a.SBOM).ToNot(BeEmpty())
+			Expect(filepath.Join(dst, a.SBOMRepositoryPath())).To(BeAnExistingFile())
+		}
+
+		res, err = MirrorRepository(ctx, source, dst, MirrorOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.Copied).To(Equal(0))
+		Expect(res.Skipped).To(Equal(3))
+	})
+
+	It("mirrors the selected packages with their runtime dependencies", func() {
+		res, err := MirrorRepository(ctx, source, dst, MirrorOptions{
+			Packages: types.Packages{{Category: "test", Name: "c", Version: ">=0"}},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.Copied).To(Equal(2))
+
+		mirrored, err := LoadLocalRepository(ctx, dst)
+		Expect(err).ToNot(HaveOccurred())
+		names := []string{}
+		for _, a := range mirrored.GetIndex() {
+			names = append(names, a.CompileSpec.GetPackage().GetName())
+		}
+		Expect(names).To(ConsistOf("a", "c"))
+		Expect(filepath.Join(dst, "b-test-1.1.package.tar.gz")).ToNot(BeAnExistingFile())
+
+		treeFile, err := mirrored.GetRepositoryFile(REPOFILE_TREE_KEY)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(treeFile.GetFileName()).To(Equal(TREE_TARBALL + ".gz"))
+		tree := repositoryTree(ctx, dst, treeFile)
+		Expect(tree.GetDatabase().World()).To(HaveLen(2))
+	})
+
+	It("fails on packages not in the repository", func() {
+		_, err := MirrorRepository(ctx, source, dst, MirrorOptions{
+			Packages: types.Packages{{Category: "test", Name: "z", Version: ">=0"}},
+		})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+// repositoryTree loads the runtime tree of the repository file f stored in dir
+func repositoryTree(ctx types.Context, dir string, f LuetRepositoryFile) tree.Builder {
+	a := artifact.NewPackageArtifact(filepath.Join(dir, f.GetFileName()))
+	a.Checksums = f.GetChecksums()
+	a.CompressionType = f.GetCompressionType()
+	Expect(a.Verify()).To(Succeed())
+
+	treefs, err := os.MkdirTemp("", "treefs")
+	Expect(err).ToNot(HaveOccurred())
+	DeferCleanup(os.RemoveAll, treefs)
+	Expect(a.Unpack(ctx, treefs, false)).To(Succeed())
+
+	recipe := tree.NewInstallerRecipe(pkg.NewInMemoryDatabase(false))
+	Expect(recipe.Load(treefs)).To(Succeed())
+	return recipe
+}
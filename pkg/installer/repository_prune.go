@@ -0,0 +1,285 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mudler/luet/pkg/api/core/image"
+	"github.com/mudler/luet/pkg/api/core/types"
+	"github.com/mudler/luet/pkg/api/core/types/artifact"
+	"github.com/mudler/luet/pkg/compiler"
+	"github.com/mudler/luet/pkg/helpers"
+	version "github.com/mudler/luet/pkg/versioner"
+	"github.com/pkg/errors"
+)
+
+// PruneOptions is the retention policy applied when pruning a repository
+type PruneOptions struct {
+	// Keep is the number of versions retained for each package
+	Keep int
+	// Snapshots are the IDs of the snapshots retained when PruneSnapshots
+	// is set. The artifacts referenced by the retained snapshots are kept.
+	Snapshots []string
+	// PruneSnapshots removes the snapshots not in Snapshots. Otherwise all
+	// the snapshots are retained, as clients might be pinned to them.
+	PruneSnapshots bool
+	// DryRun only reports what would be removed
+	DryRun bool
+}
+
+// PruneResult reports what was removed from a repository
+type PruneResult struct {
+	Artifacts []*artifact.PackageArtifact
+	Snapshots []string
+}
+
+// PruneRepository applies the retention policy to the disk repository
+// generated in dir. The artifacts and snapshots which aren't retained are
+// removed, and the tree and the index of the repository are regenerated
+// without them.
+func PruneRepository(ctx types.Context, dir string, o PruneOptions) (*PruneResult, error) {
+	repo, err := LoadLocalRepository(ctx, dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while reading the repository in '%s'", dir)
+	}
+
+	res, err := PrunePackages(ctx, dir, NewSystemRepository(types.LuetRepository{
+		Name: repo.GetName(),
+		Type: DiskRepositoryType,
+		Urls: []string{dir},
+	}), o)
+	if err != nil {
+		return nil, err
+	}
+	if o.DryRun || len(res.Artifacts) == 0 {
+		return res, nil
+	}
+
+	removed := map[string]bool{}
+	for _, a := range res.Artifacts {
+		removed[a.RepositoryPath()] = true
+	}
+	index := compiler.ArtifactIndex{}
+	packages := map[string]bool{}
+	for _, a := range repo.GetIndex() {
+		if removed[a.RepositoryPath()] {
+			continue
+		}
+		index = append(index, a)
+		packages[a.CompileSpec.GetPackage().GetFingerPrint()] = true
+	}
+	repo.SetIndex(index)
+
+	t, cleanup, err := repo.localTree(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	if _, err := repo.rewriteTree(ctx, t, dir, packages); err != nil {
+		return nil, err
+	}
+
+	return res, repo.rewriteIndex(ctx, dir)
+}
+
+// PrunePackages applies the retention policy to the artifacts in the
+// packages folder src, which the repository r is generated from. The
+// snapshots of r which aren't retained are removed when pruning snapshots,
+// and so are the images of the artifacts removed when r is a docker
+// repository.
+func PrunePackages(ctx types.Context, src string, r *LuetSystemRepository, o PruneOptions) (*PruneResult, error) {
+	if o.Keep < 1 {
+		return nil, errors.New("at least one version of each package has to be kept")
+	}
+
+	artifacts, err := readMetadataFiles(src)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while reading the artifacts in '%s'", src)
+	}
+	keep := retainedVersions(artifacts, o.Keep)
+
+	ids, err := r.snapshotIDs()
+	if err != nil {
+		return nil, err
+	}
+	res := &PruneResult{}
+	retained := map[string]bool{}
+	for _, id := range o.Snapshots {
+		retained[id] = true
+	}
+	for _, id := range ids {
+		if o.PruneSnapshots && !retained[id] {
+			res.Snapshots = append(res.Snapshots, id)
+			continue
+		}
+		delete(retained, id)
+		index, err := r.snapshotIndex(ctx, id)
+		if err != nil {
+			return nil, errors.Wrapf(err, "while reading snapshot '%s'", id)
+		}
+		for _, a := range index {
+			keep[a.RepositoryPath()] = true
+		}
+	}
+	for id := range retained {
+		return nil, fmt.Errorf("snapshot '%s' not found", id)
+	}
+
+	for _, a := range artifacts {
+		if !keep[a.RepositoryPath()] {
+			res.Artifacts = append(res.Artifacts, a)
+		}
+	}
+	if o.DryRun {
+		return res, nil
+	}
+
+	for _, id := range res.Snapshots {
+		ctx.Info(":scissors: Removing snapshot", id)
+		if err := r.removeSnapshot(ctx, id); err != nil {
+			return nil, errors.Wrapf(err, "while removing snapshot '%s'", id)
+		}
+	}
+
+	for _, a := range res.Artifacts {
+		ctx.Info(":scissors: Removing", a.CompileSpec.GetPackage().HumanReadableString(), a.RepositoryPath())
+		if err := removeArtifactFiles(a); err != nil {
+			return nil, err
+		}
+		if r.GetType() == DockerRepositoryType {
+			r.removeArtifactImages(ctx, a, artifacts, keep)
+		}
+	}
+	return res, nil
+}
+
+// readMetadataFiles returns the artifacts of the metadata files found in
+// dir, with their paths pointing to the archives next to them
+func readMetadataFiles(dir string) ([]*artifact.PackageArtifact, error) {
+	var art []*artifact.PackageArtifact
+	err := filepath.Walk(dir, func(currentpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(info.Name(), types.PackageMetaSuffix) {
+			return nil
+		}
+
+		dat, err := os.ReadFile(currentpath)
+		if err != nil {
+			return errors.Wrap(err, "Error reading file "+currentpath)
+		}
+		a, err := artifact.NewPackageArtifactFromYaml(dat)
+		if err != nil {
+			return errors.Wrap(err, "Error reading yaml "+currentpath)
+		}
+		if a.CompileSpec == nil || a.CompileSpec.GetPackage() == nil {
+			return nil
+		}
+		// The metadata contains the full path where the file was located during buildtime.
+		a.Path = filepath.Join(filepath.Dir(currentpath), filepath.Base(a.Path))
+		art = append(art, a)
+		return nil
+	})
+	return art, err
+}
+
+// retainedVersions returns the repository paths of the artifacts of the
+// keep newest versions of each package, for each platform
+func retainedVersions(artifacts []*artifact.PackageArtifact, keep int) map[string]bool {
+	versions := map[string][]string{}
+	seen := map[string]bool{}
+	for _, a := range artifacts {
+		p := a.CompileSpec.GetPackage()
+		key := filepath.Join(a.Platform.DirName(), p.GetPackageName())
+		if !seen[filepath.Join(key, p.GetVersion())] {
+			seen[filepath.Join(key, p.GetVersion())] = true
+			versions[key] = append(versions[key], p.GetVersion())
+		}
+	}
+
+	newest := map[string]map[string]bool{}
+	v := version.DefaultVersioner()
+	for key, vv := range versions {
+		sorted := v.Sort(vv)
+		if len(sorted) > keep {
+			sorted = sorted[len(sorted)-keep:]
+		}
+		newest[key] = map[string]bool{}
+		for _, s := range sorted {
+			newest[key][s] = true
+		}
+	}
+
+	retained := map[string]bool{}
+	for _, a := range artifacts {
+		p := a.CompileSpec.GetPackage()
+		if newest[filepath.Join(a.Platform.DirName(), p.GetPackageName())][p.GetVersion()] {
+			retained[a.RepositoryPath()] = true
+		}
+	}
+	return retained
+}
+
+// removeArtifactFiles removes the archive of the artifact along with the
+// files stored next to it
+func removeArtifactFiles(a *artifact.PackageArtifact) error {
+	dir := filepath.Dir(a.Path)
+	files := []string{a.Path, filepath.Join(dir, a.CompileSpec.GetPackage().GetMetadataFilePath())}
+	if a.SBOM != "" {
+		files = append(files, filepath.Join(dir, a.SBOM))
+	}
+	if a.BuildLog != "" {
+		files = append(files, filepath.Join(dir, a.BuildLog))
+	}
+	for _, f := range files {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "while removing '%s'", f)
+		}
+	}
+	return nil
+}
+
+// removeArtifactImages deletes the images pushed for the artifact a. The
+// manifest list of its package is deleted too, unless one of the other
+// artifacts built for another platform is kept.
+// Failures are only reported, as some registries don't allow deletions.
+func (r *LuetSystemRepository) removeArtifactImages(ctx types.Context, a *artifact.PackageArtifact, artifacts []*artifact.PackageArtifact, keep map[string]bool) {
+	p := a.CompileSpec.GetPackage()
+	tags := []string{a.ImageTag(), helpers.SanitizeImageString(p.GetMetadataFilePath())}
+	if !a.Platform.IsZero() {
+		listed := false
+		for _, o := range artifacts {
+			if keep[o.RepositoryPath()] && o.CompileSpec.GetPackage().ImageID() == p.ImageID() {
+				listed = true
+			}
+		}
+		if !listed {
+			tags = append(tags, p.ImageID())
+		}
+	}
+
+	for _, t := range tags {
+		img := fmt.Sprintf("%s:%s", r.GetUrls()[0], t)
+		if err := image.Delete(img); err != nil {
+			ctx.Warning("Failed deleting", img, err.Error())
+		}
+	}
+}
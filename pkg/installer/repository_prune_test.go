@@ -0,0 +1,134 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mudler/luet/pkg/api/core/context"
+	"github.com/mudler/luet/pkg/api/core/types"
+	fileHelper "github.com/mudler/luet/pkg/helpers/file"
+	. "github.com/mudler/luet/pkg/installer"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Repository pruning", func() {
+	var tmpdir string
+	ctx := context.NewContext()
+
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = os.MkdirTemp("", "repo")
+		Expect(err).ToNot(HaveOccurred())
+		ctx.Config.System.PkgsCachePath, err = os.MkdirTemp("", "cache")
+		Expect(err).ToNot(HaveOccurred())
+		diskRepo(ctx, "../../tests/fixtures/prune", tmpdir, types.GZip)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpdir)
+		os.RemoveAll(ctx.Config.System.PkgsCachePath)
+	})
+
+	snapshots := func() []string {
+		matches, err := filepath.Glob(filepath.Join(tmpdir, "*-"+REPOSITORY_SPECFILE))
+		Expect(err).ToNot(HaveOccurred())
+		ids := []string{}
+		for _, m := range matches {
+			ids = append(ids, strings.TrimSuffix(filepath.Base(m), "-"+REPOSITORY_SPECFILE))
+		}
+		return ids
+	}
+
+	versions := func() []string {
+		repo, err := LoadLocalRepository(ctx, tmpdir)
+		Expect(err).ToNot(HaveOccurred())
+		v := []string{}
+		for _, a := range repo.GetIndex() {
+			v = append(v, a.CompileSpec.GetPackage().HumanReadableString())
+		}
+		return v
+	}
+
+	It("keeps the newest versions of each package", func() {
+		before, err := LoadLocalRepository(ctx, tmpdir)
+		Expect(err).ToNot(HaveOccurred())
+
+		res, err := PruneRepository(ctx, tmpdir, PruneOptions{Keep: 2, PruneSnapshots: true})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.Artifacts).To(HaveLen(1))
+		Expect(res.Artifacts[0].CompileSpec.GetPackage().HumanReadableString()).To(Equal("test/a-1.0"))
+		Expect(fileHelper.Exists(filepath.Join(tmpdir, "a-test-1.0.package.tar.gz"))).To(BeFalse())
+		Expect(fileHelper.Exists(filepath.Join(tmpdir, "a-test-1.0.metadata.yaml"))).To(BeFalse())
+		Expect(fileHelper.Exists(filepath.Join(tmpdir, "a-test-1.1.package.tar.gz"))).To(BeTrue())
+
+		after, err := LoadLocalRepository(ctx, tmpdir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(after.GetRevision()).To(Equal(before.GetRevision() + 1))
+		Expect(versions()).To(ConsistOf("test/a-1.1", "test/a-1.2", "test/b-1.0"))
+
+		treeFile, err := after.GetRepositoryFile(REPOFILE_TREE_KEY)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(repositoryTree(ctx, tmpdir, treeFile).GetDatabase().World()).To(HaveLen(3))
+	})
+
+	It("keeps the artifacts of the retained snapshots", func() {
+		ids := snapshots()
+		Expect(ids).To(HaveLen(1))
+
+		res, err := PruneRepository(ctx, tmpdir, PruneOptions{Keep: 1, Snapshots: ids})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.Artifacts).To(BeEmpty())
+		Expect(res.Snapshots).To(BeEmpty())
+		Expect(versions()).To(HaveLen(4))
+
+		res, err = PruneRepository(ctx, tmpdir, PruneOptions{Keep: 1, PruneSnapshots: true})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.Artifacts).To(HaveLen(2))
+		Expect(res.Snapshots).To(Equal(ids))
+		Expect(versions()).To(ConsistOf("test/a-1.2", "test/b-1.0"))
+	})
+
+	It("keeps all the snapshots unless pruning them", func() {
+		ids := snapshots()
+		Expect(ids).To(HaveLen(1))
+
+		res, err := PruneRepository(ctx, tmpdir, PruneOptions{Keep: 1})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.Artifacts).To(BeEmpty())
+		Expect(res.Snapshots).To(BeEmpty())
+		Expect(snapshots()).To(Equal(ids))
+		Expect(versions()).To(HaveLen(4))
+	})
+
+	It("doesn't remove anything on dry runs", func() {
+		res, err := PruneRepository(ctx, tmpdir, PruneOptions{Keep: 1, PruneSnapshots: true, DryRun: true})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.Artifacts).To(HaveLen(2))
+		Expect(res.Snapshots).To(HaveLen(1))
+		Expect(versions()).To(HaveLen(4))
+		Expect(snapshots()).To(HaveLen(1))
+	})
+
+	It("fails on missing snapshots", func() {
+		_, err := PruneRepository(ctx, tmpdir, PruneOptions{Keep: 1, Snapshots: []string{"missing"}})
+		Expect(err).To(HaveOccurred())
+	})
+})
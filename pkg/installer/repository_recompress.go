@@ -0,0 +1,96 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mudler/luet/pkg/api/core/types"
+	"github.com/mudler/luet/pkg/api/core/types/artifact"
+	fileHelper "github.com/mudler/luet/pkg/helpers/file"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// RecompressRepository converts the artifacts of the repository generated
+// in dir to the compression type t, with the given level (0 for the
+// default one) and zstd long distance matching. Their checksums and the
+// repository index are updated, and the previous archives removed.
+func RecompressRepository(ctx types.Context, dir string, t types.CompressionImplementation, concurrency, level int, zstdLong bool) error {
+	repo, err := LoadLocalRepository(ctx, dir)
+	if err != nil {
+		return errors.Wrapf(err, "while reading the repository in '%s'", dir)
+	}
+
+	for _, a := range repo.GetIndex() {
+		if a.CompressionType == t && level == 0 && !zstdLong {
+			continue
+		}
+		repositoryPath := a.RepositoryPath()
+		oldFileName := a.GetFileName()
+		a.Path = filepath.Join(dir, repositoryPath)
+
+		ctx.Info(fmt.Sprintf(":arrows_counterclockwise: Recompressing %s to %s", repositoryPath, t))
+		if err := a.Recompress(t, concurrency,
+			artifact.WithCompressionLevel(level),
+			artifact.WithZstdLongWindow(zstdLong),
+		); err != nil {
+			return errors.Wrapf(err, "while recompressing '%s'", repositoryPath)
+		}
+
+		if err := updateMetadataFile(filepath.Dir(a.Path), oldFileName, a); err != nil {
+			return err
+		}
+	}
+
+	return repo.rewriteIndex(ctx, dir)
+}
+
+// updateMetadataFile updates the metadata file of the artifact stored next
+// to it, if any, with its new archive and checksums
+func updateMetadataFile(dir, oldFileName string, a *artifact.PackageArtifact) error {
+	if a.CompileSpec == nil || a.CompileSpec.GetPackage() == nil {
+		return nil
+	}
+	metadataFile := filepath.Join(dir, a.CompileSpec.GetPackage().GetMetadataFilePath())
+	if !fileHelper.Exists(metadataFile) {
+		return nil
+	}
+
+	dat, err := os.ReadFile(metadataFile)
+	if err != nil {
+		return errors.Wrap(err, "Error reading file "+metadataFile)
+	}
+	metadata, err := artifact.NewPackageArtifactFromYaml(dat)
+	if err != nil {
+		return errors.Wrap(err, "Error reading yaml "+metadataFile)
+	}
+	if metadata.GetFileName() != oldFileName {
+		return nil
+	}
+
+	metadata.Path = filepath.Join(filepath.Dir(metadata.Path), a.GetFileName())
+	metadata.CompressionType = a.CompressionType
+	metadata.Checksums = a.Checksums
+
+	data, err := yaml.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(metadataFile, data, os.ModePerm)
+}
@@ -0,0 +1,128 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/mudler/luet/pkg/api/core/context"
+	"github.com/mudler/luet/pkg/api/core/types"
+	artifact "github.com/mudler/luet/pkg/api/core/types/artifact"
+	pkg "github.com/mudler/luet/pkg/database"
+	fileHelper "github.com/mudler/luet/pkg/helpers/file"
+	. "github.com/mudler/luet/pkg/installer"
+	"github.com/mudler/luet/pkg/tree"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// diskRepo packs the packages of the tree, with a file named after each of
+// them and their SBOM, and generates a disk repository with them in dst
+func diskRepo(ctx types.Context, treeDir, dst string, t types.CompressionImplementation) *LuetSystemRepository {
+	recipe := tree.NewCompilerRecipe(pkg.NewInMemoryDatabase(false))
+	Expect(recipe.Load(treeDir)).To(Succeed())
+
+	for _, p := range recipe.GetDatabase().World() {
+		src, err := os.MkdirTemp("", "src")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(src)
+		Expect(os.WriteFile(filepath.Join(src, p.GetName()), []byte(p.HumanReadableString()), os.ModePerm)).To(Succeed())
+
+		a := artifact.NewPackageArtifact(filepath.Join(dst, p.GetFingerPrint()+".package.tar"))
+		a.CompressionType = t
+		a.CompileSpec = &types.LuetCompilationSpec{Package: p}
+		Expect(a.Compress(src, 1)).To(Succeed())
+		files, err := a.FileList()
+		Expect(err).ToNot(HaveOccurred())
+		a.Files = files
+		Expect(a.WriteSBOM(dst, artifact.WithRuntimePackage(p))).To(Succeed())
+		Expect(a.WriteYAML(dst)).To(Succeed())
+	}
+
+	return generateDiskRepo(ctx, treeDir, dst, "")
+}
+
+// generateDiskRepo generates a disk repository in dst with the artifacts
+// already there, creating the snapshot id
+func generateDiskRepo(ctx types.Context, treeDir, dst, id string) *LuetSystemRepository {
+	repo, err := GenerateRepository(
+		WithName("test"),
+		WithDescription("description"),
+		WithType("disk"),
+		WithUrls(dst),
+		WithPriority(1),
+		WithSource(dst),
+		FromMetadata(true),
+		WithTree(treeDir),
+		WithContext(ctx),
+		WithDatabase(pkg.NewInMemoryDatabase(false)),
+	)
+	Expect(err).ToNot(HaveOccurred())
+	repo.SetSnapshotID(id)
+	Expect(repo.Write(ctx, dst, false, true)).To(Succeed())
+	return repo
+}
+
+var _ = Describe("Repository recompression", func() {
+	var tmpdir string
+	ctx := context.NewContext()
+
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = os.MkdirTemp("", "repo")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpdir)
+	})
+
+	It("converts the artifacts and updates the index", func() {
+		diskRepo(ctx, "../../tests/fixtures/runtimedeps", tmpdir, types.GZip)
+
+		before, err := LoadLocalRepository(ctx, tmpdir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(before.GetIndex()).To(HaveLen(2))
+
+		Expect(RecompressRepository(ctx, tmpdir, types.Zstandard, 1, 19, true)).To(Succeed())
+
+		after, err := LoadLocalRepository(ctx, tmpdir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(after.GetRevision()).To(Equal(before.GetRevision() + 1))
+		Expect(after.GetIndex()).To(HaveLen(2))
+
+		for _, a := range after.GetIndex() {
+			Expect(a.CompressionType).To(Equal(types.Zstandard))
+			Expect(a.Path).To(HaveSuffix(".package.tar.zst"))
+			Expect(fileHelper.Exists(filepath.Join(tmpdir, a.Path+".gz"))).To(BeFalse())
+
+			a.Path = filepath.Join(tmpdir, a.Path)
+			Expect(a.Verify()).To(Succeed())
+			files, err := a.FileList()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(Equal([]string{a.CompileSpec.GetPackage().GetName()}))
+
+			dat, err := os.ReadFile(filepath.Join(tmpdir, a.CompileSpec.GetPackage().GetMetadataFilePath()))
+			Expect(err).ToNot(HaveOccurred())
+			metadata, err := artifact.NewPackageArtifactFromYaml(dat)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(metadata.CompressionType).To(Equal(types.Zstandard))
+			Expect(metadata.Checksums).To(Equal(a.Checksums))
+		}
+	})
+})
@@ -0,0 +1,195 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mudler/luet/pkg/api/core/image"
+	"github.com/mudler/luet/pkg/api/core/types"
+	"github.com/mudler/luet/pkg/compiler"
+	"github.com/mudler/luet/pkg/helpers"
+	"github.com/pkg/errors"
+)
+
+// RepositorySnapshot is a snapshot of a repository, which clients can be
+// pinned to
+type RepositorySnapshot struct {
+	ID         string `json:"id"`
+	Revision   int    `json:"revision"`
+	LastUpdate string `json:"last_update"`
+}
+
+// Snapshots returns the snapshots of the repository, from the oldest one.
+// The snapshots of http repositories can't be listed.
+func (r *LuetSystemRepository) Snapshots(ctx types.Context) ([]RepositorySnapshot, error) {
+	if r.GetType() == HttpRepositoryType {
+		return nil, errors.New("snapshots of http repositories can't be listed")
+	}
+	c := r.Client(ctx)
+	if c == nil {
+		return nil, errors.New("no client could be generated from repository")
+	}
+
+	ids, err := r.snapshotIDs()
+	if err != nil {
+		return nil, errors.Wrapf(err, "while listing the snapshots of '%s'", r.GetName())
+	}
+	res := []RepositorySnapshot{}
+	for _, id := range ids {
+		spec, err := r.snapshotSpec(c, id)
+		if err != nil {
+			return nil, errors.Wrapf(err, "while reading snapshot '%s'", id)
+		}
+		res = append(res, RepositorySnapshot{ID: id, Revision: spec.GetRevision(), LastUpdate: spec.GetLastUpdate()})
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		ti, _ := strconv.ParseInt(res[i].LastUpdate, 10, 64)
+		tj, _ := strconv.ParseInt(res[j].LastUpdate, 10, 64)
+		return ti < tj
+	})
+	return res, nil
+}
+
+// snapshotSpecFile returns the name of the repository spec file of the
+// snapshot id
+func snapshotSpecFile(id string) string {
+	return fmt.Sprintf("%s-%s", id, REPOSITORY_SPECFILE)
+}
+
+// snapshotIDs returns the IDs of the snapshots of the repository, which is
+// either a folder or a docker one
+func (r *LuetSystemRepository) snapshotIDs() ([]string, error) {
+	suffix := "-" + REPOSITORY_SPECFILE
+	var files []string
+	if r.GetType() == DockerRepositoryType {
+		tags, err := image.Tags(r.GetUrls()[0])
+		if err != nil {
+			return nil, err
+		}
+		files = tags
+	} else {
+		matches, err := filepath.Glob(filepath.Join(r.GetUrls()[0], "*"+suffix))
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			files = append(files, filepath.Base(m))
+		}
+	}
+
+	ids := []string{}
+	for _, f := range files {
+		if strings.HasSuffix(f, suffix) {
+			ids = append(ids, strings.TrimSuffix(f, suffix))
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// snapshotSpec downloads the repository spec of the snapshot id
+func (r *LuetSystemRepository) snapshotSpec(c Client, id string) (*LuetSystemRepository, error) {
+	file, err := c.DownloadFile(snapshotSpecFile(id))
+	if err != nil {
+		return nil, errors.Wrap(err, "while downloading "+snapshotSpecFile(id))
+	}
+	defer os.RemoveAll(file)
+	return r.ReadSpecFile(file)
+}
+
+// snapshotIndex returns the index of the artifacts referenced by the
+// snapshot id of the repository
+func (r *LuetSystemRepository) snapshotIndex(ctx types.Context, id string) (compiler.ArtifactIndex, error) {
+	c := r.Client(ctx)
+	if c == nil {
+		return nil, errors.New("no client could be generated from repository")
+	}
+	snapshot, err := r.fetchIndex(ctx, c, snapshotSpecFile(id))
+	if err != nil {
+		return nil, err
+	}
+	return snapshot.GetIndex(), nil
+}
+
+// fetchIndex downloads with c the repository spec file, along with the
+// index of the artifacts it refers to, for all the platforms
+func (r *LuetSystemRepository) fetchIndex(ctx types.Context, c Client, specFile string) (*LuetSystemRepository, error) {
+	file, err := c.DownloadFile(specFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "while downloading "+specFile)
+	}
+	defer os.RemoveAll(file)
+	spec, err := r.ReadSpecFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := spec.getRepoFile(c, REPOFILE_META_KEY)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while fetching '%s'", REPOFILE_META_KEY)
+	}
+	defer os.RemoveAll(a.Path)
+	index, err := metaIndex(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+	spec.SetIndex(index)
+	return spec, nil
+}
+
+// removeSnapshot removes the spec of the snapshot id of the repository,
+// along with the tree and metadata files copied for it
+func (r *LuetSystemRepository) removeSnapshot(ctx types.Context, id string) error {
+	c := r.Client(ctx)
+	if c == nil {
+		return errors.New("no client could be generated from repository")
+	}
+	snapshot, err := r.snapshotSpec(c, id)
+	if err != nil {
+		return err
+	}
+
+	files := []string{snapshotSpecFile(id)}
+	for _, f := range snapshot.RepositoryFiles {
+		// Only the files copied for the snapshot, the others are shared
+		if strings.HasPrefix(f.GetFileName(), id+"-") {
+			files = append(files, f.GetFileName())
+		}
+	}
+
+	for _, f := range files {
+		if r.GetType() == DockerRepositoryType {
+			err := image.Delete(fmt.Sprintf("%s:%s", r.GetUrls()[0], helpers.SanitizeImageString(f)))
+			if errors.Is(err, image.ErrSharedManifest) {
+				ctx.Warning("Not deleting", f, err.Error())
+			} else if err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.Remove(filepath.Join(r.GetUrls()[0], f)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "while removing '%s'", f)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/mudler/luet/pkg/api/core/context"
+	"github.com/mudler/luet/pkg/api/core/types"
+	. "github.com/mudler/luet/pkg/installer"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Repository snapshots", func() {
+	var tmpdir string
+	ctx := context.NewContext()
+
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = os.MkdirTemp("", "repo")
+		Expect(err).ToNot(HaveOccurred())
+		ctx.Config.System.PkgsCachePath, err = os.MkdirTemp("", "cache")
+		Expect(err).ToNot(HaveOccurred())
+		ctx.Config.System.DatabasePath, err = os.MkdirTemp("", "db")
+		Expect(err).ToNot(HaveOccurred())
+
+		// The first snapshot has all the versions of test/a, the last
+		// revision only the newest ones
+		diskRepo(ctx, "../../tests/fixtures/prune", tmpdir, types.GZip)
+		Expect(os.Remove(filepath.Join(tmpdir, "a-test-1.0.metadata.yaml"))).To(Succeed())
+		generateDiskRepo(ctx, "../../tests/fixtures/prune", tmpdir, "release")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpdir)
+		os.RemoveAll(ctx.Config.System.PkgsCachePath)
+		os.RemoveAll(ctx.Config.System.DatabasePath)
+	})
+
+	repository := func(snapshot string, cached bool) *LuetSystemRepository {
+		return NewSystemRepository(types.LuetRepository{
+			Name:     "test",
+			Type:     DiskRepositoryType,
+			Urls:     []string{tmpdir},
+			Snapshot: snapshot,
+			Cached:   cached,
+		})
+	}
+
+	packages := func(r *LuetSystemRepository) int {
+		synced, err := r.Sync(ctx, false)
+		Expect(err).ToNot(HaveOccurred())
+		return len(synced.GetTree().GetDatabase().World())
+	}
+
+	It("lists the snapshots", func() {
+		snapshots, err := repository("", false).Snapshots(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshots).To(HaveLen(2))
+		Expect(snapshots[0].Revision).To(Equal(1))
+		Expect(snapshots[1].ID).To(Equal("release"))
+		Expect(snapshots[1].Revision).To(Equal(2))
+	})
+
+	It("syncs the snapshot the repository is pinned to", func() {
+		snapshots, err := repository("", false).Snapshots(ctx)
+		Expect(err).ToNot(HaveOccurred())
+
+		synced, err := repository(snapshots[0].ID, false).Sync(ctx, true)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(synced.GetRevision()).To(Equal(1))
+		Expect(synced.GetTree().GetDatabase().World()).To(HaveLen(4))
+		Expect(synced.GetIndex()).To(HaveLen(4))
+
+		synced, err = repository("", false).Sync(ctx, true)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(synced.GetRevision()).To(Equal(2))
+		Expect(synced.GetTree().GetDatabase().World()).To(HaveLen(3))
+	})
+
+	It("syncs cached repositories again when the pinned snapshot changes", func() {
+		snapshots, err := repository("", true).Snapshots(ctx)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(packages(repository("", true))).To(Equal(3))
+		Expect(packages(repository(snapshots[0].ID, true))).To(Equal(4))
+		Expect(packages(repository("", true))).To(Equal(3))
+	})
+
+	It("fails on missing snapshots", func() {
+		_, err := repository("missing", false).Sync(ctx, true)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -21,6 +21,7 @@ import (
 
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 
 	"github.com/mudler/luet/pkg/api/core/context"
@@ -29,6 +30,7 @@ import (
 	"github.com/mudler/luet/pkg/compiler"
 	backend "github.com/mudler/luet/pkg/compiler/backend"
 	pkg "github.com/mudler/luet/pkg/database"
+	"github.com/mudler/luet/pkg/helpers"
 	fileHelper "github.com/mudler/luet/pkg/helpers/file"
 	. "github.com/mudler/luet/pkg/installer"
 	"github.com/mudler/luet/pkg/tree"
@@ -441,6 +443,52 @@ urls:
 			_, err = repos.GetTree().GetDatabase().FindPackage(spec2.GetPackage())
 			Expect(err).ToNot(HaveOccurred()) // should NOT throw error
 		})
+
+		It("adds the runtime dependencies detected when building to the tree", func() {
+			const fixture = "../../tests/fixtures/runtimedeps"
+			tmpdir, err := os.MkdirTemp("", "tree")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(tmpdir) // clean up
+
+			generalRecipe := tree.NewCompilerRecipe(pkg.NewInMemoryDatabase(false))
+			Expect(generalRecipe.Load(fixture)).To(Succeed())
+			c := compiler.NewLuetCompiler(nil, generalRecipe.GetDatabase(), compiler.WithContext(ctx), compiler.WithRuntimeDeps(types.RuntimeDepsAdd))
+
+			for _, p := range []*types.Package{{Name: "foo", Category: "lib", Version: "1.0"}, {Name: "bar", Category: "app", Version: "1.0"}} {
+				spec, err := c.FromPackage(p)
+				Expect(err).ToNot(HaveOccurred())
+				spec.SetOutputPath(tmpdir)
+
+				a := artifact.NewPackageArtifact(filepath.Join(tmpdir, p.GetFingerPrint()+".package.tar"))
+				Expect(a.Compress(filepath.Join(fixture, p.GetName(), "rootfs"), 1)).To(Succeed())
+				a.CompileSpec = spec
+				a.Files, err = a.FileList()
+				Expect(err).ToNot(HaveOccurred())
+				runtime, err := c.DetectRuntimeDeps(a, spec)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(a.WriteYAML(tmpdir, artifact.WithRuntimePackage(runtime))).To(Succeed())
+			}
+
+			repo, err := GenerateRepository(
+				WithName("test"),
+				WithType("disk"),
+				WithUrls(tmpdir),
+				WithSource(tmpdir),
+				WithTree(fixture),
+				WithContext(ctx),
+				WithDatabase(pkg.NewInMemoryDatabase(false)),
+			)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(repo.Write(ctx, tmpdir, false, true)).To(Succeed())
+
+			local, err := LoadLocalRepositoryTree(ctx, tmpdir)
+			Expect(err).ToNot(HaveOccurred())
+			bar, err := local.GetTree().GetDatabase().FindPackage(&types.Package{Name: "bar", Category: "app", Version: "1.0"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(bar.GetRequires()).To(HaveLen(1))
+			Expect(bar.GetRequires()[0].GetCategory()).To(Equal("lib"))
+			Expect(bar.GetRequires()[0].GetName()).To(Equal("foo"))
+		})
 	})
 	Context("Matching packages", func() {
 		It("Matches packages in different repositories by priority", func() {
@@ -461,6 +509,37 @@ urls:
 			Expect(matches).To(Equal([]PackageMatch{{Repo: repo1, Package: package1}}))
 
 		})
+
+		It("Selects the artifacts of the target platform", func() {
+			pkg := &types.Package{Name: "foo", Category: "bar", Version: "1.0"}
+			amd64 := types.Platform{OS: "linux", Arch: "amd64"}
+			arm64 := types.Platform{OS: "linux", Arch: "arm64"}
+			repo := &LuetSystemRepository{
+				LuetRepository: &types.LuetRepository{Name: "test"},
+				Index: compiler.ArtifactIndex{
+					&artifact.PackageArtifact{
+						Path:        "/build/linux-amd64/foo.package.tar",
+						Platform:    amd64,
+						CompileSpec: &types.LuetCompilationSpec{Package: pkg},
+					},
+					&artifact.PackageArtifact{
+						Path:        "/build/linux-arm64/foo.package.tar",
+						Platform:    arm64,
+						CompileSpec: &types.LuetCompilationSpec{Package: pkg},
+					},
+				},
+			}
+
+			meta, _ := repo.Serialize()
+			Expect(meta.Index[0].Path).To(Equal("linux-amd64/foo.package.tar"))
+			Expect(meta.Index[1].Path).To(Equal("linux-arm64/foo.package.tar"))
+
+			repo.SetIndex(meta.ToArtifactIndex().ForPlatform(arm64))
+			a, err := repo.SearchArtefact(pkg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(a.Platform).To(Equal(arm64))
+			Expect(a.RepositoryPath()).To(Equal("linux-arm64/foo.package.tar"))
+		})
 	})
 	Context("Docker repository", func() {
 		repoImage := os.Getenv("UNIT_TEST_DOCKER_IMAGE_REPOSITORY")
@@ -547,6 +626,53 @@ urls:
 			Expect(fileHelper.Read(filepath.Join(extracted, "test6"))).To(Equal("artifact6\n"))
 		})
 
+		It("generates the metadata images of each platform", func() {
+			b := backend.NewSimpleDockerBackend(ctx)
+			tmpdir, err := os.MkdirTemp("", "tree")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(tmpdir) // clean up
+
+			generalRecipe := tree.NewCompilerRecipe(pkg.NewInMemoryDatabase(false))
+			Expect(generalRecipe.Load("../../tests/fixtures/buildable")).To(Succeed())
+			p, err := generalRecipe.GetDatabase().FindPackage(&types.Package{Name: "b", Category: "test", Version: "1.0"})
+			Expect(err).ToNot(HaveOccurred())
+
+			platforms := []types.Platform{{OS: "linux", Arch: "amd64"}, {OS: "linux", Arch: "arm64"}}
+			for _, platform := range platforms {
+				src, err := os.MkdirTemp("", "src")
+				Expect(err).ToNot(HaveOccurred())
+				defer os.RemoveAll(src)
+				Expect(os.WriteFile(filepath.Join(src, "platform"), []byte(platform.String()), os.ModePerm)).To(Succeed())
+
+				dst := filepath.Join(tmpdir, platform.DirName())
+				Expect(os.MkdirAll(dst, os.ModePerm)).To(Succeed())
+				a := artifact.NewPackageArtifact(filepath.Join(dst, p.GetFingerPrint()+".package.tar"))
+				a.CompileSpec = &types.LuetCompilationSpec{Package: p}
+				a.Platform = platform
+				Expect(a.Compress(src, 1)).To(Succeed())
+				Expect(a.WriteYAML(dst)).To(Succeed())
+			}
+
+			repo, err := dockerStubRepo(tmpdir, "../../tests/fixtures/buildable", repoImage, true, true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(repo.Write(ctx, repoImage, false, true)).To(Succeed())
+
+			c := repo.Client(ctx)
+			for _, platform := range platforms {
+				metadataFile := path.Join(platform.DirName(), p.GetMetadataFilePath())
+				Expect(b.ImageAvailable(fmt.Sprintf("%s:%s", repoImage, helpers.FileImageTag(metadataFile)))).To(BeTrue())
+
+				f, err := c.DownloadFile(metadataFile)
+				Expect(err).ToNot(HaveOccurred())
+				defer os.RemoveAll(f)
+				dat, err := os.ReadFile(f)
+				Expect(err).ToNot(HaveOccurred())
+				a, err := artifact.NewPackageArtifactFromYaml(dat)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(a.Platform).To(Equal(platform))
+			}
+		})
+
 		It("generates images of virtual packages", func() {
 			b := backend.NewSimpleDockerBackend(ctx)
 			tmpdir, err := os.MkdirTemp("", "tree")
@@ -0,0 +1,133 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/mudler/luet/pkg/api/core/types"
+)
+
+// cachedSolution is what the solver cache stores for a query
+type cachedSolution struct {
+	Uninstall  types.Packages           `json:"uninstall,omitempty"`
+	Assertions types.PackagesAssertions `json:"assertions"`
+	Changes    types.ChangeReport       `json:"changes,omitempty"`
+}
+
+// solverQuery is hashed to key the solver cache. Anything which can change
+// the outcome of a solve has to be part of it.
+type solverQuery struct {
+	Operation    string                  `json:"operation"`
+	Repositories []string                `json:"repositories"`
+	Installed    []string                `json:"installed"`
+	Requested    []string                `json:"requested"`
+	Solver       types.LuetSolverOptions `json:"solver"`
+	Options      LuetInstallerOptions    `json:"options"`
+}
+
+func fingerprints(p types.Packages) []string {
+	res := []string{}
+	for _, pp := range p {
+		res = append(res, pp.GetFingerPrint())
+	}
+	sort.Strings(res)
+	return res
+}
+
+// solverCacheKey returns the cache key of a query, or an empty string if
+// solutions are not cached.
+func (l *LuetInstaller) solverCacheKey(operation string, syncedRepos Repositories, s *System, requested types.Packages) string {
+	if !l.Options.SolverOptions.Cache || l.Options.Context == nil {
+		return ""
+	}
+
+	q := solverQuery{
+		Operation: operation,
+		Installed: fingerprints(s.Database.World()),
+		Requested: fingerprints(requested),
+		Solver:    l.Options.SolverOptions,
+		Options: LuetInstallerOptions{
+			NoDeps:                     l.Options.NoDeps,
+			OnlyDeps:                   l.Options.OnlyDeps,
+			FullUninstall:              l.Options.FullUninstall,
+			SolverUpgrade:              l.Options.SolverUpgrade,
+			RemoveUnavailableOnUpgrade: l.Options.RemoveUnavailableOnUpgrade,
+			UpgradeNewRevisions:        l.Options.UpgradeNewRevisions,
+			Relaxed:                    l.Options.Relaxed,
+		},
+	}
+	for _, r := range syncedRepos {
+		q.Repositories = append(q.Repositories,
+			fmt.Sprintf("%s-%d-%s-%d", r.GetName(), r.GetRevision(), r.GetLastUpdate(), r.GetPriority()))
+	}
+
+	dat, err := json.Marshal(q)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(dat))
+}
+
+func (l *LuetInstaller) solverCacheFile(key string) string {
+	return filepath.Join(l.Options.Context.GetConfig().System.GetSolverCacheDirPath(), key+".json")
+}
+
+// cachedSolve returns the solution stored for key, if any
+func (l *LuetInstaller) cachedSolve(key string) (*cachedSolution, bool) {
+	if key == "" {
+		return nil, false
+	}
+	dat, err := os.ReadFile(l.solverCacheFile(key))
+	if err != nil {
+		return nil, false
+	}
+	sol := &cachedSolution{}
+	if err := json.Unmarshal(dat, sol); err != nil {
+		return nil, false
+	}
+	l.Options.Context.Debug("Solver cache hit", key)
+	return sol, true
+}
+
+// cacheSolve stores a solution for key. Failures are not fatal: the
+// solution is just computed again the next time.
+func (l *LuetInstaller) cacheSolve(key string, sol cachedSolution) {
+	if key == "" {
+		return
+	}
+	dat, err := json.Marshal(sol)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(l.Options.Context.GetConfig().System.GetSolverCacheDirPath(), os.ModePerm); err != nil {
+		l.Options.Context.Debug("Failed creating solver cache", err.Error())
+		return
+	}
+	if err := os.WriteFile(l.solverCacheFile(key), dat, 0600); err != nil {
+		l.Options.Context.Debug("Failed writing solver cache", err.Error())
+	}
+}
+
+// CleanSolverCache drops all the cached solver solutions of the system
+func CleanSolverCache(ctx types.Context) error {
+	return os.RemoveAll(ctx.GetConfig().System.GetSolverCacheDirPath())
+}
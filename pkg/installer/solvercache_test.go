@@ -0,0 +1,82 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer_test
+
+import (
+	"os"
+
+	"github.com/mudler/luet/pkg/api/core/context"
+	"github.com/mudler/luet/pkg/api/core/types"
+	pkg "github.com/mudler/luet/pkg/database"
+	. "github.com/mudler/luet/pkg/installer"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Solver cache", func() {
+	var tmpdir string
+	var ctx *context.Context
+
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = os.MkdirTemp("", "solvercache")
+		Expect(err).ToNot(HaveOccurred())
+		ctx = context.NewContext(context.WithConfig(&types.LuetConfig{
+			System: types.LuetSystemConfig{DatabasePath: tmpdir},
+		}))
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpdir)
+	})
+
+	solve := func(cache bool) {
+		inst := NewLuetInstaller(LuetInstallerOptions{
+			Concurrency:   1,
+			SolverOptions: types.LuetSolverOptions{Cache: cache},
+			Context:       ctx,
+		})
+		s := &System{Database: pkg.NewInMemoryDatabase(false), Target: tmpdir}
+
+		// There are no repositories to match the solution against, but
+		// solving happens before that.
+		Expect(inst.Install(types.Packages{types.NewPackage("a", "1.0", nil, nil)}, s)).To(HaveOccurred())
+	}
+
+	It("stores solutions when enabled", func() {
+		solve(true)
+		entries, err := os.ReadDir(ctx.GetConfig().System.GetSolverCacheDirPath())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(entries)).To(Equal(1))
+
+		// The same query hits the same entry
+		solve(true)
+		entries, err = os.ReadDir(ctx.GetConfig().System.GetSolverCacheDirPath())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(entries)).To(Equal(1))
+
+		Expect(CleanSolverCache(ctx)).ToNot(HaveOccurred())
+		_, err = os.Stat(ctx.GetConfig().System.GetSolverCacheDirPath())
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("doesn't store solutions by default", func() {
+		solve(false)
+		_, err := os.Stat(ctx.GetConfig().System.GetSolverCacheDirPath())
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+})
@@ -3,13 +3,17 @@ package installer
 import (
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/go-multierror"
+	"github.com/mudler/luet/pkg/api/core/sbom"
 	"github.com/mudler/luet/pkg/api/core/template"
 	"github.com/mudler/luet/pkg/api/core/types"
 	fileHelper "github.com/mudler/luet/pkg/helpers/file"
 	"github.com/mudler/luet/pkg/tree"
+	"github.com/pkg/errors"
 )
 
 type System struct {
@@ -41,6 +45,58 @@ func (s *System) OSCheck(ctx types.Context) (notFound types.Packages) {
 	return
 }
 
+// SBOM returns the SPDX bill of materials of the given installed packages,
+// or of all of them if none is given. Files are hashed from the target
+// system, the ones which are missing or not regular are not listed.
+func (s *System) SBOM(ctx types.Context, packs types.Packages) *sbom.Document {
+	if len(packs) == 0 {
+		packs = s.Database.World()
+	}
+	packs = packs.Unique()
+	sort.Slice(packs, func(i, j int) bool {
+		return packs[i].HumanReadableString() < packs[j].HumanReadableString()
+	})
+
+	var entries []sbom.Entry
+	for _, p := range packs {
+		entry := sbom.Entry{Package: p}
+		// Packages shipping no files might have none recorded
+		files, err := s.Database.GetPackageFiles(p)
+		if err != nil {
+			ctx.Debugf("No files recorded for '%s': %s", p.HumanReadableString(), err.Error())
+		}
+
+		for _, f := range files {
+			h, err := hashSystemFile(s.Target, f)
+			if err != nil {
+				ctx.Debugf("Not listing '%s' from '%s': %s", f, p.HumanReadableString(), err.Error())
+				continue
+			}
+			entry.Files = append(entry.Files, h)
+		}
+		entries = append(entries, entry)
+	}
+
+	return sbom.New("luet-system", time.Now(), entries...)
+}
+
+func hashSystemFile(target, f string) (sbom.FileHash, error) {
+	targetFile := filepath.Join(target, f)
+	info, err := os.Lstat(targetFile)
+	if err != nil {
+		return sbom.FileHash{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return sbom.FileHash{}, errors.New("not a regular file")
+	}
+	file, err := os.Open(targetFile)
+	if err != nil {
+		return sbom.FileHash{}, err
+	}
+	defer file.Close()
+	return sbom.HashFile(f, file)
+}
+
 func (s *System) ExecuteFinalizers(ctx types.Context, packs []*types.Package) error {
 	var errs error
 	executedFinalizer := map[string]bool{}
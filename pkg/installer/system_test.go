@@ -23,6 +23,7 @@ import (
 	"path/filepath"
 
 	"github.com/mudler/luet/pkg/api/core/context"
+	"github.com/mudler/luet/pkg/api/core/sbom"
 	"github.com/mudler/luet/pkg/api/core/types"
 	pkg "github.com/mudler/luet/pkg/database"
 	. "github.com/mudler/luet/pkg/installer"
@@ -86,4 +87,48 @@ var _ = Describe("System", func() {
 			Expect(len(notfound)).To(Equal(1))
 		})
 	})
+
+	Context("SBOM", func() {
+		It("describes the installed packages and their files", func() {
+			dir, err := os.MkdirTemp("", "test")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			Expect(os.WriteFile(filepath.Join(dir, "foo"), []byte("foo"), os.ModePerm)).To(Succeed())
+
+			db := pkg.NewInMemoryDatabase(false)
+			a := &types.Package{Name: "a", Version: "1", Category: "t", License: "MIT", Uri: []string{"https://example.com"}}
+			b := &types.Package{Name: "b", Version: "1", Category: "t", PackageRequires: []*types.Package{
+				{Name: "a", Version: ">=0", Category: "t"},
+				{Name: "c", Version: ">=1", Category: "t"},
+			}}
+			db.CreatePackage(a)
+			db.CreatePackage(b)
+			db.SetPackageFiles(&types.PackageFile{PackageFingerprint: a.GetFingerPrint(), Files: []string{"foo", "missing"}})
+
+			s := &System{Database: db, Target: dir}
+			doc := s.SBOM(context.NewContext(), nil)
+
+			Expect(doc.SPDXVersion).To(Equal("SPDX-2.3"))
+			Expect(doc.DocumentDescribes).To(HaveLen(2))
+			// a, b and the c requirement, not installed
+			Expect(doc.Packages).To(HaveLen(3))
+			Expect(doc.Packages[0].Name).To(Equal("t/a"))
+			Expect(doc.Packages[0].LicenseDeclared).To(Equal("MIT"))
+			Expect(doc.Packages[0].DownloadLocation).To(Equal("https://example.com"))
+			Expect(doc.Packages[0].VerificationCode).ToNot(BeNil())
+			Expect(doc.Packages[1].FilesAnalyzed).To(BeFalse())
+			Expect(doc.Packages[2].Name).To(Equal("t/c"))
+
+			Expect(doc.Files).To(HaveLen(1))
+			Expect(doc.Files[0].FileName).To(Equal("./foo"))
+			Expect(doc.Files[0].Checksums[1].Value).To(Equal("2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"))
+
+			Expect(doc.Relationships).To(ContainElement(sbom.Relationship{
+				Element: doc.Packages[1].SPDXID, Type: "DEPENDS_ON", Related: doc.Packages[0].SPDXID,
+			}))
+			Expect(doc.Relationships).To(ContainElement(sbom.Relationship{
+				Element: doc.Packages[1].SPDXID, Type: "DEPENDS_ON", Related: doc.Packages[2].SPDXID,
+			}))
+		})
+	})
 })
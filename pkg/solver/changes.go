@@ -0,0 +1,151 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package solver
+
+import (
+	"sort"
+
+	"github.com/crillab/gophersat/bf"
+	"github.com/mudler/luet/pkg/api/core/types"
+)
+
+// Changes returns the installed packages that the last upgrade removed or
+// downgraded. With MinimizeChanges set, these are the ones the minimal-change
+// pass could not keep.
+func (s *Solver) Changes() types.ChangeReport {
+	return s.changes
+}
+
+// keptBy returns whether the set carries p, or a newer version of it, and
+// whether it carries any version of it at all.
+func keptBy(p *types.Package, set types.Packages) (kept bool, present bool) {
+	for _, c := range set {
+		if c.GetPackageName() != p.GetPackageName() {
+			continue
+		}
+		present = true
+		if c.GetVersion() == p.GetVersion() {
+			return true, true
+		}
+		if ok, _ := c.VersionMatchSelector(">="+p.GetVersion(), nil); ok {
+			return true, true
+		}
+	}
+	return false, present
+}
+
+// changesAgainst compares a solution with the installed packages it is
+// supposed to replace.
+func changesAgainst(installed types.Packages, assertions types.PackagesAssertions) types.ChangeReport {
+	report := types.ChangeReport{}
+	after := trueAssertions(assertions)
+	for _, p := range sortedPackages(installed) {
+		switch kept, present := keptBy(p, after); {
+		case kept:
+		case present:
+			report.Downgraded = append(report.Downgraded, p)
+		default:
+			report.Removed = append(report.Removed, p)
+		}
+	}
+	return report
+}
+
+func trueAssertions(assertions types.PackagesAssertions) types.Packages {
+	set := types.Packages{}
+	for _, a := range assertions {
+		if a.Value {
+			set = append(set, a.Package)
+		}
+	}
+	return set
+}
+
+func sortedPackages(set types.Packages) types.Packages {
+	sorted := append(types.Packages{}, set...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].HumanReadableString() < sorted[j].HumanReadableString()
+	})
+	return sorted
+}
+
+// minimizeChanges pulls a solution back towards the installed system.
+//
+// It works the same way as improveModel: for every installed package the model
+// drops or downgrades, ask whether the same problem is still satisfiable if
+// that package is additionally kept at its version or newer. Each yes is held
+// as a constraint for the following questions, so kept packages accumulate.
+// This is greedy - keeping one package early can rule out keeping two later -
+// but it never returns a worse solution than the one it was given.
+//
+// Only versions already encoded in the formula are offered. A variable the
+// formula never mentions is unconstrained, so asserting it would "keep" a
+// package without any of its requirements.
+func (s *Solver) minimizeChanges(f bf.Formula, model map[string]bool, installed types.Packages, db types.PackageDatabase) map[string]bool {
+	constraints := []bf.Formula{f}
+	solves := 0
+
+	for _, p := range sortedPackages(installed) {
+		if solves >= maxOptimizeSolves {
+			break
+		}
+
+		assertions, err := DecodeModel(model, db)
+		if err != nil {
+			return model
+		}
+		if kept, _ := keptBy(p, trueAssertions(assertions)); kept {
+			continue
+		}
+
+		candidates := types.Packages{p}
+		if versions, err := s.DefinitionDatabase.FindPackageVersions(p); err == nil {
+			for _, v := range versions {
+				if ok, _ := v.VersionMatchSelector(">="+p.GetVersion(), nil); ok && v.GetVersion() != p.GetVersion() {
+					candidates = append(candidates, v)
+				}
+			}
+		}
+
+		var alo []bf.Formula
+		for _, c := range candidates {
+			encoded, err := c.Encode(db)
+			if err != nil {
+				continue
+			}
+			if _, ok := model[encoded]; !ok {
+				continue
+			}
+			alo = append(alo, bf.Var(encoded))
+		}
+		if len(alo) == 0 {
+			continue
+		}
+
+		solves++
+		keep := bf.Or(alo...)
+		attempt := append(append([]bf.Formula{}, constraints...), keep)
+		newModel, _, err := s.solve(bf.And(attempt...))
+		if err != nil {
+			continue // removing or downgrading it is unavoidable
+		}
+
+		constraints = append(constraints, keep)
+		model = newModel
+	}
+
+	return model
+}
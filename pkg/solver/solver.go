@@ -41,7 +41,16 @@ type Solver struct {
 	// See types.SolverOptions.Optimize.
 	Optimize bool
 
+	// MinimizeChanges enables the minimal-change pass for upgrades.
+	// See types.SolverOptions.MinimizeChanges.
+	MinimizeChanges bool
+
 	Resolver types.PackageResolver
+
+	// baseline is the installed system an upgrade is minimizing changes
+	// against. Solve only runs the minimal-change pass when it is set.
+	baseline types.Packages
+	changes  types.ChangeReport
 }
 
 // IsRelaxedResolver returns true wether a solver might
@@ -78,7 +87,7 @@ func NewResolver(t types.SolverOptions, installed types.PackageDatabase, definit
 	var s types.PackageSolver
 	switch t.Type {
 	default:
-		s = &Solver{InstalledDatabase: installed, DefinitionDatabase: definitiondb, SolverDatabase: solverdb, Resolver: re, Optimize: t.Optimize}
+		s = &Solver{InstalledDatabase: installed, DefinitionDatabase: definitiondb, SolverDatabase: solverdb, Resolver: re, Optimize: t.Optimize, MinimizeChanges: t.MinimizeChanges}
 	}
 
 	return s
@@ -503,6 +512,10 @@ func (s *Solver) UpgradeUniverse(dropremoved bool) (types.Packages, types.Packag
 		return nil, nil, errors.New("Failed finding a solution")
 	}
 
+	if s.MinimizeChanges {
+		model = s.minimizeChanges(bf.And(formulas...), model, s.Installed(), universe)
+	}
+
 	assertion, err := DecodeModel(model, universe)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "while decoding model from solution")
@@ -516,6 +529,17 @@ func (s *Solver) UpgradeUniverse(dropremoved bool) (types.Packages, types.Packag
 		}
 
 	}
+
+	// Installed packages the formula never mentions are left alone, so only
+	// the ones it asserts false can be changes.
+	after := append(types.PackagesAssertions{}, assertion...)
+	for _, p := range s.Installed() {
+		if !inPackage(markedForRemoval, p) {
+			after = append(after, types.PackageAssert{Package: p, Value: true})
+		}
+	}
+	s.changes = changesAgainst(s.Installed(), after)
+
 	return markedForRemoval, assertion, nil
 }
 
@@ -574,8 +598,13 @@ func assertionToMemDB(assertions types.PackagesAssertions) types.PackageDatabase
 func (s *Solver) upgrade(psToUpgrade, psToNotUpgrade types.Packages, fn func(defDB types.PackageDatabase, installDB types.PackageDatabase) (types.Packages, types.Packages, types.PackageDatabase, []*types.Package), defDB types.PackageDatabase, installDB types.PackageDatabase, checkconflicts, full bool) (types.Packages, types.PackagesAssertions, error) {
 
 	toUninstall, toInstall, installedcopy, packsToUpgrade := fn(defDB, installDB)
-	s2 := NewSolver(types.SolverOptions{Type: types.SolverSingleCoreSimple, Optimize: s.Optimize}, installedcopy, defDB, pkg.NewInMemoryDatabaseNoIndex())
-	s2.SetResolver(s.Resolver)
+	s2 := &Solver{InstalledDatabase: installedcopy, DefinitionDatabase: defDB, SolverDatabase: pkg.NewInMemoryDatabaseNoIndex(),
+		Resolver: s.Resolver, Optimize: s.Optimize, MinimizeChanges: s.MinimizeChanges}
+	if s.MinimizeChanges {
+		// Candidates for replacement have been dropped from installedcopy, so
+		// measure changes against the system as it was before the upgrade.
+		s2.baseline = s.Installed()
+	}
 	if !full {
 		ass := types.PackagesAssertions{}
 		for _, i := range toInstall {
@@ -636,7 +665,12 @@ func (s *Solver) Upgrade(checkconflicts, full bool) (types.Packages, types.Packa
 	if err != nil {
 		return nil, nil, err
 	}
-	return s.upgrade(types.Packages{}, types.Packages{}, s.computeUpgrade(types.Packages{}, types.Packages{}), s.DefinitionDatabase, installedcopy, checkconflicts, full)
+	uninstall, assertions, err := s.upgrade(types.Packages{}, types.Packages{}, s.computeUpgrade(types.Packages{}, types.Packages{}), s.DefinitionDatabase, installedcopy, checkconflicts, full)
+	if err != nil {
+		return uninstall, assertions, err
+	}
+	s.changes = changesAgainst(s.Installed(), assertions)
+	return uninstall, assertions, nil
 }
 
 // Uninstall takes a candidate package and return a list of packages that would be removed
@@ -934,6 +968,10 @@ func (s *Solver) Solve() (types.PackagesAssertions, error) {
 		model = s.improveModel(f, model)
 	}
 
+	if s.MinimizeChanges && len(s.baseline) != 0 {
+		model = s.minimizeChanges(f, model, s.baseline, s.SolverDatabase)
+	}
+
 	return DecodeModel(model, s.SolverDatabase)
 }
 
@@ -1526,5 +1526,66 @@ var _ = Describe("Solver", func() {
 
 			Expect(len(solution)).To(Equal(3))
 		})
+
+		Context("with MinimizeChanges", func() {
+			D := types.NewPackage("d", "1.0", []*types.Package{}, []*types.Package{})
+			D.SetCategory("test")
+			D0 := types.NewPackage("d", "0.9", []*types.Package{}, []*types.Package{})
+			D0.SetCategory("test")
+
+			BeforeEach(func() {
+				for _, p := range []*types.Package{A1, B, D, D0} {
+					_, err := dbDefinitions.CreatePackage(p)
+					Expect(err).ToNot(HaveOccurred())
+				}
+
+				// E is not in the definitions, so it has to go.
+				for _, p := range []*types.Package{A, B, D, E} {
+					_, err := dbInstalled.CreatePackage(p)
+					Expect(err).ToNot(HaveOccurred())
+				}
+			})
+
+			It("UpgradeUniverse without it drops unrelated installed packages", func() {
+				s = NewSolver(types.SolverOptions{Type: types.SolverSingleCoreSimple}, dbInstalled, dbDefinitions, db)
+
+				uninstall, _, err := s.UpgradeUniverse(true)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(uninstall).To(ContainElement(D))
+				Expect(s.Changes().Removed).To(Equal(types.Packages{D, E}))
+			})
+
+			It("UpgradeUniverse keeps unrelated installed packages", func() {
+				s = NewSolver(types.SolverOptions{Type: types.SolverSingleCoreSimple, MinimizeChanges: true}, dbInstalled, dbDefinitions, db)
+
+				uninstall, solution, err := s.UpgradeUniverse(true)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(uninstall).To(ContainElement(A))
+				Expect(uninstall).To(ContainElement(E))
+				Expect(uninstall).ToNot(ContainElement(D))
+				Expect(solution).To(ContainElement(types.PackageAssert{Package: A1, Value: true}))
+				Expect(solution).To(ContainElement(types.PackageAssert{Package: D, Value: true}))
+
+				Expect(s.Changes().Removed).To(Equal(types.Packages{E}))
+				Expect(s.Changes().Downgraded).To(BeEmpty())
+			})
+
+			It("reports the changes of an upgrade", func() {
+				s = NewSolver(types.SolverOptions{Type: types.SolverSingleCoreSimple, MinimizeChanges: true}, dbInstalled, dbDefinitions, db)
+
+				_, solution, err := s.Upgrade(false, true)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(solution).To(ContainElement(types.PackageAssert{Package: A1, Value: true}))
+				Expect(solution).To(ContainElement(types.PackageAssert{Package: D, Value: true}))
+				// Upgrade only replaces packages with newer versions, so E
+				// stays installed even if it is not in the definitions
+				Expect(solution).To(ContainElement(types.PackageAssert{Package: E, Value: true}))
+				Expect(s.Changes().Removed).To(BeEmpty())
+				Expect(s.Changes().Downgraded).To(BeEmpty())
+			})
+		})
 	})
 })
@@ -0,0 +1,124 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package tree
+
+import (
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/mudler/luet/pkg/api/core/types"
+	"github.com/pkg/errors"
+)
+
+// ChangedFiles returns the absolute paths of the files of the trees which
+// changed since the git revision ref. Changes not committed yet, and new
+// untracked files, are included as well.
+func ChangedFiles(ref string, trees ...string) ([]string, error) {
+	var files []string
+	seen := map[string]bool{}
+
+	for _, t := range trees {
+		tree, err := realPath(t)
+		if err != nil {
+			return nil, err
+		}
+
+		top, err := git(tree, "rev-parse", "--show-toplevel")
+		if err != nil {
+			return nil, errors.Wrapf(err, "'%s' is not in a git repository", t)
+		}
+		root := strings.TrimSpace(top)
+
+		diff, err := git(root, "diff", "--name-only", "-z", ref, "--", tree)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed diffing '%s' against '%s'", t, ref)
+		}
+		untracked, err := git(root, "ls-files", "--others", "--exclude-standard", "-z", "--", tree)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed listing untracked files of '%s'", t)
+		}
+
+		for _, f := range strings.Split(diff+untracked, "\x00") {
+			if f == "" {
+				continue
+			}
+			abs := filepath.Join(root, f)
+			if !seen[abs] {
+				seen[abs] = true
+				files = append(files, abs)
+			}
+		}
+	}
+	return files, nil
+}
+
+// PackagesOfFiles returns the packages of db which definition folder
+// contains any of files. A file belongs to the packages defined in the
+// closest folder above it, so changes in nested definitions don't affect the
+// outer ones.
+func PackagesOfFiles(db types.PackageDatabase, files []string) types.Packages {
+	byPath := map[string]types.Packages{}
+	for _, p := range db.World() {
+		if p.GetPath() == "" {
+			continue
+		}
+		dir, err := realPath(p.GetPath())
+		if err != nil {
+			// The definition might have been removed
+			continue
+		}
+		byPath[dir] = append(byPath[dir], p)
+	}
+
+	var res types.Packages
+	found := map[string]bool{}
+	for _, f := range files {
+		for dir := filepath.Dir(f); ; dir = filepath.Dir(dir) {
+			if packs, ok := byPath[dir]; ok {
+				if !found[dir] {
+					found[dir] = true
+					res = append(res, packs...)
+				}
+				break
+			}
+			if dir == filepath.Dir(dir) {
+				break
+			}
+		}
+	}
+	return res
+}
+
+func realPath(p string) (string, error) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+func git(dir string, args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrap(err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
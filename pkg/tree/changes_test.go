@@ -0,0 +1,92 @@
+// Copyright © 2022 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package tree_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	pkg "github.com/mudler/luet/pkg/database"
+	fileHelper "github.com/mudler/luet/pkg/helpers/file"
+	. "github.com/mudler/luet/pkg/tree"
+)
+
+var _ = Describe("Changes", func() {
+	var tmpdir string
+
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", tmpdir, "-c", "user.name=luet", "-c", "user.email=luet@localhost"}, args...)...)
+		out, err := cmd.CombinedOutput()
+		Expect(err).ToNot(HaveOccurred(), string(out))
+	}
+
+	BeforeEach(func() {
+		if _, err := exec.LookPath("git"); err != nil {
+			Skip("git is not available")
+		}
+		var err error
+		tmpdir, err = os.MkdirTemp("", "changes")
+		Expect(err).ToNot(HaveOccurred())
+		tmpdir, err = filepath.EvalSymlinks(tmpdir)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(fileHelper.CopyDir("../../tests/fixtures/buildableseed", filepath.Join(tmpdir, "tree"))).To(Succeed())
+		git("init", "-q")
+		git("add", "-A")
+		git("commit", "-q", "-m", "init")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpdir)
+	})
+
+	It("maps the files changed since a revision to their packages", func() {
+		tree := filepath.Join(tmpdir, "tree")
+
+		files, err := ChangedFiles("HEAD", tree)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files).To(BeEmpty())
+
+		Expect(os.WriteFile(filepath.Join(tree, "cat", "b", "generate.sh"), []byte("echo changed"), os.ModePerm)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(tree, "d", "new.patch"), []byte("new"), os.ModePerm)).To(Succeed())
+
+		files, err = ChangedFiles("HEAD", tree)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files).To(ConsistOf(
+			filepath.Join(tree, "cat", "b", "generate.sh"),
+			filepath.Join(tree, "d", "new.patch"),
+		))
+
+		generalRecipe := NewCompilerRecipe(pkg.NewInMemoryDatabase(false))
+		Expect(generalRecipe.Load(tree)).To(Succeed())
+
+		packs := PackagesOfFiles(generalRecipe.GetDatabase(), files)
+		var names []string
+		for _, p := range packs {
+			names = append(names, p.GetName())
+		}
+		Expect(names).To(ConsistOf("b", "d"))
+	})
+
+	It("fails on unknown revisions", func() {
+		_, err := ChangedFiles("notarevision", filepath.Join(tmpdir, "tree"))
+		Expect(err).To(HaveOccurred())
+	})
+})
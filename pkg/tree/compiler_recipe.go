@@ -105,7 +105,8 @@ func (r *CompilerRecipe) Load(path string) error {
 	if err != nil {
 		return err
 	}
-	return nil
+
+	return ResolveDockerfileRequires(r.Database)
 }
 
 func (r *CompilerRecipe) GetDatabase() types.PackageDatabase   { return r.Database }
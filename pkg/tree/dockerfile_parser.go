@@ -16,6 +16,7 @@
 package tree
 
 import (
+	"os"
 	"path/filepath"
 	"strings"
 
@@ -23,13 +24,39 @@ import (
 	"github.com/pkg/errors"
 )
 
+// dockerfilePackage returns the package described by the Dockerfile at
+// currentpath. The package name defaults to the name of its directory.
+func dockerfilePackage(srcDir, currentpath string) (*types.Package, *types.DockerfileSpec, error) {
+	dat, err := os.ReadFile(currentpath)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Error reading file "+currentpath)
+	}
+	spec, err := types.ParseDockerfile(string(dat))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Error parsing "+currentpath)
+	}
+	p, err := spec.Package(filepath.Base(filepath.Dir(currentpath)))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Error reading labels of "+currentpath)
+	}
+
+	// Path is set only internally when tree is loaded from disk
+	p.Path = filepath.Dir(currentpath)
+	p.TreeDir = srcDir
+	return p, spec, nil
+}
+
 func RuntimeDockerfileParser(srcDir, currentpath, name string, templates []string, db types.PackageDatabase) error {
 	if !strings.Contains(name, "Dockerfile") {
 		return nil
 	}
 
-	// Path is set only internally when tree is loaded from disk
-	_, err := db.CreatePackage(&types.Package{Name: filepath.Base(filepath.Dir(currentpath)), Path: filepath.Dir(currentpath), TreeDir: srcDir})
+	p, _, err := dockerfilePackage(srcDir, currentpath)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.CreatePackage(p)
 	if err != nil {
 		return errors.Wrap(err, "Error creating package "+currentpath)
 	}
@@ -41,22 +68,68 @@ func BuildDockerfileParser(srcDir, currentpath, name string, templates []string,
 		return nil
 	}
 
-	// Simply imply the name package from the directory name
-	// TODO: Read specific labels from dockerfile as we do read the image already
-	p := &types.Package{
-		Name:    filepath.Base(filepath.Dir(currentpath)),
-		Path:    filepath.Dir(currentpath),
-		TreeDir: srcDir}
+	p, spec, err := dockerfilePackage(srcDir, currentpath)
+	if err != nil {
+		return err
+	}
 
-	err := p.SetOriginalDockerfile(currentpath)
+	// At build time, requirements are the build ones. FROM instructions
+	// referring to other packages of the tree are added once the tree is
+	// loaded, see ResolveDockerfileRequires
+	p.PackageRequires, err = spec.BuildRequires()
+	if err != nil {
+		return errors.Wrap(err, "Error reading labels of "+currentpath)
+	}
+
+	err = p.SetOriginalDockerfile(currentpath)
 	if err != nil {
 		return errors.Wrap(err, "Error reading file "+currentpath)
 	}
 
-	// Path is set only internally when tree is loaded from disk
 	_, err = db.CreatePackage(p)
 	if err != nil {
 		return errors.Wrap(err, "Error creating package "+currentpath)
 	}
 	return nil
 }
+
+// ResolveDockerfileRequires adds to the Dockerfile packages of the database
+// a build requirement for each FROM instruction referring to a package
+// of the tree, in the category/name[:version] form.
+func ResolveDockerfileRequires(db types.PackageDatabase) error {
+	for _, p := range db.World() {
+		if p.OriginDockerfile == "" {
+			continue
+		}
+		spec, err := types.ParseDockerfile(p.OriginDockerfile)
+		if err != nil {
+			return errors.Wrap(err, "Error parsing Dockerfile of "+p.HumanReadableString())
+		}
+
+		updated := false
+	STAGES:
+		for _, s := range spec.ExternalStages() {
+			req, ok := types.ImagePackage(s.Image)
+			if !ok {
+				continue
+			}
+			if pp, err := db.FindPackages(req); err != nil || len(pp) == 0 {
+				continue
+			}
+			for _, r := range p.GetRequires() {
+				if r.GetPackageName() == req.GetPackageName() {
+					continue STAGES
+				}
+			}
+			p.PackageRequires = append(p.PackageRequires, req)
+			updated = true
+		}
+
+		if updated {
+			if err := db.UpdatePackage(p); err != nil {
+				return errors.Wrap(err, "Error updating package "+p.HumanReadableString())
+			}
+		}
+	}
+	return nil
+}
@@ -187,4 +187,29 @@ var _ = Describe("Tree", func() {
 		})
 	})
 
+	Context("Dockerfile packages", func() {
+		It("Reads metadata from labels and maps FROM to build requirements", func() {
+			generalRecipe := NewCompilerRecipe(pkg.NewInMemoryDatabase(false), BuildDockerfileParser)
+			Expect(generalRecipe.Load("../../tests/fixtures/dockerfiles_labels")).ToNot(HaveOccurred())
+			Expect(len(generalRecipe.GetDatabase().World())).To(Equal(2))
+
+			app, err := generalRecipe.GetDatabase().FindPackage(&types.Package{Name: "app", Category: "test", Version: "2.0"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(app.GetRequires()).To(HaveLen(1))
+			Expect(app.GetRequires()[0].GetName()).To(Equal("base"))
+
+			s := solver.NewSolver(types.SolverOptions{Type: types.SolverSingleCoreSimple}, pkg.NewInMemoryDatabase(false), generalRecipe.GetDatabase(), pkg.NewInMemoryDatabase(false))
+			solution, err := s.Install([]*types.Package{app})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(solution.Search("base-test-1.0").Value).To(BeTrue())
+
+			installerRecipe := NewInstallerRecipe(pkg.NewInMemoryDatabase(false), RuntimeDockerfileParser)
+			Expect(installerRecipe.Load("../../tests/fixtures/dockerfiles_labels")).ToNot(HaveOccurred())
+			runtimeApp, err := installerRecipe.GetDatabase().FindPackage(&types.Package{Name: "app", Category: "test", Version: "2.0"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(runtimeApp.GetRequires()).To(HaveLen(1))
+			Expect(runtimeApp.GetRequires()[0].GetVersion()).To(Equal(">=1.0"))
+		})
+	})
+
 })
@@ -0,0 +1,7 @@
+/* bar: an executable linking libfoo.so.1, which it doesn't ship */
+int foo(void);
+
+int main(void)
+{
+	return foo() == 42 ? 0 : 1;
+}
@@ -0,0 +1,5 @@
+/* libfoo.so.1: a shared library with its DT_SONAME */
+int foo(void)
+{
+	return 42;
+}
@@ -0,0 +1,12 @@
+#!/bin/bash
+# Regenerates the ELF files shipped by the packages of the fixture, which are
+# scanned for the shared libraries they need and provide.
+set -e
+
+cd "$(dirname "$0")/.."
+
+CC=${CC:-gcc}
+
+mkdir -p foo/rootfs/usr/lib bar/rootfs/usr/bin
+$CC -shared -fPIC -s -Wl,-soname,libfoo.so.1 -o foo/rootfs/usr/lib/libfoo.so.1 src/foo.c
+$CC -s -o bar/rootfs/usr/bin/bar src/bar.c -Lfoo/rootfs/usr/lib -l:libfoo.so.1